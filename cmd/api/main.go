@@ -11,15 +11,17 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/pzkpfw44/wave-server/internal/api"
 	"github.com/pzkpfw44/wave-server/internal/api/handlers"
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
 	"github.com/pzkpfw44/wave-server/internal/repository"
-	"github.com/pzkpfw44/wave-server/internal/service"
 	"github.com/pzkpfw44/wave-server/pkg/health"
 	"github.com/pzkpfw44/wave-server/pkg/logger"
+	"github.com/pzkpfw44/wave-server/pkg/metrics"
 )
 
 func main() {
@@ -58,28 +60,36 @@ func main() {
 	e := echo.New()
 	e.HideBanner = true
 
-	// Create handlers
-	h := handlers.NewHandler(db, cfg, log)
+	// Create one of each service, shared by the handlers and the auth middleware
+	hub := realtime.NewHub(log)
+	services := handlers.NewServices(db, hub, cfg, log)
 
-	// Create services for middleware and authentication
-	userRepo := repository.NewUserRepository(db)
-	tokenRepo := repository.NewTokenRepository(db)
-	authService := service.NewAuthService(userRepo, tokenRepo, cfg, log)
+	// Create handlers; their background jobs run until the handler is closed
+	h := handlers.NewHandler(ctx, services, hub, cfg, log)
+	h.ScheduleCleanup()
+
+	// Report connection pool statistics on the metrics endpoint
+	metrics.SetPoolStatsProvider(db.Stat)
+	metrics.SetRequestDurationBuckets(cfg.Metrics.LatencyBuckets, cfg.Metrics.LatencySLO.Seconds())
 
 	// Setup health checker
-	healthChecker := health.New(db.Pool, log)
+	healthChecker := health.New(db, log)
+
+	// The auth middleware records user activity in the background until it is closed
+	authMiddleware := middleware.NewAuthMiddleware(services.Auth, cfg, log)
+
+	// The rate limiters run cleanup and hold Redis connections until they are closed
+	limiters := middleware.NewRateLimiters(cfg)
 
 	// Configure middleware
-	middleware.SetupMiddleware(e, cfg, log, authService)
+	middleware.SetupMiddleware(e, cfg, log, authMiddleware, limiters)
 
 	// Configure routes
-	api.SetupRoutes(e, h, cfg, authService, healthChecker, log)
+	api.SetupRoutes(e, h, cfg, authMiddleware, limiters, healthChecker, log)
 
 	// Start server
 	go func() {
-		address := fmt.Sprintf(":%d", cfg.Server.Port)
-		log.Info("Starting server", zap.String("address", address))
-		if err := e.Start(address); err != nil && err != http.ErrServerClosed {
+		if err := startServer(e, cfg, log); err != nil && err != http.ErrServerClosed {
 			log.Fatal("Server error", zap.Error(err))
 		}
 	}()
@@ -91,12 +101,50 @@ func main() {
 
 	// Gracefully shutdown
 	log.Info("Shutting down server...")
-	ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer shutdownCancel()
+
+	// Stop the cleanup jobs and close hijacked WebSocket connections, which Shutdown doesn't
+	h.Close()
 
-	if err := e.Shutdown(ctx); err != nil {
+	if err := e.Shutdown(shutdownCtx); err != nil {
 		log.Fatal("Server shutdown error", zap.Error(err))
 	}
 
+	// Write the activity recorded by the last requests
+	authMiddleware.Close()
+
+	// Stop the rate limiters' cleanup and close their Redis connections
+	if err := limiters.Close(); err != nil {
+		log.Warn("Failed to close rate limiters", zap.Error(err))
+	}
+
+	// Stop anything else still running on the root context
+	cancel()
+
 	log.Info("Server stopped")
 }
+
+// startServer serves over TLS when a certificate or an ACME domain is configured, and plain HTTP otherwise
+func startServer(e *echo.Echo, cfg *config.Config, log *zap.Logger) error {
+	address := fmt.Sprintf(":%d", cfg.Server.Port)
+
+	switch {
+	case cfg.Server.TLSCertFile != "":
+		log.Info("Starting server with TLS", zap.String("address", address))
+		return e.StartTLS(address, cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+
+	case cfg.Server.AutoTLSDomain != "":
+		// Let's Encrypt validates the domain over TLS-ALPN, so the server must be reachable on port 443
+		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.Server.AutoTLSDomain)
+		e.AutoTLSManager.Cache = autocert.DirCache(cfg.Server.AutoTLSCacheDir)
+		log.Info("Starting server with automatic TLS",
+			zap.String("address", address),
+			zap.String("domain", cfg.Server.AutoTLSDomain))
+		return e.StartAutoTLS(address)
+
+	default:
+		log.Info("Starting server", zap.String("address", address))
+		return e.Start(address)
+	}
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -9,7 +12,6 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/api/request"
 	"github.com/pzkpfw44/wave-server/internal/api/response"
-	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
@@ -33,25 +35,126 @@ func NewAccountHandler(
 	}
 }
 
-// BackupAccount handles account backup
+// backupFlushInterval is how many backup messages are written between flushes to the client
+const backupFlushInterval = 100
+
+// Backup formats
+const (
+	backupFormatNDJSON = "ndjson" // One JSON object per line: a header, the messages, then an end line
+	backupFormatJSON   = "json"   // A single object in the shape the recover endpoint accepts
+)
+
+// backupHeaderRecord is the first line of a streamed backup
+type backupHeaderRecord struct {
+	Type string `json:"type"`
+	*service.BackupHeader
+}
+
+// backupEndRecord is the last line of a complete backup; without it the backup was cut short
+type backupEndRecord struct {
+	Type     string `json:"type"`
+	Messages int    `json:"messages"`
+}
+
+// BackupAccount streams a backup of the current user's account as NDJSON
+// The first line is the header with the keys and contacts, then one line per message, then an end line
 func (h *AccountHandler) BackupAccount(c echo.Context) error {
+	return h.streamBackup(c, backupFormatNDJSON, "wave-backup.ndjson")
+}
+
+// ExportAccount streams a backup of the current user's account as a dated file download
+// It is JSON by default, or NDJSON like BackupAccount with format=ndjson
+func (h *AccountHandler) ExportAccount(c echo.Context) error {
+	format := c.QueryParam("format")
+	switch format {
+	case "":
+		format = backupFormatJSON
+	case backupFormatJSON, backupFormatNDJSON:
+	default:
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Format must be json or ndjson", "BAD_REQUEST"))
+	}
+
+	filename := fmt.Sprintf("wave-backup-%s.%s", time.Now().UTC().Format(time.DateOnly), format)
+	return h.streamBackup(c, format, filename)
+}
+
+// streamBackup streams the current user's backup in format as an attachment named filename
+func (h *AccountHandler) streamBackup(c echo.Context, format, filename string) error {
 	// Get user ID from context
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
 		return err
 	}
 
-	// Create backup
-	backup, err := h.accountService.BackupAccount(c.Request().Context(), userID)
+	res := c.Response()
+	encoder := json.NewEncoder(res)
+
+	// The status is only sent once the header is ready, so earlier errors still get a normal error response
+	started := false
+	writeHeader := func(header *service.BackupHeader) error {
+		contentType := "application/x-ndjson"
+		if format == backupFormatJSON {
+			contentType = echo.MIMEApplicationJSON
+		}
+		res.Header().Set(echo.HeaderContentType, contentType)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		res.WriteHeader(http.StatusOK)
+		started = true
+
+		if format == backupFormatNDJSON {
+			return encoder.Encode(backupHeaderRecord{Type: "header", BackupHeader: header})
+		}
+
+		// The header object is left open so the messages can be streamed into it
+		data, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(res, "%s,\"messages\":[\n", data[:len(data)-1])
+		return err
+	}
+
+	written := 0
+	writeMessage := func(message map[string]interface{}) error {
+		if format == backupFormatNDJSON {
+			message["type"] = "message"
+		} else if written > 0 {
+			if _, err := res.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+		written++
+		if written%backupFlushInterval == 0 {
+			res.Flush()
+		}
+		return nil
+	}
+
+	count, err := h.accountService.BackupAccount(c.Request().Context(), userID, writeHeader, writeMessage)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
+		if started {
+			// Too late for an error response; the missing end tells the client the backup is incomplete
+			middleware.LoggerFromContext(c).Error("Backup interrupted", zap.Error(err), zap.Int("messages_written", written))
+			return nil
 		}
-		h.logger.Error("Backup failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to create backup", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, response.NewSuccessResponse(backup))
+	if format == backupFormatNDJSON {
+		err = encoder.Encode(backupEndRecord{Type: "end", Messages: count})
+	} else {
+		_, err = res.Write([]byte("]}\n"))
+	}
+	if err != nil {
+		middleware.LoggerFromContext(c).Warn("Failed to finish backup", zap.Error(err))
+		return nil
+	}
+	res.Flush()
+
+	return nil
 }
 
 // RecoverAccount handles account recovery
@@ -63,7 +166,7 @@ func (h *AccountHandler) RecoverAccount(c echo.Context) error {
 	}
 
 	// Recover account
-	user, err := h.accountService.RecoverAccount(
+	user, summary, err := h.accountService.RecoverAccount(
 		c.Request().Context(),
 		req.Username,
 		req.PublicKey,
@@ -72,31 +175,23 @@ func (h *AccountHandler) RecoverAccount(c echo.Context) error {
 		req.Messages,
 	)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Recovery failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to recover account", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
-	// Generate token for the recovered account
-	token, err := h.authService.Login(c.Request().Context(), user.Username)
+	// Restored messages reach their recipients like any others sent, so they count against the user's send limit
+	middleware.ChargeSendBudget(c, user.UserID, summary.MessagesRestored)
+
+	// Generate tokens for the recovered account
+	tokens, err := h.authService.Login(c.Request().Context(), user.Username, "")
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Token generation failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Authentication failed", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
-	// Return token
+	// Return tokens
 	result := map[string]interface{}{
-		"user": user.ToPublic(),
-		"token": response.TokenResponse{
-			AccessToken: token,
-			TokenType:   "Bearer",
-			ExpiresIn:   86400, // 24 hours
-		},
+		"user":     user.ToPublic(),
+		"restored": summary,
+		"token":    newTokenResponse(tokens),
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(result))
@@ -112,12 +207,30 @@ func (h *AccountHandler) DeleteAccount(c echo.Context) error {
 
 	// Delete account
 	if err := h.accountService.DeleteAccount(c.Request().Context(), userID); err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Account deletion failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to delete account", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"deleted": true}))
 }
+
+// UpdatePrivacy handles updating the current user's privacy settings
+func (h *AccountHandler) UpdatePrivacy(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	var req request.UpdatePrivacyRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	// Update privacy settings
+	if err := h.accountService.UpdatePrivacy(c.Request().Context(), userID, *req.Discoverable); err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"discoverable": *req.Discoverable}))
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+func TestRecoverAcceptsRealisticBackup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Messages.MaxCiphertextBytes = 64 << 10
+	cfg.Messages.MaxBulkBodyBytes = 256 << 20
+
+	e := echo.New()
+	e.Validator = request.NewValidator(zaptest.NewLogger(t))
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+	e.Use(middleware.BodyLimit(cfg))
+
+	// Stands in for the account service: the request only has to get through to it intact
+	var restored int
+	e.POST("/api/v1/account/recover", func(c echo.Context) error {
+		var req request.RecoverAccountRequest
+		if err := request.ValidateRequest(c, &req); err != nil {
+			return err
+		}
+		restored = len(req.Messages)
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/api/v1/messages/send", func(c echo.Context) error { return c.NoContent(http.StatusCreated) })
+
+	// A backup of 50 messages at the largest ciphertext size is several times the size of any single message request
+	ciphertext := base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{1}, cfg.Messages.MaxCiphertextBytes))
+	messages := make([]map[string]interface{}, 50)
+	for i := range messages {
+		messages[i] = map[string]interface{}{
+			"message_id":            uuid.NewString(),
+			"sender_pubkey":         "alice",
+			"recipient_pubkey":      "bob",
+			"ciphertext_kem":        ciphertext[:1024],
+			"ciphertext_msg":        ciphertext,
+			"nonce":                 ciphertext[:16],
+			"sender_ciphertext_kem": ciphertext[:1024],
+			"sender_ciphertext_msg": ciphertext,
+			"sender_nonce":          ciphertext[:16],
+			"timestamp":             time.Now().Format(time.RFC3339Nano),
+			"status":                "read",
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"username":              "alice",
+		"public_key":            "alice",
+		"encrypted_private_key": map[string]string{"salt": "salt", "encrypted_key": "key"},
+		"messages":              messages,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, postJSON(e, "/api/v1/account/recover", body).Code)
+	assert.Equal(t, len(messages), restored)
+
+	// The same body is far too large for a single message
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postJSON(e, "/api/v1/messages/send", body).Code)
+}
+
+// exportAccount gets the user's account export in format, or the default format when it is empty
+func exportAccount(t *testing.T, h *AccountHandler, userID, format string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	target := "/api/v1/account/export"
+	if format != "" {
+		target += "?format=" + format
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)
+	c.Set("user_id", userID)
+	require.NoError(t, h.ExportAccount(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec
+}
+
+func TestExportAccountDecodes(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	userRepo := repository.NewUserRepository(db)
+	contactRepo := repository.NewContactRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	accounts := service.NewAccountService(db, userRepo, contactRepo, messageRepo,
+		repository.NewTokenRepository(db), nil, cfg, zaptest.NewLogger(t))
+	h := NewAccountHandler(accounts, nil, zaptest.NewLogger(t))
+
+	user := createTestUser(t, userRepo, true)
+	silent := createTestUser(t, userRepo, true)
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	peer := "peer-" + uuid.NewString()
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), userPubKey)
+		_, _ = db.Pool.Exec(context.Background(), "DELETE FROM contacts WHERE user_id = $1", user.UserID)
+	})
+
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(user.UserID, peer, "Peer")))
+	var messageIDs []string
+	for i := 0; i < 3; i++ {
+		sender, recipient := userPubKey, peer
+		if i == 1 {
+			sender, recipient = peer, userPubKey
+		}
+		message := domain.NewMessage(sender, recipient, []byte("kem"), []byte("msg"), []byte("nonce"),
+			[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+		message.Timestamp = time.Now().Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, messageRepo.Create(ctx, message))
+		messageIDs = append(messageIDs, message.MessageID.String())
+	}
+
+	tests := []struct {
+		name       string
+		user       *domain.User
+		messageIDs []string
+		contacts   int
+	}{
+		{"with messages", user, messageIDs, 1},
+		{"without messages", silent, nil, 0},
+	}
+
+	for _, tt := range tests {
+		publicKey := base64.URLEncoding.EncodeToString(tt.user.PublicKey)
+
+		t.Run(tt.name+"/json", func(t *testing.T) {
+			rec := exportAccount(t, h, tt.user.UserID, "")
+			assert.Equal(t, echo.MIMEApplicationJSON, rec.Header().Get(echo.HeaderContentType))
+
+			// The whole export is one object, which is the recovery request apart from the username
+			var export request.RecoverAccountRequest
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+			assert.Equal(t, publicKey, export.PublicKey)
+			assert.Equal(t, base64.URLEncoding.EncodeToString(tt.user.Salt), export.EncryptedPrivateKey["salt"])
+			assert.Len(t, export.Contacts, tt.contacts)
+			require.NotNil(t, export.Messages)
+			require.Len(t, export.Messages, len(tt.messageIDs))
+			for i, message := range export.Messages {
+				assert.Equal(t, tt.messageIDs[i], message.(map[string]interface{})["message_id"])
+			}
+		})
+
+		t.Run(tt.name+"/ndjson", func(t *testing.T) {
+			rec := exportAccount(t, h, tt.user.UserID, "ndjson")
+			assert.Equal(t, "application/x-ndjson", rec.Header().Get(echo.HeaderContentType))
+
+			var lines []map[string]interface{}
+			decoder := json.NewDecoder(rec.Body)
+			for decoder.More() {
+				var line map[string]interface{}
+				require.NoError(t, decoder.Decode(&line))
+				lines = append(lines, line)
+			}
+
+			// A header, then each message oldest first, then an end line counting them
+			require.Len(t, lines, len(tt.messageIDs)+2)
+			assert.Equal(t, "header", lines[0]["type"])
+			assert.Equal(t, publicKey, lines[0]["public_key"])
+			assert.Len(t, lines[0]["contacts"], tt.contacts)
+			for i, messageID := range tt.messageIDs {
+				assert.Equal(t, "message", lines[i+1]["type"])
+				assert.Equal(t, messageID, lines[i+1]["message_id"])
+			}
+			end := lines[len(lines)-1]
+			assert.Equal(t, "end", end["type"])
+			assert.EqualValues(t, len(tt.messageIDs), end["messages"])
+		})
+	}
+}
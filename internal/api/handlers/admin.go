@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+// defaultInactiveUserLimit is how many inactive users are listed or deleted when no limit is given
+const defaultInactiveUserLimit = 100
+
+// AdminHandler handles admin requests
+type AdminHandler struct {
+	adminService *service.AdminService
+	userService  *service.UserService
+	cfg          *config.Config
+	logger       *zap.Logger
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(adminService *service.AdminService, userService *service.UserService, cfg *config.Config, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+		userService:  userService,
+		cfg:          cfg,
+		logger:       logger.With(zap.String("handler", "admin")),
+	}
+}
+
+// GetStats handles getting counts of users, messages, contacts and sessions
+// The counts may be up to 30 seconds old
+func (h *AdminHandler) GetStats(c echo.Context) error {
+	stats, err := h.adminService.GetStats(c.Request().Context())
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.AdminStatsResponse{
+		TotalUsers:     stats.Users,
+		TotalMessages:  stats.Messages,
+		TotalContacts:  stats.Contacts,
+		ActiveSessions: stats.ActiveSessions,
+		CollectedAt:    stats.CollectedAt.Format(time.RFC3339),
+	}))
+}
+
+// GetConfig handles getting the effective server configuration
+func (h *AdminHandler) GetConfig(c echo.Context) error {
+	routeLimits := make(map[string]response.RateLimitResponse, len(h.cfg.RateLimit.Routes))
+	for route, limit := range h.cfg.RateLimit.Routes {
+		routeLimits[route] = rateLimitResponse(limit.Limit, limit.Window)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.AdminConfigResponse{
+		RateLimit:        rateLimitResponse(h.cfg.RateLimit.Limit, h.cfg.RateLimit.Window),
+		RouteRateLimits:  routeLimits,
+		RateLimitBackend: h.cfg.RateLimit.Backend,
+	}))
+}
+
+// ListInactiveUsers handles listing users who haven't been active since a given time, least recently active first
+func (h *AdminHandler) ListInactiveUsers(c echo.Context) error {
+	var req request.InactiveUsersRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	before, limit, err := parseInactiveUsers(req)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	users, err := h.userService.ListInactiveUsers(c.Request().Context(), before, limit)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	inactive := make([]response.InactiveUserResponse, 0, len(users))
+	for _, user := range users {
+		inactive = append(inactive, response.InactiveUserResponse{
+			UserID:     user.UserID,
+			Username:   user.Username,
+			CreatedAt:  user.CreatedAt.Format(time.RFC3339),
+			LastActive: user.LastActive.Format(time.RFC3339),
+		})
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.InactiveUsersResponse{Users: inactive}))
+}
+
+// DeleteInactiveUsers handles deleting the accounts of users who haven't been active since a given time
+// Each account is deleted with its messages, contacts and sessions; at most limit are deleted per request
+func (h *AdminHandler) DeleteInactiveUsers(c echo.Context) error {
+	var req request.InactiveUsersRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	before, limit, err := parseInactiveUsers(req)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	deleted, err := h.userService.DeleteInactiveUsers(c.Request().Context(), before, limit)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	h.logger.Info("Inactive users deleted by admin", zap.Int("count", deleted), zap.Time("before", before))
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.DeleteInactiveUsersResponse{Deleted: deleted}))
+}
+
+// parseInactiveUsers reads the cutoff and limit of an inactive user request
+// The cutoff must be in the past, so a mistyped time can't select every user
+func parseInactiveUsers(req request.InactiveUsersRequest) (time.Time, int, error) {
+	before, err := time.Parse(time.RFC3339Nano, req.Before)
+	if err != nil {
+		return time.Time{}, 0, errors.NewValidationError("Invalid before time, expected RFC 3339", err)
+	}
+	if !before.Before(time.Now()) {
+		return time.Time{}, 0, errors.NewValidationError("The before time must be in the past", nil)
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultInactiveUserLimit
+	}
+	return before, limit, nil
+}
+
+// rateLimitResponse converts a rate limit to its response form
+func rateLimitResponse(limit int, window time.Duration) response.RateLimitResponse {
+	return response.RateLimitResponse{
+		Limit:         limit,
+		WindowSeconds: int(window.Seconds()),
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/config"
+)
+
+func TestDeleteInactiveUsersRejectsBadCutoffs(t *testing.T) {
+	e := echo.New()
+	e.Validator = request.NewValidator(zaptest.NewLogger(t))
+
+	// The requests are rejected before any service is reached
+	h := NewAdminHandler(nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	for _, before := range []string{"", "yesterday", time.Now().Add(time.Hour).Format(time.RFC3339)} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/inactive-users?before="+url.QueryEscape(before), nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		if err := h.DeleteInactiveUsers(c); err != nil {
+			middleware.HTTPErrorHandler(err, c)
+		}
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, before)
+	}
+}
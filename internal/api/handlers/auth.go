@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 
@@ -11,14 +13,19 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/response"
 	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/security"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
+// powChallengeTTL is how long a registration proof-of-work challenge can be solved and used
+const powChallengeTTL = 5 * time.Minute
+
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
 	authService *service.AuthService
 	userService *service.UserService
 	config      *config.Config
+	enumeration *enumerationGuard
 	logger      *zap.Logger
 }
 
@@ -33,6 +40,7 @@ func NewAuthHandler(
 		authService: authService,
 		userService: userService,
 		config:      config,
+		enumeration: newEnumerationGuard(config),
 		logger:      logger.With(zap.String("handler", "auth")),
 	}
 }
@@ -44,6 +52,13 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return err
 	}
 
+	if h.config.Auth.RequirePoW {
+		err := security.VerifyPoW(h.config.Auth.PoWSecret, req.PoWChallenge, req.Username, req.PoWNonce, time.Now())
+		if err != nil {
+			return response.WriteError(c, errors.NewValidationError("Invalid or expired proof of work", err))
+		}
+	}
+
 	// Register user
 	_, err := h.userService.Register(
 		c.Request().Context(),
@@ -53,31 +68,53 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		req.Salt,
 	)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Registration failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Registration failed", "INTERNAL"))
+		return response.WriteError(c, err)
+	}
+
+	// Generate tokens
+	tokens, err := h.authService.Login(c.Request().Context(), req.Username, req.DeviceName)
+	if err = h.enumeration.lookupDone(err); err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Return tokens
+	tokenResponse := newTokenResponse(tokens)
+
+	return c.JSON(http.StatusCreated, response.NewSuccessResponse(tokenResponse))
+}
+
+// GetChallenge issues a proof-of-work challenge to solve before registering
+func (h *AuthHandler) GetChallenge(c echo.Context) error {
+	if !h.config.Auth.RequirePoW {
+		return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ChallengeResponse{Required: false}))
 	}
 
-	// Generate token
-	token, err := h.authService.Login(c.Request().Context(), req.Username)
+	challenge, err := security.NewPoWChallenge(h.config.Auth.PoWSecret, h.config.Auth.PoWDifficulty, powChallengeTTL)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Token generation failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Authentication failed", "INTERNAL"))
+		return response.WriteError(c, errors.NewInternalError("Failed to create challenge", err))
 	}
 
-	// Return token
-	tokenResponse := response.TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   int(h.config.Auth.TokenExpiry.Seconds()),
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ChallengeResponse{
+		Required:   true,
+		Challenge:  challenge.Challenge,
+		Difficulty: challenge.Difficulty,
+		ExpiresAt:  challenge.ExpiresAt.Format(time.RFC3339),
+	}))
+}
+
+// CheckUsernameAvailable reports whether a username can still be registered
+func (h *AuthHandler) CheckUsernameAvailable(c echo.Context) error {
+	var req request.UsernameAvailableRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusCreated, response.NewSuccessResponse(tokenResponse))
+	available, err := h.userService.IsUsernameAvailable(c.Request().Context(), req.Username)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.UsernameAvailableResponse{Available: available}))
 }
 
 // Login handles user login
@@ -87,58 +124,48 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return err
 	}
 
-	// Generate token
-	token, err := h.authService.Login(c.Request().Context(), req.Username)
-	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Login failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Authentication failed", "INTERNAL"))
+	// Generate tokens
+	tokens, err := h.authService.Login(c.Request().Context(), req.Username, req.DeviceName)
+	if err = h.enumeration.lookupDone(err); err != nil {
+		return response.WriteError(c, err)
 	}
 
-	// Return token
-	tokenResponse := response.TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   int(h.config.Auth.TokenExpiry.Seconds()),
-	}
+	// Return tokens
+	tokenResponse := newTokenResponse(tokens)
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(tokenResponse))
 }
 
-// RefreshToken handles token refresh
+// RefreshToken exchanges a refresh token for new access and refresh tokens
 func (h *AuthHandler) RefreshToken(c echo.Context) error {
-	// Extract token from Authorization header
-	authHeader := c.Request().Header.Get("Authorization")
-	if authHeader == "" {
-		return c.JSON(http.StatusUnauthorized, response.NewErrorResponse("Missing authorization header", "UNAUTHENTICATED"))
-	}
-
-	// Support both "Bearer token" and just "token" formats
-	token := authHeader
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		token = authHeader[7:]
+	var req request.RefreshTokenRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
 	}
 
-	// Refresh token
-	newToken, err := h.authService.RefreshToken(c.Request().Context(), token)
+	// Refresh tokens
+	tokens, err := h.authService.RefreshToken(c.Request().Context(), req.RefreshToken)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Token refresh failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Token refresh failed", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
-	// Return new token
-	tokenResponse := response.TokenResponse{
-		AccessToken: newToken,
-		TokenType:   "Bearer",
-		ExpiresIn:   int(h.config.Auth.TokenExpiry.Seconds()),
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(newTokenResponse(tokens)))
+}
+
+// newTokenResponse formats a session's tokens for the client
+func newTokenResponse(tokens *service.TokenPair) response.TokenResponse {
+	return response.TokenResponse{
+		AccessToken:      tokens.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        secondsUntil(tokens.AccessExpiresAt),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresIn: secondsUntil(tokens.RefreshExpiresAt),
 	}
+}
 
-	return c.JSON(http.StatusOK, response.NewSuccessResponse(tokenResponse))
+// secondsUntil returns the whole seconds from now until t
+func secondsUntil(t time.Time) int {
+	return int(time.Until(t).Round(time.Second).Seconds())
 }
 
 // Logout handles user logout
@@ -149,19 +176,9 @@ func (h *AuthHandler) Logout(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, response.NewErrorResponse("Missing authorization header", "UNAUTHENTICATED"))
 	}
 
-	// Support both "Bearer token" and just "token" formats
-	token := authHeader
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		token = authHeader[7:]
-	}
-
 	// Invalidate token
-	if err := h.authService.Logout(c.Request().Context(), token); err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Logout failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Logout failed", "INTERNAL"))
+	if err := h.authService.Logout(c.Request().Context(), middleware.BearerToken(authHeader)); err != nil {
+		return response.WriteError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"logged_out": true}))
@@ -177,12 +194,90 @@ func (h *AuthHandler) LogoutAll(c echo.Context) error {
 
 	// Invalidate all tokens
 	if err := h.authService.LogoutAll(c.Request().Context(), userID); err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Logout all failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to logout from all devices", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"logged_out_all": true}))
 }
+
+// RevokeSession logs out one of the current user's sessions
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse token ID
+	tokenID, err := uuid.Parse(c.Param("token_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid token ID format", "BAD_REQUEST"))
+	}
+
+	// Revoke session
+	if err := h.authService.RevokeSession(c.Request().Context(), userID, tokenID); err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"revoked": true}))
+}
+
+// ListSessions lists the current user's sessions
+func (h *AuthHandler) ListSessions(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse query parameters; expired sessions are only listed when asked for
+	req := request.ListSessionsRequest{Active: true}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Set defaults
+	var fetch int
+	req.Limit, fetch = pageLimit(req.Limit, defaultSessionPageSize, maxSessionPageSize)
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	// Get sessions
+	tokens, err := h.authService.ListSessions(c.Request().Context(), userID, fetch, req.Offset, req.Active)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+	tokens, pagination := response.Paginate(tokens, req.Limit, req.Offset)
+
+	activeCount, err := h.authService.CountActiveSessions(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Format sessions for response
+	now := time.Now()
+	currentSessionID := uuid.Nil
+	if tokenHash := middleware.GetTokenHash(c); tokenHash != "" {
+		// Not knowing the current session only means none is marked
+		currentSessionID, _ = h.authService.CurrentSessionID(c.Request().Context(), tokenHash)
+	}
+	sessions := make([]response.SessionResponse, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = response.SessionResponse{
+			TokenID:    token.TokenID.String(),
+			DeviceName: token.DeviceLabel(),
+			CreatedAt:  token.CreatedAt.Format(time.RFC3339),
+			LastUsed:   token.LastUsed.Format(time.RFC3339),
+			ExpiresAt:  token.ExpiresAt.Format(time.RFC3339),
+			Active:     !token.IsExpiredAt(now, h.config.Auth.ExpiryGrace),
+			Current:    currentSessionID != uuid.Nil && token.TokenID == currentSessionID,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.SessionsResponse{
+		Sessions:    sessions,
+		Pagination:  pagination,
+		ActiveCount: activeCount,
+	}))
+}
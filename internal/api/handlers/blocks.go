@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+// BlockHandler handles blocklist requests
+type BlockHandler struct {
+	blockService *service.BlockService
+	logger       *zap.Logger
+}
+
+// NewBlockHandler creates a new block handler
+func NewBlockHandler(
+	blockService *service.BlockService,
+	logger *zap.Logger,
+) *BlockHandler {
+	return &BlockHandler{
+		blockService: blockService,
+		logger:       logger.With(zap.String("handler", "block")),
+	}
+}
+
+// Block handles blocking a public key
+func (h *BlockHandler) Block(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	var req request.BlockRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	// Block the key
+	block, err := h.blockService.Block(c.Request().Context(), userID, req.PublicKey)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, response.NewSuccessResponse(response.BlockResponse{
+		BlockedPubKey: block.BlockedPubKey,
+		CreatedAt:     block.CreatedAt.Format(time.RFC3339),
+	}))
+}
+
+// GetBlocks gets the public keys the current user has blocked
+func (h *BlockHandler) GetBlocks(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse query parameters
+	var req request.GetBlocksRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Set defaults
+	req.Limit, _ = pageLimit(req.Limit, defaultPageSize, maxPageSize)
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	// Get blocks
+	blocks, err := h.blockService.GetBlocks(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// All blocks are loaded, so the page is cut here and the total is known
+	total := len(blocks)
+	blocks = blocks[min(req.Offset, total):]
+	blocks, pagination := response.Paginate(blocks, req.Limit, req.Offset)
+	pagination.Total = &total
+
+	blockResponses := make([]response.BlockResponse, len(blocks))
+	for i, block := range blocks {
+		blockResponses[i] = response.BlockResponse{
+			BlockedPubKey: block.BlockedPubKey,
+			CreatedAt:     block.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.BlocksResponse{
+		Blocks:     blockResponses,
+		Pagination: pagination,
+	}))
+}
+
+// Unblock handles unblocking a public key
+func (h *BlockHandler) Unblock(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Get blocked public key from path
+	blockedPubKey := c.Param("pubkey")
+	if blockedPubKey == "" {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Blocked public key is required", "BAD_REQUEST"))
+	}
+
+	// Unblock the key
+	if err := h.blockService.Unblock(c.Request().Context(), userID, blockedPubKey); err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"deleted": true}))
+}
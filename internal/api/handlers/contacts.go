@@ -10,7 +10,7 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/api/request"
 	"github.com/pzkpfw44/wave-server/internal/api/response"
-	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
@@ -46,25 +46,59 @@ func (h *ContactHandler) AddContact(c echo.Context) error {
 	}
 
 	// Add contact
-	contact, err := h.contactService.AddContact(c.Request().Context(), userID, req.ContactPublicKey, req.Nickname)
+	contact, err := h.contactService.AddContact(c.Request().Context(), userID, req.ContactPublicKey, req.Nickname, req.GroupName)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Add contact failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to add contact", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Format response
 	contactResponse := response.ContactResponse{
 		ContactPubKey: contact.ContactPubKey,
 		Nickname:      contact.Nickname,
+		GroupName:     contact.GroupName,
+		Username:      contact.Username,
 		CreatedAt:     contact.CreatedAt.Format(time.RFC3339),
 	}
 
 	return c.JSON(http.StatusCreated, response.NewSuccessResponse(contactResponse))
 }
 
+// ImportContacts handles adding many contacts at once
+func (h *ContactHandler) ImportContacts(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	var req request.ImportContactsRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	inputs := make([]service.ContactInput, len(req.Contacts))
+	for i, item := range req.Contacts {
+		inputs[i] = service.ContactInput{
+			ContactPubKey: item.ContactPubKey,
+			Nickname:      item.Nickname,
+			GroupName:     item.GroupName,
+		}
+	}
+
+	// Import contacts
+	created, skipped, invalid, err := h.contactService.ImportContacts(c.Request().Context(), userID, inputs)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ImportContactsResponse{
+		Created: created,
+		Skipped: skipped,
+		Invalid: invalid,
+	}))
+}
+
 // GetContacts gets all contacts for the current user
 func (h *ContactHandler) GetContacts(c echo.Context) error {
 	// Get user ID from context
@@ -73,34 +107,82 @@ func (h *ContactHandler) GetContacts(c echo.Context) error {
 		return err
 	}
 
-	// Get contacts
-	contacts, err := h.contactService.GetContacts(c.Request().Context(), userID)
+	// Parse query parameters
+	var req request.GetContactsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Set defaults
+	var fetch int
+	req.Limit, fetch = pageLimit(req.Limit, defaultContactPageSize, maxPageSize)
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	// Get a page of contacts, optionally limited to one group, with one extra to tell whether more follow
+	var contacts []*domain.Contact
+	var total int
+	if req.Group != "" {
+		contacts, total, err = h.contactService.GetContactsByGroup(c.Request().Context(), userID, req.Group, fetch, req.Offset)
+	} else {
+		contacts, total, err = h.contactService.GetContacts(c.Request().Context(), userID, fetch, req.Offset)
+	}
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get contacts failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get contacts", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
+	contacts, pagination := response.Paginate(contacts, req.Limit, req.Offset)
+	pagination.Total = &total
+
 	// Format contacts for response
 	contactResponses := make([]response.ContactResponse, len(contacts))
 	for i, contact := range contacts {
 		contactResponses[i] = response.ContactResponse{
 			ContactPubKey: contact.ContactPubKey,
 			Nickname:      contact.Nickname,
+			GroupName:     contact.GroupName,
+			Username:      contact.Username,
 			CreatedAt:     contact.CreatedAt.Format(time.RFC3339),
 		}
 	}
 
 	// Return contacts
 	contactsResponse := response.ContactsResponse{
-		Contacts: contactResponses,
+		Contacts:   contactResponses,
+		Pagination: pagination,
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(contactsResponse))
 }
 
+// GetGroups lists the current user's contact groups with how many contacts each holds
+func (h *ContactHandler) GetGroups(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Get groups
+	groups, err := h.contactService.GetGroups(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	groupResponses := make([]response.ContactGroupResponse, len(groups))
+	for i, group := range groups {
+		groupResponses[i] = response.ContactGroupResponse{
+			Name:  group.Name,
+			Count: group.Count,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ContactGroupsResponse{
+		Groups: groupResponses,
+	}))
+}
+
 // GetContact gets a specific contact
 func (h *ContactHandler) GetContact(c echo.Context) error {
 	// Get user ID from context
@@ -118,23 +200,47 @@ func (h *ContactHandler) GetContact(c echo.Context) error {
 	// Get contact
 	contact, err := h.contactService.GetContact(c.Request().Context(), userID, contactPubKey)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get contact failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get contact", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Format response
 	contactResponse := response.ContactResponse{
 		ContactPubKey: contact.ContactPubKey,
 		Nickname:      contact.Nickname,
+		GroupName:     contact.GroupName,
+		Username:      contact.Username,
 		CreatedAt:     contact.CreatedAt.Format(time.RFC3339),
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(contactResponse))
 }
 
+// GetFingerprint gets the safety number for verifying a contact's public key
+func (h *ContactHandler) GetFingerprint(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Get contact public key from path
+	contactPubKey := c.Param("pubkey")
+	if contactPubKey == "" {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Contact public key is required", "BAD_REQUEST"))
+	}
+
+	// Derive the safety number
+	safetyNumber, err := h.contactService.GetSafetyNumber(c.Request().Context(), userID, contactPubKey)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.FingerprintResponse{
+		ContactPubKey: contactPubKey,
+		SafetyNumber:  safetyNumber,
+	}))
+}
+
 // UpdateContact updates a contact
 func (h *ContactHandler) UpdateContact(c echo.Context) error {
 	// Get user ID from context
@@ -156,19 +262,17 @@ func (h *ContactHandler) UpdateContact(c echo.Context) error {
 	}
 
 	// Update contact
-	contact, err := h.contactService.UpdateContact(c.Request().Context(), userID, contactPubKey, req.Nickname)
+	contact, err := h.contactService.UpdateContact(c.Request().Context(), userID, contactPubKey, req.Nickname, req.GroupName)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Update contact failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to update contact", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Format response
 	contactResponse := response.ContactResponse{
 		ContactPubKey: contact.ContactPubKey,
 		Nickname:      contact.Nickname,
+		GroupName:     contact.GroupName,
+		Username:      contact.Username,
 		CreatedAt:     contact.CreatedAt.Format(time.RFC3339),
 	}
 
@@ -191,12 +295,75 @@ func (h *ContactHandler) DeleteContact(c echo.Context) error {
 
 	// Delete contact
 	if err := h.contactService.DeleteContact(c.Request().Context(), userID, contactPubKey); err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Delete contact failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to delete contact", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"deleted": true}))
 }
+
+// CountContacts counts the current user's contacts
+func (h *ContactHandler) CountContacts(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := h.contactService.CountContacts(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ContactCountResponse{Count: count}))
+}
+
+// DeleteContacts deletes many of the current user's contacts at once
+func (h *ContactHandler) DeleteContacts(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	var req request.DeleteContactsRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	deleted, err := h.contactService.DeleteContacts(c.Request().Context(), userID, req.ContactPubKeys)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.DeleteContactsResponse{Deleted: deleted}))
+}
+
+// GetIncomingContacts gets the users who have added the current user as a contact
+func (h *ContactHandler) GetIncomingContacts(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Get incoming contacts
+	incoming, err := h.contactService.GetIncomingContacts(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Format incoming contacts for response
+	incomingResponses := make([]response.IncomingContactResponse, len(incoming))
+	for i, contact := range incoming {
+		incomingResponses[i] = response.IncomingContactResponse{
+			UserID:   contact.UserID,
+			Username: contact.Username,
+			AddedAt:  contact.AddedAt.Format(time.RFC3339),
+		}
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.IncomingContactsResponse{
+		Contacts: incomingResponses,
+	}))
+}
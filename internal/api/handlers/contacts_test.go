@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/service"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+// newTestDatabase connects to the test database and runs migrations
+// Handler tests that need a database are skipped when testutil.DatabaseEnv is not set
+func newTestDatabase(t *testing.T) *repository.Database {
+	t.Helper()
+
+	db := &repository.Database{
+		Pool:   testutil.NewPool(t),
+		Logger: zaptest.NewLogger(t),
+		Config: &config.Config{},
+	}
+	require.NoError(t, db.RunMigrations(context.Background()))
+
+	return db
+}
+
+// createTestUser stores a user with a unique ID and public key, deleted with their contacts when the test ends
+func createTestUser(t *testing.T, userRepo *repository.UserRepository, discoverable bool) *domain.User {
+	t.Helper()
+
+	ctx := context.Background()
+	user := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, user))
+	require.NoError(t, userRepo.SetDiscoverable(ctx, user.UserID, discoverable))
+	t.Cleanup(func() { _ = userRepo.Delete(context.Background(), user.UserID) })
+
+	return user
+}
+
+func TestGetContactsShowsOnlyDiscoverableUsernames(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	contactRepo := repository.NewContactRepository(db)
+	h := NewContactHandler(service.NewContactService(contactRepo, userRepo, zaptest.NewLogger(t)), zaptest.NewLogger(t))
+
+	owner := createTestUser(t, userRepo, true)
+	discoverable := createTestUser(t, userRepo, true)
+	hidden := createTestUser(t, userRepo, false)
+	discoverableKey := base64.URLEncoding.EncodeToString(discoverable.PublicKey)
+	hiddenKey := base64.URLEncoding.EncodeToString(hidden.PublicKey)
+	t.Cleanup(func() {
+		_, _ = db.Pool.Exec(context.Background(), "DELETE FROM contacts WHERE user_id = $1", owner.UserID)
+	})
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(owner.UserID, discoverableKey, "Discoverable")))
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(owner.UserID, hiddenKey, "Hidden")))
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/contacts", nil), rec)
+	c.Set("user_id", owner.UserID)
+	require.NoError(t, h.GetContacts(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Data struct {
+			Contacts []map[string]interface{} `json:"contacts"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Data.Contacts, 2)
+
+	contacts := make(map[string]map[string]interface{})
+	for _, contact := range body.Data.Contacts {
+		contacts[contact["contact_pubkey"].(string)] = contact
+	}
+	assert.Equal(t, discoverable.Username, contacts[discoverableKey]["username"])
+	assert.NotContains(t, contacts[hiddenKey], "username", "a user who isn't discoverable is never named")
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+// enumerationGuard blunts username enumeration on endpoints that look users up by username
+type enumerationGuard struct {
+	enabled  bool
+	maxDelay time.Duration
+	sleep    func(time.Duration)
+}
+
+// newEnumerationGuard creates an enumeration guard from the anti-enumeration config
+func newEnumerationGuard(cfg *config.Config) *enumerationGuard {
+	return &enumerationGuard{
+		enabled:  cfg.AntiEnumeration.Enabled,
+		maxDelay: cfg.AntiEnumeration.MaxDelay,
+		sleep:    time.Sleep,
+	}
+}
+
+// lookupDone handles the result of a username lookup, whether it succeeded or not
+// When enabled it waits a random delay on both outcomes, so timing doesn't tell them apart,
+// and replaces not found errors with one that doesn't echo the username
+func (g *enumerationGuard) lookupDone(err error) error {
+	if !g.enabled {
+		return err
+	}
+
+	if g.maxDelay > 0 {
+		g.sleep(rand.N(g.maxDelay))
+	}
+
+	if err == nil {
+		return nil
+	}
+	if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+		return errors.NewNotFoundError("User")
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+func newTestEnumerationGuard(enabled bool) (*enumerationGuard, *[]time.Duration) {
+	cfg := &config.Config{}
+	cfg.AntiEnumeration.Enabled = enabled
+	cfg.AntiEnumeration.MaxDelay = 100 * time.Millisecond
+
+	var slept []time.Duration
+	guard := newEnumerationGuard(cfg)
+	guard.sleep = func(d time.Duration) { slept = append(slept, d) }
+	return guard, &slept
+}
+
+func TestEnumerationGuardDisabled(t *testing.T) {
+	guard, slept := newTestEnumerationGuard(false)
+
+	notFound := errors.NewNotFoundError(fmt.Sprintf("User with username '%s'", "alice"))
+	assert.Same(t, notFound, guard.lookupDone(notFound))
+	assert.NoError(t, guard.lookupDone(nil))
+	assert.Empty(t, *slept)
+}
+
+func TestEnumerationGuardEnabled(t *testing.T) {
+	guard, slept := newTestEnumerationGuard(true)
+
+	err := guard.lookupDone(errors.NewNotFoundError(fmt.Sprintf("User with username '%s'", "alice")))
+	appErr, ok := errors.IsAppError(err)
+	assert.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+	assert.NotContains(t, appErr.Message, "alice")
+
+	if assert.Len(t, *slept, 1) {
+		assert.GreaterOrEqual(t, (*slept)[0], time.Duration(0))
+		assert.Less(t, (*slept)[0], 100*time.Millisecond)
+	}
+
+	// Other errors are delayed but passed through unchanged
+	unauthenticated := errors.NewUnauthenticatedError("Invalid username")
+	assert.Same(t, unauthenticated, guard.lookupDone(unauthenticated))
+	assert.Len(t, *slept, 2)
+
+	// Successful lookups are delayed too, so they can't be told apart by timing
+	assert.NoError(t, guard.lookupDone(nil))
+	assert.Len(t, *slept, 3)
+}
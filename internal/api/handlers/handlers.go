@@ -1,45 +1,117 @@
 package handlers
 
 import (
+	"context"
+
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
 // Handler is a container for all handlers
 type Handler struct {
-	Auth    *AuthHandler
-	Message *MessageHandler
-	Contact *ContactHandler
-	Key     *KeyHandler
-	Account *AccountHandler
-	logger  *zap.Logger
+	Auth      *AuthHandler
+	Message   *MessageHandler
+	Contact   *ContactHandler
+	Block     *BlockHandler
+	Key       *KeyHandler
+	User      *UserHandler
+	Account   *AccountHandler
+	Admin     *AdminHandler
+	WebSocket *WebSocketHandler
+	Stream    *StreamHandler
+	hub       *realtime.Hub
+	auth      *service.AuthService
+	messages  *service.MessageService
+	users     *service.UserService
+	ctx       context.Context // Context of the background jobs; cancelled by Close
+	cancel    context.CancelFunc
+	jobs      []<-chan struct{} // Closed as each background job returns
+	logger    *zap.Logger
+}
+
+// Services are the services shared by the handlers, and by any middleware that needs them
+type Services struct {
+	User    *service.UserService
+	Auth    *service.AuthService
+	Block   *service.BlockService
+	Message *service.MessageService
+	Contact *service.ContactService
+	Account *service.AccountService
+	Admin   *service.AdminService
 }
 
-// NewHandler creates a new Handler with all handlers
-func NewHandler(db *repository.Database, cfg *config.Config, logger *zap.Logger) *Handler {
+// NewServices creates one of each service on top of the database
+// New messages are pushed to the sockets subscribed to hub
+func NewServices(db *repository.Database, hub *realtime.Hub, cfg *config.Config, logger *zap.Logger) Services {
 	// Create repositories
 	userRepo := repository.NewUserRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
 	contactRepo := repository.NewContactRepository(db)
 	tokenRepo := repository.NewTokenRepository(db)
+	blockRepo := repository.NewBlockRepository(db)
+	statsRepo := repository.NewStatsRepository(db)
 
 	// Create services
-	userService := service.NewUserService(userRepo, logger)
-	authService := service.NewAuthService(userRepo, tokenRepo, cfg, logger)
-	messageService := service.NewMessageService(messageRepo, userRepo, logger)
-	contactService := service.NewContactService(contactRepo, logger)
-	accountService := service.NewAccountService(userRepo, contactRepo, messageRepo, tokenRepo, logger)
+	blockService := service.NewBlockService(blockRepo, logger)
+	accountService := service.NewAccountService(db, userRepo, contactRepo, messageRepo, tokenRepo, hub, cfg, logger)
+	return Services{
+		User:    service.NewUserService(userRepo, accountService, cfg, logger),
+		Auth:    service.NewAuthService(userRepo, tokenRepo, cfg, logger),
+		Block:   blockService,
+		Message: service.NewMessageService(messageRepo, userRepo, blockService, hub, cfg, logger),
+		Contact: service.NewContactService(contactRepo, userRepo, logger),
+		Account: accountService,
+		Admin:   service.NewAdminService(statsRepo, logger),
+	}
+}
+
+// NewHandler creates a new Handler with all handlers, using services built once by the caller
+// hub must be the one the message service pushes to; background jobs run until ctx is cancelled or Close is called
+func NewHandler(ctx context.Context, services Services, hub *realtime.Hub, cfg *config.Config, logger *zap.Logger) *Handler {
+	ctx, cancel := context.WithCancel(ctx)
 
 	// Create handlers
 	return &Handler{
-		Auth:    NewAuthHandler(authService, userService, cfg, logger),
-		Message: NewMessageHandler(messageService, userService, logger),
-		Contact: NewContactHandler(contactService, logger),
-		Key:     NewKeyHandler(userService, logger),
-		Account: NewAccountHandler(accountService, authService, logger),
-		logger:  logger,
+		Auth:      NewAuthHandler(services.Auth, services.User, cfg, logger),
+		Message:   NewMessageHandler(services.Message, services.User, logger),
+		Contact:   NewContactHandler(services.Contact, logger),
+		Block:     NewBlockHandler(services.Block, logger),
+		Key:       NewKeyHandler(services.User, cfg, logger),
+		User:      NewUserHandler(services.User, logger),
+		Account:   NewAccountHandler(services.Account, services.Auth, logger),
+		Admin:     NewAdminHandler(services.Admin, services.User, cfg, logger),
+		WebSocket: NewWebSocketHandler(hub, services.User, cfg, logger),
+		Stream:    NewStreamHandler(hub, services.Message, services.User, logger),
+		hub:       hub,
+		auth:      services.Auth,
+		messages:  services.Message,
+		users:     services.User,
+		ctx:       ctx,
+		cancel:    cancel,
+		logger:    logger,
+	}
+}
+
+// ScheduleCleanup starts the background jobs that delete expired data and inactive users, which run until Close is called
+func (h *Handler) ScheduleCleanup() {
+	h.jobs = append(h.jobs,
+		h.auth.ScheduleTokenCleanup(h.ctx),
+		h.messages.ScheduleExpiredCleanup(h.ctx),
+		h.messages.ScheduleDeletedPurge(h.ctx),
+		h.users.ScheduleInactiveCleanup(h.ctx),
+	)
+}
+
+// Close stops the background jobs, waiting for any cleanup in progress, and disconnects all open WebSockets and message streams
+// It is safe to call more than once
+func (h *Handler) Close() {
+	h.cancel()
+	for _, done := range h.jobs {
+		<-done
 	}
+	h.hub.Close()
 }
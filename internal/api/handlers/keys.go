@@ -9,23 +9,26 @@ import (
 
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/api/response"
-	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
 // KeyHandler handles key-related requests
 type KeyHandler struct {
 	userService *service.UserService
+	enumeration *enumerationGuard
 	logger      *zap.Logger
 }
 
 // NewKeyHandler creates a new key handler
 func NewKeyHandler(
 	userService *service.UserService,
+	cfg *config.Config,
 	logger *zap.Logger,
 ) *KeyHandler {
 	return &KeyHandler{
 		userService: userService,
+		enumeration: newEnumerationGuard(cfg),
 		logger:      logger.With(zap.String("handler", "key")),
 	}
 }
@@ -43,11 +46,7 @@ func (h *KeyHandler) GetPublicKey(c echo.Context) error {
 
 		user, err := h.userService.GetByID(c.Request().Context(), userID)
 		if err != nil {
-			if appErr, ok := errors.IsAppError(err); ok {
-				return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-			}
-			h.logger.Error("Get user failed", zap.Error(err))
-			return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get user information", "INTERNAL"))
+			return response.WriteError(c, err)
 		}
 
 		// Return public key
@@ -56,18 +55,13 @@ func (h *KeyHandler) GetPublicKey(c echo.Context) error {
 	}
 
 	// Get public key for specified username
-	user, err := h.userService.GetByUsername(c.Request().Context(), username)
-	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get user failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get user information", "INTERNAL"))
+	publicKey, err := h.userService.GetPublicKey(c.Request().Context(), username)
+	if err = h.enumeration.lookupDone(err); err != nil {
+		return response.WriteError(c, err)
 	}
 
 	// Return public key
-	publicKey := base64.URLEncoding.EncodeToString(user.PublicKey)
-	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]string{"public_key": publicKey}))
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]string{"public_key": base64.URLEncoding.EncodeToString(publicKey)}))
 }
 
 // GetEncryptedPrivateKey handles getting a user's encrypted private key
@@ -81,11 +75,7 @@ func (h *KeyHandler) GetEncryptedPrivateKey(c echo.Context) error {
 	// Get encrypted private key
 	privateKeyResponse, err := h.userService.GetEncryptedPrivateKey(c.Request().Context(), userID)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get encrypted private key failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get encrypted private key", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Return encrypted private key
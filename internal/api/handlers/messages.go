@@ -13,7 +13,7 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/request"
 	"github.com/pzkpfw44/wave-server/internal/api/response"
 	"github.com/pzkpfw44/wave-server/internal/domain"
-	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
@@ -62,13 +62,12 @@ func (h *MessageHandler) SendMessage(c echo.Context) error {
 		req.SenderCiphertextKEM,
 		req.SenderCiphertextMsg,
 		req.SenderNonce,
+		req.ReplyToMessageID,
+		time.Duration(req.ExpiresInSeconds)*time.Second,
+		req.MessageID,
 	)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Send message failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to send message", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Construct response
@@ -84,11 +83,77 @@ func (h *MessageHandler) SendMessage(c echo.Context) error {
 		SenderNonce:         base64.URLEncoding.EncodeToString(msg.SenderNonce),
 		Timestamp:           msg.Timestamp.Format(time.RFC3339),
 		Status:              string(msg.Status),
+		ContentHash:         msg.ContentHash,
+	}
+	if msg.ReplyToMessageID != nil {
+		msgResponse.ReplyToMessageID = msg.ReplyToMessageID.String()
+	}
+	if msg.ExpiresAt != nil {
+		msgResponse.ExpiresAt = msg.ExpiresAt.Format(time.RFC3339)
 	}
 
 	return c.JSON(http.StatusCreated, response.NewSuccessResponse(msgResponse))
 }
 
+// SendMultiMessage handles sending one message to several recipients at once
+// Either every copy is stored or none is
+func (h *MessageHandler) SendMultiMessage(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate request
+	var req request.SendMultiMessageRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	// Each recipient counts against the user's send budget, as if they had been sent one by one
+	if err := middleware.TakeSendBudget(c, userID, len(req.Recipients)); err != nil {
+		return err
+	}
+
+	copies := make([]service.RecipientCopy, len(req.Recipients))
+	for i, recipient := range req.Recipients {
+		copies[i] = service.RecipientCopy{
+			RecipientPubKey: recipient.RecipientPubKey,
+			CiphertextKEM:   recipient.CiphertextKEM,
+			CiphertextMsg:   recipient.CiphertextMsg,
+			Nonce:           recipient.Nonce,
+		}
+	}
+
+	// Send the message
+	messages, err := h.messageService.SendMultiMessage(
+		c.Request().Context(),
+		userID,
+		copies,
+		req.SenderCiphertextKEM,
+		req.SenderCiphertextMsg,
+		req.SenderNonce,
+		time.Duration(req.ExpiresInSeconds)*time.Second,
+	)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Construct response
+	multiResponse := response.SendMultiMessageResponse{
+		MessageIDs: make([]string, len(messages)),
+		Timestamp:  messages[0].Timestamp.Format(time.RFC3339),
+	}
+	for i, msg := range messages {
+		multiResponse.MessageIDs[i] = msg.MessageID.String()
+		if msg.Status == domain.MessageStatusFailed {
+			multiResponse.Failed++
+		}
+	}
+
+	return c.JSON(http.StatusCreated, response.NewSuccessResponse(multiResponse))
+}
+
 // GetMessages gets messages for the current user
 func (h *MessageHandler) GetMessages(c echo.Context) error {
 	// Get user ID from context
@@ -104,33 +169,52 @@ func (h *MessageHandler) GetMessages(c echo.Context) error {
 	}
 
 	// Set defaults
-	if req.Limit <= 0 {
-		req.Limit = 100
-	}
-	if req.Limit > 1000 {
-		req.Limit = 1000
+	var fetch int
+	req.Limit, fetch = pageLimit(req.Limit, defaultPageSize, maxPageSize)
+
+	// A since timestamp asks only for messages received after it, for incremental sync
+	var since time.Time
+	if req.Since != "" {
+		if req.Before != "" {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Use either before or since, not both", "BAD_REQUEST"))
+		}
+		since, err = time.Parse(time.RFC3339Nano, req.Since)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid since timestamp, expected RFC 3339", "BAD_REQUEST"))
+		}
 	}
 
 	// Get user to get public key
 	user, err := h.userService.GetByID(c.Request().Context(), userID)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get user failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get user information", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Get messages
 	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
-	messages, err := h.messageService.GetMessagesForUser(c.Request().Context(), userPubKey, req.Limit, req.Offset)
-	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
+	var messages []*domain.Message
+	var pagination response.Pagination
+	if req.Since != "" {
+		// Received messages only, oldest first; the total isn't counted, since syncing clients page until has_more is false
+		messages, err = h.messageService.GetMessagesReceivedSince(c.Request().Context(), userPubKey, since, fetch)
+		if err != nil {
+			return response.WriteError(c, err)
+		}
+		messages, pagination = response.Paginate(messages, req.Limit, 0)
+	} else {
+		// Fetch one extra message to tell whether another page exists
+		var total int
+		messages, total, err = h.messageService.GetMessagesForUser(c.Request().Context(), userPubKey, req.Before, fetch, req.Offset)
+		if err != nil {
+			return response.WriteError(c, err)
 		}
-		h.logger.Error("Get messages failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get messages", "INTERNAL"))
+		if req.Before != "" {
+			req.Offset = 0
+		}
+		messages, pagination = response.Paginate(messages, req.Limit, req.Offset)
+		pagination.Total = &total
 	}
+	h.messageService.MarkDelivered(userPubKey, messages)
 
 	// Format messages for response
 	messageResponses := make([]response.MessageResponse, len(messages))
@@ -138,31 +222,14 @@ func (h *MessageHandler) GetMessages(c echo.Context) error {
 		// Determine if all fields should be included
 		includeAllFields := msg.SenderPubKey == userPubKey
 
-		// Convert message to response
-		msgResp := msg.ToResponse(includeAllFields)
-
-		// Map domain response to API response
-		messageResponses[i] = response.MessageResponse{
-			MessageID:           msgResp.MessageID,
-			SenderPubKey:        msgResp.SenderPubKey,
-			RecipientPubKey:     msgResp.RecipientPubKey,
-			CiphertextKEM:       msgResp.CiphertextKEM,
-			CiphertextMsg:       msgResp.CiphertextMsg,
-			Nonce:               msgResp.Nonce,
-			SenderCiphertextKEM: msgResp.SenderCiphertextKEM,
-			SenderCiphertextMsg: msgResp.SenderCiphertextMsg,
-			SenderNonce:         msgResp.SenderNonce,
-			Timestamp:           msgResp.Timestamp.Format(time.RFC3339),
-			Status:              string(msgResp.Status),
-		}
+		messageResponses[i] = toMessageResponse(msg, includeAllFields)
 	}
 
 	// Return messages
 	messagesResponse := response.MessagesResponse{
-		Messages: messageResponses,
-		Total:    len(messageResponses),
-		Limit:    req.Limit,
-		Offset:   req.Offset,
+		Messages:   messageResponses,
+		Pagination: pagination,
+		NextCursor: nextCursor(messages, pagination),
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(messagesResponse))
@@ -188,38 +255,57 @@ func (h *MessageHandler) GetConversation(c echo.Context) error {
 	}
 
 	// Set defaults
-	if queryParams.Limit <= 0 {
-		queryParams.Limit = 100
+	var fetch int
+	queryParams.Limit, fetch = pageLimit(queryParams.Limit, defaultPageSize, maxPageSize)
+
+	// Pages go back in time from before, or forward from since when ascending
+	var ascending bool
+	switch queryParams.Order {
+	case "", "desc":
+	case "asc":
+		ascending = true
+	default:
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Order must be asc or desc", "BAD_REQUEST"))
 	}
-	if queryParams.Limit > 1000 {
-		queryParams.Limit = 1000
+	cursor := queryParams.Before
+	if ascending {
+		cursor = queryParams.Since
+	}
+	if (ascending && queryParams.Before != "") || (!ascending && queryParams.Since != "") {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Use before with descending order and since with ascending order", "BAD_REQUEST"))
 	}
 
 	// Get user to get public key
 	user, err := h.userService.GetByID(c.Request().Context(), userID)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get user failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get user information", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	// Get conversation
 	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
-	messages, err := h.messageService.GetConversation(
+	// Fetch one extra message to tell whether another page exists
+	messages, total, err := h.messageService.GetConversation(
 		c.Request().Context(),
 		userPubKey,
 		contactPubKey,
-		queryParams.Limit,
+		cursor,
+		ascending,
+		fetch,
 		queryParams.Offset,
 	)
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Get conversation failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to get conversation", "INTERNAL"))
+		return response.WriteError(c, err)
+	}
+	if cursor != "" {
+		queryParams.Offset = 0
+	}
+	messages, pagination := response.Paginate(messages, queryParams.Limit, queryParams.Offset)
+	pagination.Total = &total
+
+	// Get the replied-to messages for context
+	replyReferences, err := h.messageService.GetReplyReferences(c.Request().Context(), messages)
+	if err != nil {
+		return response.WriteError(c, err)
 	}
 
 	// Format messages for response
@@ -228,38 +314,99 @@ func (h *MessageHandler) GetConversation(c echo.Context) error {
 		// Determine if all fields should be included
 		includeAllFields := msg.SenderPubKey == userPubKey
 
-		// Convert message to response
-		msgResp := msg.ToResponse(includeAllFields)
-
-		// Map domain response to API response
-		messageResponses[i] = response.MessageResponse{
-			MessageID:           msgResp.MessageID,
-			SenderPubKey:        msgResp.SenderPubKey,
-			RecipientPubKey:     msgResp.RecipientPubKey,
-			CiphertextKEM:       msgResp.CiphertextKEM,
-			CiphertextMsg:       msgResp.CiphertextMsg,
-			Nonce:               msgResp.Nonce,
-			SenderCiphertextKEM: msgResp.SenderCiphertextKEM,
-			SenderCiphertextMsg: msgResp.SenderCiphertextMsg,
-			SenderNonce:         msgResp.SenderNonce,
-			Timestamp:           msgResp.Timestamp.Format(time.RFC3339),
-			Status:              string(msgResp.Status),
+		messageResponses[i] = toMessageResponse(msg, includeAllFields)
+
+		if msg.ReplyToMessageID != nil {
+			if ref, ok := replyReferences[*msg.ReplyToMessageID]; ok {
+				messageResponses[i].ReplyTo = &response.MessageReferenceResponse{
+					MessageID:    ref.MessageID.String(),
+					SenderPubKey: ref.SenderPubKey,
+					Timestamp:    ref.Timestamp.Format(time.RFC3339),
+				}
+			}
 		}
 	}
 
 	// Return messages
 	messagesResponse := response.MessagesResponse{
-		Messages: messageResponses,
-		Total:    len(messageResponses),
-		Limit:    queryParams.Limit,
-		Offset:   queryParams.Offset,
+		Messages:   messageResponses,
+		Pagination: pagination,
+		NextCursor: nextCursor(messages, pagination),
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(messagesResponse))
 }
 
-// UpdateMessageStatus updates a message's status
+// SearchMessages finds the current user's sent and received messages by time range, peer and status
+// Message bodies are end-to-end encrypted, so the search only ever matches metadata
+func (h *MessageHandler) SearchMessages(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate query parameters
+	var req request.SearchMessagesRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	// Set defaults
+	var fetch int
+	req.Limit, fetch = pageLimit(req.Limit, defaultPageSize, maxPageSize)
+
+	opts := repository.SearchOptions{
+		Peer:   req.Peer,
+		Status: domain.MessageStatus(req.Status),
+		Limit:  fetch,
+		Offset: req.Offset,
+	}
+	if req.From != "" {
+		if opts.From, err = time.Parse(time.RFC3339Nano, req.From); err != nil {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid from timestamp, expected RFC 3339", "BAD_REQUEST"))
+		}
+	}
+	if req.To != "" {
+		if opts.To, err = time.Parse(time.RFC3339Nano, req.To); err != nil {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid to timestamp, expected RFC 3339", "BAD_REQUEST"))
+		}
+	}
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	messages, err := h.messageService.SearchMessages(c.Request().Context(), userPubKey, opts)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+	messages, pagination := response.Paginate(messages, req.Limit, req.Offset)
+
+	// Format messages for response
+	messageResponses := make([]response.MessageResponse, len(messages))
+	for i, msg := range messages {
+		messageResponses[i] = toMessageResponse(msg, msg.SenderPubKey == userPubKey)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.SearchMessagesResponse{
+		Messages:   messageResponses,
+		Pagination: pagination,
+		Scope:      response.SearchScopeMetadata,
+	}))
+}
+
+// UpdateMessageStatus updates the status of a message the current user received
 func (h *MessageHandler) UpdateMessageStatus(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
 	// Parse message ID
 	messageIDStr := c.Param("message_id")
 	if messageIDStr == "" {
@@ -278,14 +425,314 @@ func (h *MessageHandler) UpdateMessageStatus(c echo.Context) error {
 	}
 
 	// Update message status
-	err = h.messageService.UpdateMessageStatus(c.Request().Context(), messageID, domain.MessageStatus(req.Status))
+	err = h.messageService.UpdateMessageStatus(c.Request().Context(), userID, messageID, domain.MessageStatus(req.Status))
 	if err != nil {
-		if appErr, ok := errors.IsAppError(err); ok {
-			return c.JSON(appErr.Status, response.NewErrorResponse(appErr.Message, appErr.Code))
-		}
-		h.logger.Error("Update message status failed", zap.Error(err))
-		return c.JSON(http.StatusInternalServerError, response.NewErrorResponse("Failed to update message status", "INTERNAL"))
+		return response.WriteError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]string{"status": "updated"}))
 }
+
+// UpdateMessageStatusBatch updates the status of several messages the current user received in one request
+func (h *MessageHandler) UpdateMessageStatusBatch(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse request body
+	var req request.UpdateMessageStatusBatchRequest
+	if err := request.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	messageIDs := make([]uuid.UUID, len(req.MessageIDs))
+	for i, id := range req.MessageIDs {
+		messageID, err := uuid.Parse(id)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid message ID format", "BAD_REQUEST"))
+		}
+		messageIDs[i] = messageID
+	}
+
+	// Update message statuses
+	updated, err := h.messageService.UpdateMessageStatuses(c.Request().Context(), userID, messageIDs, domain.MessageStatus(req.Status))
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]int64{"updated": updated}))
+}
+
+// GetMessageTimeline gets the delivery timeline of a message the current user sent or received
+func (h *MessageHandler) GetMessageTimeline(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse message ID
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid message ID format", "BAD_REQUEST"))
+	}
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Get message
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	msg, err := h.messageService.GetMessageTimeline(c.Request().Context(), userPubKey, messageID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Format response
+	timeline := response.MessageTimelineResponse{
+		MessageID: msg.MessageID.String(),
+		Status:    string(msg.Status),
+		CreatedAt: msg.Timestamp.Format(time.RFC3339),
+	}
+	if msg.DeliveredAt != nil {
+		deliveredAt := msg.DeliveredAt.Format(time.RFC3339)
+		timeline.DeliveredAt = &deliveredAt
+	}
+	if msg.ReadAt != nil {
+		readAt := msg.ReadAt.Format(time.RFC3339)
+		timeline.ReadAt = &readAt
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(timeline))
+}
+
+// GetUnreadCount counts the current user's received messages that are not yet delivered or read
+func (h *MessageHandler) GetUnreadCount(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse query parameters
+	var req request.GetUnreadCountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	count, bySender, err := h.messageService.CountUnread(c.Request().Context(), userPubKey, req.BySender)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.UnreadCountResponse{
+		Count:    count,
+		BySender: bySender,
+	}))
+}
+
+// ListConversations lists the peers the current user has exchanged messages with, most recent first
+func (h *MessageHandler) ListConversations(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse query parameters
+	var req request.ListConversationsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Set defaults
+	req.Limit, _ = pageLimit(req.Limit, defaultPageSize, maxPageSize)
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	conversations, err := h.messageService.ListConversations(c.Request().Context(), userPubKey)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// All conversations are loaded, so the page is cut here and the total is known
+	total := len(conversations)
+	conversations = conversations[min(req.Offset, total):]
+	conversations, pagination := response.Paginate(conversations, req.Limit, req.Offset)
+	pagination.Total = &total
+
+	// Format conversations for response
+	conversationResponses := make([]response.ConversationResponse, len(conversations))
+	for i, conversation := range conversations {
+		conversationResponses[i] = response.ConversationResponse{
+			PeerPubKey:    conversation.PeerPubKey,
+			LastMessageAt: conversation.LastMessageAt.Format(time.RFC3339),
+			UnreadCount:   conversation.UnreadCount,
+		}
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.ConversationsResponse{
+		Conversations: conversationResponses,
+		Pagination:    pagination,
+	}))
+}
+
+// GetFailedMessages gets the current user's sent messages that could not be delivered
+func (h *MessageHandler) GetFailedMessages(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse query parameters
+	var req request.GetMessagesRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid query parameters", "BAD_REQUEST"))
+	}
+
+	// Set defaults
+	var fetch int
+	req.Limit, fetch = pageLimit(req.Limit, defaultPageSize, maxPageSize)
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Fetch one extra message to tell whether another page exists
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	messages, err := h.messageService.GetFailedMessages(c.Request().Context(), userPubKey, fetch, req.Offset)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+	messages, pagination := response.Paginate(messages, req.Limit, req.Offset)
+
+	// Format messages for response
+	messageResponses := make([]response.MessageResponse, len(messages))
+	for i, msg := range messages {
+		messageResponses[i] = toMessageResponse(msg, true)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(response.MessagesResponse{
+		Messages:   messageResponses,
+		Pagination: pagination,
+	}))
+}
+
+// ResendMessage retries delivery of one of the current user's failed messages
+func (h *MessageHandler) ResendMessage(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse message ID
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid message ID format", "BAD_REQUEST"))
+	}
+
+	// Get user to get public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	// Resend message
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	msg, err := h.messageService.ResendMessage(c.Request().Context(), userPubKey, messageID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(toMessageResponse(msg, true)))
+}
+
+// DeleteMessage permanently deletes a message the current user sent
+func (h *MessageHandler) DeleteMessage(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Parse message ID
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid message ID format", "BAD_REQUEST"))
+	}
+
+	// Delete message
+	if err := h.messageService.DeleteMessage(c.Request().Context(), userID, messageID); err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(map[string]bool{"deleted": true}))
+}
+
+// nextCursor returns the cursor for the page after messages, or an empty string on the last page
+// Messages are newest first, so the next page starts before the oldest one
+func nextCursor(messages []*domain.Message, pagination response.Pagination) string {
+	if !pagination.HasMore || len(messages) == 0 {
+		return ""
+	}
+	return messages[len(messages)-1].Timestamp.Format(time.RFC3339Nano)
+}
+
+// toMessageResponse maps a message to its API response
+func toMessageResponse(msg *domain.Message, includeAllFields bool) response.MessageResponse {
+	msgResp := msg.ToResponse(includeAllFields)
+
+	resp := response.MessageResponse{
+		MessageID:           msgResp.MessageID,
+		SenderPubKey:        msgResp.SenderPubKey,
+		RecipientPubKey:     msgResp.RecipientPubKey,
+		CiphertextKEM:       msgResp.CiphertextKEM,
+		CiphertextMsg:       msgResp.CiphertextMsg,
+		Nonce:               msgResp.Nonce,
+		SenderCiphertextKEM: msgResp.SenderCiphertextKEM,
+		SenderCiphertextMsg: msgResp.SenderCiphertextMsg,
+		SenderNonce:         msgResp.SenderNonce,
+		Timestamp:           msgResp.Timestamp.Format(time.RFC3339),
+		Status:              string(msgResp.Status),
+		ContentHash:         msgResp.ContentHash,
+		ReplyToMessageID:    msgResp.ReplyToMessageID,
+	}
+	if msgResp.ExpiresAt != nil {
+		resp.ExpiresAt = msgResp.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return resp
+}
+
+// toReceiptResponse converts a domain.MessageReceipt to a response.ReceiptResponse
+func toReceiptResponse(receipt *domain.MessageReceipt) response.ReceiptResponse {
+	return response.ReceiptResponse{
+		Type:      "receipt",
+		MessageID: receipt.MessageID.String(),
+		Status:    string(receipt.Status),
+		UpdatedAt: receipt.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+)
+
+// sendMultiBody builds a /send-multi body for n recipients, each with a ciphertext of the largest allowed size
+func sendMultiBody(t *testing.T, cfg *config.Config, n int) []byte {
+	t.Helper()
+
+	ciphertext := base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{1}, cfg.Messages.MaxCiphertextBytes))
+	kem := base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{2}, 1568))
+	recipients := make([]request.MessageRecipient, n)
+	for i := range recipients {
+		recipients[i] = request.MessageRecipient{
+			RecipientPubKey: base64.URLEncoding.EncodeToString(bytes.Repeat([]byte{byte(i)}, 1568)),
+			CiphertextKEM:   kem,
+			CiphertextMsg:   ciphertext,
+			Nonce:           kem[:32],
+		}
+	}
+
+	body, err := json.Marshal(request.SendMultiMessageRequest{
+		Recipients:          recipients,
+		SenderCiphertextKEM: kem,
+		SenderCiphertextMsg: ciphertext,
+		SenderNonce:         kem[:32],
+	})
+	require.NoError(t, err)
+	return body
+}
+
+// newSendMultiEcho serves /send-multi as the API does, for a logged in user with a send budget of budget messages
+func newSendMultiEcho(t *testing.T, cfg *config.Config, budget int, handler echo.HandlerFunc) *echo.Echo {
+	e := echo.New()
+	e.Validator = request.NewValidator(zaptest.NewLogger(t))
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+	e.Use(middleware.BodyLimit(cfg))
+
+	limiter := middleware.NewRateLimiter(budget, time.Minute, zaptest.NewLogger(t))
+	t.Cleanup(func() { _ = limiter.Close() })
+	login := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user_id", "alice")
+			return next(c)
+		}
+	}
+	e.POST("/api/v1/messages/send-multi", handler, login, limiter.SendBudget())
+	return e
+}
+
+// postJSON posts a JSON body to the path and returns the response
+func postJSON(e *echo.Echo, path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSendMultiMessageAtRecipientCap(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Messages.MaxCiphertextBytes = 64 << 10
+
+	// Stands in for the handler past validation and the send budget
+	e := newSendMultiEcho(t, cfg, 1000, func(c echo.Context) error {
+		var req request.SendMultiMessageRequest
+		if err := request.ValidateRequest(c, &req); err != nil {
+			return err
+		}
+		if err := middleware.TakeSendBudget(c, "alice", len(req.Recipients)); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusCreated)
+	})
+
+	// A full fan-out at the largest ciphertext size fits the body limit
+	assert.Equal(t, http.StatusCreated, postJSON(e, "/api/v1/messages/send-multi", sendMultiBody(t, cfg, domain.MaxMessageRecipients)).Code)
+
+	// One recipient more is refused by validation rather than the body limit
+	assert.Equal(t, http.StatusUnprocessableEntity, postJSON(e, "/api/v1/messages/send-multi", sendMultiBody(t, cfg, domain.MaxMessageRecipients+1)).Code)
+}
+
+func TestSendMultiMessageCountsEveryRecipient(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Messages.MaxCiphertextBytes = 1024
+
+	// The request is refused before the service is reached, so none is needed
+	h := NewMessageHandler(nil, nil, zaptest.NewLogger(t))
+	e := newSendMultiEcho(t, cfg, domain.MaxMessageRecipients-1, h.SendMultiMessage)
+
+	rec := postJSON(e, "/api/v1/messages/send-multi", sendMultiBody(t, cfg, domain.MaxMessageRecipients))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "RATE_LIMIT_EXCEEDED")
+	assert.Equal(t, "99", rec.Header().Get("X-RateLimit-Remaining"), "a refused request uses none of the budget")
+}
@@ -0,0 +1,23 @@
+package handlers
+
+// Page sizes of the listing endpoints; the services trust the sizes they are given, so these are the only bounds
+const (
+	defaultPageSize        = 100  // Messages, conversations and blocks listed when the client gives no limit
+	maxPageSize            = 1000 // Largest page of messages, conversations, blocks or contacts
+	defaultContactPageSize = 200
+	defaultSessionPageSize = 20
+	maxSessionPageSize     = 100
+)
+
+// pageLimit clamps a requested page size between 1 and max, using def when the client gave none
+// It also returns how many rows to fetch: one more than the page holds, so response.Paginate can tell whether another page follows
+func pageLimit(limit, def, max int) (size, fetch int) {
+	size = limit
+	if size <= 0 {
+		size = def
+	}
+	if size > max {
+		size = max
+	}
+	return size, size + 1
+}
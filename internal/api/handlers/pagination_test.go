@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLimit(t *testing.T) {
+	for _, tt := range []struct{ limit, size int }{
+		{0, defaultPageSize},
+		{-5, defaultPageSize},
+		{50, 50},
+		{maxPageSize, maxPageSize},
+		{maxPageSize + 1, maxPageSize},
+	} {
+		size, fetch := pageLimit(tt.limit, defaultPageSize, maxPageSize)
+		assert.Equal(t, tt.size, size, tt.limit)
+		assert.Equal(t, tt.size+1, fetch, "one extra row is fetched to detect further pages")
+	}
+}
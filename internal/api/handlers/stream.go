@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+const (
+	// sseKeepaliveInterval is how often a comment is sent so proxies keep the stream open
+	sseKeepaliveInterval = 15 * time.Second
+
+	// sseReplayLimit caps how many missed messages are replayed on reconnect
+	sseReplayLimit = 100
+)
+
+// StreamHandler streams new messages to clients over Server-Sent Events
+type StreamHandler struct {
+	hub               *realtime.Hub
+	messageService    *service.MessageService
+	userService       *service.UserService
+	keepaliveInterval time.Duration
+	logger            *zap.Logger
+}
+
+// NewStreamHandler creates a new stream handler
+func NewStreamHandler(
+	hub *realtime.Hub,
+	messageService *service.MessageService,
+	userService *service.UserService,
+	logger *zap.Logger,
+) *StreamHandler {
+	return &StreamHandler{
+		hub:               hub,
+		messageService:    messageService,
+		userService:       userService,
+		keepaliveInterval: sseKeepaliveInterval,
+		logger:            logger.With(zap.String("handler", "stream")),
+	}
+}
+
+// sseEventID identifies a message in the stream by its timestamp in microseconds, the precision the database stores
+func sseEventID(msg *domain.Message) string {
+	return strconv.FormatInt(msg.Timestamp.Round(time.Microsecond).UnixMicro(), 10)
+}
+
+// parseLastEventID converts a Last-Event-ID header back into the timestamp to replay from
+func parseLastEventID(id string) (time.Time, error) {
+	micros, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || micros < 0 {
+		return time.Time{}, fmt.Errorf("invalid event ID %q", id)
+	}
+	return time.UnixMicro(micros), nil
+}
+
+// StreamMessages streams messages sent to the current user as `message` events
+// Clients reconnecting with Last-Event-ID first receive the messages they missed
+func (h *StreamHandler) StreamMessages(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var since *time.Time
+	if lastEventID := c.Request().Header.Get("Last-Event-ID"); lastEventID != "" {
+		t, err := parseLastEventID(lastEventID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, response.NewErrorResponse("Invalid Last-Event-ID", "BAD_REQUEST"))
+		}
+		since = &t
+	}
+
+	// Messages are routed by public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	// Subscribe before replaying so nothing sent in between is missed
+	sub := h.hub.Subscribe(userPubKey)
+	defer h.hub.Unsubscribe(sub)
+
+	var missed []*domain.Message
+	if since != nil {
+		missed, err = h.messageService.GetMessagesReceivedSince(c.Request().Context(), userPubKey, *since, sseReplayLimit)
+		if err != nil {
+			return response.WriteError(c, err)
+		}
+		h.messageService.MarkDelivered(userPubKey, missed)
+	}
+
+	return h.stream(c, sub, userPubKey, missed)
+}
+
+// stream writes the missed messages and then every published message and receipt until the client disconnects
+func (h *StreamHandler) stream(c echo.Context, sub *realtime.Subscription, userPubKey string, missed []*domain.Message) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	// Replayed messages may also arrive through the subscription
+	replayed := make(map[uuid.UUID]struct{}, len(missed))
+	for _, msg := range missed {
+		if err := h.writeEvent(c, msg, userPubKey); err != nil {
+			return nil
+		}
+		replayed[msg.MessageID] = struct{}{}
+	}
+
+	ticker := time.NewTicker(h.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-sub.Done():
+			return nil
+
+		case msg := <-sub.Messages():
+			if _, ok := replayed[msg.MessageID]; ok {
+				continue
+			}
+			if err := h.writeEvent(c, msg, userPubKey); err != nil {
+				return nil
+			}
+
+		case receipt := <-sub.Receipts():
+			if err := h.writeReceipt(c, receipt); err != nil {
+				return nil
+			}
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ":keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// writeEvent writes a message as a `message` event
+func (h *StreamHandler) writeEvent(c echo.Context, msg *domain.Message, userPubKey string) error {
+	data, err := json.Marshal(toMessageResponse(msg, msg.SenderPubKey == userPubKey))
+	if err != nil {
+		h.logger.Error("Failed to encode message event", zap.Error(err))
+		return err
+	}
+
+	res := c.Response()
+	if _, err := fmt.Fprintf(res, "id: %s\nevent: message\ndata: %s\n\n", sseEventID(msg), data); err != nil {
+		h.logger.Debug("Stream write failed", zap.Error(err))
+		return err
+	}
+	res.Flush()
+
+	return nil
+}
+
+// writeReceipt writes a status receipt as a `receipt` event
+// Receipts carry no id, so Last-Event-ID keeps tracking the last message received
+func (h *StreamHandler) writeReceipt(c echo.Context, receipt *domain.MessageReceipt) error {
+	data, err := json.Marshal(toReceiptResponse(receipt))
+	if err != nil {
+		h.logger.Error("Failed to encode receipt event", zap.Error(err))
+		return err
+	}
+
+	res := c.Response()
+	if _, err := fmt.Fprintf(res, "event: receipt\ndata: %s\n\n", data); err != nil {
+		h.logger.Debug("Stream write failed", zap.Error(err))
+		return err
+	}
+	res.Flush()
+
+	return nil
+}
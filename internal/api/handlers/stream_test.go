@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+)
+
+func TestLastEventIDRoundTrip(t *testing.T) {
+	msg := &domain.Message{Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.UTC)}
+
+	since, err := parseLastEventID(sseEventID(msg))
+	require.NoError(t, err)
+	assert.True(t, since.Equal(msg.Timestamp.Round(time.Microsecond)))
+
+	for _, id := range []string{"abc", "-1", "1.5"} {
+		_, err := parseLastEventID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+// openTestStream serves the stream for pubKey with the given missed messages and returns the client's event reader
+func openTestStream(t *testing.T, hub *realtime.Hub, pubKey string, missed []*domain.Message) (*bufio.Reader, context.CancelFunc) {
+	t.Helper()
+
+	h := NewStreamHandler(hub, nil, nil, zaptest.NewLogger(t))
+	h.keepaliveInterval = 20 * time.Millisecond
+
+	e := echo.New()
+	e.GET("/stream", func(c echo.Context) error {
+		sub := hub.Subscribe(pubKey)
+		defer hub.Unsubscribe(sub)
+		return h.stream(c, sub, pubKey, missed)
+	})
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	return bufio.NewReader(res.Body), cancel
+}
+
+// readEvent reads lines up to the next blank line, skipping keepalive comments
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(lines) > 0 {
+				return lines
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestStreamReplaysThenPushes(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	missed := domain.NewMessage("sender", "recipient", nil, nil, nil, nil, nil, nil)
+	reader, _ := openTestStream(t, hub, "recipient", []*domain.Message{missed})
+
+	event := readEvent(t, reader)
+	require.Len(t, event, 3)
+	assert.Equal(t, "id: "+sseEventID(missed), event[0])
+	assert.Equal(t, "event: message", event[1])
+	assert.Contains(t, event[2], missed.MessageID.String())
+
+	// Replayed messages arriving again through the hub are skipped
+	waitForSubscribers(t, hub, "recipient", 1)
+	hub.Publish(missed)
+	live := domain.NewMessage("sender", "recipient", nil, nil, nil, nil, nil, nil)
+	hub.Publish(live)
+
+	event = readEvent(t, reader)
+	assert.Contains(t, event[2], live.MessageID.String())
+}
+
+func TestStreamSendsKeepalive(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	reader, _ := openTestStream(t, hub, "recipient", nil)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ":keepalive\n", line)
+}
+
+func TestStreamDisconnectUnsubscribes(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	_, cancel := openTestStream(t, hub, "recipient", nil)
+	waitForSubscribers(t, hub, "recipient", 1)
+
+	cancel()
+
+	waitForSubscribers(t, hub, "recipient", 0)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+// UserHandler handles requests about the current user
+type UserHandler struct {
+	userService *service.UserService
+	logger      *zap.Logger
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(userService *service.UserService, logger *zap.Logger) *UserHandler {
+	return &UserHandler{
+		userService: userService,
+		logger:      logger.With(zap.String("handler", "user")),
+	}
+}
+
+// GetMe handles getting the current user's profile
+func (h *UserHandler) GetMe(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response.NewSuccessResponse(user.ToPublic()))
+}
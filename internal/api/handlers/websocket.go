@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/middleware"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+	"github.com/pzkpfw44/wave-server/internal/service"
+)
+
+const (
+	// wsWriteWait is how long a single write to the socket may take
+	wsWriteWait = 10 * time.Second
+
+	// wsPongWait is how long to wait for a pong before treating the client as gone
+	wsPongWait = 60 * time.Second
+
+	// wsPingPeriod is how often pings are sent; it must be shorter than wsPongWait
+	wsPingPeriod = (wsPongWait * 9) / 10
+
+	// wsMaxMessageSize caps client frames; clients only send control frames
+	wsMaxMessageSize = 512
+)
+
+// WebSocketHandler pushes new messages to connected recipients
+type WebSocketHandler struct {
+	hub         *realtime.Hub
+	userService *service.UserService
+	upgrader    websocket.Upgrader
+	logger      *zap.Logger
+}
+
+// NewWebSocketHandler creates a new WebSocket handler
+func NewWebSocketHandler(hub *realtime.Hub, userService *service.UserService, cfg *config.Config, logger *zap.Logger) *WebSocketHandler {
+	return &WebSocketHandler{
+		hub:         hub,
+		userService: userService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     allowedOrigin(cfg.Server.AllowedOrigins),
+		},
+		logger: logger.With(zap.String("handler", "websocket")),
+	}
+}
+
+// allowedOrigin checks a browser's Origin header against the CORS allowed origins
+// Requests without an Origin header come from non-browser clients and are allowed
+func allowedOrigin(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range origins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Connect upgrades the request to a WebSocket and streams messages sent to the current user
+func (h *WebSocketHandler) Connect(c echo.Context) error {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// Messages are routed by public key
+	user, err := h.userService.GetByID(c.Request().Context(), userID)
+	if err != nil {
+		return response.WriteError(c, err)
+	}
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	// The upgrader writes its own error response on failure
+	conn, err := h.upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		h.logger.Debug("WebSocket upgrade failed", zap.Error(err), zap.String("user_id", userID))
+		return nil
+	}
+
+	sub := h.hub.Subscribe(userPubKey)
+	h.logger.Debug("WebSocket connected", zap.String("user_id", userID))
+
+	// The reader notices disconnects and answers pings; the writer owns all writes
+	closed := make(chan struct{})
+	go h.readPump(conn, closed)
+	h.writePump(conn, sub, userPubKey, closed)
+
+	h.hub.Unsubscribe(sub)
+	conn.Close()
+	h.logger.Debug("WebSocket disconnected", zap.String("user_id", userID))
+
+	return nil
+}
+
+// readPump discards client frames until the connection closes, keeping the read deadline fresh on pongs
+func (h *WebSocketHandler) readPump(conn *websocket.Conn, closed chan<- struct{}) {
+	defer close(closed)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				h.logger.Debug("WebSocket read failed", zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// writePump sends published messages, receipts and keepalive pings until the client leaves or the hub closes
+func (h *WebSocketHandler) writePump(conn *websocket.Conn, sub *realtime.Subscription, userPubKey string, closed <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-sub.Done():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(wsWriteWait))
+			return
+
+		case msg := <-sub.Messages():
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(toMessageResponse(msg, msg.SenderPubKey == userPubKey)); err != nil {
+				h.logger.Debug("WebSocket write failed", zap.Error(err))
+				return
+			}
+
+		case receipt := <-sub.Receipts():
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(toReceiptResponse(receipt)); err != nil {
+				h.logger.Debug("WebSocket write failed", zap.Error(err))
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
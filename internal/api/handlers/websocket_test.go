@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+)
+
+func TestAllowedOrigin(t *testing.T) {
+	check := allowedOrigin([]string{"https://app.example.com"})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/ws", nil)
+	assert.True(t, check(req), "non-browser clients send no origin")
+
+	req.Header.Set("Origin", "https://app.example.com")
+	assert.True(t, check(req))
+
+	req.Header.Set("Origin", "https://evil.example.com")
+	assert.False(t, check(req))
+
+	assert.True(t, allowedOrigin([]string{"*"})(req))
+}
+
+// newTestSocket serves the write and read pumps for pubKey and returns a connected client
+func newTestSocket(t *testing.T, hub *realtime.Hub, pubKey string) *websocket.Conn {
+	t.Helper()
+
+	cfg := &config.Config{}
+	h := NewWebSocketHandler(hub, nil, cfg, zaptest.NewLogger(t))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sub := hub.Subscribe(pubKey)
+		defer hub.Unsubscribe(sub)
+
+		closed := make(chan struct{})
+		go h.readPump(conn, closed)
+		h.writePump(conn, sub, pubKey, closed)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func waitForSubscribers(t *testing.T, hub *realtime.Hub, pubKey string, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return hub.Subscribers(pubKey) == n }, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketPushesToEverySocket(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	first := newTestSocket(t, hub, "recipient")
+	second := newTestSocket(t, hub, "recipient")
+	waitForSubscribers(t, hub, "recipient", 2)
+
+	message := domain.NewMessage("sender", "recipient", []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	hub.Publish(message)
+
+	for _, client := range []*websocket.Conn{first, second} {
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+		var got response.MessageResponse
+		require.NoError(t, client.ReadJSON(&got))
+		assert.Equal(t, message.MessageID.String(), got.MessageID)
+		assert.Equal(t, "recipient", got.RecipientPubKey)
+	}
+}
+
+func TestWebSocketDisconnectUnsubscribes(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	client := newTestSocket(t, hub, "recipient")
+	waitForSubscribers(t, hub, "recipient", 1)
+
+	require.NoError(t, client.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+
+	waitForSubscribers(t, hub, "recipient", 0)
+}
+
+func TestWebSocketClosedOnHubClose(t *testing.T) {
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	client := newTestSocket(t, hub, "recipient")
+	waitForSubscribers(t, hub, "recipient", 1)
+
+	hub.Close()
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, _, err := client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	activityQueueSize     = 1024            // Activity records waiting to be written before new ones are dropped
+	activityUpdateTimeout = 5 * time.Second // Bound on each activity write
+)
+
+// activityTracker records user activity in the background
+// Updates are coalesced so a busy user costs at most one write per interval
+type activityTracker struct {
+	update   func(ctx context.Context, userID string) error
+	interval time.Duration
+	queue    chan string
+	written  map[string]time.Time // When each user's activity was last written; only touched by the worker
+	mutex    sync.RWMutex         // Guards closed against sends on the closed queue
+	closed   bool
+	done     chan struct{}
+	logger   *zap.Logger
+
+	anonymize bool // Leave user IDs out of logs
+}
+
+// newActivityTracker creates a tracker and starts its worker
+// An interval of 0 writes every recorded update; anonymize leaves user IDs out of its logs, as LOG_ANONYMIZE does
+func newActivityTracker(update func(ctx context.Context, userID string) error, interval time.Duration, anonymize bool, logger *zap.Logger) *activityTracker {
+	tracker := &activityTracker{
+		update:    update,
+		interval:  interval,
+		queue:     make(chan string, activityQueueSize),
+		written:   make(map[string]time.Time),
+		done:      make(chan struct{}),
+		logger:    logger,
+		anonymize: anonymize,
+	}
+
+	go tracker.run()
+
+	return tracker
+}
+
+// Record queues an activity update for the user
+// It never blocks: when the queue is full the update is dropped, since the next request records it again
+func (t *activityTracker) Record(userID string) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.closed {
+		return
+	}
+
+	select {
+	case t.queue <- userID:
+	default:
+		t.logger.Debug("Dropped user activity update", clientField(t.anonymize, "user_id", userID))
+	}
+}
+
+// run writes queued updates until the queue is closed and drained
+func (t *activityTracker) run() {
+	defer close(t.done)
+
+	// Without an interval nothing is remembered, so there is nothing to prune
+	var prune <-chan time.Time
+	if t.interval > 0 {
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		prune = ticker.C
+	}
+
+	for {
+		select {
+		case userID, ok := <-t.queue:
+			if !ok {
+				return
+			}
+			t.write(userID)
+
+		case now := <-prune:
+			// Forget users whose last write is old enough that the next one will go through anyway
+			for userID, writtenAt := range t.written {
+				if now.Sub(writtenAt) >= t.interval {
+					delete(t.written, userID)
+				}
+			}
+		}
+	}
+}
+
+// write updates the user's activity unless it was written within the interval
+// It uses its own context, since the request that recorded the activity has usually finished
+func (t *activityTracker) write(userID string) {
+	now := time.Now()
+	if writtenAt, ok := t.written[userID]; ok && now.Sub(writtenAt) < t.interval {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), activityUpdateTimeout)
+	defer cancel()
+
+	if err := t.update(ctx, userID); err != nil {
+		t.logger.Warn("Failed to update user activity", zap.Error(err), clientField(t.anonymize, "user_id", userID))
+		return
+	}
+	if t.interval > 0 {
+		t.written[userID] = now
+	}
+}
+
+// Close stops accepting updates and waits for the queued ones to be written
+// It is safe to call more than once
+func (t *activityTracker) Close() {
+	t.mutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mutex.Unlock()
+
+	<-t.done
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// recordedUpdates collects the activity writes made by a tracker
+type recordedUpdates struct {
+	mutex   sync.Mutex
+	userIDs []string
+}
+
+func (r *recordedUpdates) update(ctx context.Context, userID string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.userIDs = append(r.userIDs, userID)
+	return nil
+}
+
+func TestActivityTrackerCoalescesUpdates(t *testing.T) {
+	updates := &recordedUpdates{}
+	tracker := newActivityTracker(updates.update, time.Minute, false, zap.NewNop())
+
+	for i := 0; i < 5; i++ {
+		tracker.Record("alice")
+	}
+	tracker.Record("bob")
+
+	// Close drains the queue before returning
+	tracker.Close()
+	assert.ElementsMatch(t, []string{"alice", "bob"}, updates.userIDs)
+
+	// Records after Close are ignored rather than panicking on the closed queue
+	assert.NotPanics(t, func() { tracker.Record("carol") })
+	tracker.Close()
+	assert.Len(t, updates.userIDs, 2)
+}
+
+func TestActivityTrackerWithoutInterval(t *testing.T) {
+	updates := &recordedUpdates{}
+	tracker := newActivityTracker(updates.update, 0, false, zap.NewNop())
+
+	tracker.Record("alice")
+	tracker.Record("alice")
+	tracker.Close()
+
+	assert.Equal(t, []string{"alice", "alice"}, updates.userIDs)
+}
+
+func TestAnonymizedActivityLogsLeaveOutUsers(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	failing := func(ctx context.Context, userID string) error { return errors.New("database unavailable") }
+	tracker := newActivityTracker(failing, 0, true, zap.New(core))
+
+	tracker.Record("alice")
+	tracker.Close()
+
+	entries := logs.FilterMessage("Failed to update user activity").All()
+	require.Len(t, entries, 1)
+	assert.NotContains(t, entries[0].ContextMap(), "user_id")
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+// AdminTokenHeader is the header carrying the admin token
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminOnly returns middleware that restricts access to holders of the admin token
+// Admin routes are disabled entirely when no admin token is configured
+func AdminOnly(cfg *config.Config, logger *zap.Logger) echo.MiddlewareFunc {
+	logger = logger.With(zap.String("middleware", "admin"))
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Admin.Token == "" {
+				return c.JSON(http.StatusNotFound, response.NewErrorResponse("Not found", "NOT_FOUND"))
+			}
+
+			token := c.Request().Header.Get(AdminTokenHeader)
+			if token == "" || !security.SecureCompare(token, cfg.Admin.Token) {
+				logger.Warn("Admin access denied", clientField(cfg.LogAnonymize, "ip", c.RealIP()), zap.String("path", c.Path()))
+				return c.JSON(http.StatusForbidden, response.NewErrorResponse("Admin access required", "UNAUTHORIZED"))
+			}
+
+			return next(c)
+		}
+	}
+}
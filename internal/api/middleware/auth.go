@@ -7,23 +7,34 @@ import (
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/security"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
 // AuthMiddleware handles JWT authentication
 type AuthMiddleware struct {
 	authService *service.AuthService
+	activity    *activityTracker
 	logger      *zap.Logger
 }
 
 // NewAuthMiddleware creates a new auth middleware
-func NewAuthMiddleware(authService *service.AuthService, logger *zap.Logger) *AuthMiddleware {
+// It starts a background worker that records user activity; call Close to stop it
+func NewAuthMiddleware(authService *service.AuthService, cfg *config.Config, logger *zap.Logger) *AuthMiddleware {
+	logger = logger.With(zap.String("middleware", "auth"))
 	return &AuthMiddleware{
 		authService: authService,
-		logger:      logger.With(zap.String("middleware", "auth")),
+		activity:    newActivityTracker(authService.UpdateUserActivity, cfg.Auth.ActivityInterval, cfg.LogAnonymize, logger),
+		logger:      logger,
 	}
 }
 
+// Close stops recording activity once the queued updates are written
+func (m *AuthMiddleware) Close() {
+	m.activity.Close()
+}
+
 // Authenticate middleware handles token authentication
 func (m *AuthMiddleware) Authenticate() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -34,11 +45,7 @@ func (m *AuthMiddleware) Authenticate() echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Missing authorization header")
 			}
 
-			// Support both "Bearer token" and just "token" formats
-			token := authHeader
-			if strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
-				token = authHeader[7:] // Remove "Bearer " prefix
-			}
+			token := BearerToken(authHeader)
 
 			// Validate token
 			userID, err := m.authService.ValidateToken(c.Request().Context(), token)
@@ -47,23 +54,27 @@ func (m *AuthMiddleware) Authenticate() echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token")
 			}
 
-			// Set user ID in context
+			// Set user ID and token hash in context
 			c.Set("user_id", userID)
+			c.Set("token_hash", security.HashToken(token))
 
-			// Update activity timestamp
-			// This is not critical, so we don't handle errors or wait for it
-			go func() {
-				ctx := c.Request().Context()
-				if err := m.authService.UpdateUserActivity(ctx, userID); err != nil {
-					m.logger.Warn("Failed to update user activity", zap.Error(err), zap.String("user_id", userID))
-				}
-			}()
+			// Update activity timestamp in the background; this is not critical, so failures are only logged
+			m.activity.Record(userID)
 
 			return next(c)
 		}
 	}
 }
 
+// BearerToken extracts the token from an Authorization header
+// Both "Bearer token", in any case, and just "token" formats are supported
+func BearerToken(authHeader string) string {
+	if strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+		return authHeader[7:] // Remove "Bearer " prefix
+	}
+	return authHeader
+}
+
 // GetUserID extracts the authenticated user ID from the context
 func GetUserID(c echo.Context) (string, error) {
 	userID, ok := c.Get("user_id").(string)
@@ -72,3 +83,10 @@ func GetUserID(c echo.Context) (string, error) {
 	}
 	return userID, nil
 }
+
+// GetTokenHash extracts the hash of the token that authenticated the request
+// It is empty when the request was not authenticated
+func GetTokenHash(c echo.Context) string {
+	tokenHash, _ := c.Get("token_hash").(string)
+	return tokenHash
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"bearer", "Bearer abc123", "abc123"},
+		{"lowercase bearer", "bearer abc123", "abc123"},
+		{"uppercase bearer", "BEARER abc123", "abc123"},
+		{"bare token", "abc123", "abc123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, BearerToken(tt.header))
+		})
+	}
+}
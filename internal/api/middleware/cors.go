@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"net/http"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/api/response"
 	"github.com/pzkpfw44/wave-server/internal/config"
 )
 
@@ -23,12 +26,37 @@ func NewCORSMiddleware(logger *zap.Logger, config *config.Config) *CORSMiddlewar
 }
 
 // CORS configures CORS middleware
+// With a "*" origin any site may call the API, but never with credentials
+// Otherwise only the listed origins are allowed; requests from any other origin are rejected
 func (m *CORSMiddleware) CORS() echo.MiddlewareFunc {
-	return middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     m.config.Server.AllowedOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Requested-With"},
-		AllowCredentials: true,
-		MaxAge:           86400, // 24 hours
-	})
+	origins := m.config.Server.AllowedOrigins
+	corsConfig := middleware.CORSConfig{
+		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
+		AllowHeaders: []string{"Authorization", "Content-Type", "X-Requested-With"},
+		MaxAge:       86400, // 24 hours
+	}
+
+	if origins.AllowsAny() {
+		corsConfig.AllowOrigins = []string{"*"}
+		return middleware.CORSWithConfig(corsConfig)
+	}
+
+	// Echo reflects the request origin when it is allowed by AllowOriginFunc
+	corsConfig.AllowOriginFunc = func(origin string) (bool, error) {
+		return origins.Allows(origin), nil
+	}
+	corsConfig.AllowCredentials = true
+	cors := middleware.CORSWithConfig(corsConfig)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handler := cors(next)
+		return func(c echo.Context) error {
+			origin := c.Request().Header.Get(echo.HeaderOrigin)
+			if origin != "" && !origins.Allows(origin) {
+				m.logger.Debug("Rejected request from disallowed origin", zap.String("origin", origin))
+				return c.JSON(http.StatusForbidden, response.NewErrorResponse("Origin not allowed", "ORIGIN_NOT_ALLOWED"))
+			}
+			return handler(c)
+		}
+	}
 }
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+)
+
+// corsRequest serves one request from origin through the CORS middleware
+func corsRequest(origins config.Origins, method, origin string) *httptest.ResponseRecorder {
+	cfg := &config.Config{}
+	cfg.Server.AllowedOrigins = origins
+
+	e := echo.New()
+	e.Use(NewCORSMiddleware(zap.NewNop(), cfg).CORS())
+	e.GET("/test", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	req := httptest.NewRequest(method, "/test", nil)
+	if origin != "" {
+		req.Header.Set(echo.HeaderOrigin, origin)
+	}
+	if method == http.MethodOptions {
+		req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSListedOrigin(t *testing.T) {
+	origins := config.Origins{"https://app.example.com"}
+
+	rec := corsRequest(origins, http.MethodGet, "https://app.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Equal(t, "true", rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+
+	rec = corsRequest(origins, http.MethodOptions, "https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+}
+
+func TestCORSRejectsUnlistedOrigin(t *testing.T) {
+	origins := config.Origins{"https://app.example.com"}
+
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		rec := corsRequest(origins, method, "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+		assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+	}
+
+	// Requests without an Origin don't come from a browser and are not affected
+	assert.Equal(t, http.StatusOK, corsRequest(origins, http.MethodGet, "").Code)
+}
+
+func TestCORSWildcardWithoutCredentials(t *testing.T) {
+	rec := corsRequest(config.Origins{"*"}, http.MethodGet, "https://any.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+}
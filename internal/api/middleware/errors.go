@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+// httpErrorCodes maps the statuses of Echo's errors to API error codes
+var httpErrorCodes = map[int]string{
+	http.StatusBadRequest:            errors.ErrCodeBadRequest,
+	http.StatusUnauthorized:          errors.ErrCodeUnauthenticated,
+	http.StatusForbidden:             errors.ErrCodeUnauthorized,
+	http.StatusNotFound:              errors.ErrCodeNotFound,
+	http.StatusConflict:              errors.ErrCodeConflict,
+	http.StatusRequestEntityTooLarge: "PAYLOAD_TOO_LARGE",
+	http.StatusTooManyRequests:       "RATE_LIMIT_EXCEEDED",
+}
+
+// HTTPErrorHandler writes errors returned up the middleware chain in the same shape handlers use
+// AppErrors keep their own code, and Echo's errors, such as unknown routes or failed authentication,
+// are given the code for their status
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		_ = response.WriteError(c, err)
+		return
+	}
+
+	if c.Request().Method == http.MethodHead {
+		_ = c.NoContent(he.Code)
+		return
+	}
+
+	code, ok := httpErrorCodes[he.Code]
+	if !ok {
+		code = errors.ErrCodeBadRequest
+		if he.Code >= http.StatusInternalServerError {
+			code = errors.ErrCodeInternal
+		}
+	}
+
+	message, ok := he.Message.(string)
+	if !ok {
+		message = fmt.Sprint(he.Message)
+	}
+	if he.Code >= http.StatusInternalServerError {
+		// The message of a server error may describe internals, so it is kept for the log only
+		c.Set(response.ErrorContextKey, err)
+		message = http.StatusText(he.Code)
+	}
+
+	_ = c.JSON(he.Code, response.NewErrorResponse(message, code))
+}
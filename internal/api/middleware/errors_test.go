@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		err         error
+		wantStatus  int
+		wantMessage string
+		wantCode    string
+	}{
+		{"echo error", "/test", echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token"), http.StatusUnauthorized, "Invalid or expired token", errors.ErrCodeUnauthenticated},
+		{"app error", "/test", errors.NewValidationError("Username is required", nil), http.StatusUnprocessableEntity, "Username is required", errors.ErrCodeValidation},
+		{"unexpected error", "/test", fmt.Errorf("connection reset"), http.StatusInternalServerError, "Internal server error", errors.ErrCodeInternal},
+		{"echo server error", "/test", echo.NewHTTPError(http.StatusBadGateway, "upstream 10.0.0.3 refused"), http.StatusBadGateway, "Bad Gateway", errors.ErrCodeInternal},
+		{"unknown route", "/missing", nil, http.StatusNotFound, "Not Found", errors.ErrCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.HTTPErrorHandler = HTTPErrorHandler
+			e.GET("/test", func(c echo.Context) error {
+				return tt.err
+			})
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			var body response.Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.False(t, body.Success)
+			require.NotNil(t, body.Error)
+			assert.Equal(t, tt.wantMessage, body.Error.Message)
+			assert.Equal(t, tt.wantCode, body.Error.Code)
+		})
+	}
+}
@@ -5,18 +5,37 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
 )
 
+// loggerContextKey is the echo context key holding the request's logger
+const loggerContextKey = "logger"
+
 // LoggingMiddleware handles request logging
 type LoggingMiddleware struct {
-	logger *zap.Logger
+	base      *zap.Logger // Logger the per-request loggers are derived from
+	logger    *zap.Logger
+	anonymize bool // Omit client IPs and user IDs from access logs
 }
 
 // NewLoggingMiddleware creates a new logging middleware
-func NewLoggingMiddleware(logger *zap.Logger) *LoggingMiddleware {
+func NewLoggingMiddleware(logger *zap.Logger, cfg *config.Config) *LoggingMiddleware {
 	return &LoggingMiddleware{
-		logger: logger.With(zap.String("middleware", "logging")),
+		base:      logger,
+		logger:    logger.With(zap.String("middleware", "logging")),
+		anonymize: cfg.LogAnonymize,
+	}
+}
+
+// LoggerFromContext returns the logger for the request, which tags its entries with the request ID
+// Outside the logging middleware it returns the global logger
+func LoggerFromContext(c echo.Context) *zap.Logger {
+	if logger, ok := c.Get(loggerContextKey).(*zap.Logger); ok {
+		return logger
 	}
+	return zap.L()
 }
 
 // Logger middleware logs requests
@@ -26,14 +45,20 @@ func (m *LoggingMiddleware) Logger() echo.MiddlewareFunc {
 			start := time.Now()
 			req := c.Request()
 
-			// Set request ID in context
-			requestID := req.Header.Get("X-Request-ID")
+			// The RequestID middleware runs first and echoes the ID, generating one if the client sent none
+			requestID := c.Response().Header().Get(echo.HeaderXRequestID)
 			if requestID == "" {
-				requestID = c.Response().Header().Get(echo.HeaderXRequestID)
+				requestID = req.Header.Get(echo.HeaderXRequestID)
 			}
 
+			// Give handlers a logger that ties their entries to this request
+			c.Set(loggerContextKey, m.base.With(zap.String("request_id", requestID)))
+
 			// Process the request
-			err := next(c)
+			// A returned error is answered here rather than by Echo, so its status is the one logged
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
 
 			// Log after response
 			latency := time.Since(start)
@@ -42,12 +67,9 @@ func (m *LoggingMiddleware) Logger() echo.MiddlewareFunc {
 			// Skip logging for health check endpoints to reduce noise
 			path := c.Path()
 			if path == "/health" || path == "/health/liveness" || path == "/health/readiness" {
-				return err
+				return nil
 			}
 
-			// Get user ID if available
-			userID, _ := c.Get("user_id").(string)
-
 			// Log at appropriate level based on status code
 			logFunc := m.logger.Info
 			if status >= 500 {
@@ -56,19 +78,42 @@ func (m *LoggingMiddleware) Logger() echo.MiddlewareFunc {
 				logFunc = m.logger.Warn
 			}
 
-			// Log the request
-			logFunc("HTTP Request",
+			fields := []zap.Field{
 				zap.String("method", req.Method),
 				zap.String("path", path),
 				zap.Int("status", status),
 				zap.Duration("latency", latency),
-				zap.String("ip", c.RealIP()),
+				zap.Int64("bytes_in", req.ContentLength), // -1 if unknown, such as a chunked body
+				zap.Int64("bytes_out", c.Response().Size),
 				zap.String("user_agent", req.UserAgent()),
 				zap.String("request_id", requestID),
-				zap.String("user_id", userID), // Empty if not authenticated
+			}
+
+			// Unexpected errors are kept out of responses, so their details are only logged here
+			if cause, ok := c.Get(response.ErrorContextKey).(error); ok {
+				fields = append(fields, zap.Error(cause))
+			}
+
+			// Identify the client unless running in anonymized mode
+			userID, _ := c.Get("user_id").(string)
+			fields = append(fields,
+				clientField(m.anonymize, "ip", c.RealIP()),
+				clientField(m.anonymize, "user_id", userID), // Empty if not authenticated
 			)
 
-			return err
+			// Log the request
+			logFunc("HTTP Request", fields...)
+
+			return nil
 		}
 	}
 }
+
+// clientField is a log field that identifies a client, such as its IP or user ID
+// It is left out when logs are anonymized, so every middleware that names clients honours LOG_ANONYMIZE
+func clientField(anonymize bool, key, value string) zap.Field {
+	if anonymize {
+		return zap.Skip()
+	}
+	return zap.String(key, value)
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+)
+
+// logRequest serves one authenticated request through the logging middleware and returns the access log entry
+func logRequest(t *testing.T, anonymize bool) observer.LoggedEntry {
+	t.Helper()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := &config.Config{LogAnonymize: anonymize}
+
+	e := echo.New()
+	e.Use(NewLoggingMiddleware(zap.New(core), cfg).Logger())
+	e.GET("/test", func(c echo.Context) error {
+		c.Set("user_id", "user-123")
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Request-ID", "req-abc")
+	req.Header.Set(echo.HeaderXRealIP, "203.0.113.7")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.FilterMessage("HTTP Request").All()
+	require.Len(t, entries, 1)
+	return entries[0]
+}
+
+func TestLoggingMiddlewareAnonymized(t *testing.T) {
+	fields := logRequest(t, true).ContextMap()
+
+	assert.Equal(t, "req-abc", fields["request_id"])
+	assert.NotContains(t, fields, "ip")
+	assert.NotContains(t, fields, "user_id")
+	for _, v := range fields {
+		assert.NotEqual(t, "203.0.113.7", v)
+		assert.NotEqual(t, "user-123", v)
+	}
+}
+
+func TestLoggingMiddlewareDefault(t *testing.T) {
+	fields := logRequest(t, false).ContextMap()
+
+	assert.Equal(t, "req-abc", fields["request_id"])
+	assert.Equal(t, "203.0.113.7", fields["ip"])
+	assert.Equal(t, "user-123", fields["user_id"])
+	assert.EqualValues(t, 0, fields["bytes_out"])
+}
+
+func TestLoggerFromContextCarriesRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	e := echo.New()
+	e.Use(middleware.RequestID())
+	e.Use(NewLoggingMiddleware(zap.New(core), &config.Config{}).Logger())
+	e.GET("/test", func(c echo.Context) error {
+		LoggerFromContext(c).Info("Handling")
+		return c.String(http.StatusOK, "hello")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+	requestID := rec.Header().Get(echo.HeaderXRequestID)
+	require.NotEmpty(t, requestID, "a request ID is generated when the client sends none")
+
+	handled := logs.FilterMessage("Handling").All()
+	require.Len(t, handled, 1)
+	assert.Equal(t, requestID, handled[0].ContextMap()["request_id"])
+
+	access := logs.FilterMessage("HTTP Request").All()
+	require.Len(t, access, 1)
+	assert.Equal(t, requestID, access[0].ContextMap()["request_id"])
+	assert.EqualValues(t, len("hello"), access[0].ContextMap()["bytes_out"])
+
+	// Outside a request the global logger is used
+	assert.Same(t, zap.L(), LoggerFromContext(e.NewContext(nil, nil)))
+}
+
+func TestLoggingMiddlewareLogsReturnedErrors(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(NewLoggingMiddleware(zap.New(core), &config.Config{}).Logger())
+	e.GET("/test", func(c echo.Context) error {
+		return fmt.Errorf("connection reset")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	entries := logs.FilterMessage("HTTP Request").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+
+	fields := entries[0].ContextMap()
+	assert.EqualValues(t, http.StatusInternalServerError, fields["status"])
+	assert.Equal(t, "connection reset", fields["error"])
+}
+
+func TestAnonymizedLogsLeaveOutClients(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := &config.Config{LogAnonymize: true}
+	cfg.Admin.Token = "admin-token"
+
+	limiter := NewRateLimiterFromConfig(cfg, "test", 1, time.Minute, zap.New(core))
+	defer limiter.Close()
+
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/limited", ok, limiter.Limit())
+	e.GET("/admin", ok, AdminOnly(cfg, zap.New(core)))
+
+	for _, path := range []string{"/limited", "/limited", "/admin"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderXRealIP, "203.0.113.7")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Len(t, logs.FilterMessage("Rate limit exceeded").All(), 1)
+	require.Len(t, logs.FilterMessage("Admin access denied").All(), 1)
+	for _, entry := range logs.All() {
+		for key, value := range entry.ContextMap() {
+			assert.NotContains(t, fmt.Sprint(value), "203.0.113.7", "%s: %s", entry.Message, key)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package middleware
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/pkg/metrics"
 )
 
@@ -31,7 +33,9 @@ func (m *MetricsMiddleware) Metrics() echo.MiddlewareFunc {
 			err := next(c)
 
 			// Skip metrics for health check endpoints to reduce noise
-			path := c.Path()
+			// Requests are labelled by route template, such as /api/v1/contacts/:pubkey, never by URL,
+			// so each route is one series however many keys are looked up through it
+			path := routeLabel(c)
 			if path == "/health" || path == "/health/liveness" || path == "/health/readiness" {
 				return err
 			}
@@ -39,26 +43,48 @@ func (m *MetricsMiddleware) Metrics() echo.MiddlewareFunc {
 			// Record metrics
 			duration := time.Since(start).Seconds()
 			status := c.Response().Status
-			method := c.Request().Method
+			if err != nil {
+				// The error handler writes the error response after the middleware chain returns
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				} else if appErr, ok := errors.IsAppError(err); ok {
+					status = appErr.Status
+				}
+			}
+			method := methodLabel(c.Request().Method)
 			responseSize := c.Response().Size
 
 			// Record HTTP metrics
+			// Errors are counted by code when their response is built, including those answered by the error handler
 			metrics.RecordRequestMetrics(method, path, status, duration, int(responseSize))
 
-			// If this is an error, record it
-			if status >= 400 {
-				errorType := "client_error"
-				if status >= 500 {
-					errorType = "server_error"
-				}
-				metrics.RecordError(errorType)
-			}
-
 			return err
 		}
 	}
 }
 
+// unmatchedRoute labels requests that matched no route, whatever their URL
+const unmatchedRoute = "unmatched"
+
+// routeLabel returns the template of the route that matched the request
+func routeLabel(c echo.Context) string {
+	if path := c.Path(); path != "" {
+		return path
+	}
+	return unmatchedRoute
+}
+
+// methodLabel returns the request method, or OTHER for methods that aren't standard, since clients can send any
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
 // SetupMetricsEndpoint registers metrics endpoint with Echo
 func (m *MetricsMiddleware) SetupMetricsEndpoint(e *echo.Echo) {
 	metrics.RegisterMetricsHandler(e)
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMetricsLabelRequestsByRoute(t *testing.T) {
+	e := echo.New()
+	metricsMiddleware := NewMetricsMiddleware(zap.NewNop())
+	e.Use(metricsMiddleware.Metrics())
+	metricsMiddleware.SetupMetricsEndpoint(e)
+	e.GET("/api/v1/messages/conversation/:pubkey", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	for _, target := range []string{
+		"/api/v1/messages/conversation/first-key",
+		"/api/v1/messages/conversation/second-key",
+		"/not-a-route/third-key",
+	} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("MADEUP", "/api/v1/messages/conversation/fourth-key", nil))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	scrape := string(body)
+
+	assert.Contains(t, scrape, `wave_http_requests_total{method="GET",path="/api/v1/messages/conversation/:pubkey",status="200"} 2`)
+	assert.Contains(t, scrape, `method="GET",path="unmatched"`)
+	assert.Contains(t, scrape, `method="OTHER",path="/api/v1/messages/conversation/:pubkey"`)
+	for _, key := range []string{"first-key", "second-key", "third-key", "fourth-key", "MADEUP"} {
+		assert.NotContains(t, scrape, key)
+	}
+}
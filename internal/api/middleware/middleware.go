@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"time"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -9,52 +9,117 @@ import (
 
 	"github.com/pzkpfw44/wave-server/internal/api/request"
 	"github.com/pzkpfw44/wave-server/internal/config"
-	"github.com/pzkpfw44/wave-server/internal/service"
+	"github.com/pzkpfw44/wave-server/internal/domain"
 )
 
+// defaultBodyLimit is the largest body accepted by routes that carry neither messages nor backups
+const defaultBodyLimit = 1 << 20
+
+// messageBodyOverhead is room for the fields of a message request that aren't part of any one copy
+// messageCopyOverhead is room for the KEM ciphertext, nonce and recipient key that go with each copy's message ciphertext
+const (
+	messageBodyOverhead = 16 << 10
+	messageCopyOverhead = 8 << 10
+)
+
+// messageRoutes carry messages, and are limited to fit this many copies of the largest ciphertext
+var messageRoutes = map[string]int64{
+	"/api/v1/messages/send":       2,                               // The recipient's and the sender's
+	"/api/v1/messages/send-multi": domain.MaxMessageRecipients + 1, // One for each recipient, and the sender's
+}
+
+// bulkRoutes carry whole backups or contact lists, and are limited by MAX_BULK_BODY_BYTES
+var bulkRoutes = map[string]bool{
+	"/api/v1/account/recover": true,
+	"/api/v1/contacts/import": true,
+}
+
+// bodyLimit returns the largest request body accepted on the route, or 0 for no limit
+// Message routes are sized from MAX_CIPHERTEXT_BYTES, and base64 grows each ciphertext by a third
+func bodyLimit(cfg *config.Config, path string) int64 {
+	if bulkRoutes[path] {
+		return cfg.Messages.MaxBulkBodyBytes
+	}
+
+	copies, ok := messageRoutes[path]
+	if !ok {
+		return defaultBodyLimit
+	}
+	maxBytes := int64(cfg.Messages.MaxCiphertextBytes)
+	if maxBytes == 0 {
+		return 0
+	}
+	return messageBodyOverhead + copies*(messageCopyOverhead+(maxBytes+2)/3*4)
+}
+
+// BodyLimit rejects request bodies larger than the matched route accepts with 413
+func BodyLimit(cfg *config.Config) echo.MiddlewareFunc {
+	limits := make(map[string]echo.MiddlewareFunc)
+	limitFor := func(path string) echo.MiddlewareFunc {
+		if limit := bodyLimit(cfg, path); limit > 0 {
+			return middleware.BodyLimit(strconv.FormatInt(limit, 10))
+		}
+		return nil
+	}
+	for path := range messageRoutes {
+		limits[path] = limitFor(path)
+	}
+	for path := range bulkRoutes {
+		limits[path] = limitFor(path)
+	}
+	defaultLimit := limitFor("")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit, ok := limits[c.Path()]
+			if !ok {
+				limit = defaultLimit
+			}
+			if limit == nil {
+				return next(c)
+			}
+			return limit(next)(c)
+		}
+	}
+}
+
 // SetupMiddleware configures all middleware for the API
-func SetupMiddleware(e *echo.Echo, cfg *config.Config, logger *zap.Logger, authService *service.AuthService) {
+// The auth middleware and rate limiters are created by the caller, which closes them on shutdown
+func SetupMiddleware(e *echo.Echo, cfg *config.Config, logger *zap.Logger, authMiddleware *AuthMiddleware, limiters *RateLimiters) {
 	// Create middleware instances
-	recoveryMiddleware := NewRecoveryMiddleware(logger)
-	loggingMiddleware := NewLoggingMiddleware(logger)
+	recoveryMiddleware := NewRecoveryMiddleware(logger, cfg)
+	loggingMiddleware := NewLoggingMiddleware(logger, cfg)
 	corsMiddleware := NewCORSMiddleware(logger, cfg)
-	authMiddleware := NewAuthMiddleware(authService, logger)
 	metricsMiddleware := NewMetricsMiddleware(logger)
 
 	// Setup rate limiters
-	// General rate limiter: 100 requests per minute
-	generalRateLimiter := NewRateLimiter(100, time.Minute, logger)
-	// Auth rate limiter: 20 requests per 5 minutes
-	authRateLimiter := NewRateLimiter(20, 5*time.Minute, logger)
+	// General rate limiter: applies to every route without its own limit
+	// The stricter limit on auth endpoints is applied to their routes, see AuthRateLimit
+	generalRateLimiter := limiters.New("global", cfg.RateLimit.Limit, cfg.RateLimit.Window, logger)
 
 	// Set custom validator
 	e.Validator = request.NewValidator(logger)
 
+	// Answer errors returned by handlers and middleware in the API's error format
+	e.HTTPErrorHandler = HTTPErrorHandler
+
 	// Apply global middleware
 	e.Use(middleware.RequestID())
 	e.Use(recoveryMiddleware.Recover())
 	e.Use(loggingMiddleware.Logger())
 	e.Use(corsMiddleware.CORS())
 	e.Use(middleware.Secure())
-	e.Use(generalRateLimiter.Limit())
+	e.Use(BodyLimit(cfg))
+	e.Use(generalRateLimiter.limitBy(ipKey, hasRouteLimit(cfg.RateLimit.Routes)))
+	e.Use(RequestTimeout(cfg.Server.Timeout, isLongLived))
 	e.Use(metricsMiddleware.Metrics())
 
 	// Register metrics endpoint
 	metricsMiddleware.SetupMetricsEndpoint(e)
 
-	// Create auth group
-	authGroup := e.Group("/api/v1/auth")
-	authGroup.Use(authRateLimiter.Limit())
-
 	// Create protected group for authenticated endpoints
 	protectedGroup := e.Group("/api/v1")
 	protectedGroup.Use(authMiddleware.Authenticate())
 
 	// Extra middleware for specific endpoints can be added later
 }
-
-// AuthOnly returns the auth middleware for routes that need it
-func AuthOnly(authService *service.AuthService, logger *zap.Logger) echo.MiddlewareFunc {
-	authMiddleware := NewAuthMiddleware(authService, logger)
-	return authMiddleware.Authenticate()
-}
@@ -1,7 +1,13 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -9,30 +15,132 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
 )
 
-// Simple in-memory rate limiter
-// For production, use a distributed implementation with Redis
+const (
+	authRateLimit  = 20              // Requests each client may make to the auth endpoints per window
+	authRateWindow = 5 * time.Minute // Window for authRateLimit
+)
+
+// Limiter counts requests per key against a rate limit
+// reset is when the oldest counted request for the key leaves the window
+// AllowN counts n requests at once, and counts none of them unless they all fit
+type Limiter interface {
+	Allow(key string) (allowed bool, remaining int, reset time.Time)
+	AllowN(key string, n int) (allowed bool, remaining int, reset time.Time)
+}
 
-// RateLimiter handles rate limiting
+// RateLimiter is rate limiting middleware backed by a Limiter
 type RateLimiter struct {
-	logger       *zap.Logger
+	logger  *zap.Logger
+	limiter Limiter
+	limit   int           // Maximum requests
+	window  time.Duration // Time window
+
+	anonymize bool // Leave keys, which name users and client IPs, out of logs
+}
+
+// NewRateLimiter creates a rate limiter that keeps its counts in memory
+func NewRateLimiter(limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
+	return newRateLimiter(NewMemoryRateLimiter(limit, window), limit, window, logger)
+}
+
+// NewRateLimiterFromConfig creates a rate limiter using the backend selected in the config
+// Redis keys are prefixed with name so each limiter keeps its own counts
+// If the Redis client can't be created the limiter falls back to memory, so each replica still enforces the limit
+// The caller closes the limiter; limiters built for the server come from RateLimiters, which closes them together
+func NewRateLimiterFromConfig(cfg *config.Config, name string, limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
+	var limiter *RateLimiter
+	if cfg.RateLimit.Backend != config.RateLimitBackendRedis {
+		limiter = NewRateLimiter(limit, window, logger)
+	} else if redisLimiter, err := NewRedisRateLimiter(cfg.Cache.RedisURL, name, limit, window, logger); err != nil {
+		logger.Error("Failed to create redis rate limiter, falling back to memory",
+			zap.Error(err), zap.String("limiter", name))
+		limiter = NewRateLimiter(limit, window, logger)
+	} else {
+		limiter = newRateLimiter(redisLimiter, limit, window, logger)
+	}
+
+	limiter.anonymize = cfg.LogAnonymize
+	return limiter
+}
+
+// RateLimiters builds the server's rate limiters from the config and closes them when it shuts down
+// It is created by the caller of SetupMiddleware and SetupRoutes, like the auth middleware
+type RateLimiters struct {
+	cfg      *config.Config
+	limiters []*RateLimiter
+}
+
+// NewRateLimiters creates an empty set of rate limiters for the config
+func NewRateLimiters(cfg *config.Config) *RateLimiters {
+	return &RateLimiters{cfg: cfg}
+}
+
+// New builds a rate limiter with NewRateLimiterFromConfig, to be closed with the rest
+// Limiters are built while the server is set up, before it serves requests, so this isn't safe for concurrent use
+func (rs *RateLimiters) New(name string, limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
+	limiter := NewRateLimiterFromConfig(rs.cfg, name, limit, window, logger)
+	rs.limiters = append(rs.limiters, limiter)
+	return limiter
+}
+
+// Close closes every limiter built by New, stopping their cleanup and Redis connections
+// Call it once the server has stopped serving requests
+func (rs *RateLimiters) Close() error {
+	limiters := rs.limiters
+	rs.limiters = nil
+
+	var errs []error
+	for _, limiter := range limiters {
+		errs = append(errs, limiter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func newRateLimiter(limiter Limiter, limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
+	return &RateLimiter{
+		logger:  logger.With(zap.String("middleware", "rate_limiter")),
+		limiter: limiter,
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// Close stops the limiter's background work and releases its connections
+// It is safe to call more than once
+func (rl *RateLimiter) Close() error {
+	if closer, ok := rl.limiter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// MemoryRateLimiter is a sliding window Limiter kept in memory
+// Each replica has its own counts, so use RedisRateLimiter when running more than one
+type MemoryRateLimiter struct {
 	requests     map[string][]time.Time
 	mutex        sync.RWMutex
 	limit        int           // Maximum requests
 	window       time.Duration // Time window
 	cleanupEvery time.Duration // How often to clean up old records
+	stopOnce     sync.Once
+	stop         chan struct{} // Closed to stop the cleanup goroutine
+	done         chan struct{} // Closed once the cleanup goroutine has returned
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(limit int, window time.Duration, logger *zap.Logger) *RateLimiter {
-	limiter := &RateLimiter{
-		logger:       logger.With(zap.String("middleware", "rate_limiter")),
+// NewMemoryRateLimiter creates a new in-memory limiter
+// Its cleanup goroutine runs until Close is called
+func NewMemoryRateLimiter(limit int, window time.Duration) *MemoryRateLimiter {
+	limiter := &MemoryRateLimiter{
 		requests:     make(map[string][]time.Time),
 		mutex:        sync.RWMutex{},
 		limit:        limit,
 		window:       window,
 		cleanupEvery: 5 * time.Minute,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -41,14 +149,23 @@ func NewRateLimiter(limit int, window time.Duration, logger *zap.Logger) *RateLi
 	return limiter
 }
 
-// cleanup periodically removes old request timestamps
-func (rl *RateLimiter) cleanup() {
+// cleanup periodically removes old request timestamps until the limiter is closed
+// Keys of every kind (IP, user, username) are pruned the same way
+func (rl *MemoryRateLimiter) cleanup() {
+	defer close(rl.done)
+
 	ticker := time.NewTicker(rl.cleanupEvery)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+		}
+
 		rl.mutex.Lock()
-		for ip, times := range rl.requests {
+		for key, times := range rl.requests {
 			var newTimes []time.Time
 			for _, t := range times {
 				if time.Since(t) < rl.window {
@@ -56,48 +173,144 @@ func (rl *RateLimiter) cleanup() {
 				}
 			}
 			if len(newTimes) == 0 {
-				delete(rl.requests, ip)
+				delete(rl.requests, key)
 			} else {
-				rl.requests[ip] = newTimes
+				rl.requests[key] = newTimes
 			}
 		}
 		rl.mutex.Unlock()
 	}
 }
 
-// Limit middleware implements rate limiting
-func (rl *RateLimiter) Limit() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			// Get client IP
-			ip := c.RealIP()
+// Close stops the cleanup goroutine and waits for it to return
+// The limiter keeps counting requests afterwards, it just no longer prunes keys that went quiet
+// It is safe to call more than once
+func (rl *MemoryRateLimiter) Close() error {
+	rl.stopOnce.Do(func() {
+		close(rl.stop)
+	})
+	<-rl.done
+	return nil
+}
 
-			// Check if rate limit exceeded
-			now := time.Now()
-			rl.mutex.Lock()
+// keyFunc extracts the rate limit bucket key for a request
+type keyFunc func(c echo.Context) string
 
-			// Initialize if needed
-			if _, exists := rl.requests[ip]; !exists {
-				rl.requests[ip] = []time.Time{}
-			}
+// ipKey buckets requests by client IP
+func ipKey(c echo.Context) string {
+	return c.RealIP()
+}
 
-			// Remove old timestamps
-			var validTimes []time.Time
-			for _, t := range rl.requests[ip] {
-				if now.Sub(t) < rl.window {
-					validTimes = append(validTimes, t)
-				}
+// userOrIPKey buckets authenticated requests by user ID and falls back to the client IP
+func userOrIPKey(c echo.Context) string {
+	if userID, ok := c.Get("user_id").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.RealIP()
+}
+
+// lookupUsernameKey is the context key the username a request looks up is stored under
+const lookupUsernameKey = "lookup_username"
+
+// usernameKey buckets requests by the username they look up, from the query string or JSON body
+// It returns an empty key when the request names no username
+func usernameKey(c echo.Context) string {
+	username := lookupUsername(c)
+	if username == "" {
+		return ""
+	}
+	return "username:" + username
+}
+
+// lookupUsername returns the username a request looks up, from the query string or JSON body
+// The body is only read on the first call; the result is kept in the context for later ones
+func lookupUsername(c echo.Context) string {
+	if username, ok := c.Get(lookupUsernameKey).(string); ok {
+		return username
+	}
+
+	username := c.QueryParam("username")
+	if username == "" && c.Request().Body != nil {
+		body, err := io.ReadAll(c.Request().Body)
+		if err == nil {
+			// Restore the body for the handler
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			var payload struct {
+				Username string `json:"username"`
 			}
+			if json.Unmarshal(body, &payload) == nil {
+				username = payload.Username
+			}
+		}
+	}
 
-			// Check rate limit
-			if len(validTimes) >= rl.limit {
-				rl.mutex.Unlock()
-				rl.logger.Warn("Rate limit exceeded",
-					zap.String("ip", ip),
-					zap.Int("limit", rl.limit),
-					zap.Duration("window", rl.window),
-				)
+	c.Set(lookupUsernameKey, username)
+	return username
+}
+
+// Allow records a request for the key and reports whether it is within the limit
+// It also returns how many requests the key has left and when its oldest counted request leaves the window
+func (rl *MemoryRateLimiter) Allow(key string) (bool, int, time.Time) {
+	return rl.AllowN(key, 1)
+}
+
+// AllowN records n requests for the key if they all fit within the limit, and reports whether they did
+func (rl *MemoryRateLimiter) AllowN(key string, n int) (bool, int, time.Time) {
+	now := time.Now()
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
 
+	// Remove old timestamps
+	var validTimes []time.Time
+	for _, t := range rl.requests[key] {
+		if now.Sub(t) < rl.window {
+			validTimes = append(validTimes, t)
+		}
+	}
+
+	// Check rate limit
+	if len(validTimes)+n > rl.limit {
+		rl.requests[key] = validTimes
+		remaining := max(rl.limit-len(validTimes), 0)
+		if len(validTimes) == 0 {
+			return false, remaining, now.Add(rl.window)
+		}
+		return false, remaining, validTimes[0].Add(rl.window)
+	}
+
+	// Add current timestamps
+	for i := 0; i < n; i++ {
+		validTimes = append(validTimes, now)
+	}
+	rl.requests[key] = validTimes
+	if len(validTimes) == 0 {
+		return true, rl.limit, now.Add(rl.window)
+	}
+	return true, rl.limit - len(validTimes), validTimes[0].Add(rl.window)
+}
+
+// Limit middleware implements rate limiting
+func (rl *RateLimiter) Limit() echo.MiddlewareFunc {
+	return rl.limitBy(ipKey, nil)
+}
+
+// LimitByUser rate limits authenticated requests per user and the rest per client IP
+// It must run after the auth middleware, so users sharing an IP get separate budgets
+func (rl *RateLimiter) LimitByUser() echo.MiddlewareFunc {
+	return rl.limitBy(userOrIPKey, nil)
+}
+
+// limitBy returns rate limiting middleware that buckets requests with keyFn
+// Requests for which skip returns true are not counted
+func (rl *RateLimiter) limitBy(keyFn keyFunc, skip func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip != nil && skip(c) {
+				return next(c)
+			}
+
+			if !rl.allowN(c, keyFn(c), 1) {
 				resp := response.NewErrorResponse(
 					"Too many requests. Please try again later.",
 					"RATE_LIMIT_EXCEEDED",
@@ -105,18 +318,148 @@ func (rl *RateLimiter) Limit() echo.MiddlewareFunc {
 				return c.JSON(http.StatusTooManyRequests, resp)
 			}
 
-			// Add current timestamp
-			rl.requests[ip] = append(validTimes, now)
-			rl.mutex.Unlock()
+			return next(c)
+		}
+	}
+}
+
+// allowN counts n requests for key against the limit and sets the rate limit headers of the response
+// It reports whether they fit, and logs when they don't
+func (rl *RateLimiter) allowN(c echo.Context, key string, n int) bool {
+	allowed, remaining, reset := rl.limiter.AllowN(key, n)
+
+	header := c.Response().Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(rl.limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	if !allowed {
+		header.Set("Retry-After", strconv.Itoa(retryAfterSeconds(reset)))
+		rl.logger.Warn("Rate limit exceeded",
+			clientField(rl.anonymize, "key", key),
+			zap.String("path", c.Path()),
+			zap.Int("count", n),
+			zap.Int("limit", rl.limit),
+			zap.Duration("window", rl.window),
+		)
+	}
+	return allowed
+}
+
+// sendBudgetKey is the context key the limiter counting the messages each user sends is stored under
+const sendBudgetKey = "send_budget"
 
+// SendBudget makes the limiter the route's budget of messages sent per user
+// Handlers that send several messages in one request count each of them with TakeSendBudget or ChargeSendBudget
+func (rl *RateLimiter) SendBudget() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(sendBudgetKey, rl)
 			return next(c)
 		}
 	}
 }
 
-// AuthLimit is a specialized rate limiter for authentication endpoints
-func (rl *RateLimiter) AuthLimit() echo.MiddlewareFunc {
-	// More restrictive rate limit for auth endpoints
-	authRateLimit := NewRateLimiter(20, 5*time.Minute, rl.logger)
-	return authRateLimit.Limit()
+// TakeSendBudget counts n messages about to be sent by the user against the route's send budget
+// If they don't all fit, none are counted and a 429 error is returned; routes without a send budget allow them all
+func TakeSendBudget(c echo.Context, userID string, n int) error {
+	rl, ok := c.Get(sendBudgetKey).(*RateLimiter)
+	if !ok {
+		return nil
+	}
+
+	if !rl.allowN(c, "user:"+userID, n) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many messages. Please try again later.")
+	}
+	return nil
+}
+
+// ChargeSendBudget counts n messages the user has already sent, such as ones restored from a backup, against the route's send budget
+// When they don't all fit they use up whatever is left, so the user can send no more until the window moves on
+func ChargeSendBudget(c echo.Context, userID string, n int) {
+	rl, ok := c.Get(sendBudgetKey).(*RateLimiter)
+	if !ok || n <= 0 {
+		return
+	}
+
+	key := "user:" + userID
+	if allowed, remaining, _ := rl.limiter.AllowN(key, n); !allowed && remaining > 0 {
+		rl.limiter.AllowN(key, remaining)
+	}
+}
+
+// retryAfterSeconds rounds the wait until reset up to whole seconds, and is at least 1
+func retryAfterSeconds(reset time.Time) int {
+	seconds := int(math.Ceil(time.Until(reset).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// AuthRateLimit rate limits each client IP across the routes that take a username, to slow down guessing them
+// It builds a single limiter, so apply the returned middleware to each of those routes rather than calling it per route
+func AuthRateLimit(limiters *RateLimiters, logger *zap.Logger) echo.MiddlewareFunc {
+	limiter := limiters.New("auth", authRateLimit, authRateWindow, logger.With(zap.String("limit", "auth")))
+	return limiter.Limit()
+}
+
+// UsernameLimit rate limits lookups of each username, no matter which client makes them
+// It is a no-op unless anti-enumeration is enabled
+func UsernameLimit(limiters *RateLimiters, logger *zap.Logger) echo.MiddlewareFunc {
+	cfg := limiters.cfg
+	if !cfg.AntiEnumeration.Enabled {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+
+	limiter := limiters.New("username", cfg.AntiEnumeration.Limit, cfg.AntiEnumeration.Window, logger.With(zap.String("limit", "username")))
+	return limiter.limitBy(usernameKey, func(c echo.Context) bool {
+		return lookupUsername(c) == ""
+	})
+}
+
+// RouteRateLimiter applies the configured per-route rate limits
+// Each route has its own buckets, keyed by user when authenticated
+type RouteRateLimiter struct {
+	limiters map[string]*RateLimiter
+}
+
+// NewRouteRateLimiter creates a rate limiter for every route listed in the config
+func NewRouteRateLimiter(limiters *RateLimiters, logger *zap.Logger) *RouteRateLimiter {
+	routes := limiters.cfg.RateLimit.Routes
+	routeLimiters := make(map[string]*RateLimiter, len(routes))
+	for route, limit := range routes {
+		routeLimiters[route] = limiters.New("route:"+route, limit.Limit, limit.Window, logger.With(zap.String("route", route)))
+	}
+
+	return &RouteRateLimiter{limiters: routeLimiters}
+}
+
+// Limit middleware enforces the limit of the matched route
+// Routes without a configured limit are left to the global rate limiter
+func (rl *RouteRateLimiter) Limit() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limiter, ok := rl.limiters[config.RouteKey(c.Request().Method, c.Path())]
+			if !ok {
+				return next(c)
+			}
+			return limiter.LimitByUser()(next)(c)
+		}
+	}
+}
+
+// For returns the limiter of the route, or nil when the route has no limit of its own
+func (rl *RouteRateLimiter) For(method, path string) *RateLimiter {
+	return rl.limiters[config.RouteKey(method, path)]
+}
+
+// hasRouteLimit reports whether the matched route has its own rate limit
+func hasRouteLimit(routes config.RouteLimits) func(c echo.Context) bool {
+	return func(c echo.Context) bool {
+		_, ok := routes[config.RouteKey(c.Request().Method, c.Path())]
+		return ok
+	}
 }
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// redisLimiterTimeout bounds each Redis round trip so a slow server can't stall requests
+const redisLimiterTimeout = 500 * time.Millisecond
+
+// slidingWindowScript counts requests in a sorted set scored by millisecond timestamp
+// It drops requests that left the window, records the ARGV[5] new ones if they all fit, and returns
+// {allowed, remaining, reset in milliseconds}, all in one atomic step
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local n = tonumber(ARGV[5])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+local allowed = 0
+if count + n <= limit then
+	for i = 1, n do
+		redis.call('ZADD', key, now, ARGV[4] .. ':' .. i)
+	end
+	count = count + n
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = now + window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window
+end
+
+return {allowed, limit - count, reset}
+`)
+
+// RedisRateLimiter is a sliding window Limiter shared by all replicas through Redis
+type RedisRateLimiter struct {
+	client *redis.Client
+	prefix string
+	limit  int
+	window time.Duration
+	logger *zap.Logger
+}
+
+// NewRedisRateLimiter connects to the Redis server at url
+// Keys are prefixed with name so several limiters can share a server
+func NewRedisRateLimiter(url, name string, limit int, window time.Duration, logger *zap.Logger) (*RedisRateLimiter, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	return &RedisRateLimiter{
+		client: redis.NewClient(opts),
+		prefix: "wave:ratelimit:" + name + ":",
+		limit:  limit,
+		window: window,
+		logger: logger.With(zap.String("limiter", name)),
+	}, nil
+}
+
+// Allow records a request for the key and reports whether it is within the limit
+// If Redis can't be reached the request is allowed, so an outage doesn't take the API down with it
+func (rl *RedisRateLimiter) Allow(key string) (bool, int, time.Time) {
+	return rl.AllowN(key, 1)
+}
+
+// AllowN records n requests for the key if they all fit within the limit, and reports whether they did
+func (rl *RedisRateLimiter) AllowN(key string, n int) (bool, int, time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisLimiterTimeout)
+	defer cancel()
+
+	now := time.Now()
+	result, err := slidingWindowScript.Run(ctx, rl.client, []string{rl.prefix + key},
+		now.UnixMilli(), rl.window.Milliseconds(), rl.limit, uuid.NewString(), n).Int64Slice()
+	if err != nil || len(result) != 3 {
+		rl.logger.Warn("Rate limit check failed, allowing request", zap.Error(err))
+		return true, rl.limit, now.Add(rl.window)
+	}
+
+	remaining := int(result[1])
+	if remaining < 0 {
+		remaining = 0
+	}
+	return result[0] == 1, remaining, time.UnixMilli(result[2])
+}
+
+// Close closes the Redis connection
+func (rl *RedisRateLimiter) Close() error {
+	return rl.client.Close()
+}
@@ -0,0 +1,406 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+)
+
+// newTestRateLimiters creates rate limiters for cfg that are closed when the test ends
+func newTestRateLimiters(t *testing.T, cfg *config.Config) *RateLimiters {
+	t.Helper()
+
+	limiters := NewRateLimiters(cfg)
+	t.Cleanup(func() { _ = limiters.Close() })
+	return limiters
+}
+
+func newRouteLimitedEcho(t *testing.T, routes config.RouteLimits) *echo.Echo {
+	e := echo.New()
+	cfg := &config.Config{}
+	cfg.RateLimit.Routes = routes
+	limiter := NewRouteRateLimiter(newTestRateLimiters(t, cfg), zap.NewNop())
+
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userID := c.Request().Header.Get("X-Test-User"); userID != "" {
+				c.Set("user_id", userID)
+			}
+			return next(c)
+		}
+	}
+
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/send", ok, setUser, limiter.Limit())
+	e.GET("/inbox", ok, setUser, limiter.Limit())
+	e.GET("/other", ok, setUser, limiter.Limit())
+	return e
+}
+
+func doRequest(e *echo.Echo, method, path, userID string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if userID != "" {
+		req.Header.Set("X-Test-User", userID)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRouteRateLimiterIndependentBuckets(t *testing.T) {
+	e := newRouteLimitedEcho(t, config.RouteLimits{
+		"POST /send": {Limit: 1, Window: time.Minute},
+		"GET /inbox": {Limit: 2, Window: time.Minute},
+	})
+
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/send", "alice"))
+
+	// Exhausting /send must not affect /inbox
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/inbox", "alice"))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/inbox", "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodGet, "/inbox", "alice"))
+
+	// Routes without a limit are left to the global limiter
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/other", "alice"))
+	}
+}
+
+func TestRouteRateLimiterKeysByUser(t *testing.T) {
+	e := newRouteLimitedEcho(t, config.RouteLimits{
+		"POST /send": {Limit: 1, Window: time.Minute},
+	})
+
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/send", "alice"))
+
+	// Same IP, different user gets its own bucket
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", "bob"))
+
+	// Unauthenticated requests fall back to the IP bucket
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", ""))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/send", ""))
+}
+
+func TestLimitByUser(t *testing.T) {
+	e := echo.New()
+	limiter := NewRateLimiter(1, time.Minute, zap.NewNop())
+	t.Cleanup(func() { _ = limiter.Close() })
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userID := c.Request().Header.Get("X-Test-User"); userID != "" {
+				c.Set("user_id", userID)
+			}
+			return next(c)
+		}
+	}
+	e.POST("/send", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, setUser, limiter.LimitByUser())
+
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/send", "alice"))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", "bob"))
+
+	// A user ID and an IP never share a bucket
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/send", ""))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/send", ""))
+}
+
+func TestAuthRateLimitSharedAcrossRoutes(t *testing.T) {
+	e := echo.New()
+	authLimit := AuthRateLimit(newTestRateLimiters(t, &config.Config{}), zap.NewNop())
+
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/login", ok, authLimit)
+	e.POST("/register", ok, authLimit)
+
+	for i := 0; i < authRateLimit/2; i++ {
+		require.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/login", ""))
+		require.Equal(t, http.StatusOK, doRequest(e, http.MethodPost, "/register", ""))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodPost, "/login", ""))
+}
+
+func TestAuthRateLimitBlocksBruteForce(t *testing.T) {
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/api/v1/auth/login", ok, AuthRateLimit(newTestRateLimiters(t, &config.Config{}), zap.NewNop()))
+
+	for i := 1; i <= 25; i++ {
+		code := doRequest(e, http.MethodPost, "/api/v1/auth/login", "")
+		if i <= authRateLimit {
+			require.Equal(t, http.StatusOK, code, "request %d", i)
+		} else {
+			require.Equal(t, http.StatusTooManyRequests, code, "request %d", i)
+		}
+	}
+}
+
+func TestMemoryRateLimiterCloseStopsCleanup(t *testing.T) {
+	limiter := NewMemoryRateLimiter(1, time.Minute)
+	require.NoError(t, limiter.Close())
+
+	select {
+	case <-limiter.done:
+	default:
+		t.Fatal("cleanup goroutine still running after Close")
+	}
+
+	// Closing twice is harmless, and the limiter still counts requests
+	require.NoError(t, limiter.Close())
+	allowed, _, _ := limiter.Allow("key")
+	assert.True(t, allowed)
+}
+
+func TestMemoryRateLimiterAllowN(t *testing.T) {
+	limiter := NewMemoryRateLimiter(5, time.Minute)
+	t.Cleanup(func() { _ = limiter.Close() })
+
+	allowed, remaining, _ := limiter.AllowN("key", 3)
+	assert.True(t, allowed)
+	assert.Equal(t, 2, remaining)
+
+	// Requests that don't all fit are refused without counting any of them
+	allowed, remaining, _ = limiter.AllowN("key", 3)
+	assert.False(t, allowed)
+	assert.Equal(t, 2, remaining)
+
+	allowed, remaining, _ = limiter.AllowN("key", 2)
+	assert.True(t, allowed)
+	assert.Equal(t, 0, remaining)
+}
+
+func TestSendBudget(t *testing.T) {
+	limiter := NewRateLimiter(3, time.Minute, zap.NewNop())
+	t.Cleanup(func() { _ = limiter.Close() })
+
+	e := echo.New()
+	var taken []error
+	e.POST("/send", func(c echo.Context) error {
+		taken = append(taken, TakeSendBudget(c, "alice", 2))
+		return c.NoContent(http.StatusOK)
+	}, limiter.SendBudget())
+	e.POST("/restore", func(c echo.Context) error {
+		ChargeSendBudget(c, "bob", 10)
+		taken = append(taken, TakeSendBudget(c, "bob", 1))
+		return c.NoContent(http.StatusOK)
+	}, limiter.SendBudget())
+	e.POST("/unlimited", func(c echo.Context) error {
+		taken = append(taken, TakeSendBudget(c, "alice", 100))
+		return c.NoContent(http.StatusOK)
+	})
+
+	doRequest(e, http.MethodPost, "/send", "")
+	doRequest(e, http.MethodPost, "/send", "")
+	require.Len(t, taken, 2)
+	assert.NoError(t, taken[0])
+	if he, ok := taken[1].(*echo.HTTPError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusTooManyRequests, he.Code)
+	}
+
+	// Messages already sent use up the rest of the budget even when there are more of them than it holds
+	doRequest(e, http.MethodPost, "/restore", "")
+	require.Len(t, taken, 3)
+	assert.Error(t, taken[2])
+
+	// Routes without a send budget don't count messages
+	doRequest(e, http.MethodPost, "/unlimited", "")
+	require.Len(t, taken, 4)
+	assert.NoError(t, taken[3])
+}
+
+func TestRateLimitersCloseStopsTheirLimiters(t *testing.T) {
+	limiters := NewRateLimiters(&config.Config{})
+	limiter := limiters.New("test", 1, time.Minute, zap.NewNop())
+	memory := limiter.limiter.(*MemoryRateLimiter)
+
+	require.NoError(t, limiters.Close())
+
+	select {
+	case <-memory.done:
+	default:
+		t.Fatal("cleanup goroutine still running after RateLimiters.Close")
+	}
+
+	// Closed limiters are forgotten, so closing again has nothing to do
+	require.NoError(t, limiters.Close())
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	e := echo.New()
+	limiter := NewRateLimiter(2, time.Minute, zap.NewNop())
+	t.Cleanup(func() { _ = limiter.Close() })
+	e.GET("/inbox", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, limiter.Limit())
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inbox", nil))
+		return rec
+	}
+
+	first := get()
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("X-RateLimit-Remaining"))
+	reset, err := strconv.ParseInt(first.Header().Get("X-RateLimit-Reset"), 10, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), reset, 2)
+	assert.Empty(t, first.Header().Get("Retry-After"))
+
+	assert.Equal(t, "0", get().Header().Get("X-RateLimit-Remaining"))
+
+	blocked := get()
+	assert.Equal(t, http.StatusTooManyRequests, blocked.Code)
+	assert.Equal(t, "0", blocked.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, first.Header().Get("X-RateLimit-Reset"), blocked.Header().Get("X-RateLimit-Reset"))
+	retryAfter, err := strconv.Atoi(blocked.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.True(t, retryAfter > 0 && retryAfter <= 60)
+}
+
+func TestGlobalLimiterSkipsRoutesWithOwnLimit(t *testing.T) {
+	routes := config.RouteLimits{"GET /inbox": {Limit: 10, Window: time.Minute}}
+
+	e := echo.New()
+	global := NewRateLimiter(1, time.Minute, zap.NewNop())
+	t.Cleanup(func() { _ = global.Close() })
+	e.Use(global.limitBy(ipKey, hasRouteLimit(routes)))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/inbox", ok)
+	e.GET("/other", ok)
+
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/inbox", ""))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/inbox", ""))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/other", ""))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodGet, "/other", ""))
+}
+
+func TestUsernameLimit(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AntiEnumeration.Enabled = true
+	cfg.AntiEnumeration.Limit = 1
+	cfg.AntiEnumeration.Window = time.Minute
+
+	limiters := newTestRateLimiters(t, cfg)
+
+	e := echo.New()
+	e.GET("/lookup", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, UsernameLimit(limiters, zap.NewNop()))
+	e.POST("/login", func(c echo.Context) error {
+		// The handler must still see the body after the limiter read it
+		var body struct {
+			Username string `json:"username"`
+		}
+		if err := c.Bind(&body); err != nil || body.Username == "" {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		return c.NoContent(http.StatusOK)
+	}, UsernameLimit(limiters, zap.NewNop()))
+
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/lookup?username=alice", ""))
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(e, http.MethodGet, "/lookup?username=alice", ""))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/lookup?username=bob", ""))
+
+	// Requests without a username are not limited
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/lookup", ""))
+	assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/lookup", ""))
+
+	login := func(username string) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"`+username+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, login("carol"))
+	assert.Equal(t, http.StatusTooManyRequests, login("carol"))
+}
+
+func TestLookupUsernameReadsBodyOnce(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	assert.Equal(t, "username:alice", usernameKey(c))
+
+	// Later calls use the username stored in the context rather than parsing the body again
+	c.Request().Body = http.NoBody
+	assert.Equal(t, "alice", lookupUsername(c))
+	assert.Equal(t, "username:alice", usernameKey(c))
+}
+
+func TestUsernameLimitDisabled(t *testing.T) {
+	cfg := &config.Config{}
+
+	e := echo.New()
+	e.GET("/lookup", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, UsernameLimit(newTestRateLimiters(t, cfg), zap.NewNop()))
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(e, http.MethodGet, "/lookup?username=alice", ""))
+	}
+}
+
+// testRedisEnv names the environment variable holding the test Redis URL
+// Redis tests are skipped when it is not set
+const testRedisEnv = "WAVE_TEST_REDIS_URL"
+
+func TestNewRateLimiterFromConfigSelectsBackend(t *testing.T) {
+	cfg := &config.Config{}
+	limiters := newTestRateLimiters(t, cfg)
+	limiter := limiters.New("test", 1, time.Minute, zap.NewNop())
+	assert.IsType(t, &MemoryRateLimiter{}, limiter.limiter)
+
+	// A bad Redis URL falls back to memory rather than leaving routes unlimited
+	cfg.RateLimit.Backend = config.RateLimitBackendRedis
+	cfg.Cache.RedisURL = "not a url"
+	limiter = limiters.New("test", 1, time.Minute, zap.NewNop())
+	assert.IsType(t, &MemoryRateLimiter{}, limiter.limiter)
+
+	cfg.Cache.RedisURL = "redis://localhost:6379/0"
+	limiter = limiters.New("test", 1, time.Minute, zap.NewNop())
+	assert.IsType(t, &RedisRateLimiter{}, limiter.limiter)
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	url := os.Getenv(testRedisEnv)
+	if url == "" {
+		t.Skipf("%s not set, skipping redis test", testRedisEnv)
+	}
+
+	limiter, err := NewRedisRateLimiter(url, "test", 2, time.Minute, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = limiter.Close() })
+
+	key := "alice-" + time.Now().Format(time.RFC3339Nano)
+	allowed, remaining, reset := limiter.Allow(key)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, remaining)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), reset, 2*time.Second)
+
+	allowed, remaining, _ = limiter.Allow(key)
+	assert.True(t, allowed)
+	assert.Equal(t, 0, remaining)
+
+	allowed, remaining, blockedReset := limiter.Allow(key)
+	assert.False(t, allowed)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, reset.Unix(), blockedReset.Unix())
+
+	// Other keys have their own budget, and several requests can be counted at once
+	allowed, _, _ = limiter.AllowN(key+"-other", 3)
+	assert.False(t, allowed)
+	allowed, remaining, _ = limiter.AllowN(key+"-other", 2)
+	assert.True(t, allowed)
+	assert.Equal(t, 0, remaining)
+}
@@ -9,17 +9,20 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
 )
 
 // RecoveryMiddleware handles panic recovery
 type RecoveryMiddleware struct {
-	logger *zap.Logger
+	logger    *zap.Logger
+	anonymize bool // Omit client IPs from panic logs
 }
 
 // NewRecoveryMiddleware creates a new recovery middleware
-func NewRecoveryMiddleware(logger *zap.Logger) *RecoveryMiddleware {
+func NewRecoveryMiddleware(logger *zap.Logger, cfg *config.Config) *RecoveryMiddleware {
 	return &RecoveryMiddleware{
-		logger: logger.With(zap.String("middleware", "recovery")),
+		logger:    logger.With(zap.String("middleware", "recovery")),
+		anonymize: cfg.LogAnonymize,
 	}
 }
 
@@ -45,7 +48,7 @@ func (m *RecoveryMiddleware) Recover() echo.MiddlewareFunc {
 						zap.String("stack", stackTrace),
 						zap.String("method", c.Request().Method),
 						zap.String("path", c.Request().URL.Path),
-						zap.String("client_ip", c.RealIP()),
+						clientField(m.anonymize, "client_ip", c.RealIP()),
 					)
 
 					// Return a generic error to the client
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// longLivedRoutes are routes whose connections stay open for as long as the client wants them
+// They are exempt from the request timeout, which would otherwise cut them off
+var longLivedRoutes = map[string]bool{
+	"/api/v1/messages/stream": true,
+	"/api/v1/ws":              true,
+	"/api/v1/account/backup":  true,
+	"/api/v1/account/export":  true,
+}
+
+// isLongLived reports whether the matched route is a long-lived connection
+func isLongLived(c echo.Context) bool {
+	return longLivedRoutes[c.Path()]
+}
+
+// RequestTimeout gives each request's context a deadline of timeout, so database queries it starts are cancelled
+// once the client can no longer get an answer instead of holding a pool connection
+// Requests for which skip returns true keep their context as it is; a timeout of 0 disables the middleware
+func RequestTimeout(timeout time.Duration, skip func(c echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if timeout <= 0 {
+			return next
+		}
+
+		return func(c echo.Context) error {
+			if skip != nil && skip(c) {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
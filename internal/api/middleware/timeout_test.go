@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	e := echo.New()
+	e.Use(RequestTimeout(time.Minute, isLongLived))
+
+	deadlines := map[string]bool{}
+	record := func(c echo.Context) error {
+		_, ok := c.Request().Context().Deadline()
+		deadlines[c.Path()] = ok
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/api/v1/messages", record)
+	e.GET("/api/v1/messages/stream", record)
+	e.GET("/api/v1/account/backup", record)
+	e.GET("/api/v1/account/export", record)
+
+	paths := []string{"/api/v1/messages", "/api/v1/messages/stream", "/api/v1/account/backup", "/api/v1/account/export"}
+	for _, path := range paths {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.True(t, deadlines["/api/v1/messages"], "requests get a deadline")
+	assert.False(t, deadlines["/api/v1/messages/stream"], "long-lived connections are left alone")
+	assert.False(t, deadlines["/api/v1/account/backup"], "backups stream for as long as they take")
+	assert.False(t, deadlines["/api/v1/account/export"], "exports stream for as long as they take")
+}
+
+func TestRequestTimeoutCancelsSlowWork(t *testing.T) {
+	e := echo.New()
+	e.Use(RequestTimeout(10*time.Millisecond, nil))
+	e.GET("/slow", func(c echo.Context) error {
+		select {
+		case <-c.Request().Context().Done():
+			return c.Request().Context().Err()
+		case <-time.After(time.Second):
+			return c.NoContent(http.StatusOK)
+		}
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+}
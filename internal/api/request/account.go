@@ -14,3 +14,9 @@ type RecoverAccountRequest struct {
 type DeleteAccountRequest struct {
 	// Empty struct
 }
+
+// UpdatePrivacyRequest is the request body for updating privacy settings
+type UpdatePrivacyRequest struct {
+	// Discoverable controls whether users this user has added can see them as an incoming contact
+	Discoverable *bool `json:"discoverable" validate:"required"`
+}
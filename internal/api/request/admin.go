@@ -0,0 +1,7 @@
+package request
+
+// InactiveUsersRequest is the query parameters for listing or deleting inactive users
+type InactiveUsersRequest struct {
+	Before string `query:"before" validate:"required"` // RFC 3339 time; users last active before it are inactive
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+}
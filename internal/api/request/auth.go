@@ -6,15 +6,32 @@ type RegisterRequest struct {
 	PublicKey           string `json:"public_key" validate:"required"`
 	EncryptedPrivateKey string `json:"encrypted_private_key" validate:"required"`
 	Salt                string `json:"salt" validate:"required"`
+	DeviceName          string `json:"device_name,omitempty" validate:"max=100"`
+
+	// PoWChallenge and PoWNonce solve a challenge from GET /auth/challenge; required only when the server asks for proof of work
+	PoWChallenge string `json:"pow_challenge,omitempty" validate:"max=200"`
+	PoWNonce     string `json:"pow_nonce,omitempty" validate:"max=64"`
 }
 
 // LoginRequest is the request body for user login
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
+	Username   string `json:"username" validate:"required"`
+	DeviceName string `json:"device_name,omitempty" validate:"max=100"` // Labels the session in the sessions list
+}
+
+// UsernameAvailableRequest is the query parameters for checking whether a username is available
+type UsernameAvailableRequest struct {
+	Username string `query:"username" validate:"required,min=3,max=50"`
 }
 
 // RefreshTokenRequest is the request body for token refresh
-// The token itself is sent in the Authorization header
 type RefreshTokenRequest struct {
-	// Empty struct as the token is in the header
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// ListSessionsRequest is the query parameters for listing sessions
+type ListSessionsRequest struct {
+	Limit  int  `query:"limit" validate:"omitempty,min=1,max=100"`
+	Offset int  `query:"offset" validate:"omitempty,min=0"`
+	Active bool `query:"active"` // Only sessions that haven't expired; true unless active=false is given
 }
@@ -0,0 +1,12 @@
+package request
+
+// BlockRequest is the request body for blocking a public key
+type BlockRequest struct {
+	PublicKey string `json:"public_key" validate:"required"`
+}
+
+// GetBlocksRequest is the query parameters for listing blocked public keys
+type GetBlocksRequest struct {
+	Limit  int `query:"limit" validate:"omitempty,min=1,max=1000"`
+	Offset int `query:"offset" validate:"omitempty,min=0"`
+}
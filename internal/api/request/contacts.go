@@ -4,11 +4,39 @@ package request
 type AddContactRequest struct {
 	ContactPublicKey string `json:"contact_public_key" validate:"required"`
 	Nickname         string `json:"nickname" validate:"required,max=50"`
+	GroupName        string `json:"group_name" validate:"max=50"`
+}
+
+// ImportContactsRequest is the request body for importing contacts in bulk
+// Entries are validated one by one so a bad entry doesn't reject the whole import
+type ImportContactsRequest struct {
+	Contacts []ImportContactItem `json:"contacts" validate:"required,min=1,max=1000"`
+}
+
+// ImportContactItem is a single contact to import
+type ImportContactItem struct {
+	ContactPubKey string `json:"contact_pubkey"`
+	Nickname      string `json:"nickname"`
+	GroupName     string `json:"group_name"`
+}
+
+// DeleteContactsRequest is the request body for deleting contacts in bulk
+type DeleteContactsRequest struct {
+	ContactPubKeys []string `json:"contact_pubkeys" validate:"required,min=1,max=1000,dive,required"`
 }
 
 // UpdateContactRequest is the request body for updating a contact
+// Leaving out group_name keeps the current group; an empty one ungroups the contact
 type UpdateContactRequest struct {
-	Nickname string `json:"nickname" validate:"required,max=50"`
+	Nickname  string  `json:"nickname" validate:"required,max=50"`
+	GroupName *string `json:"group_name" validate:"omitempty,max=50"`
+}
+
+// GetContactsRequest is the query parameters for listing contacts
+type GetContactsRequest struct {
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+	Offset int    `query:"offset" validate:"omitempty,min=0"`
+	Group  string `query:"group" validate:"omitempty,max=50"` // Only list contacts in this group
 }
 
 // GetContactRequest is the path parameter for getting a contact
@@ -2,6 +2,7 @@ package request
 
 // SendMessageRequest is the request body for sending a message
 type SendMessageRequest struct {
+	MessageID           string `json:"message_id,omitempty" validate:"omitempty,uuid"` // Client-chosen ID; resending with the same ID doesn't create a duplicate
 	RecipientPubKey     string `json:"recipient_pubkey" validate:"required"`
 	CiphertextKEM       string `json:"ciphertext_kem" validate:"required"`
 	CiphertextMsg       string `json:"ciphertext_msg" validate:"required"`
@@ -9,10 +10,55 @@ type SendMessageRequest struct {
 	SenderCiphertextKEM string `json:"sender_ciphertext_kem" validate:"required"`
 	SenderCiphertextMsg string `json:"sender_ciphertext_msg" validate:"required"`
 	SenderNonce         string `json:"sender_nonce" validate:"required"`
+	ReplyToMessageID    string `json:"reply_to_message_id,omitempty" validate:"omitempty,uuid"`
+	ExpiresInSeconds    int    `json:"expires_in_seconds,omitempty" validate:"omitempty,min=1,max=2592000"` // Delete the message this long after sending; at most 30 days
+}
+
+// SendMultiMessageRequest is the request body for sending one message to several recipients
+// Each recipient gets their own ciphertext; the sender's copy is sent once and stored with every recipient's message
+// Recipients is bounded by domain.MaxMessageRecipients
+type SendMultiMessageRequest struct {
+	Recipients          []MessageRecipient `json:"recipients" validate:"required,min=1,max=100,dive"`
+	SenderCiphertextKEM string             `json:"sender_ciphertext_kem" validate:"required"`
+	SenderCiphertextMsg string             `json:"sender_ciphertext_msg" validate:"required"`
+	SenderNonce         string             `json:"sender_nonce" validate:"required"`
+	ExpiresInSeconds    int                `json:"expires_in_seconds,omitempty" validate:"omitempty,min=1,max=2592000"` // Delete the messages this long after sending; at most 30 days
+}
+
+// MessageRecipient is a message encrypted for one of its recipients
+type MessageRecipient struct {
+	RecipientPubKey string `json:"recipient_pubkey" validate:"required"`
+	CiphertextKEM   string `json:"ciphertext_kem" validate:"required"`
+	CiphertextMsg   string `json:"ciphertext_msg" validate:"required"`
+	Nonce           string `json:"nonce" validate:"required"`
 }
 
 // GetMessagesRequest is the query parameters for getting messages
 type GetMessagesRequest struct {
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+	Offset int    `query:"offset" validate:"omitempty,min=0"`
+	Before string `query:"before"` // Cursor: a message timestamp or message ID; replaces offset when set
+	Since  string `query:"since"`  // Cursor for ascending order, paging forward from a message timestamp (or, in conversations, a message ID)
+	Order  string `query:"order"`  // asc or desc (the default)
+}
+
+// SearchMessagesRequest is the query parameters for searching messages by metadata
+type SearchMessagesRequest struct {
+	From   string `query:"from"`                                                         // RFC 3339 time; only messages sent at or after it
+	To     string `query:"to"`                                                           // RFC 3339 time; only messages sent before it
+	Peer   string `query:"peer"`                                                         // Public key of the other party
+	Status string `query:"status" validate:"omitempty,oneof=sent delivered read failed"` // Only messages with this status
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+	Offset int    `query:"offset" validate:"omitempty,min=0"`
+}
+
+// GetUnreadCountRequest is the query parameters for counting unread messages
+type GetUnreadCountRequest struct {
+	BySender bool `query:"by_sender"`
+}
+
+// ListConversationsRequest is the query parameters for listing conversations
+type ListConversationsRequest struct {
 	Limit  int `query:"limit" validate:"omitempty,min=1,max=1000"`
 	Offset int `query:"offset" validate:"omitempty,min=0"`
 }
@@ -26,5 +72,11 @@ type GetConversationRequest struct {
 
 // UpdateMessageStatusRequest is the request body for updating a message's status
 type UpdateMessageStatusRequest struct {
-	Status string `json:"status" validate:"required,oneof=sent delivered read"`
+	Status string `json:"status" validate:"required,oneof=delivered read"`
+}
+
+// UpdateMessageStatusBatchRequest is the request body for updating the status of several messages
+type UpdateMessageStatusBatchRequest struct {
+	MessageIDs []string `json:"message_ids" validate:"required,min=1,max=1000,dive,uuid"`
+	Status     string   `json:"status" validate:"required,oneof=delivered read"`
 }
@@ -53,7 +53,7 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 			// Log validation errors at debug level
 			cv.logger.Debug("Validation failed", zap.Any("errors", fieldsErrors))
 
-			return errors.NewValidationError("Validation failed", err)
+			return errors.NewValidationError("Validation failed", err).WithDetails(fieldsErrors)
 		}
 
 		return errors.NewValidationError("Validation failed", err)
@@ -87,7 +87,7 @@ func ValidateRequest(c echo.Context, req interface{}) error {
 
 	if err := c.Validate(req); err != nil {
 		if appErr, ok := errors.IsAppError(err); ok {
-			return echo.NewHTTPError(appErr.Status, appErr.Message)
+			return appErr
 		}
 		return echo.NewHTTPError(http.StatusBadRequest, "Validation failed")
 	}
@@ -1,4 +1,24 @@
 package response
 
-// This file is intentionally left empty
-// All response types are consolidated in response.go
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+// ErrorContextKey is the context key under which WriteError stores an unexpected error for the request log
+const ErrorContextKey = "error"
+
+// WriteError writes the error response for err
+// An AppError is written with its own status, message, code and details; anything else is an internal error,
+// whose details are kept out of the response and left on the context for the request log
+func WriteError(c echo.Context, err error) error {
+	if appErr, ok := errors.IsAppError(err); ok {
+		return c.JSON(appErr.Status, NewErrorResponseWithDetails(appErr.Message, appErr.Code, appErr.Details))
+	}
+
+	c.Set(ErrorContextKey, err)
+	return c.JSON(http.StatusInternalServerError, NewErrorResponse("Internal server error", errors.ErrCodeInternal))
+}
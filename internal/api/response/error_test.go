@@ -0,0 +1,61 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+		wantCode    string
+		wantDetails map[string]string
+	}{
+		{"app error", errors.NewNotFoundError("Contact"), http.StatusNotFound, "Contact not found", errors.ErrCodeNotFound, nil},
+		{"wrapped app error", fmt.Errorf("lookup: %w", errors.NewConflictError("Contact already exists")), http.StatusConflict, "Contact already exists", errors.ErrCodeConflict, nil},
+		{
+			"app error with details",
+			errors.NewValidationError("Validation failed", nil).WithDetails(map[string]string{"username": "This field is required"}),
+			http.StatusUnprocessableEntity, "Validation failed", errors.ErrCodeValidation,
+			map[string]string{"username": "This field is required"},
+		},
+		{"unexpected error", fmt.Errorf("connection reset"), http.StatusInternalServerError, "Internal server error", errors.ErrCodeInternal, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+			assert.NoError(t, WriteError(c, tt.err))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			var body Response
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.False(t, body.Success)
+			if assert.NotNil(t, body.Error) {
+				assert.Equal(t, tt.wantMessage, body.Error.Message)
+				assert.Equal(t, tt.wantCode, body.Error.Code)
+				assert.Equal(t, tt.wantDetails, body.Error.Details)
+			}
+
+			// Only unexpected errors are left for the request log
+			if tt.wantStatus == http.StatusInternalServerError {
+				assert.Equal(t, tt.err, c.Get(ErrorContextKey))
+			} else {
+				assert.Nil(t, c.Get(ErrorContextKey))
+			}
+		})
+	}
+}
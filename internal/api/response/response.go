@@ -1,5 +1,7 @@
 package response
 
+import "github.com/pzkpfw44/wave-server/pkg/metrics"
+
 // Response is the base API response format
 type Response struct {
 	Success bool        `json:"success"`
@@ -9,8 +11,9 @@ type Response struct {
 
 // ErrorInfo contains error details
 type ErrorInfo struct {
-	Message string `json:"message"`
-	Code    string `json:"code"`
+	Message string            `json:"message"`
+	Code    string            `json:"code"`
+	Details map[string]string `json:"details,omitempty"` // Per-field problems, such as validation failures
 }
 
 // NewSuccessResponse creates a new success response
@@ -23,20 +26,67 @@ func NewSuccessResponse(data interface{}) Response {
 
 // NewErrorResponse creates a new error response
 func NewErrorResponse(message, code string) Response {
+	return NewErrorResponseWithDetails(message, code, nil)
+}
+
+// NewErrorResponseWithDetails creates a new error response with per-field details
+// Every error response is built here, so this is where errors are counted by code
+func NewErrorResponseWithDetails(message, code string, details map[string]string) Response {
+	metrics.RecordError(code)
+
 	return Response{
 		Success: false,
 		Error: &ErrorInfo{
 			Message: message,
 			Code:    code,
+			Details: details,
 		},
 	}
 }
 
+// Pagination describes the page returned by a list response
+type Pagination struct {
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	Total   *int `json:"total,omitempty"` // Only set when the total is known
+	HasMore bool `json:"has_more"`
+}
+
+// Paginate trims items fetched with one extra row beyond limit and describes the page
+// The extra row only signals that another page exists and is never returned
+func Paginate[T any](items []T, limit, offset int) ([]T, Pagination) {
+	pagination := Pagination{
+		Limit:  limit,
+		Offset: offset,
+	}
+	if len(items) > limit {
+		items = items[:limit]
+		pagination.HasMore = true
+	}
+	return items, pagination
+}
+
 // TokenResponse is the response for token requests
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"` // Seconds
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`                   // Seconds
+	RefreshToken     string `json:"refresh_token,omitempty"`      // Exchange at /auth/refresh for new tokens; single-use
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty"` // Seconds
+}
+
+// UsernameAvailableResponse is the response for username availability checks
+type UsernameAvailableResponse struct {
+	Available bool `json:"available"`
+}
+
+// ChallengeResponse is the response for registration proof-of-work challenges
+// When Required is false the other fields are empty and registration needs no proof of work
+type ChallengeResponse struct {
+	Required   bool   `json:"required"`
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
 }
 
 // MessageResponse is the response for message operations
@@ -52,24 +102,209 @@ type MessageResponse struct {
 	SenderNonce         string `json:"sender_nonce,omitempty"`
 	Timestamp           string `json:"timestamp"`
 	Status              string `json:"status"`
+	ContentHash         string `json:"content_hash,omitempty"`
+	ReplyToMessageID    string `json:"reply_to_message_id,omitempty"`
+	ExpiresAt           string `json:"expires_at,omitempty"`
+
+	// ReplyTo describes the replied-to message; only included in conversation responses
+	ReplyTo *MessageReferenceResponse `json:"reply_to,omitempty"`
+}
+
+// MessageReferenceResponse is the metadata of a referenced message, without its content
+type MessageReferenceResponse struct {
+	MessageID    string `json:"message_id"`
+	SenderPubKey string `json:"sender_pubkey"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// ReceiptResponse tells a sender over a socket or stream that a message's status changed
+type ReceiptResponse struct {
+	Type      string `json:"type"` // Always "receipt", to tell it apart from new messages on a socket
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// MessageTimelineResponse is the response for a message's delivery timeline
+type MessageTimelineResponse struct {
+	MessageID   string  `json:"message_id"`
+	Status      string  `json:"status"`
+	CreatedAt   string  `json:"created_at"`
+	DeliveredAt *string `json:"delivered_at"`
+	ReadAt      *string `json:"read_at"`
+}
+
+// UnreadCountResponse is the response for counting unread messages
+type UnreadCountResponse struct {
+	Count    int            `json:"count"`
+	BySender map[string]int `json:"by_sender,omitempty"` // Unread count per sender public key, when requested
+}
+
+// ConversationResponse summarizes the conversation with one peer
+type ConversationResponse struct {
+	PeerPubKey    string `json:"peer_pubkey"`
+	LastMessageAt string `json:"last_message_at"`
+	UnreadCount   int    `json:"unread_count"`
+}
+
+// ConversationsResponse is the response for listing conversations
+type ConversationsResponse struct {
+	Conversations []ConversationResponse `json:"conversations"`
+	Pagination    Pagination             `json:"pagination"`
+}
+
+// SendMultiMessageResponse is the response for sending a message to several recipients
+type SendMultiMessageResponse struct {
+	MessageIDs []string `json:"message_ids"` // In the order the recipients were given
+	Timestamp  string   `json:"timestamp"`
+	Failed     int      `json:"failed"` // Copies to recipients who can't receive them; they are kept as failed for resending
 }
 
 // MessagesResponse is the response for listing messages
 type MessagesResponse struct {
-	Messages []MessageResponse `json:"messages"`
-	Total    int               `json:"total"`
-	Limit    int               `json:"limit"`
-	Offset   int               `json:"offset"`
+	Messages   []MessageResponse `json:"messages"`
+	Pagination Pagination        `json:"pagination"`
+	NextCursor string            `json:"next_cursor,omitempty"` // Pass as `before` to get the next page
+}
+
+// SearchScopeMetadata is the only search scope: message bodies are end-to-end encrypted,
+// so searches match time, peer and status but never content
+const SearchScopeMetadata = "metadata"
+
+// SearchMessagesResponse is the response for searching messages
+type SearchMessagesResponse struct {
+	Messages   []MessageResponse `json:"messages"`
+	Pagination Pagination        `json:"pagination"`
+	Scope      string            `json:"scope"` // What the search matched on; always SearchScopeMetadata
 }
 
 // ContactResponse is the response for contact operations
 type ContactResponse struct {
 	ContactPubKey string `json:"contact_pubkey"`
 	Nickname      string `json:"nickname"`
+	GroupName     string `json:"group_name,omitempty"`
+	Username      string `json:"username,omitempty"` // Registered owner of the public key, if discoverable
 	CreatedAt     string `json:"created_at"`
 }
 
 // ContactsResponse is the response for listing contacts
 type ContactsResponse struct {
-	Contacts []ContactResponse `json:"contacts"`
+	Contacts   []ContactResponse `json:"contacts"`
+	Pagination Pagination        `json:"pagination"`
+}
+
+// BlockResponse is a public key the user has blocked
+type BlockResponse struct {
+	BlockedPubKey string `json:"blocked_pubkey"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// BlocksResponse is the response for listing blocked public keys
+type BlocksResponse struct {
+	Blocks     []BlockResponse `json:"blocks"`
+	Pagination Pagination      `json:"pagination"`
+}
+
+// FingerprintResponse is the safety number for the current user and a contact
+type FingerprintResponse struct {
+	ContactPubKey string `json:"contact_pubkey"`
+	SafetyNumber  string `json:"safety_number"`
+}
+
+// ImportContactsResponse summarizes a bulk contact import
+type ImportContactsResponse struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"` // Already in the user's contacts
+	Invalid int `json:"invalid"` // Missing a public key or with a bad nickname or group
+}
+
+// ContactCountResponse is the response for counting contacts
+type ContactCountResponse struct {
+	Count int `json:"count"`
+}
+
+// DeleteContactsResponse summarizes a bulk contact deletion
+type DeleteContactsResponse struct {
+	Deleted int64 `json:"deleted"` // Keys that weren't among the user's contacts aren't counted
+}
+
+// ContactGroupResponse is a contact group and how many contacts it holds
+type ContactGroupResponse struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ContactGroupsResponse is the response for listing contact groups
+type ContactGroupsResponse struct {
+	Groups []ContactGroupResponse `json:"groups"`
+}
+
+// SessionResponse describes a login session without exposing its token
+type SessionResponse struct {
+	TokenID    string `json:"token_id"`
+	DeviceName string `json:"device_name"`
+	CreatedAt  string `json:"created_at"`
+	LastUsed   string `json:"last_used"`
+	ExpiresAt  string `json:"expires_at"`
+	Active     bool   `json:"active"`
+	Current    bool   `json:"current"` // Whether this session made the request
+}
+
+// SessionsResponse is the response for listing sessions
+type SessionsResponse struct {
+	Sessions    []SessionResponse `json:"sessions"`
+	Pagination  Pagination        `json:"pagination"`
+	ActiveCount int               `json:"active_count"` // Active sessions in total, whatever the filter
+}
+
+// IncomingContactResponse is a user who has added the current user as a contact
+type IncomingContactResponse struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	AddedAt  string `json:"added_at"`
+}
+
+// IncomingContactsResponse is the response for listing incoming contacts
+type IncomingContactsResponse struct {
+	Contacts []IncomingContactResponse `json:"contacts"`
+}
+
+// RateLimitResponse describes a single rate limit
+type RateLimitResponse struct {
+	Limit         int `json:"limit"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// AdminStatsResponse is the response for the admin stats endpoint
+type AdminStatsResponse struct {
+	TotalUsers     int64  `json:"total_users"`
+	TotalMessages  int64  `json:"total_messages"` // Deleted and expired messages aren't counted
+	TotalContacts  int64  `json:"total_contacts"`
+	ActiveSessions int64  `json:"active_sessions"`
+	CollectedAt    string `json:"collected_at"` // When the counts were taken; they are cached briefly
+}
+
+// AdminConfigResponse is the response for the admin config endpoint
+type AdminConfigResponse struct {
+	RateLimit        RateLimitResponse            `json:"rate_limit"`
+	RouteRateLimits  map[string]RateLimitResponse `json:"route_rate_limits"`
+	RateLimitBackend string                       `json:"rate_limit_backend"`
+}
+
+// InactiveUserResponse is a user who hasn't been active since a given time
+type InactiveUserResponse struct {
+	UserID     string `json:"user_id"`
+	Username   string `json:"username"`
+	CreatedAt  string `json:"created_at"`
+	LastActive string `json:"last_active"`
+}
+
+// InactiveUsersResponse is the response for listing inactive users
+type InactiveUsersResponse struct {
+	Users []InactiveUserResponse `json:"users"`
+}
+
+// DeleteInactiveUsersResponse summarizes a deletion of inactive users
+type DeleteInactiveUsersResponse struct {
+	Deleted int `json:"deleted"` // Accounts that failed to delete aren't counted
 }
@@ -0,0 +1,42 @@
+package response
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name        string
+		fetched     int
+		limit       int
+		wantLen     int
+		wantHasMore bool
+	}{
+		{"empty", 0, 10, 0, false},
+		{"partial page", 5, 10, 5, false},
+		{"exactly full page", 10, 10, 10, false},
+		{"one past full page", 11, 10, 10, true},
+		{"limit of one with extra", 2, 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := make([]int, tt.fetched)
+			for i := range items {
+				items[i] = i
+			}
+
+			page, pagination := Paginate(items, tt.limit, 20)
+			assert.Len(t, page, tt.wantLen)
+			assert.Equal(t, tt.wantHasMore, pagination.HasMore)
+			assert.Equal(t, tt.limit, pagination.Limit)
+			assert.Equal(t, 20, pagination.Offset)
+			assert.Nil(t, pagination.Total)
+			if tt.wantLen > 0 {
+				assert.Equal(t, 0, page[0])
+			}
+		})
+	}
+}
@@ -7,12 +7,12 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/handlers"
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/config"
-	"github.com/pzkpfw44/wave-server/internal/service"
 	"github.com/pzkpfw44/wave-server/pkg/health"
 )
 
 // SetupRoutes configures all API routes
-func SetupRoutes(e *echo.Echo, h *handlers.Handler, cfg *config.Config, authService *service.AuthService, healthChecker *health.Checker, logger *zap.Logger) {
+// Their rate limiters are built by limiters, which the caller closes on shutdown
+func SetupRoutes(e *echo.Echo, h *handlers.Handler, cfg *config.Config, authMiddleware *middleware.AuthMiddleware, limiters *middleware.RateLimiters, healthChecker *health.Checker, logger *zap.Logger) {
 	// Health check routes
 	if healthChecker != nil {
 		healthChecker.RegisterHandlers(e)
@@ -29,49 +29,116 @@ func SetupRoutes(e *echo.Echo, h *handlers.Handler, cfg *config.Config, authServ
 	// API versioning
 	v1 := e.Group("/api/v1")
 
-	// Authentication routes (no auth required)
-	auth := v1.Group("/auth")
-	auth.POST("/register", h.Auth.Register)
-	auth.POST("/login", h.Auth.Login)
+	// Per-route rate limits; routes without one use the global limit
+	routeLimiter := middleware.NewRouteRateLimiter(limiters, logger)
+	routeLimit := routeLimiter.Limit()
+
+	// Per-user limits on sending; the limit of /send is also the budget every message sent counts against, whichever route sends it
+	sendLimiter, limitSend := sendLimit(cfg, limiters, routeLimiter, "send", logger)
+	_, limitSendMulti := sendLimit(cfg, limiters, routeLimiter, "send-multi", logger)
+
+	// Per-username limit on lookups that could reveal whether a username exists
+	usernameLimit := middleware.UsernameLimit(limiters, logger)
+
+	// Authentication routes (no auth required); the ones that take a username share a stricter limit on top of their own
+	authLimit := middleware.AuthRateLimit(limiters, logger)
+	auth := v1.Group("/auth", routeLimit)
+	auth.GET("/challenge", h.Auth.GetChallenge)
+	auth.POST("/register", h.Auth.Register, authLimit)
+	auth.GET("/username-available", h.Auth.CheckUsernameAvailable, authLimit, usernameLimit)
+	auth.POST("/login", h.Auth.Login, authLimit, usernameLimit)
 	auth.POST("/refresh", h.Auth.RefreshToken)
 	auth.POST("/logout", h.Auth.Logout)
 
 	// Account recovery route (no auth required)
 	account := v1.Group("/account")
-	account.POST("/recover", h.Account.RecoverAccount)
+	account.POST("/recover", h.Account.RecoverAccount, routeLimit, sendLimiter.SendBudget())
 
 	// Routes requiring authentication
-	// Create middleware for authenticated routes
-	authMiddleware := middleware.AuthOnly(authService, logger)
+	authenticate := authMiddleware.Authenticate()
 
 	// User routes
-	v1.GET("/keys/public", h.Key.GetPublicKey) // This endpoint works with or without auth
-	privateKeys := v1.Group("/keys/private", authMiddleware)
+	v1.GET("/me", h.User.GetMe, authenticate, routeLimit)
+	v1.GET("/keys/public", h.Key.GetPublicKey, routeLimit, usernameLimit) // This endpoint works with or without auth
+	privateKeys := v1.Group("/keys/private", authenticate, routeLimit)
 	privateKeys.GET("", h.Key.GetEncryptedPrivateKey)
 
 	// Message routes
-	messages := v1.Group("/messages", authMiddleware)
-	messages.POST("/send", h.Message.SendMessage)
+	messages := v1.Group("/messages", authenticate, routeLimit)
+	messages.POST("/send", h.Message.SendMessage, limitSend)
+	messages.POST("/send-multi", h.Message.SendMultiMessage, limitSendMulti, sendLimiter.SendBudget())
 	messages.GET("", h.Message.GetMessages)
 	messages.GET("/conversation/:pubkey", h.Message.GetConversation)
+	messages.GET("/failed", h.Message.GetFailedMessages)
+	messages.GET("/search", h.Message.SearchMessages)
+	messages.GET("/unread/count", h.Message.GetUnreadCount)
+	messages.GET("/stream", h.Stream.StreamMessages)
+	messages.PATCH("/status/batch", h.Message.UpdateMessageStatusBatch)
 	messages.PATCH("/:message_id/status", h.Message.UpdateMessageStatus)
+	messages.GET("/:message_id/timeline", h.Message.GetMessageTimeline)
+	messages.POST("/:message_id/resend", h.Message.ResendMessage)
+	messages.DELETE("/:message_id", h.Message.DeleteMessage)
+
+	// Real-time message delivery
+	v1.GET("/ws", h.WebSocket.Connect, authenticate, routeLimit)
+
+	// Conversation routes
+	conversations := v1.Group("/conversations", authenticate, routeLimit)
+	conversations.GET("", h.Message.ListConversations)
 
 	// Contact routes
-	contacts := v1.Group("/contacts", authMiddleware)
+	contacts := v1.Group("/contacts", authenticate, routeLimit)
 	contacts.POST("", h.Contact.AddContact)
+	contacts.POST("/import", h.Contact.ImportContacts)
+	contacts.POST("/delete-batch", h.Contact.DeleteContacts)
 	contacts.GET("", h.Contact.GetContacts)
+	contacts.GET("/count", h.Contact.CountContacts)
+	contacts.GET("/incoming", h.Contact.GetIncomingContacts)
+	contacts.GET("/groups", h.Contact.GetGroups)
 	contacts.GET("/:pubkey", h.Contact.GetContact)
+	contacts.GET("/:pubkey/fingerprint", h.Contact.GetFingerprint)
 	contacts.PUT("/:pubkey", h.Contact.UpdateContact)
 	contacts.DELETE("/:pubkey", h.Contact.DeleteContact)
 
+	// Blocklist routes
+	blocks := v1.Group("/blocks", authenticate, routeLimit)
+	blocks.POST("", h.Block.Block)
+	blocks.GET("", h.Block.GetBlocks)
+	blocks.DELETE("/:pubkey", h.Block.Unblock)
+
 	// Account management routes
-	accountAuth := account.Group("", authMiddleware)
+	accountAuth := account.Group("", authenticate, routeLimit)
 	accountAuth.GET("/backup", h.Account.BackupAccount)
+	accountAuth.GET("/export", h.Account.ExportAccount)
 	accountAuth.DELETE("", h.Account.DeleteAccount)
+	accountAuth.PUT("/privacy", h.Account.UpdatePrivacy)
 
 	// Auth routes that require authentication
-	authLogoutAll := auth.Group("/logout-all", authMiddleware)
-	authLogoutAll.POST("", h.Auth.LogoutAll)
+	authProtected := v1.Group("/auth", authenticate, routeLimit)
+	authProtected.POST("/logout-all", h.Auth.LogoutAll)
+	authProtected.GET("/sessions", h.Auth.ListSessions)
+	authProtected.DELETE("/sessions/:token_id", h.Auth.RevokeSession)
+
+	// Admin routes
+	admin := v1.Group("/admin", middleware.AdminOnly(cfg, logger))
+	admin.GET("/config", h.Admin.GetConfig)
+	admin.GET("/stats", h.Admin.GetStats)
+	admin.GET("/inactive-users", h.Admin.ListInactiveUsers)
+	admin.DELETE("/inactive-users", h.Admin.DeleteInactiveUsers)
 
 	logger.Info("API routes configured")
 }
+
+// sendLimit returns the per-user limiter of sending through /api/v1/messages/{route}, and the middleware that applies it
+// A limit from RATE_LIMIT_ROUTES is already applied by the route limiter, so no middleware is added for it;
+// otherwise the route gets its own limiter at the global limit
+func sendLimit(cfg *config.Config, limiters *middleware.RateLimiters, routeLimiter *middleware.RouteRateLimiter, route string, logger *zap.Logger) (*middleware.RateLimiter, echo.MiddlewareFunc) {
+	if limiter := routeLimiter.For("POST", "/api/v1/messages/"+route); limiter != nil {
+		return limiter, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+
+	limiter := limiters.New(route, cfg.RateLimit.Limit, cfg.RateLimit.Window, logger.With(zap.String("limit", route)))
+	return limiter, limiter.LimitByUser()
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/pkg/metrics"
+)
+
+// Cache is a key-value cache for hot lookups
+// Failures are treated as misses, so a broken cache only costs a database round trip
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte)
+	Delete(ctx context.Context, key string)
+	Close() error
+}
+
+// New creates the cache backend selected in the config
+// Lookups are recorded in the cache metrics under name
+func New(cfg *config.Config, name string, logger *zap.Logger) (Cache, error) {
+	logger = logger.With(zap.String("cache", name), zap.String("backend", cfg.Cache.Backend))
+
+	switch cfg.Cache.Backend {
+	case config.CacheBackendNone, "":
+		return Noop(), nil
+	case config.CacheBackendMemory:
+		return &instrumented{Cache: NewMemoryCache(cfg.Cache.TTL), name: name}, nil
+	case config.CacheBackendRedis:
+		redisCache, err := NewRedisCache(cfg.Cache.RedisURL, name, cfg.Cache.TTL, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &instrumented{Cache: redisCache, name: name}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Cache.Backend)
+	}
+}
+
+// noopCache is used when caching is disabled; every lookup misses
+type noopCache struct{}
+
+// Noop returns a cache that stores nothing
+func Noop() Cache {
+	return noopCache{}
+}
+
+func (noopCache) Get(context.Context, string) ([]byte, bool) { return nil, false }
+func (noopCache) Set(context.Context, string, []byte)        {}
+func (noopCache) Delete(context.Context, string)             {}
+func (noopCache) Close() error                               { return nil }
+
+// instrumented records hits and misses of the wrapped cache
+type instrumented struct {
+	Cache
+	name string
+}
+
+// Get looks up key and records whether it was found
+func (c *instrumented) Get(ctx context.Context, key string) ([]byte, bool) {
+	value, ok := c.Cache.Get(ctx, key)
+	metrics.RecordCacheLookup(c.name, ok)
+	return value, ok
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+)
+
+// testRedisEnv names the environment variable holding the test Redis URL
+// Redis tests are skipped when it is not set
+const testRedisEnv = "WAVE_TEST_REDIS_URL"
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(time.Minute)
+	t.Cleanup(func() { _ = c.Close() })
+
+	_, ok := c.Get(ctx, "alice")
+	assert.False(t, ok)
+
+	c.Set(ctx, "alice", []byte("key-1"))
+	value, ok := c.Get(ctx, "alice")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("key-1"), value)
+
+	c.Delete(ctx, "alice")
+	_, ok = c.Get(ctx, "alice")
+	assert.False(t, ok)
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(time.Millisecond)
+	t.Cleanup(func() { _ = c.Close() })
+
+	c.Set(ctx, "alice", []byte("key-1"))
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := c.Get(ctx, "alice")
+	assert.False(t, ok)
+}
+
+func TestNewSelectsBackend(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Cache.TTL = time.Minute
+
+	cfg.Cache.Backend = config.CacheBackendNone
+	c, err := New(cfg, "test", zap.NewNop())
+	require.NoError(t, err)
+	c.Set(context.Background(), "alice", []byte("key-1"))
+	_, ok := c.Get(context.Background(), "alice")
+	assert.False(t, ok, "disabled cache must not store values")
+
+	cfg.Cache.Backend = config.CacheBackendMemory
+	c, err = New(cfg, "test", zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	c.Set(context.Background(), "alice", []byte("key-1"))
+	_, ok = c.Get(context.Background(), "alice")
+	assert.True(t, ok)
+
+	cfg.Cache.Backend = "memcached"
+	_, err = New(cfg, "test", zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestRedisCache(t *testing.T) {
+	url := os.Getenv(testRedisEnv)
+	if url == "" {
+		t.Skipf("%s not set, skipping redis test", testRedisEnv)
+	}
+
+	ctx := context.Background()
+	c, err := NewRedisCache(url, "test", time.Minute, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+
+	key := "alice-" + time.Now().Format(time.RFC3339Nano)
+	c.Set(ctx, key, []byte("key-1"))
+	value, ok := c.Get(ctx, key)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("key-1"), value)
+
+	c.Delete(ctx, key)
+	_, ok = c.Get(ctx, key)
+	assert.False(t, ok)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a cached value and when it expires
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryCache is an in-process cache; each replica has its own copy
+type MemoryCache struct {
+	entries      map[string]memoryEntry
+	mutex        sync.RWMutex
+	ttl          time.Duration
+	cleanupEvery time.Duration // How often to remove expired entries
+	done         chan struct{}
+}
+
+// NewMemoryCache creates an in-process cache whose entries expire after ttl
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	c := &MemoryCache{
+		entries:      make(map[string]memoryEntry),
+		ttl:          ttl,
+		cleanupEvery: time.Minute,
+		done:         make(chan struct{}),
+	}
+
+	// Start cleanup goroutine
+	go c.cleanup()
+
+	return c
+}
+
+// cleanup periodically removes expired entries
+func (c *MemoryCache) cleanup() {
+	ticker := time.NewTicker(c.cleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			for key, entry := range c.entries {
+				if now.After(entry.expiresAt) {
+					delete(c.entries, key)
+				}
+			}
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// Get gets an unexpired value
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool) {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores a value
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte) {
+	c.mutex.Lock()
+	c.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(c.ttl)}
+	c.mutex.Unlock()
+}
+
+// Delete removes a value
+func (c *MemoryCache) Delete(_ context.Context, key string) {
+	c.mutex.Lock()
+	delete(c.entries, key)
+	c.mutex.Unlock()
+}
+
+// Close stops the cleanup goroutine
+func (c *MemoryCache) Close() error {
+	close(c.done)
+	return nil
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RedisCache is a cache shared by all replicas through Redis
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+// NewRedisCache connects to the Redis server at url
+// Keys are prefixed with name so several caches can share a server
+func NewRedisCache(url, name string, ttl time.Duration, logger *zap.Logger) (*RedisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	return &RedisCache{
+		client: redis.NewClient(opts),
+		prefix: "wave:" + name + ":",
+		ttl:    ttl,
+		logger: logger,
+	}, nil
+}
+
+// Get gets a value
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.logger.Warn("Cache get failed", zap.Error(err))
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+// Set stores a value
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte) {
+	if err := c.client.Set(ctx, c.prefix+key, value, c.ttl).Err(); err != nil {
+		c.logger.Warn("Cache set failed", zap.Error(err))
+	}
+}
+
+// Delete removes a value
+// A failed delete leaves a stale entry until it expires, so it is logged as an error
+func (c *RedisCache) Delete(ctx context.Context, key string) {
+	if err := c.client.Del(ctx, c.prefix+key).Err(); err != nil {
+		c.logger.Error("Cache delete failed", zap.Error(err))
+	}
+}
+
+// Close closes the Redis connection
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
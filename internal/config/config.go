@@ -2,18 +2,62 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
 
+// Token modes select how access tokens are issued and validated
+const (
+	TokenModeOpaque = "opaque" // Random tokens stored hashed in the database
+	TokenModeJWT    = "jwt"    // Signed JWTs using Auth.JWTSecret
+)
+
+// Cache backends select where cached lookups are kept
+const (
+	CacheBackendNone   = "none"   // Caching disabled
+	CacheBackendMemory = "memory" // Per-process cache
+	CacheBackendRedis  = "redis"  // Shared cache in Redis at Cache.RedisURL
+)
+
+// Rate limit backends select where request counts are kept
+const (
+	RateLimitBackendMemory = "memory" // Per-process counts; each replica enforces its own limit
+	RateLimitBackendRedis  = "redis"  // Counts shared by all replicas in Redis at Cache.RedisURL
+)
+
+// MinProductionJWTSecretLength is the shortest JWT secret accepted in production, in bytes
+const MinProductionJWTSecretLength = 32
+
+// knownJWTSecrets are example secrets from the repository that must never be used in production
+var knownJWTSecrets = []string{
+	"development_secret_key_replace_in_production",
+}
+
+// MaxTokenExpiryGrace is the largest allowed clock skew tolerance for token expiry
+const MaxTokenExpiryGrace = time.Minute
+
+// MaxPoWDifficulty is the highest allowed registration proof-of-work difficulty, in leading zero bits
+// Each bit doubles the work a client has to do, so beyond this registration takes far too long
+const MaxPoWDifficulty = 32
+
 // Config holds the application configuration
 type Config struct {
 	Server struct {
 		Port           int           `envconfig:"PORT" default:"8080"`
 		Timeout        time.Duration `envconfig:"SERVER_TIMEOUT" default:"30s"`
-		AllowedOrigins []string      `envconfig:"ALLOWED_ORIGINS" default:"*"`
+		AllowedOrigins Origins       `envconfig:"ALLOWED_ORIGINS" default:"*"`
+
+		// TLS is off unless a certificate and key are given or AutoTLSDomain is set
+		TLSCertFile     string `envconfig:"TLS_CERT_FILE"`
+		TLSKeyFile      string `envconfig:"TLS_KEY_FILE"`
+		AutoTLSDomain   string `envconfig:"AUTO_TLS_DOMAIN"`                    // Get a certificate for this domain from Let's Encrypt
+		AutoTLSCacheDir string `envconfig:"AUTO_TLS_CACHE_DIR" default:"certs"` // Where ACME certificates are kept between restarts
 	}
 
 	Database struct {
@@ -23,16 +67,234 @@ type Config struct {
 		Password string `envconfig:"DB_PASSWORD" required:"true"`
 		Name     string `envconfig:"DB_NAME" default:"wave"`
 		PoolSize int    `envconfig:"DB_POOL_SIZE" default:"10"`
+		MinConns int    `envconfig:"DB_MIN_CONNS" default:"0"`
+		Warmup   bool   `envconfig:"DB_WARMUP" default:"false"` // Open MinConns connections before serving requests
+		SSLMode  string `envconfig:"DB_SSLMODE"`                // Defaults to disable in development and require elsewhere
+
+		ConnectTimeout time.Duration `envconfig:"DB_CONNECT_TIMEOUT" default:"10s"` // Rounded down to whole seconds; 0 waits forever
+		MaxRetries     int           `envconfig:"DB_MAX_RETRIES" default:"3"`       // Retries of the initial connection and of statements failing with transient errors
+
+		MaxConnLifetime time.Duration `envconfig:"DB_MAX_CONN_LIFETIME" default:"1h"`   // Pooled connections are closed and replaced after this long
+		MaxConnIdleTime time.Duration `envconfig:"DB_MAX_CONN_IDLE_TIME" default:"30m"` // Idle connections above MinConns are closed after this long
 	}
 
 	Auth struct {
-		JWTSecret     string        `envconfig:"JWT_SECRET" required:"true"`
-		TokenExpiry   time.Duration `envconfig:"TOKEN_EXPIRY" default:"24h"`
+		TokenMode     string        `envconfig:"TOKEN_MODE" default:"opaque"`
+		JWTSecret     string        `envconfig:"JWT_SECRET"` // Required only in jwt token mode
+		TokenExpiry   time.Duration `envconfig:"TOKEN_EXPIRY" default:"15m"`
 		RefreshExpiry time.Duration `envconfig:"REFRESH_EXPIRY" default:"720h"`
+		ExpiryGrace   time.Duration `envconfig:"TOKEN_EXPIRY_GRACE" default:"5s"`
+
+		// RefreshRotation replaces the refresh token, with a new full lifetime, each time it is used,
+		// and revokes all of the user's sessions if a replaced refresh token is presented again
+		RefreshRotation bool `envconfig:"REFRESH_TOKEN_ROTATION" default:"true"`
+
+		// IdleTimeout ends sessions that haven't been used for this long, even before they expire; 0 disables it
+		IdleTimeout time.Duration `envconfig:"SESSION_IDLE_TIMEOUT" default:"0"`
+
+		// CleanupInterval is how often expired sessions are deleted
+		CleanupInterval time.Duration `envconfig:"TOKEN_CLEANUP_INTERVAL" default:"1h"`
+
+		// UserIDSecret keys the hash that derives user IDs from usernames, so IDs can't be computed by outsiders
+		// Changing it changes the ID every username maps to, so it can't be set or changed on a deployment with users
+		UserIDSecret string `envconfig:"USER_ID_SECRET"`
+
+		// RequirePoW makes registration solve a proof-of-work challenge, to slow down mass account creation
+		// PoWDifficulty is the number of leading zero bits the solution's hash needs; PoWSecret signs the challenges
+		RequirePoW    bool   `envconfig:"REQUIRE_POW" default:"false"`
+		PoWDifficulty int    `envconfig:"POW_DIFFICULTY" default:"20"`
+		PoWSecret     string `envconfig:"POW_SECRET"`
+
+		// JWTRevocationCheck looks up the session of each JWT so logged out tokens are rejected before they expire
+		JWTRevocationCheck bool `envconfig:"JWT_REVOCATION_CHECK" default:"false"`
+
+		// ActivityInterval is the least time between writes of a user's last active time; 0 writes on every request
+		ActivityInterval time.Duration `envconfig:"ACTIVITY_UPDATE_INTERVAL" default:"60s"`
+
+		// InactiveUserTTL deletes accounts whose last active time is older than this, every InactiveCleanupInterval; 0 keeps them
+		InactiveUserTTL         time.Duration `envconfig:"INACTIVE_USER_TTL" default:"0"`
+		InactiveCleanupInterval time.Duration `envconfig:"INACTIVE_USER_CLEANUP_INTERVAL" default:"24h"`
+	}
+
+	RateLimit struct {
+		Limit   int           `envconfig:"RATE_LIMIT" default:"100"`
+		Window  time.Duration `envconfig:"RATE_LIMIT_WINDOW" default:"1m"`
+		Routes  RouteLimits   `envconfig:"RATE_LIMIT_ROUTES" default:"POST /api/v1/messages/send=30/1m,POST /api/v1/messages/send-multi=10/1m,GET /api/v1/messages=120/1m"`
+		Backend string        `envconfig:"RATE_LIMIT_BACKEND" default:"memory"` // memory or redis
+	}
+
+	Messages struct {
+		// RequireKnownRecipient rejects messages to public keys with no registered user instead of storing them as failed
+		RequireKnownRecipient bool `envconfig:"REQUIRE_KNOWN_RECIPIENT" default:"false"`
+
+		// MaxCiphertextBytes bounds each decoded message ciphertext; 0 means no limit
+		MaxCiphertextBytes int `envconfig:"MAX_CIPHERTEXT_BYTES" default:"65536"`
+
+		// MaxBulkBodyBytes bounds the bodies of account recovery and contact imports, which carry whole backups; 0 means no limit
+		MaxBulkBodyBytes int64 `envconfig:"MAX_BULK_BODY_BYTES" default:"268435456"`
+
+		// HardDelete removes deleted messages at once; otherwise they are kept hidden for DeletedRetention, then purged
+		HardDelete       bool          `envconfig:"MESSAGE_HARD_DELETE" default:"true"`
+		DeletedRetention time.Duration `envconfig:"MESSAGE_DELETED_RETENTION" default:"720h"`
+	}
+
+	Admin struct {
+		Token string `envconfig:"ADMIN_TOKEN"`
+	}
+
+	Metrics struct {
+		// LatencyBuckets are the upper bounds, in seconds, of the request duration histogram's buckets; empty keeps the default
+		// Most requests finish in milliseconds, so the default resolves those finely rather than spanning up to 10s
+		LatencyBuckets Buckets `envconfig:"METRICS_LATENCY_BUCKETS" default:"0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5"`
+
+		// LatencySLO is the request latency objective; it is always a bucket bound, so the share of requests
+		// meeting it can be read straight off the histogram. 0 adds no bound
+		LatencySLO time.Duration `envconfig:"METRICS_LATENCY_SLO" default:"300ms"`
+	}
+
+	// Cache holds hot lookups such as user public keys; the redis backend is shared by all replicas
+	Cache struct {
+		Backend  string        `envconfig:"CACHE_BACKEND" default:"none"` // none, memory or redis
+		TTL      time.Duration `envconfig:"CACHE_TTL" default:"5m"`
+		RedisURL string        `envconfig:"REDIS_URL"` // Required for the redis cache and rate limit backends
+	}
+
+	// AntiEnumeration hides whether a username exists on lookup endpoints, trading usability for privacy
+	AntiEnumeration struct {
+		Enabled  bool          `envconfig:"ANTI_ENUMERATION" default:"false"`
+		MaxDelay time.Duration `envconfig:"ANTI_ENUMERATION_MAX_DELAY" default:"200ms"` // Upper bound of the random delay on failed lookups
+		Limit    int           `envconfig:"ANTI_ENUMERATION_LIMIT" default:"10"`        // Lookups allowed per username per window
+		Window   time.Duration `envconfig:"ANTI_ENUMERATION_WINDOW" default:"1m"`
 	}
 
 	Environment string `envconfig:"ENVIRONMENT" default:"production"`
 	LogLevel    string `envconfig:"LOG_LEVEL" default:"info"`
+
+	// LogAnonymize omits client IPs and user IDs from access, rate limit and admin logs; requests stay correlatable by request ID
+	LogAnonymize bool `envconfig:"LOG_ANONYMIZE" default:"false"`
+}
+
+// RouteLimit is the request budget for a single route
+type RouteLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RouteLimits maps route keys ("METHOD /path") to their rate limits
+type RouteLimits map[string]RouteLimit
+
+// Decode parses a comma-separated list of "METHOD /path=limit/window" entries
+func (r *RouteLimits) Decode(value string) error {
+	limits := make(RouteLimits)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, budget, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid route limit %q: expected METHOD /path=limit/window", entry)
+		}
+
+		limitStr, windowStr, ok := strings.Cut(budget, "/")
+		if !ok {
+			return fmt.Errorf("invalid route limit %q: expected limit/window", entry)
+		}
+
+		limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid limit in route limit %q", entry)
+		}
+
+		window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid window in route limit %q", entry)
+		}
+
+		method, path, ok := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok {
+			return fmt.Errorf("invalid route in route limit %q: expected METHOD /path", entry)
+		}
+
+		limits[RouteKey(method, strings.TrimSpace(path))] = RouteLimit{Limit: limit, Window: window}
+	}
+
+	*r = limits
+	return nil
+}
+
+// Origins lists the browser origins allowed to call the API; "*" allows any origin
+type Origins []string
+
+// Decode parses a comma-separated list of origins such as "https://app.example.com, https://example.com"
+// Whitespace and trailing slashes are removed, since browsers send origins without them
+func (o *Origins) Decode(value string) error {
+	var origins Origins
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin != "*" && !strings.Contains(origin, "://") {
+			return fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+		}
+		origins = append(origins, origin)
+	}
+
+	*o = origins
+	return nil
+}
+
+// Buckets are histogram bucket upper bounds
+type Buckets []float64
+
+// Decode parses a comma-separated list of increasing positive bounds such as "0.005, 0.01, 0.025"
+func (b *Buckets) Decode(value string) error {
+	var buckets Buckets
+	for _, bound := range strings.Split(value, ",") {
+		bound = strings.TrimSpace(bound)
+		if bound == "" {
+			continue
+		}
+
+		upper, err := strconv.ParseFloat(bound, 64)
+		if err != nil || upper <= 0 {
+			return fmt.Errorf("invalid bucket %q: expected a positive number", bound)
+		}
+		if len(buckets) > 0 && upper <= buckets[len(buckets)-1] {
+			return fmt.Errorf("invalid bucket %q: buckets must be increasing", bound)
+		}
+		buckets = append(buckets, upper)
+	}
+
+	*b = buckets
+	return nil
+}
+
+// AllowsAny reports whether the list contains the "*" wildcard
+func (o Origins) AllowsAny() bool {
+	for _, origin := range o {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether requests from origin are allowed
+func (o Origins) Allows(origin string) bool {
+	for _, allowed := range o {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteKey builds the key used to look up a route's rate limit
+func RouteKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
 }
 
 // Load loads the configuration from environment variables
@@ -45,21 +307,217 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to process config: %w", err)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+// Validate checks the configuration for invalid or inconsistent values
+// All problems are reported together so they can be fixed in one pass
+func (c *Config) Validate() error {
+	var problems []string
+
+	switch c.Auth.TokenMode {
+	case TokenModeOpaque:
+	case TokenModeJWT:
+		if c.Auth.JWTSecret == "" {
+			problems = append(problems, "JWT_SECRET is required when TOKEN_MODE is jwt")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("TOKEN_MODE must be %q or %q, got %q", TokenModeOpaque, TokenModeJWT, c.Auth.TokenMode))
+	}
+
+	if c.Server.Timeout < 0 {
+		problems = append(problems, "SERVER_TIMEOUT must not be negative")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.Server.AutoTLSDomain != "" && c.Server.TLSCertFile != "" {
+		problems = append(problems, "AUTO_TLS_DOMAIN can't be used with TLS_CERT_FILE")
+	}
+
+	switch c.Database.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		problems = append(problems, fmt.Sprintf("DB_SSLMODE must be a PostgreSQL sslmode such as require or verify-full, got %q", c.Database.SSLMode))
+	}
+	if c.Database.ConnectTimeout < 0 {
+		problems = append(problems, "DB_CONNECT_TIMEOUT must not be negative")
+	}
+
+	if c.Database.MaxRetries < 0 {
+		problems = append(problems, "DB_MAX_RETRIES must not be negative")
+	}
+
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.PoolSize {
+		problems = append(problems, "DB_MIN_CONNS must be between 0 and DB_POOL_SIZE")
+	}
+	if c.Database.MaxConnLifetime <= 0 {
+		problems = append(problems, "DB_MAX_CONN_LIFETIME must be positive")
+	}
+	if c.Database.MaxConnIdleTime <= 0 {
+		problems = append(problems, "DB_MAX_CONN_IDLE_TIME must be positive")
+	}
+
+	if c.Messages.MaxCiphertextBytes < 0 {
+		problems = append(problems, "MAX_CIPHERTEXT_BYTES must not be negative")
+	}
+	if c.Messages.MaxBulkBodyBytes < 0 {
+		problems = append(problems, "MAX_BULK_BODY_BYTES must not be negative")
+	}
+	if c.Messages.DeletedRetention < 0 {
+		problems = append(problems, "MESSAGE_DELETED_RETENTION must not be negative")
+	}
+
+	if c.Auth.TokenExpiry <= 0 {
+		problems = append(problems, "TOKEN_EXPIRY must be positive")
+	}
+	if c.Auth.RefreshExpiry < c.Auth.TokenExpiry {
+		problems = append(problems, "REFRESH_EXPIRY must not be shorter than TOKEN_EXPIRY")
+	}
+	if c.Auth.CleanupInterval <= 0 {
+		problems = append(problems, "TOKEN_CLEANUP_INTERVAL must be positive")
+	}
+	if c.Auth.IdleTimeout < 0 {
+		problems = append(problems, "SESSION_IDLE_TIMEOUT must not be negative")
+	}
+	if c.Auth.ExpiryGrace < 0 || c.Auth.ExpiryGrace > MaxTokenExpiryGrace {
+		problems = append(problems, fmt.Sprintf("TOKEN_EXPIRY_GRACE must be between 0 and %s", MaxTokenExpiryGrace))
+	}
+	if c.Auth.RequirePoW {
+		if c.Auth.PoWSecret == "" {
+			problems = append(problems, "POW_SECRET is required when REQUIRE_POW is set")
+		}
+		if c.Auth.PoWDifficulty < 1 || c.Auth.PoWDifficulty > MaxPoWDifficulty {
+			problems = append(problems, fmt.Sprintf("POW_DIFFICULTY must be between 1 and %d", MaxPoWDifficulty))
+		}
+	}
+
+	if c.Auth.ActivityInterval < 0 {
+		problems = append(problems, "ACTIVITY_UPDATE_INTERVAL must not be negative")
+	}
+	if c.Auth.InactiveUserTTL < 0 {
+		problems = append(problems, "INACTIVE_USER_TTL must not be negative")
+	}
+	if c.Auth.InactiveUserTTL > 0 && c.Auth.InactiveCleanupInterval <= 0 {
+		problems = append(problems, "INACTIVE_USER_CLEANUP_INTERVAL must be positive")
+	}
+
+	if c.Metrics.LatencySLO < 0 {
+		problems = append(problems, "METRICS_LATENCY_SLO must not be negative")
+	}
+
+	switch c.Cache.Backend {
+	case CacheBackendNone, "":
+	case CacheBackendMemory, CacheBackendRedis:
+		if c.Cache.TTL <= 0 {
+			problems = append(problems, "CACHE_TTL must be positive")
+		}
+		if c.Cache.Backend == CacheBackendRedis && c.Cache.RedisURL == "" {
+			problems = append(problems, "REDIS_URL is required when CACHE_BACKEND is redis")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("CACHE_BACKEND must be %q, %q or %q, got %q",
+			CacheBackendNone, CacheBackendMemory, CacheBackendRedis, c.Cache.Backend))
+	}
+
+	switch c.RateLimit.Backend {
+	case RateLimitBackendMemory, "":
+	case RateLimitBackendRedis:
+		if c.Cache.RedisURL == "" {
+			problems = append(problems, "REDIS_URL is required when RATE_LIMIT_BACKEND is redis")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT_BACKEND must be %q or %q, got %q",
+			RateLimitBackendMemory, RateLimitBackendRedis, c.RateLimit.Backend))
+	}
+
+	if c.AntiEnumeration.Enabled {
+		if c.AntiEnumeration.MaxDelay < 0 {
+			problems = append(problems, "ANTI_ENUMERATION_MAX_DELAY must not be negative")
+		}
+		if c.AntiEnumeration.Limit <= 0 || c.AntiEnumeration.Window <= 0 {
+			problems = append(problems, "ANTI_ENUMERATION_LIMIT and ANTI_ENUMERATION_WINDOW must be positive")
+		}
+	}
+
+	if c.IsProduction() {
+		problems = append(problems, c.productionProblems()...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// productionProblems lists settings that are acceptable for development but insecure in production
+func (c *Config) productionProblems() []string {
+	var problems []string
+
+	if c.Auth.TokenMode == TokenModeJWT && c.Auth.JWTSecret != "" {
+		if len(c.Auth.JWTSecret) < MinProductionJWTSecretLength {
+			problems = append(problems, fmt.Sprintf("JWT_SECRET must be at least %d bytes in production", MinProductionJWTSecretLength))
+		}
+		for _, known := range knownJWTSecrets {
+			if c.Auth.JWTSecret == known {
+				problems = append(problems, "JWT_SECRET is an example value and must be replaced in production")
+			}
+		}
+	}
+
+	if c.DatabaseSSLMode() == "disable" {
+		problems = append(problems, "DB_SSLMODE must not be disable in production")
+	}
+
+	if c.Server.AllowedOrigins.AllowsAny() {
+		problems = append(problems, "ALLOWED_ORIGINS must list the allowed origins in production, not *")
+	}
+
+	return problems
+}
+
+// IsProduction checks if the environment is production
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
 // IsDevelopment checks if the environment is development
 func (c *Config) IsDevelopment() bool {
 	return c.Environment == "development"
 }
 
+// DatabaseSSLMode returns the configured sslmode, defaulting to disable in development and require elsewhere
+func (c *Config) DatabaseSSLMode() string {
+	if c.Database.SSLMode != "" {
+		return c.Database.SSLMode
+	}
+	if c.IsDevelopment() {
+		return "disable"
+	}
+	return "require"
+}
+
 // GetDSN returns the database connection string
+// It names the application the same way pkg/yugabytedb.Connect does, so connections are recognisable on the server
 func (c *Config) GetDSN() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		c.Database.User,
-		c.Database.Password,
-		c.Database.Host,
-		c.Database.Port,
-		c.Database.Name,
-	)
+	params := url.Values{}
+	params.Set("sslmode", c.DatabaseSSLMode())
+	params.Set("application_name", "wave-server")
+	if seconds := int(c.Database.ConnectTimeout.Seconds()); seconds > 0 {
+		params.Set("connect_timeout", strconv.Itoa(seconds))
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Database.User, c.Database.Password),
+		Host:     net.JoinHostPort(c.Database.Host, strconv.Itoa(c.Database.Port)),
+		Path:     "/" + c.Database.Name,
+		RawQuery: params.Encode(),
+	}
+	return dsn.String()
 }
@@ -0,0 +1,19 @@
+package domain
+
+import "time"
+
+// Block is a public key a user refuses messages from
+type Block struct {
+	UserID        string    `json:"user_id"`        // The user who blocked the key
+	BlockedPubKey string    `json:"blocked_pubkey"` // The blocked sender's public key
+	CreatedAt     time.Time `json:"created_at"`     // When the key was blocked
+}
+
+// NewBlock creates a new Block
+func NewBlock(userID, blockedPubKey string) *Block {
+	return &Block{
+		UserID:        userID,
+		BlockedPubKey: blockedPubKey,
+		CreatedAt:     time.Now(),
+	}
+}
@@ -4,16 +4,26 @@ import "time"
 
 // Contact represents a user's contact
 type Contact struct {
-	UserID        string    `json:"user_id"`        // The user who owns this contact
-	ContactPubKey string    `json:"contact_pubkey"` // The contact's public key
-	Nickname      string    `json:"nickname"`       // Friendly name for the contact
-	CreatedAt     time.Time `json:"created_at"`     // When the contact was added
+	UserID        string    `json:"user_id"`            // The user who owns this contact
+	ContactPubKey string    `json:"contact_pubkey"`     // The contact's public key
+	Nickname      string    `json:"nickname"`           // Friendly name for the contact
+	GroupName     string    `json:"group_name"`         // Folder the contact is filed under; empty when ungrouped
+	CreatedAt     time.Time `json:"created_at"`         // When the contact was added
+	Username      string    `json:"username,omitempty"` // Username registered for the contact's key; empty if there is none to show
+}
+
+// ContactGroup is a contact folder and how many contacts it holds
+type ContactGroup struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
 // ContactResponse is the API response format for a contact
 type ContactResponse struct {
 	ContactPubKey string    `json:"contact_pubkey"`
 	Nickname      string    `json:"nickname"`
+	GroupName     string    `json:"group_name,omitempty"`
+	Username      string    `json:"username,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
@@ -22,6 +32,8 @@ func (c *Contact) ToResponse() ContactResponse {
 	return ContactResponse{
 		ContactPubKey: c.ContactPubKey,
 		Nickname:      c.Nickname,
+		GroupName:     c.GroupName,
+		Username:      c.Username,
 		CreatedAt:     c.CreatedAt,
 	}
 }
@@ -35,3 +47,10 @@ func NewContact(userID, contactPubKey, nickname string) *Contact {
 		CreatedAt:     time.Now(),
 	}
 }
+
+// IncomingContact is a user who has added the current user as a contact
+type IncomingContact struct {
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	AddedAt  time.Time `json:"added_at"`
+}
@@ -14,8 +14,12 @@ const (
 	MessageStatusSent      MessageStatus = "sent"
 	MessageStatusDelivered MessageStatus = "delivered"
 	MessageStatusRead      MessageStatus = "read"
+	MessageStatusFailed    MessageStatus = "failed" // The recipient can't receive it; the sender may resend
 )
 
+// MaxMessageRecipients is the most recipients a single send can fan out to
+const MaxMessageRecipients = 100
+
 // Message represents an encrypted message
 type Message struct {
 	MessageID           uuid.UUID     `json:"message_id"`
@@ -29,6 +33,11 @@ type Message struct {
 	SenderNonce         []byte        `json:"-"` // Don't include binary data in JSON
 	Timestamp           time.Time     `json:"timestamp"`
 	Status              MessageStatus `json:"status"`
+	ContentHash         string        `json:"content_hash"`                  // Hex SHA-256 of ciphertext_kem || ciphertext_msg || nonce
+	ReplyToMessageID    *uuid.UUID    `json:"reply_to_message_id,omitempty"` // Message this one replies to, if any
+	DeliveredAt         *time.Time    `json:"delivered_at,omitempty"`        // When the message was first marked delivered
+	ReadAt              *time.Time    `json:"read_at,omitempty"`             // When the message was first marked read
+	ExpiresAt           *time.Time    `json:"expires_at,omitempty"`          // When the message is deleted, if the sender set a lifetime
 }
 
 // MessageResponse is the API response format for a message
@@ -44,6 +53,31 @@ type MessageResponse struct {
 	SenderNonce         string        `json:"sender_nonce,omitempty"`          // Base64 encoded, optional
 	Timestamp           time.Time     `json:"timestamp"`
 	Status              MessageStatus `json:"status"`
+	ContentHash         string        `json:"content_hash,omitempty"`
+	ReplyToMessageID    string        `json:"reply_to_message_id,omitempty"`
+	ExpiresAt           *time.Time    `json:"expires_at,omitempty"`
+}
+
+// HasParty checks if the public key is the message's sender or recipient
+func (m *Message) HasParty(pubKey string) bool {
+	return m.SenderPubKey == pubKey || m.RecipientPubKey == pubKey
+}
+
+// ExpireAfter sets the message to expire the given duration after it was sent
+func (m *Message) ExpireAfter(ttl time.Duration) {
+	expiresAt := m.Timestamp.Add(ttl)
+	m.ExpiresAt = &expiresAt
+}
+
+// IsExpiredAt checks if the message has an expiry that has passed at the given time
+func (m *Message) IsExpiredAt(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// IsBetween checks if the message was exchanged between the two public keys, in either direction
+func (m *Message) IsBetween(pubKeyA, pubKeyB string) bool {
+	return (m.SenderPubKey == pubKeyA && m.RecipientPubKey == pubKeyB) ||
+		(m.SenderPubKey == pubKeyB && m.RecipientPubKey == pubKeyA)
 }
 
 // ToResponse converts a Message to a MessageResponse
@@ -57,6 +91,12 @@ func (m *Message) ToResponse(includeAllFields bool) MessageResponse {
 		Nonce:           base64.URLEncoding.EncodeToString(m.Nonce),
 		Timestamp:       m.Timestamp,
 		Status:          m.Status,
+		ContentHash:     m.ContentHash,
+		ExpiresAt:       m.ExpiresAt,
+	}
+
+	if m.ReplyToMessageID != nil {
+		response.ReplyToMessageID = m.ReplyToMessageID.String()
 	}
 
 	// Include sender fields for the sender or for both if debugging
@@ -91,3 +131,18 @@ func NewMessage(senderPubKey, recipientPubKey string,
 		Status:              MessageStatusSent,
 	}
 }
+
+// ConversationSummary describes a user's conversation with one peer
+type ConversationSummary struct {
+	PeerPubKey    string    `json:"peer_pubkey"`
+	LastMessageAt time.Time `json:"last_message_at"`
+	UnreadCount   int       `json:"unread_count"` // Messages from the peer not yet delivered or read
+}
+
+// MessageReceipt tells a message's sender that the recipient changed its status
+type MessageReceipt struct {
+	MessageID    uuid.UUID     `json:"message_id"`
+	SenderPubKey string        `json:"-"` // Who the receipt is delivered to
+	Status       MessageStatus `json:"status"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageExpiry(t *testing.T) {
+	msg := NewMessage("alice", "bob", nil, nil, nil, nil, nil, nil)
+	assert.Nil(t, msg.ExpiresAt)
+	assert.False(t, msg.IsExpiredAt(msg.Timestamp.Add(24*time.Hour)))
+
+	msg.ExpireAfter(time.Minute)
+	require.NotNil(t, msg.ExpiresAt)
+	assert.Equal(t, msg.Timestamp.Add(time.Minute), *msg.ExpiresAt)
+	assert.False(t, msg.IsExpiredAt(msg.Timestamp.Add(59*time.Second)))
+	assert.True(t, msg.IsExpiredAt(msg.Timestamp.Add(time.Minute)))
+}
@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// ServerStats is a snapshot of how much the server holds
+type ServerStats struct {
+	Users          int64     // Registered users
+	Messages       int64     // Messages that haven't been deleted or expired
+	Contacts       int64     // Contacts across all users
+	ActiveSessions int64     // Sessions that haven't expired
+	CollectedAt    time.Time // When the counts were taken
+}
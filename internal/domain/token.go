@@ -14,6 +14,33 @@ type Token struct {
 	CreatedAt time.Time `json:"created_at"` // When the token was created
 	ExpiresAt time.Time `json:"expires_at"` // When the token expires
 	LastUsed  time.Time `json:"last_used"`  // Last time the token was used
+	// DeviceName is the client-supplied session label, empty if none was given
+	DeviceName string `json:"device_name,omitempty"`
+	// TokenType is TokenTypeAccess or TokenTypeRefresh
+	TokenType string `json:"token_type"`
+	// RefreshTokenID is the refresh token an access token was issued from; nil for refresh tokens and older access tokens
+	RefreshTokenID *uuid.UUID `json:"refresh_token_id,omitempty"`
+	// FamilyID is shared by a login's refresh token, the refresh tokens it was rotated into, and their access tokens
+	FamilyID *uuid.UUID `json:"family_id,omitempty"`
+	// Used marks a refresh token that has been rotated; presenting it again suggests it was stolen
+	Used bool `json:"used,omitempty"`
+}
+
+// Token types
+const (
+	TokenTypeAccess  = "access"  // Authenticates requests; short-lived
+	TokenTypeRefresh = "refresh" // Only exchanged for new access tokens; lives as long as the session
+)
+
+// DefaultDeviceName labels sessions created without a device name
+const DefaultDeviceName = "Unknown device"
+
+// DeviceLabel returns the session's device name, or DefaultDeviceName if it has none
+func (t *Token) DeviceLabel() string {
+	if t.DeviceName == "" {
+		return DefaultDeviceName
+	}
+	return t.DeviceName
 }
 
 // IsExpired checks if the token is expired
@@ -21,7 +48,32 @@ func (t *Token) IsExpired() bool {
 	return time.Now().After(t.ExpiresAt)
 }
 
-// NewToken creates a new Token
+// IsExpiredAt checks if the token is expired at the given time, allowing a grace period past expires_at
+// The grace period tolerates small clock skew between clients and the server
+func (t *Token) IsExpiredAt(now time.Time, grace time.Duration) bool {
+	return now.After(t.ExpiresAt.Add(grace))
+}
+
+// InGracePeriod checks if the token is past expires_at but still within the grace period
+func (t *Token) InGracePeriod(now time.Time, grace time.Duration) bool {
+	return now.After(t.ExpiresAt) && !t.IsExpiredAt(now, grace)
+}
+
+// IsIdleAt checks if the token has gone unused for longer than idleTimeout at the given time
+// An idleTimeout of 0 means tokens never go idle
+func (t *Token) IsIdleAt(now time.Time, idleTimeout time.Duration) bool {
+	return idleTimeout > 0 && now.Sub(t.LastUsed) > idleTimeout
+}
+
+// SessionID returns the ID of the session the token belongs to: its refresh token's ID, or its own
+func (t *Token) SessionID() uuid.UUID {
+	if t.RefreshTokenID != nil {
+		return *t.RefreshTokenID
+	}
+	return t.TokenID
+}
+
+// NewToken creates a new access Token
 func NewToken(userID, tokenHash string, expiresAt time.Time) *Token {
 	now := time.Now()
 	return &Token{
@@ -31,5 +83,6 @@ func NewToken(userID, tokenHash string, expiresAt time.Time) *Token {
 		CreatedAt: now,
 		ExpiresAt: expiresAt,
 		LastUsed:  now,
+		TokenType: TokenTypeAccess,
 	}
 }
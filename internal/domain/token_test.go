@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenIsExpiredAtWithGrace(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := &Token{ExpiresAt: expiresAt}
+	grace := 5 * time.Second
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		expired bool
+		inGrace bool
+	}{
+		{"before expiry", expiresAt.Add(-time.Second), false, false},
+		{"exactly at expiry", expiresAt, false, false},
+		{"just past expiry", expiresAt.Add(time.Microsecond), false, true},
+		{"just inside grace", expiresAt.Add(grace - time.Millisecond), false, true},
+		{"at end of grace", expiresAt.Add(grace), false, true},
+		{"just outside grace", expiresAt.Add(grace + time.Millisecond), true, false},
+		{"well past grace", expiresAt.Add(time.Hour), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expired, token.IsExpiredAt(tt.now, grace))
+			assert.Equal(t, tt.inGrace, token.InGracePeriod(tt.now, grace))
+		})
+	}
+}
+
+func TestTokenIsExpiredAtWithoutGrace(t *testing.T) {
+	expiresAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := &Token{ExpiresAt: expiresAt}
+
+	assert.False(t, token.IsExpiredAt(expiresAt, 0))
+	assert.True(t, token.IsExpiredAt(expiresAt.Add(time.Microsecond), 0))
+	assert.False(t, token.InGracePeriod(expiresAt.Add(time.Microsecond), 0))
+}
+
+func TestTokenDeviceLabel(t *testing.T) {
+	assert.Equal(t, DefaultDeviceName, (&Token{}).DeviceLabel())
+	assert.Equal(t, "Phone", (&Token{DeviceName: "Phone"}).DeviceLabel())
+}
+
+func TestTokenSessionID(t *testing.T) {
+	refresh := NewToken("user-1", "", time.Now())
+	refresh.TokenType = TokenTypeRefresh
+	access := NewToken("user-1", "", time.Now())
+	access.RefreshTokenID = &refresh.TokenID
+
+	assert.Equal(t, refresh.TokenID, refresh.SessionID())
+	assert.Equal(t, refresh.TokenID, access.SessionID())
+	assert.Equal(t, TokenTypeAccess, access.TokenType)
+}
+
+func TestTokenIsIdleAt(t *testing.T) {
+	now := time.Now()
+	token := NewToken("user-1", "", now.Add(time.Hour))
+	token.LastUsed = now.Add(-2 * time.Hour)
+
+	assert.True(t, token.IsIdleAt(now, time.Hour))
+	assert.False(t, token.IsIdleAt(now, 3*time.Hour))
+	assert.False(t, token.IsIdleAt(now, 0), "a zero idle timeout disables the check")
+}
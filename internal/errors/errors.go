@@ -8,10 +8,11 @@ import (
 
 // AppError represents an application-specific error
 type AppError struct {
-	Code    string // Error code
-	Message string // User-facing message
-	Err     error  // Original error (not exposed to users)
-	Status  int    // HTTP status code
+	Code    string            // Error code
+	Message string            // User-facing message
+	Err     error             // Original error (not exposed to users)
+	Status  int               // HTTP status code
+	Details map[string]string // User-facing detail per field, such as why each invalid field failed validation
 }
 
 // Error returns the error message
@@ -93,6 +94,12 @@ func NewValidationError(msg string, err error) *AppError {
 	}
 }
 
+// WithDetails sets the error's details and returns it
+func (e *AppError) WithDetails(details map[string]string) *AppError {
+	e.Details = details
+	return e
+}
+
 // IsAppError checks if an error is an AppError
 func IsAppError(err error) (*AppError, bool) {
 	var appErr *AppError
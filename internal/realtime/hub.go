@@ -0,0 +1,161 @@
+package realtime
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+)
+
+// subscriptionBuffer is how many messages a subscription holds before new ones are dropped
+const subscriptionBuffer = 64
+
+// Subscription receives messages and receipts published to one public key
+type Subscription struct {
+	pubKey   string
+	messages chan *domain.Message
+	receipts chan *domain.MessageReceipt
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Messages returns the channel of messages published to the subscription's public key
+func (s *Subscription) Messages() <-chan *domain.Message {
+	return s.messages
+}
+
+// Receipts returns the channel of status receipts for messages the subscription's public key sent
+func (s *Subscription) Receipts() <-chan *domain.MessageReceipt {
+	return s.receipts
+}
+
+// Done is closed when the subscription is cancelled or the hub is closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// close stops the subscription; it is safe to call more than once
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Hub is an in-process pub/sub that routes new messages to subscribers by recipient public key
+// Each replica has its own hub, so only sockets connected to the replica that stored a message are notified
+type Hub struct {
+	subscriptions map[string]map[*Subscription]struct{}
+	mutex         sync.RWMutex
+	closed        bool
+	logger        *zap.Logger
+}
+
+// NewHub creates a new Hub
+func NewHub(logger *zap.Logger) *Hub {
+	return &Hub{
+		subscriptions: make(map[string]map[*Subscription]struct{}),
+		logger:        logger.With(zap.String("component", "realtime_hub")),
+	}
+}
+
+// Subscribe registers a subscription for messages sent to pubKey
+// A user may hold several subscriptions at once, one per connected socket
+func (h *Hub) Subscribe(pubKey string) *Subscription {
+	sub := &Subscription{
+		pubKey:   pubKey,
+		messages: make(chan *domain.Message, subscriptionBuffer),
+		receipts: make(chan *domain.MessageReceipt, subscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		sub.close()
+		return sub
+	}
+
+	if h.subscriptions[pubKey] == nil {
+		h.subscriptions[pubKey] = make(map[*Subscription]struct{})
+	}
+	h.subscriptions[pubKey][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes a subscription and closes its Done channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mutex.Lock()
+	if subs, ok := h.subscriptions[sub.pubKey]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(h.subscriptions, sub.pubKey)
+		}
+	}
+	h.mutex.Unlock()
+
+	sub.close()
+}
+
+// Publish delivers a message to every subscription for its recipient
+// It never blocks: a subscription whose buffer is full misses the message and the client picks it up by polling
+// A nil hub publishes nothing
+func (h *Hub) Publish(message *domain.Message) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for sub := range h.subscriptions[message.RecipientPubKey] {
+		select {
+		case sub.messages <- message:
+		default:
+			h.logger.Warn("Dropped real-time message for slow subscriber",
+				zap.String("message_id", message.MessageID.String()))
+		}
+	}
+}
+
+// PublishReceipt delivers a status receipt to every subscription for the message's sender
+// Like Publish it never blocks; a sender that misses a receipt sees the status when it next fetches the message
+// A nil hub publishes nothing
+func (h *Hub) PublishReceipt(receipt *domain.MessageReceipt) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for sub := range h.subscriptions[receipt.SenderPubKey] {
+		select {
+		case sub.receipts <- receipt:
+		default:
+			h.logger.Warn("Dropped real-time receipt for slow subscriber",
+				zap.String("message_id", receipt.MessageID.String()))
+		}
+	}
+}
+
+// Subscribers returns the number of subscriptions for pubKey
+func (h *Hub) Subscribers(pubKey string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subscriptions[pubKey])
+}
+
+// Close ends all subscriptions; later subscriptions are closed immediately
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for pubKey, subs := range h.subscriptions {
+		for sub := range subs {
+			sub.close()
+		}
+		delete(h.subscriptions, pubKey)
+	}
+}
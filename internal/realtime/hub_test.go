@@ -0,0 +1,118 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+)
+
+func newTestMessage(recipientPubKey string) *domain.Message {
+	return domain.NewMessage("sender", recipientPubKey, nil, nil, nil, nil, nil, nil)
+}
+
+func TestPublishReachesEverySubscription(t *testing.T) {
+	hub := NewHub(zaptest.NewLogger(t))
+
+	first := hub.Subscribe("alice")
+	second := hub.Subscribe("alice")
+	other := hub.Subscribe("bob")
+	assert.Equal(t, 2, hub.Subscribers("alice"))
+
+	message := newTestMessage("alice")
+	hub.Publish(message)
+
+	for _, sub := range []*Subscription{first, second} {
+		select {
+		case got := <-sub.Messages():
+			assert.Equal(t, message.MessageID, got.MessageID)
+		default:
+			t.Fatal("expected message on subscription")
+		}
+	}
+	assert.Empty(t, other.Messages())
+}
+
+func TestUnsubscribe(t *testing.T) {
+	hub := NewHub(zaptest.NewLogger(t))
+
+	sub := hub.Subscribe("alice")
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(sub)
+
+	assert.Equal(t, 0, hub.Subscribers("alice"))
+	hub.Publish(newTestMessage("alice"))
+	assert.Empty(t, sub.Messages())
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("expected subscription to be done")
+	}
+}
+
+func TestPublishDropsWhenBufferFull(t *testing.T) {
+	hub := NewHub(zaptest.NewLogger(t))
+	sub := hub.Subscribe("alice")
+
+	for i := 0; i < subscriptionBuffer+5; i++ {
+		hub.Publish(newTestMessage("alice"))
+	}
+
+	assert.Len(t, sub.Messages(), subscriptionBuffer)
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	hub := NewHub(zaptest.NewLogger(t))
+	sub := hub.Subscribe("alice")
+
+	hub.Close()
+	late := hub.Subscribe("alice")
+
+	for _, s := range []*Subscription{sub, late} {
+		select {
+		case <-s.Done():
+		default:
+			t.Fatal("expected subscription to be done")
+		}
+	}
+	require.Equal(t, 0, hub.Subscribers("alice"))
+}
+
+func TestPublishOnNilHub(t *testing.T) {
+	var hub *Hub
+	assert.NotPanics(t, func() { hub.Publish(newTestMessage("alice")) })
+}
+
+func TestPublishReceiptReachesSender(t *testing.T) {
+	hub := NewHub(zaptest.NewLogger(t))
+
+	sender := hub.Subscribe("sender")
+	recipient := hub.Subscribe("alice")
+
+	message := newTestMessage("alice")
+	receipt := &domain.MessageReceipt{
+		MessageID:    message.MessageID,
+		SenderPubKey: message.SenderPubKey,
+		Status:       domain.MessageStatusRead,
+		UpdatedAt:    time.Now(),
+	}
+	hub.PublishReceipt(receipt)
+
+	select {
+	case got := <-sender.Receipts():
+		assert.Equal(t, message.MessageID, got.MessageID)
+		assert.Equal(t, domain.MessageStatusRead, got.Status)
+	default:
+		t.Fatal("expected receipt on sender subscription")
+	}
+	assert.Empty(t, recipient.Receipts())
+	assert.Empty(t, sender.Messages())
+
+	var nilHub *Hub
+	assert.NotPanics(t, func() { nilHub.PublishReceipt(receipt) })
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+// BlockRepository handles blocklist data storage operations
+type BlockRepository struct {
+	db     *Database
+	q      Querier
+	logger *zap.Logger
+}
+
+// NewBlockRepository creates a new BlockRepository
+func NewBlockRepository(db *Database) *BlockRepository {
+	return &BlockRepository{
+		db:     db,
+		q:      instrument(db.Pool, "blocks"),
+		logger: db.Logger.With(zap.String("repository", "block")),
+	}
+}
+
+// NewBlockRepositoryTx creates a BlockRepository whose statements run in tx
+func NewBlockRepositoryTx(db *Database, tx pgx.Tx) *BlockRepository {
+	r := NewBlockRepository(db)
+	r.q = instrument(tx, "blocks")
+	return r
+}
+
+// Create blocks a public key for a user
+// Blocking a key that is already blocked keeps the original block
+func (r *BlockRepository) Create(ctx context.Context, block *domain.Block) error {
+	query := `
+	INSERT INTO blocks (user_id, blocked_pubkey, created_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, blocked_pubkey) DO NOTHING
+	`
+
+	_, err := r.q.Exec(ctx, query, block.UserID, block.BlockedPubKey, block.CreatedAt)
+	if err != nil {
+		r.logger.Error("Failed to create block",
+			zap.Error(err),
+			zap.String("user_id", block.UserID),
+			zap.String("blocked_pubkey", block.BlockedPubKey))
+		return errors.NewInternalError("Failed to block public key", err)
+	}
+
+	return nil
+}
+
+// GetByUserID gets all public keys a user has blocked, newest first
+func (r *BlockRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Block, error) {
+	query := `
+	SELECT user_id, blocked_pubkey, created_at
+	FROM blocks
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	`
+
+	rows, err := r.q.Query(ctx, query, userID)
+	if err != nil {
+		r.logger.Error("Failed to get blocks by user ID", zap.Error(err), zap.String("user_id", userID))
+		return nil, errors.NewInternalError("Failed to get blocked public keys", err)
+	}
+	defer rows.Close()
+
+	var blocks []*domain.Block
+	for rows.Next() {
+		block := &domain.Block{}
+		if err := rows.Scan(&block.UserID, &block.BlockedPubKey, &block.CreatedAt); err != nil {
+			r.logger.Error("Failed to scan block row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read blocklist data", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating block rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read blocklist data", err)
+	}
+
+	return blocks, nil
+}
+
+// Exists checks whether a user has blocked a public key
+func (r *BlockRepository) Exists(ctx context.Context, userID, blockedPubKey string) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM blocks WHERE user_id = $1 AND blocked_pubkey = $2)
+	`
+
+	var exists bool
+	if err := r.q.QueryRow(ctx, query, userID, blockedPubKey).Scan(&exists); err != nil {
+		r.logger.Error("Failed to check block",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("blocked_pubkey", blockedPubKey))
+		return false, errors.NewInternalError("Failed to check blocklist", err)
+	}
+
+	return exists, nil
+}
+
+// Delete unblocks a public key for a user
+func (r *BlockRepository) Delete(ctx context.Context, userID, blockedPubKey string) error {
+	query := `
+	DELETE FROM blocks
+	WHERE user_id = $1 AND blocked_pubkey = $2
+	`
+
+	result, err := r.q.Exec(ctx, query, userID, blockedPubKey)
+	if err != nil {
+		r.logger.Error("Failed to delete block",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("blocked_pubkey", blockedPubKey))
+		return errors.NewInternalError("Failed to unblock public key", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError(fmt.Sprintf("Block for public key '%s'", blockedPubKey))
+	}
+
+	return nil
+}
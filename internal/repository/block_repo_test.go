@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+func TestBlockLifecycle(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewBlockRepository(db)
+
+	user := createTestUser(t, db)
+
+	blocked, err := repo.Exists(ctx, user.UserID, "abusive-key")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	// Blocking twice keeps a single entry
+	require.NoError(t, repo.Create(ctx, domain.NewBlock(user.UserID, "abusive-key")))
+	require.NoError(t, repo.Create(ctx, domain.NewBlock(user.UserID, "abusive-key")))
+
+	blocks, err := repo.GetByUserID(ctx, user.UserID)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "abusive-key", blocks[0].BlockedPubKey)
+
+	blocked, err = repo.Exists(ctx, user.UserID, "abusive-key")
+	require.NoError(t, err)
+	assert.True(t, blocked)
+
+	require.NoError(t, repo.Delete(ctx, user.UserID, "abusive-key"))
+
+	blocked, err = repo.Exists(ctx, user.UserID, "abusive-key")
+	require.NoError(t, err)
+	assert.False(t, blocked)
+
+	err = repo.Delete(ctx, user.UserID, "abusive-key")
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+}
@@ -5,16 +5,19 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
 )
 
+// contactColumns are the contact columns in the order scanContact reads them
+const contactColumns = `user_id, contact_pubkey, nickname, COALESCE(group_name, ''), created_at`
+
 // ContactRepository handles contact data storage operations
 type ContactRepository struct {
 	db     *Database
+	q      Querier
 	logger *zap.Logger
 }
 
@@ -22,21 +25,30 @@ type ContactRepository struct {
 func NewContactRepository(db *Database) *ContactRepository {
 	return &ContactRepository{
 		db:     db,
+		q:      instrument(db.Pool, "contacts"),
 		logger: db.Logger.With(zap.String("repository", "contact")),
 	}
 }
 
+// NewContactRepositoryTx creates a ContactRepository whose statements run in tx
+func NewContactRepositoryTx(db *Database, tx pgx.Tx) *ContactRepository {
+	r := NewContactRepository(db)
+	r.q = instrument(tx, "contacts")
+	return r
+}
+
 // Create creates a new contact
 func (r *ContactRepository) Create(ctx context.Context, contact *domain.Contact) error {
 	query := `
-	INSERT INTO contacts (user_id, contact_pubkey, nickname, created_at)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO contacts (user_id, contact_pubkey, nickname, group_name, created_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5)
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query,
+	_, err := r.q.Exec(ctx, query,
 		contact.UserID,
 		contact.ContactPubKey,
 		contact.Nickname,
+		contact.GroupName,
 		contact.CreatedAt,
 	)
 
@@ -47,7 +59,7 @@ func (r *ContactRepository) Create(ctx context.Context, contact *domain.Contact)
 			zap.String("contact_pubkey", contact.ContactPubKey))
 
 		// Check for unique constraint violation
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		if isUniqueViolation(err) {
 			return errors.NewConflictError(fmt.Sprintf("Contact with public key '%s' already exists for this user", contact.ContactPubKey))
 		}
 
@@ -57,31 +69,170 @@ func (r *ContactRepository) Create(ctx context.Context, contact *domain.Contact)
 	return nil
 }
 
-// GetByUserID gets all contacts for a user
-func (r *ContactRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Contact, error) {
+// CreateMany creates contacts in a single transaction and returns how many were created
+// Contacts that already exist are skipped rather than failing the batch
+func (r *ContactRepository) CreateMany(ctx context.Context, contacts []*domain.Contact) (int, error) {
 	query := `
-	SELECT user_id, contact_pubkey, nickname, created_at
+	INSERT INTO contacts (user_id, contact_pubkey, nickname, group_name, created_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	ON CONFLICT (user_id, contact_pubkey) DO NOTHING
+	`
+
+	tx, err := r.q.Begin(ctx)
+	if err != nil {
+		r.logger.Error("Failed to begin contact import", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to import contacts", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	created := 0
+	for _, contact := range contacts {
+		result, err := tx.Exec(ctx, query,
+			contact.UserID,
+			contact.ContactPubKey,
+			contact.Nickname,
+			contact.GroupName,
+			contact.CreatedAt,
+		)
+		if err != nil {
+			r.logger.Error("Failed to import contact",
+				zap.Error(err),
+				zap.String("user_id", contact.UserID),
+				zap.String("contact_pubkey", contact.ContactPubKey))
+			return 0, errors.NewInternalError("Failed to import contacts", err)
+		}
+		created += int(result.RowsAffected())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("Failed to commit contact import", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to import contacts", err)
+	}
+
+	return created, nil
+}
+
+// GetByUserID gets a page of a user's contacts, ordered by nickname
+// A limit of 0 returns all of them
+func (r *ContactRepository) GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*domain.Contact, error) {
+	query := `SELECT ` + contactColumns + `
 	FROM contacts
 	WHERE user_id = $1
-	ORDER BY nickname ASC
+	ORDER BY nickname ASC, contact_pubkey ASC
+	LIMIT NULLIF($2, 0) OFFSET $3
 	`
 
-	rows, err := r.db.Pool.Query(ctx, query, userID)
+	rows, err := r.q.Query(ctx, query, userID, limit, offset)
 	if err != nil {
 		r.logger.Error("Failed to get contacts by user ID", zap.Error(err), zap.String("user_id", userID))
 		return nil, errors.NewInternalError("Failed to get contacts", err)
 	}
+
+	return r.scanContacts(rows)
+}
+
+// CountByUserID counts a user's contacts
+func (r *ContactRepository) CountByUserID(ctx context.Context, userID string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM contacts
+	WHERE user_id = $1
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		r.logger.Error("Failed to count contacts", zap.Error(err), zap.String("user_id", userID))
+		return 0, errors.NewInternalError("Failed to count contacts", err)
+	}
+
+	return count, nil
+}
+
+// GetByGroup gets a page of a user's contacts filed under a group, ordered by nickname
+// A limit of 0 returns all of them
+func (r *ContactRepository) GetByGroup(ctx context.Context, userID, group string, limit, offset int) ([]*domain.Contact, error) {
+	query := `SELECT ` + contactColumns + `
+	FROM contacts
+	WHERE user_id = $1 AND group_name = $2
+	ORDER BY nickname ASC, contact_pubkey ASC
+	LIMIT NULLIF($3, 0) OFFSET $4
+	`
+
+	rows, err := r.q.Query(ctx, query, userID, group, limit, offset)
+	if err != nil {
+		r.logger.Error("Failed to get contacts by group",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("group", group))
+		return nil, errors.NewInternalError("Failed to get contacts", err)
+	}
+
+	return r.scanContacts(rows)
+}
+
+// CountByGroup counts a user's contacts filed under a group
+func (r *ContactRepository) CountByGroup(ctx context.Context, userID, group string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM contacts
+	WHERE user_id = $1 AND group_name = $2
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, userID, group).Scan(&count); err != nil {
+		r.logger.Error("Failed to count contacts by group",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("group", group))
+		return 0, errors.NewInternalError("Failed to count contacts", err)
+	}
+
+	return count, nil
+}
+
+// GetGroups gets the distinct group names a user has filed contacts under, with how many contacts each holds
+// Ungrouped contacts are not counted
+func (r *ContactRepository) GetGroups(ctx context.Context, userID string) ([]*domain.ContactGroup, error) {
+	query := `
+	SELECT group_name, COUNT(*)
+	FROM contacts
+	WHERE user_id = $1 AND group_name IS NOT NULL
+	GROUP BY group_name
+	ORDER BY group_name ASC
+	`
+
+	rows, err := r.q.Query(ctx, query, userID)
+	if err != nil {
+		r.logger.Error("Failed to get contact groups", zap.Error(err), zap.String("user_id", userID))
+		return nil, errors.NewInternalError("Failed to get contact groups", err)
+	}
+	defer rows.Close()
+
+	var groups []*domain.ContactGroup
+	for rows.Next() {
+		group := &domain.ContactGroup{}
+		if err := rows.Scan(&group.Name, &group.Count); err != nil {
+			r.logger.Error("Failed to scan contact group row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read contact group data", err)
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating contact group rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read contact group data", err)
+	}
+
+	return groups, nil
+}
+
+// scanContacts reads and closes rows selected with contactColumns
+func (r *ContactRepository) scanContacts(rows pgx.Rows) ([]*domain.Contact, error) {
 	defer rows.Close()
 
 	var contacts []*domain.Contact
 	for rows.Next() {
-		contact := &domain.Contact{}
-		err := rows.Scan(
-			&contact.UserID,
-			&contact.ContactPubKey,
-			&contact.Nickname,
-			&contact.CreatedAt,
-		)
+		contact, err := scanContact(rows)
 		if err != nil {
 			r.logger.Error("Failed to scan contact row", zap.Error(err))
 			return nil, errors.NewInternalError("Failed to read contact data", err)
@@ -97,23 +248,27 @@ func (r *ContactRepository) GetByUserID(ctx context.Context, userID string) ([]*
 	return contacts, nil
 }
 
-// GetByContactPubKey gets a specific contact
-func (r *ContactRepository) GetByContactPubKey(ctx context.Context, userID, contactPubKey string) (*domain.Contact, error) {
-	query := `
-	SELECT user_id, contact_pubkey, nickname, created_at
-	FROM contacts
-	WHERE user_id = $1 AND contact_pubkey = $2
-	`
-
-	row := r.db.Pool.QueryRow(ctx, query, userID, contactPubKey)
-
+// scanContact reads a contact selected with contactColumns
+func scanContact(row pgx.Row) (*domain.Contact, error) {
 	contact := &domain.Contact{}
 	err := row.Scan(
 		&contact.UserID,
 		&contact.ContactPubKey,
 		&contact.Nickname,
+		&contact.GroupName,
 		&contact.CreatedAt,
 	)
+	return contact, err
+}
+
+// GetByContactPubKey gets a specific contact
+func (r *ContactRepository) GetByContactPubKey(ctx context.Context, userID, contactPubKey string) (*domain.Contact, error) {
+	query := `SELECT ` + contactColumns + `
+	FROM contacts
+	WHERE user_id = $1 AND contact_pubkey = $2
+	`
+
+	contact, err := scanContact(r.q.QueryRow(ctx, query, userID, contactPubKey))
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -129,15 +284,51 @@ func (r *ContactRepository) GetByContactPubKey(ctx context.Context, userID, cont
 	return contact, nil
 }
 
+// GetUsersWhoAddedKey gets the users who have the given public key as a contact
+// Users who have opted out of discovery are excluded
+func (r *ContactRepository) GetUsersWhoAddedKey(ctx context.Context, pubKey string) ([]*domain.IncomingContact, error) {
+	query := `
+	SELECT u.user_id, u.username, c.created_at
+	FROM contacts c
+	JOIN users u ON u.user_id = c.user_id
+	WHERE c.contact_pubkey = $1 AND u.discoverable
+	ORDER BY c.created_at DESC
+	`
+
+	rows, err := r.q.Query(ctx, query, pubKey)
+	if err != nil {
+		r.logger.Error("Failed to get users who added key", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to get incoming contacts", err)
+	}
+	defer rows.Close()
+
+	var incoming []*domain.IncomingContact
+	for rows.Next() {
+		contact := &domain.IncomingContact{}
+		if err := rows.Scan(&contact.UserID, &contact.Username, &contact.AddedAt); err != nil {
+			r.logger.Error("Failed to scan incoming contact row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read incoming contact data", err)
+		}
+		incoming = append(incoming, contact)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating incoming contact rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read incoming contact data", err)
+	}
+
+	return incoming, nil
+}
+
 // Update updates a contact
 func (r *ContactRepository) Update(ctx context.Context, contact *domain.Contact) error {
 	query := `
 	UPDATE contacts
-	SET nickname = $3
+	SET nickname = $3, group_name = NULLIF($4, '')
 	WHERE user_id = $1 AND contact_pubkey = $2
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, contact.UserID, contact.ContactPubKey, contact.Nickname)
+	result, err := r.q.Exec(ctx, query, contact.UserID, contact.ContactPubKey, contact.Nickname, contact.GroupName)
 	if err != nil {
 		r.logger.Error("Failed to update contact",
 			zap.Error(err),
@@ -160,7 +351,7 @@ func (r *ContactRepository) Delete(ctx context.Context, userID, contactPubKey st
 	WHERE user_id = $1 AND contact_pubkey = $2
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, userID, contactPubKey)
+	result, err := r.q.Exec(ctx, query, userID, contactPubKey)
 	if err != nil {
 		r.logger.Error("Failed to delete contact",
 			zap.Error(err),
@@ -176,6 +367,26 @@ func (r *ContactRepository) Delete(ctx context.Context, userID, contactPubKey st
 	return nil
 }
 
+// DeleteMany deletes the user's contacts with the given public keys and returns how many were deleted
+// Keys that aren't among the user's contacts are ignored
+func (r *ContactRepository) DeleteMany(ctx context.Context, userID string, contactPubKeys []string) (int64, error) {
+	query := `
+	DELETE FROM contacts
+	WHERE user_id = $1 AND contact_pubkey = ANY($2)
+	`
+
+	result, err := r.q.Exec(ctx, query, userID, contactPubKeys)
+	if err != nil {
+		r.logger.Error("Failed to delete contacts",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.Int("count", len(contactPubKeys)))
+		return 0, errors.NewInternalError("Failed to delete contacts", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
 // DeleteUserContacts deletes all contacts for a user
 func (r *ContactRepository) DeleteUserContacts(ctx context.Context, userID string) (int64, error) {
 	query := `
@@ -183,7 +394,7 @@ func (r *ContactRepository) DeleteUserContacts(ctx context.Context, userID strin
 	WHERE user_id = $1
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, userID)
+	result, err := r.q.Exec(ctx, query, userID)
 	if err != nil {
 		r.logger.Error("Failed to delete user contacts", zap.Error(err), zap.String("user_id", userID))
 		return 0, errors.NewInternalError("Failed to delete contacts", err)
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+)
+
+func TestGetUsersWhoAddedKey(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	contactRepo := NewContactRepository(db)
+	userRepo := NewUserRepository(db)
+
+	target := createTestUser(t, db)
+	targetKey := base64.URLEncoding.EncodeToString(target.PublicKey)
+
+	visible := createTestUser(t, db)
+	hidden := createTestUser(t, db)
+	unrelated := createTestUser(t, db)
+
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(visible.UserID, targetKey, "target")))
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(hidden.UserID, targetKey, "target")))
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(unrelated.UserID, "some-other-key", "other")))
+
+	// Opt out of discovery
+	require.NoError(t, userRepo.SetDiscoverable(ctx, hidden.UserID, false))
+
+	incoming, err := contactRepo.GetUsersWhoAddedKey(ctx, targetKey)
+	require.NoError(t, err)
+	require.Len(t, incoming, 1)
+	assert.Equal(t, visible.UserID, incoming[0].UserID)
+	assert.Equal(t, visible.Username, incoming[0].Username)
+
+	// Opting back in makes the user visible again
+	require.NoError(t, userRepo.SetDiscoverable(ctx, hidden.UserID, true))
+
+	incoming, err = contactRepo.GetUsersWhoAddedKey(ctx, targetKey)
+	require.NoError(t, err)
+	assert.Len(t, incoming, 2)
+}
+
+func TestMultibyteNicknameAtServiceLimit(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	contactRepo := NewContactRepository(db)
+
+	user := createTestUser(t, db)
+
+	// The service accepts up to 50 characters; the column must store them regardless of byte length
+	nickname := strings.Repeat("🌊", 50)
+	require.NoError(t, contactRepo.Create(ctx, domain.NewContact(user.UserID, "multibyte-key", nickname)))
+
+	contact, err := contactRepo.GetByContactPubKey(ctx, user.UserID, "multibyte-key")
+	require.NoError(t, err)
+	assert.Equal(t, nickname, contact.Nickname)
+}
+
+func TestContactGroups(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewContactRepository(db)
+
+	user := createTestUser(t, db)
+
+	work := domain.NewContact(user.UserID, "work-key-1", "alice")
+	work.GroupName = "work"
+	otherWork := domain.NewContact(user.UserID, "work-key-2", "bob")
+	otherWork.GroupName = "work"
+	family := domain.NewContact(user.UserID, "family-key", "carol")
+	family.GroupName = "family"
+	ungrouped := domain.NewContact(user.UserID, "plain-key", "dave")
+
+	for _, contact := range []*domain.Contact{work, otherWork, family, ungrouped} {
+		require.NoError(t, repo.Create(ctx, contact))
+	}
+
+	contacts, err := repo.GetByGroup(ctx, user.UserID, "work", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, contacts, 2)
+	assert.Equal(t, "alice", contacts[0].Nickname)
+	assert.Equal(t, "work", contacts[0].GroupName)
+
+	contacts, err = repo.GetByGroup(ctx, user.UserID, "work", 1, 1)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	assert.Equal(t, "bob", contacts[0].Nickname)
+
+	count, err := repo.CountByGroup(ctx, user.UserID, "work")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	groups, err := repo.GetGroups(ctx, user.UserID)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, domain.ContactGroup{Name: "family", Count: 1}, *groups[0])
+	assert.Equal(t, domain.ContactGroup{Name: "work", Count: 2}, *groups[1])
+
+	// Ungrouped contacts read back with an empty group
+	stored, err := repo.GetByContactPubKey(ctx, user.UserID, "plain-key")
+	require.NoError(t, err)
+	assert.Empty(t, stored.GroupName)
+
+	// Clearing the group moves the contact out of it
+	family.GroupName = ""
+	require.NoError(t, repo.Update(ctx, family))
+
+	groups, err = repo.GetGroups(ctx, user.UserID)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "work", groups[0].Name)
+}
+
+func TestCreateManySkipsExisting(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewContactRepository(db)
+
+	user := createTestUser(t, db)
+	require.NoError(t, repo.Create(ctx, domain.NewContact(user.UserID, "existing-key", "existing")))
+
+	created, err := repo.CreateMany(ctx, []*domain.Contact{
+		domain.NewContact(user.UserID, "existing-key", "renamed"),
+		domain.NewContact(user.UserID, "new-key", "new"),
+		domain.NewContact(user.UserID, "new-key", "repeated in batch"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, created)
+
+	// The existing contact keeps its nickname
+	existing, err := repo.GetByContactPubKey(ctx, user.UserID, "existing-key")
+	require.NoError(t, err)
+	assert.Equal(t, "existing", existing.Nickname)
+
+	contacts, err := repo.GetByUserID(ctx, user.UserID, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, contacts, 2)
+}
+
+func TestGetByUserIDPages(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewContactRepository(db)
+
+	user := createTestUser(t, db)
+	for _, nickname := range []string{"carol", "alice", "bob"} {
+		require.NoError(t, repo.Create(ctx, domain.NewContact(user.UserID, nickname+"-key", nickname)))
+	}
+
+	contacts, err := repo.GetByUserID(ctx, user.UserID, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, contacts, 2)
+	assert.Equal(t, "alice", contacts[0].Nickname)
+	assert.Equal(t, "bob", contacts[1].Nickname)
+
+	contacts, err = repo.GetByUserID(ctx, user.UserID, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	assert.Equal(t, "carol", contacts[0].Nickname)
+}
+
+func TestCountAndDeleteMany(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewContactRepository(db)
+
+	user := createTestUser(t, db)
+	other := createTestUser(t, db)
+	for _, key := range []string{"key-a", "key-b", "key-c"} {
+		require.NoError(t, repo.Create(ctx, domain.NewContact(user.UserID, key, key)))
+	}
+	require.NoError(t, repo.Create(ctx, domain.NewContact(other.UserID, "key-a", "other's contact")))
+
+	count, err := repo.CountByUserID(ctx, user.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Unknown keys are ignored, and other users' contacts are left alone
+	deleted, err := repo.DeleteMany(ctx, user.UserID, []string{"key-a", "key-c", "key-unknown"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, deleted)
+
+	count, err = repo.CountByUserID(ctx, user.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = repo.CountByUserID(ctx, other.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
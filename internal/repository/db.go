@@ -2,20 +2,36 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/cache"
 	"github.com/pzkpfw44/wave-server/internal/config"
+	apperrors "github.com/pzkpfw44/wave-server/internal/errors"
 )
 
+// Pool is the connection pool a Database runs statements on
+// It is a *pgxpool.Pool in production; tests can substitute their own
+type Pool interface {
+	Querier
+	Ping(ctx context.Context) error
+	Close()
+}
+
 // Database represents a connection to the database
 type Database struct {
-	Pool   *pgxpool.Pool
+	Pool   Pool
 	Logger *zap.Logger
 	Config *config.Config
+
+	// UserCache caches user lookups by username and public key; nil disables caching
+	UserCache cache.Cache
 }
 
 // New creates a new database connection
@@ -28,6 +44,11 @@ func New(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*Database
 
 	// Set connection pool size
 	poolConfig.MaxConns = int32(cfg.Database.PoolSize)
+	poolConfig.MinConns = int32(cfg.Database.MinConns)
+
+	// Recycle connections so stale ones don't pile up as YugabyteDB nodes come and go
+	poolConfig.MaxConnLifetime = cfg.Database.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.Database.MaxConnIdleTime
 
 	// Increase health check timeout for YugabyteDB
 	poolConfig.HealthCheckPeriod = 30 * time.Second
@@ -38,31 +59,171 @@ func New(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*Database
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
 
-	// Verify connection
-	if err := pool.Ping(ctx); err != nil {
+	// Verify connection, waiting out a database that is still starting or rebalancing
+	err = retry(ctx, cfg.Database.MaxRetries, func() error {
+		err := pool.Ping(ctx)
+		if err != nil {
+			logger.Warn("Database ping failed", zap.Error(err))
+		}
+		return err
+	})
+	if err != nil {
 		pool.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// Pre-fill the pool so the first requests don't pay connection setup latency
+	if cfg.Database.Warmup && cfg.Database.MinConns > 0 {
+		start := time.Now()
+		warmed, err := warmup(ctx, cfg.Database.MinConns, func(ctx context.Context) (func(), error) {
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return conn.Release, nil
+		})
+		if err != nil {
+			logger.Warn("Connection pool warmup incomplete",
+				zap.Error(err),
+				zap.Int("warmed", warmed),
+				zap.Int("target", cfg.Database.MinConns))
+		} else {
+			logger.Info("Connection pool warmed up",
+				zap.Int("connections", warmed),
+				zap.Duration("duration", time.Since(start)))
+		}
+	}
+
 	logger.Info("Connected to database",
 		zap.String("host", cfg.Database.Host),
 		zap.Int("port", cfg.Database.Port),
 		zap.String("database", cfg.Database.Name),
 		zap.Int("pool_size", cfg.Database.PoolSize))
 
+	userCache, err := cache.New(cfg, "user", logger)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to create user cache: %w", err)
+	}
+
 	return &Database{
-		Pool:   pool,
-		Logger: logger,
-		Config: cfg,
+		Pool:      newRetryingPool(pool, cfg.Database.MaxRetries),
+		Logger:    logger,
+		Config:    cfg,
+		UserCache: userCache,
 	}, nil
 }
 
+// warmup acquires n connections at once, so each is a distinct connection, then releases them all
+// It returns the number of connections acquired
+func warmup(ctx context.Context, n int, acquire func(ctx context.Context) (release func(), err error)) (int, error) {
+	releases := make([]func(), 0, n)
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		release, err := acquire(ctx)
+		if err != nil {
+			return len(releases), fmt.Errorf("failed to acquire connection %d of %d: %w", i+1, n, err)
+		}
+		releases = append(releases, release)
+	}
+
+	return len(releases), nil
+}
+
+// Querier runs statements on either the connection pool or a transaction
+// Repositories run every statement through one, so several of them can share a transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+
+	// Begin starts a transaction, or a savepoint when already in one
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// The pgx pool satisfies Pool, and its transactions satisfy Querier
+var (
+	_ Pool    = (*pgxpool.Pool)(nil)
+	_ Querier = (pgx.Tx)(nil)
+)
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling back otherwise
+// Repositories created for tx with their New...RepositoryTx constructors run their statements in it
+func (db *Database) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		db.Logger.Error("Failed to begin transaction", zap.Error(err))
+		return apperrors.NewInternalError("Failed to begin transaction", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	committing := &committingTx{Tx: tx}
+	if err := fn(committing); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		db.Logger.Error("Failed to commit transaction", zap.Error(err))
+		return apperrors.NewInternalError("Failed to commit transaction", err)
+	}
+
+	for _, hook := range committing.afterCommit {
+		hook()
+	}
+
+	return nil
+}
+
+// committingTx is the transaction WithTx passes to fn, holding what to run once it commits
+type committingTx struct {
+	pgx.Tx
+	afterCommit []func()
+}
+
+// afterCommit runs hook once tx commits, or never if it is rolled back
+// Transactions that didn't come from WithTx can't be followed, so the hook runs right away
+func afterCommit(tx pgx.Tx, hook func()) {
+	if committing, ok := tx.(*committingTx); ok {
+		committing.afterCommit = append(committing.afterCommit, hook)
+		return
+	}
+	hook()
+}
+
+// Ping checks that the database can be reached
+func (db *Database) Ping(ctx context.Context) error {
+	return db.Pool.Ping(ctx)
+}
+
+// Stat returns the connection pool statistics, or nil if the pool doesn't keep any
+func (db *Database) Stat() *pgxpool.Stat {
+	pool := db.Pool
+	if retrying, ok := pool.(*retryingPool); ok {
+		pool = retrying.Pool
+	}
+
+	if stats, ok := pool.(interface{ Stat() *pgxpool.Stat }); ok {
+		return stats.Stat()
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (db *Database) Close() {
 	if db.Pool != nil {
 		db.Pool.Close()
 		db.Logger.Info("Closed database connection")
 	}
+	if db.UserCache != nil {
+		if err := db.UserCache.Close(); err != nil {
+			db.Logger.Warn("Failed to close user cache", zap.Error(err))
+		}
+	}
 }
 
 // RunMigrations runs database migrations
@@ -82,6 +243,8 @@ CREATE TABLE IF NOT EXISTS users (
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 CREATE INDEX IF NOT EXISTS idx_users_last_active ON users(last_active);
 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT TRUE;
+
 CREATE TABLE IF NOT EXISTS messages (
     message_id UUID PRIMARY KEY,
     sender_pubkey VARCHAR(1200) NOT NULL,
@@ -96,9 +259,41 @@ CREATE TABLE IF NOT EXISTS messages (
     status VARCHAR(16) DEFAULT 'sent'
 );
 
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id UUID;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_users_public_key ON users USING HASH (public_key);
+-- The unique index can't be built while users share a public key, so name them rather than fail with only the index's name
+DO $$
+DECLARE
+    shared TEXT;
+BEGIN
+    IF to_regclass('idx_users_public_key_unique') IS NULL THEN
+        SELECT string_agg(usernames, '; ') INTO shared
+        FROM (
+            SELECT string_agg(username, ', ' ORDER BY created_at) AS usernames
+            FROM users
+            GROUP BY sha256(public_key)
+            HAVING COUNT(*) > 1
+        ) AS duplicates;
+
+        IF shared IS NOT NULL THEN
+            RAISE EXCEPTION 'Users share a public key, so idx_users_public_key_unique cannot be created: %', shared
+                USING HINT = 'Delete or recover with a new key all but one user in each group, then run the migrations again';
+        END IF;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_public_key_unique ON users (sha256(public_key));
+
 CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages(recipient_pubkey);
 CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_pubkey);
 CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages(deleted_at) WHERE deleted_at IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages((
     CASE WHEN sender_pubkey < recipient_pubkey
         THEN sender_pubkey || recipient_pubkey
@@ -114,7 +309,10 @@ CREATE TABLE IF NOT EXISTS contacts (
     PRIMARY KEY (user_id, contact_pubkey)
 );
 
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS group_name VARCHAR(64);
+
 CREATE INDEX IF NOT EXISTS idx_contacts_user_id ON contacts(user_id);
+CREATE INDEX IF NOT EXISTS idx_contacts_contact_pubkey ON contacts(contact_pubkey);
 
 CREATE TABLE IF NOT EXISTS tokens (
     token_id UUID PRIMARY KEY,
@@ -125,8 +323,23 @@ CREATE TABLE IF NOT EXISTS tokens (
     last_used TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS device_name VARCHAR(100);
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS token_type VARCHAR(16) NOT NULL DEFAULT 'access';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS refresh_token_id UUID REFERENCES tokens(token_id) ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used BOOLEAN NOT NULL DEFAULT FALSE;
+
 CREATE INDEX IF NOT EXISTS idx_tokens_user_id ON tokens(user_id);
 CREATE INDEX IF NOT EXISTS idx_tokens_expires_at ON tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_tokens_refresh_token_id ON tokens(refresh_token_id);
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens(family_id);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    user_id VARCHAR(64) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    blocked_pubkey VARCHAR(1200) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, blocked_pubkey)
+);
     `
 
 	// Execute the migration
@@ -138,3 +351,24 @@ CREATE INDEX IF NOT EXISTS idx_tokens_expires_at ON tokens(expires_at);
 	db.Logger.Info("Ran database migrations successfully")
 	return nil
 }
+
+// uniqueViolationCode is the Postgres error code for a unique constraint violation
+const uniqueViolationCode = "23505"
+
+// messagePrimaryKey is the constraint violated when a message ID is stored twice
+const messagePrimaryKey = "messages_pkey"
+
+// publicKeyUniqueIndex keeps public keys unique; hash indexes can't, so it indexes a digest of the key
+const publicKeyUniqueIndex = "idx_users_public_key_unique"
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+// isConstraintViolation reports whether err is a Postgres violation of the named constraint or index
+func isConstraintViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.ConstraintName == constraint
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAcquirer tracks connections acquired and released during warmup
+type fakeAcquirer struct {
+	held     int
+	maxHeld  int
+	acquired int
+	released int
+	failAt   int // Fail the nth acquire, 0 to never fail
+}
+
+func (f *fakeAcquirer) acquire(ctx context.Context) (func(), error) {
+	if f.failAt > 0 && f.acquired+1 == f.failAt {
+		return nil, errors.New("connection refused")
+	}
+
+	f.acquired++
+	f.held++
+	if f.held > f.maxHeld {
+		f.maxHeld = f.held
+	}
+
+	return func() {
+		f.held--
+		f.released++
+	}, nil
+}
+
+func TestWarmupAcquiresExpectedConnections(t *testing.T) {
+	fake := &fakeAcquirer{}
+
+	warmed, err := warmup(context.Background(), 5, fake.acquire)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, warmed)
+	assert.Equal(t, 5, fake.acquired)
+	assert.Equal(t, 5, fake.maxHeld, "connections must be held together to open distinct connections")
+	assert.Equal(t, 5, fake.released)
+	assert.Zero(t, fake.held)
+}
+
+func TestWarmupReleasesOnFailure(t *testing.T) {
+	fake := &fakeAcquirer{failAt: 3}
+
+	warmed, err := warmup(context.Background(), 5, fake.acquire)
+	assert.Error(t, err)
+
+	assert.Equal(t, 2, warmed)
+	assert.Equal(t, 2, fake.released)
+	assert.Zero(t, fake.held)
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	violation := &pgconn.PgError{Code: uniqueViolationCode, ConstraintName: "users_pkey"}
+
+	assert.True(t, isUniqueViolation(violation))
+	assert.True(t, isUniqueViolation(fmt.Errorf("insert failed: %w", violation)))
+	assert.False(t, isUniqueViolation(&pgconn.PgError{Code: "23503"}))
+	assert.False(t, isUniqueViolation(errors.New("connection reset")))
+	assert.False(t, isUniqueViolation(nil))
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	user := createTestUser(t, db)
+
+	failure := errors.New("deletion interrupted")
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := NewTokenRepositoryTx(db, tx).DeleteUserTokens(ctx, user.UserID); err != nil {
+			return err
+		}
+		if err := NewUserRepositoryTx(db, tx).Delete(ctx, user.UserID); err != nil {
+			return err
+		}
+
+		// Statements in the transaction see its own changes
+		_, err := NewUserRepositoryTx(db, tx).GetByID(ctx, user.UserID)
+		assert.Error(t, err)
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+
+	// The deletion was rolled back
+	_, err = repo.GetByID(ctx, user.UserID)
+	assert.NoError(t, err)
+
+	// A transaction that succeeds is committed
+	require.NoError(t, db.WithTx(ctx, func(tx pgx.Tx) error {
+		return NewUserRepositoryTx(db, tx).Delete(ctx, user.UserID)
+	}))
+	_, err = repo.GetByID(ctx, user.UserID)
+	assert.Error(t, err)
+}
+
+func TestAfterCommitWaitsForWithTx(t *testing.T) {
+	ran := false
+	committing := &committingTx{}
+	afterCommit(committing, func() { ran = true })
+	assert.False(t, ran, "hooks on a WithTx transaction wait for its commit")
+	require.Len(t, committing.afterCommit, 1)
+
+	committing.afterCommit[0]()
+	assert.True(t, ran)
+
+	// Any other transaction can't be followed, so the hook runs right away
+	ran = false
+	afterCommit(nil, func() { ran = true })
+	assert.True(t, ran)
+}
+
+func TestQueriesStopAtContextDeadline(t *testing.T) {
+	db := newTestDatabase(t)
+
+	// Request contexts carry the server timeout, and pgx must cancel the query on the server when it passes
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.Pool.Exec(ctx, "SELECT pg_sleep(10)")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
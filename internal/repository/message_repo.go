@@ -3,6 +3,8 @@ package repository
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -10,11 +12,13 @@ import (
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/security"
 )
 
 // MessageRepository handles message data storage operations
 type MessageRepository struct {
 	db     *Database
+	q      Querier
 	logger *zap.Logger
 }
 
@@ -22,23 +26,82 @@ type MessageRepository struct {
 func NewMessageRepository(db *Database) *MessageRepository {
 	return &MessageRepository{
 		db:     db,
+		q:      instrument(db.Pool, "messages"),
 		logger: db.Logger.With(zap.String("repository", "message")),
 	}
 }
 
+// NewMessageRepositoryTx creates a MessageRepository whose statements run in tx
+func NewMessageRepositoryTx(db *Database, tx pgx.Tx) *MessageRepository {
+	r := NewMessageRepository(db)
+	r.q = instrument(tx, "messages")
+	return r
+}
+
+// messageColumns is the column list selected for messages, in the order scanMessage reads them
+const messageColumns = `
+		message_id, sender_pubkey, recipient_pubkey,
+		ciphertext_kem, ciphertext_msg, nonce,
+		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
+		timestamp, status, COALESCE(content_hash, ''), reply_to_message_id,
+		delivered_at, read_at, expires_at`
+
+// messageVisible matches messages that are neither deleted nor expired
+// Soft-deleted and expired messages are hidden from reads until the purge and cleanup jobs delete them
+const messageVisible = `(deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()))`
+
+// statusesBefore lists, for each status, the statuses a message can move to it from
+// Statuses only move forward, from sent to delivered to read; a failed message goes back to sent only when resent
+var statusesBefore = map[domain.MessageStatus][]string{
+	domain.MessageStatusSent:      {string(domain.MessageStatusFailed)},
+	domain.MessageStatusDelivered: {string(domain.MessageStatusSent)},
+	domain.MessageStatusRead:      {string(domain.MessageStatusSent), string(domain.MessageStatusDelivered)},
+}
+
+// scanMessage reads a message selected with messageColumns
+func scanMessage(row pgx.Row) (*domain.Message, error) {
+	message := &domain.Message{}
+	err := row.Scan(
+		&message.MessageID,
+		&message.SenderPubKey,
+		&message.RecipientPubKey,
+		&message.CiphertextKEM,
+		&message.CiphertextMsg,
+		&message.Nonce,
+		&message.SenderCiphertextKEM,
+		&message.SenderCiphertextMsg,
+		&message.SenderNonce,
+		&message.Timestamp,
+		&message.Status,
+		&message.ContentHash,
+		&message.ReplyToMessageID,
+		&message.DeliveredAt,
+		&message.ReadAt,
+		&message.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
 // Create creates a new message
+// The content hash is computed here so it always reflects the stored ciphertext
 func (r *MessageRepository) Create(ctx context.Context, message *domain.Message) error {
 	query := `
 	INSERT INTO messages (
 		message_id, sender_pubkey, recipient_pubkey,
 		ciphertext_kem, ciphertext_msg, nonce,
 		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
-		timestamp, status
+		timestamp, status, content_hash, reply_to_message_id, expires_at
 	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query,
+	message.ContentHash = security.HashMessageContent(message.CiphertextKEM, message.CiphertextMsg, message.Nonce)
+
+	_, err := r.q.Exec(ctx, query,
 		message.MessageID,
 		message.SenderPubKey,
 		message.RecipientPubKey,
@@ -50,9 +113,17 @@ func (r *MessageRepository) Create(ctx context.Context, message *domain.Message)
 		message.SenderNonce,
 		message.Timestamp,
 		message.Status,
+		message.ContentHash,
+		message.ReplyToMessageID,
+		message.ExpiresAt,
 	)
 
 	if err != nil {
+		// A retried send reuses its message ID; storing it again is a no-op that yields the stored message
+		if isUniqueViolation(err) && isConstraintViolation(err, messagePrimaryKey) {
+			return r.resolveDuplicate(ctx, message)
+		}
+
 		r.logger.Error("Failed to create message", zap.Error(err), zap.String("message_id", message.MessageID.String()))
 		return errors.NewInternalError("Failed to create message", err)
 	}
@@ -60,35 +131,123 @@ func (r *MessageRepository) Create(ctx context.Context, message *domain.Message)
 	return nil
 }
 
+// resolveDuplicate replaces message with the stored message of the same ID if it is the same sender's retry
+// An ID already used by another conversation is a conflict
+func (r *MessageRepository) resolveDuplicate(ctx context.Context, message *domain.Message) error {
+	existing, err := r.GetByID(ctx, message.MessageID)
+	if err != nil {
+		if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+			// Stored but already expired or deleted
+			return errors.NewConflictError(fmt.Sprintf("Message with ID '%s' already exists", message.MessageID))
+		}
+		return err
+	}
+
+	if existing.SenderPubKey != message.SenderPubKey || existing.RecipientPubKey != message.RecipientPubKey {
+		return errors.NewConflictError(fmt.Sprintf("Message with ID '%s' already exists", message.MessageID))
+	}
+
+	*message = *existing
+	return nil
+}
+
+// messageCopyColumns are the columns CreateBatch copies into the staging table
+var messageCopyColumns = []string{
+	"message_id", "sender_pubkey", "recipient_pubkey",
+	"ciphertext_kem", "ciphertext_msg", "nonce",
+	"sender_ciphertext_kem", "sender_ciphertext_msg", "sender_nonce",
+	"timestamp", "status", "content_hash", "reply_to_message_id", "expires_at",
+}
+
+// CreateBatch stores messages with COPY and returns how many were stored
+// The rows are copied into a staging table and moved over in one statement, so messages whose ID
+// already exists are left as they are instead of failing the copy; if anything fails, none of the batch is stored
+func (r *MessageRepository) CreateBatch(ctx context.Context, messages []*domain.Message) (int64, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	stagingQuery := `
+	CREATE TEMP TABLE messages_import (LIKE messages INCLUDING DEFAULTS) ON COMMIT DROP
+	`
+
+	insertQuery := `
+	INSERT INTO messages (` + strings.Join(messageCopyColumns, ", ") + `)
+	SELECT ` + strings.Join(messageCopyColumns, ", ") + `
+	FROM messages_import
+	ON CONFLICT (message_id) DO NOTHING
+	`
+
+	rows := make([][]interface{}, len(messages))
+	for i, message := range messages {
+		message.ContentHash = security.HashMessageContent(message.CiphertextKEM, message.CiphertextMsg, message.Nonce)
+		rows[i] = []interface{}{
+			message.MessageID,
+			message.SenderPubKey,
+			message.RecipientPubKey,
+			message.CiphertextKEM,
+			message.CiphertextMsg,
+			message.Nonce,
+			message.SenderCiphertextKEM,
+			message.SenderCiphertextMsg,
+			message.SenderNonce,
+			message.Timestamp,
+			message.Status,
+			message.ContentHash,
+			message.ReplyToMessageID,
+			message.ExpiresAt,
+		}
+	}
+
+	tx, err := r.q.Begin(ctx)
+	if err != nil {
+		r.logger.Error("Failed to begin message batch", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, stagingQuery); err != nil {
+		r.logger.Error("Failed to create message staging table", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"messages_import"}, messageCopyColumns, pgx.CopyFromRows(rows)); err != nil {
+		r.logger.Error("Failed to copy message batch", zap.Error(err), zap.Int("count", len(messages)))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+
+	result, err := tx.Exec(ctx, insertQuery)
+	if err != nil {
+		r.logger.Error("Failed to create message batch", zap.Error(err), zap.Int("count", len(messages)))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+
+	// Inside a caller's transaction this commit only releases a savepoint, so the staging table is dropped
+	// here rather than left for ON COMMIT, where the next batch would find it still there
+	if _, err := tx.Exec(ctx, "DROP TABLE messages_import"); err != nil {
+		r.logger.Error("Failed to drop message staging table", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("Failed to commit message batch", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to create messages", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
 // GetByID gets a message by ID
 func (r *MessageRepository) GetByID(ctx context.Context, messageID uuid.UUID) (*domain.Message, error) {
 	query := `
-	SELECT
-		message_id, sender_pubkey, recipient_pubkey,
-		ciphertext_kem, ciphertext_msg, nonce,
-		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
-		timestamp, status
+	SELECT ` + messageColumns + `
 	FROM messages
-	WHERE message_id = $1
+	WHERE message_id = $1 AND ` + messageVisible + `
 	`
 
-	row := r.db.Pool.QueryRow(ctx, query, messageID)
-
-	message := &domain.Message{}
-	err := row.Scan(
-		&message.MessageID,
-		&message.SenderPubKey,
-		&message.RecipientPubKey,
-		&message.CiphertextKEM,
-		&message.CiphertextMsg,
-		&message.Nonce,
-		&message.SenderCiphertextKEM,
-		&message.SenderCiphertextMsg,
-		&message.SenderNonce,
-		&message.Timestamp,
-		&message.Status,
-	)
+	row := r.q.QueryRow(ctx, query, messageID)
 
+	message, err := scanMessage(row)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
@@ -100,21 +259,55 @@ func (r *MessageRepository) GetByID(ctx context.Context, messageID uuid.UUID) (*
 	return message, nil
 }
 
+// GetByIDs gets the messages with the given IDs
+// IDs that do not exist are skipped
+func (r *MessageRepository) GetByIDs(ctx context.Context, messageIDs []uuid.UUID) ([]*domain.Message, error) {
+	if len(messageIDs) == 0 {
+		return []*domain.Message{}, nil
+	}
+
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE message_id = ANY($1) AND ` + messageVisible + `
+	`
+
+	rows, err := r.q.Query(ctx, query, messageIDs)
+	if err != nil {
+		r.logger.Error("Failed to get messages by IDs", zap.Error(err), zap.Int("count", len(messageIDs)))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
 // GetByRecipient gets messages for a recipient with pagination
 func (r *MessageRepository) GetByRecipient(ctx context.Context, pubKey string, limit, offset int) ([]*domain.Message, error) {
 	query := `
-	SELECT
-		message_id, sender_pubkey, recipient_pubkey,
-		ciphertext_kem, ciphertext_msg, nonce,
-		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
-		timestamp, status
+	SELECT ` + messageColumns + `
 	FROM messages
-	WHERE recipient_pubkey = $1
+	WHERE recipient_pubkey = $1 AND ` + messageVisible + `
 	ORDER BY timestamp DESC
 	LIMIT $2 OFFSET $3
 	`
 
-	rows, err := r.db.Pool.Query(ctx, query, pubKey, limit, offset)
+	rows, err := r.q.Query(ctx, query, pubKey, limit, offset)
 	if err != nil {
 		r.logger.Error("Failed to get messages by recipient", zap.Error(err), zap.String("recipient_pubkey", pubKey))
 		return nil, errors.NewInternalError("Failed to get messages", err)
@@ -123,20 +316,79 @@ func (r *MessageRepository) GetByRecipient(ctx context.Context, pubKey string, l
 
 	var messages []*domain.Message
 	for rows.Next() {
-		message := &domain.Message{}
-		err := rows.Scan(
-			&message.MessageID,
-			&message.SenderPubKey,
-			&message.RecipientPubKey,
-			&message.CiphertextKEM,
-			&message.CiphertextMsg,
-			&message.Nonce,
-			&message.SenderCiphertextKEM,
-			&message.SenderCiphertextMsg,
-			&message.SenderNonce,
-			&message.Timestamp,
-			&message.Status,
-		)
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// GetByRecipientSince gets the oldest messages for a recipient sent after the given time
+func (r *MessageRepository) GetByRecipientSince(ctx context.Context, pubKey string, since time.Time, limit int) ([]*domain.Message, error) {
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE recipient_pubkey = $1 AND timestamp > $2 AND ` + messageVisible + `
+	ORDER BY timestamp ASC
+	LIMIT $3
+	`
+
+	rows, err := r.q.Query(ctx, query, pubKey, since, limit)
+	if err != nil {
+		r.logger.Error("Failed to get messages by recipient since", zap.Error(err), zap.String("recipient_pubkey", pubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// GetByRecipientBefore gets the newest messages for a recipient sent before the given time
+func (r *MessageRepository) GetByRecipientBefore(ctx context.Context, pubKey string, before time.Time, limit int) ([]*domain.Message, error) {
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE recipient_pubkey = $1 AND timestamp < $2 AND ` + messageVisible + `
+	ORDER BY timestamp DESC
+	LIMIT $3
+	`
+
+	rows, err := r.q.Query(ctx, query, pubKey, before, limit)
+	if err != nil {
+		r.logger.Error("Failed to get messages by recipient before cursor",
+			zap.Error(err),
+			zap.String("recipient_pubkey", pubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error("Failed to scan message row", zap.Error(err))
 			return nil, errors.NewInternalError("Failed to read message data", err)
@@ -155,18 +407,14 @@ func (r *MessageRepository) GetByRecipient(ctx context.Context, pubKey string, l
 // GetBySender gets messages sent by a sender with pagination
 func (r *MessageRepository) GetBySender(ctx context.Context, pubKey string, limit, offset int) ([]*domain.Message, error) {
 	query := `
-	SELECT
-		message_id, sender_pubkey, recipient_pubkey,
-		ciphertext_kem, ciphertext_msg, nonce,
-		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
-		timestamp, status
+	SELECT ` + messageColumns + `
 	FROM messages
-	WHERE sender_pubkey = $1
+	WHERE sender_pubkey = $1 AND ` + messageVisible + `
 	ORDER BY timestamp DESC
 	LIMIT $2 OFFSET $3
 	`
 
-	rows, err := r.db.Pool.Query(ctx, query, pubKey, limit, offset)
+	rows, err := r.q.Query(ctx, query, pubKey, limit, offset)
 	if err != nil {
 		r.logger.Error("Failed to get messages by sender", zap.Error(err), zap.String("sender_pubkey", pubKey))
 		return nil, errors.NewInternalError("Failed to get messages", err)
@@ -175,20 +423,7 @@ func (r *MessageRepository) GetBySender(ctx context.Context, pubKey string, limi
 
 	var messages []*domain.Message
 	for rows.Next() {
-		message := &domain.Message{}
-		err := rows.Scan(
-			&message.MessageID,
-			&message.SenderPubKey,
-			&message.RecipientPubKey,
-			&message.CiphertextKEM,
-			&message.CiphertextMsg,
-			&message.Nonce,
-			&message.SenderCiphertextKEM,
-			&message.SenderCiphertextMsg,
-			&message.SenderNonce,
-			&message.Timestamp,
-			&message.Status,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error("Failed to scan message row", zap.Error(err))
 			return nil, errors.NewInternalError("Failed to read message data", err)
@@ -204,47 +439,28 @@ func (r *MessageRepository) GetBySender(ctx context.Context, pubKey string, limi
 	return messages, nil
 }
 
-// GetConversation gets messages between two users with pagination
-func (r *MessageRepository) GetConversation(ctx context.Context, userPubKey, contactPubKey string, limit, offset int) ([]*domain.Message, error) {
+// GetBySenderBefore gets the newest messages sent by a sender before the given time
+func (r *MessageRepository) GetBySenderBefore(ctx context.Context, pubKey string, before time.Time, limit int) ([]*domain.Message, error) {
 	query := `
-	SELECT
-		message_id, sender_pubkey, recipient_pubkey,
-		ciphertext_kem, ciphertext_msg, nonce,
-		sender_ciphertext_kem, sender_ciphertext_msg, sender_nonce,
-		timestamp, status
+	SELECT ` + messageColumns + `
 	FROM messages
-	WHERE (sender_pubkey = $1 AND recipient_pubkey = $2)
-	   OR (sender_pubkey = $2 AND recipient_pubkey = $1)
+	WHERE sender_pubkey = $1 AND timestamp < $2 AND ` + messageVisible + `
 	ORDER BY timestamp DESC
-	LIMIT $3 OFFSET $4
+	LIMIT $3
 	`
 
-	rows, err := r.db.Pool.Query(ctx, query, userPubKey, contactPubKey, limit, offset)
+	rows, err := r.q.Query(ctx, query, pubKey, before, limit)
 	if err != nil {
-		r.logger.Error("Failed to get conversation messages",
+		r.logger.Error("Failed to get messages by sender before cursor",
 			zap.Error(err),
-			zap.String("user_pubkey", userPubKey),
-			zap.String("contact_pubkey", contactPubKey))
+			zap.String("sender_pubkey", pubKey))
 		return nil, errors.NewInternalError("Failed to get messages", err)
 	}
 	defer rows.Close()
 
 	var messages []*domain.Message
 	for rows.Next() {
-		message := &domain.Message{}
-		err := rows.Scan(
-			&message.MessageID,
-			&message.SenderPubKey,
-			&message.RecipientPubKey,
-			&message.CiphertextKEM,
-			&message.CiphertextMsg,
-			&message.Nonce,
-			&message.SenderCiphertextKEM,
-			&message.SenderCiphertextMsg,
-			&message.SenderNonce,
-			&message.Timestamp,
-			&message.Status,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			r.logger.Error("Failed to scan message row", zap.Error(err))
 			return nil, errors.NewInternalError("Failed to read message data", err)
@@ -260,41 +476,701 @@ func (r *MessageRepository) GetConversation(ctx context.Context, userPubKey, con
 	return messages, nil
 }
 
-// UpdateStatus updates a message's status
-func (r *MessageRepository) UpdateStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) error {
+// GetBySenderAndStatus gets messages sent by a user with the given status, with pagination
+func (r *MessageRepository) GetBySenderAndStatus(ctx context.Context, pubKey string, status domain.MessageStatus, limit, offset int) ([]*domain.Message, error) {
 	query := `
-	UPDATE messages
-	SET status = $1
-	WHERE message_id = $2
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE sender_pubkey = $1 AND status = $2 AND ` + messageVisible + `
+	ORDER BY timestamp DESC
+	LIMIT $3 OFFSET $4
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, status, messageID)
+	rows, err := r.q.Query(ctx, query, pubKey, status, limit, offset)
 	if err != nil {
-		r.logger.Error("Failed to update message status",
+		r.logger.Error("Failed to get messages by sender and status",
 			zap.Error(err),
-			zap.String("message_id", messageID.String()),
+			zap.String("sender_pubkey", pubKey),
 			zap.String("status", string(status)))
-		return errors.NewInternalError("Failed to update message status", err)
+		return nil, errors.NewInternalError("Failed to get messages", err)
 	}
+	defer rows.Close()
 
-	if result.RowsAffected() == 0 {
-		return errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
 }
 
-// DeleteUserMessages deletes all messages where a user is sender or recipient
-func (r *MessageRepository) DeleteUserMessages(ctx context.Context, pubKey string) (int64, error) {
+// GetConversation gets messages between two users with pagination, newest first unless ascending is set
+func (r *MessageRepository) GetConversation(ctx context.Context, userPubKey, contactPubKey string, ascending bool, limit, offset int) ([]*domain.Message, error) {
+	order := "DESC"
+	if ascending {
+		order = "ASC"
+	}
+
 	query := `
-	DELETE FROM messages
-	WHERE sender_pubkey = $1 OR recipient_pubkey = $1
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE ((sender_pubkey = $1 AND recipient_pubkey = $2)
+	    OR (sender_pubkey = $2 AND recipient_pubkey = $1))
+	  AND ` + messageVisible + `
+	ORDER BY timestamp ` + order + `
+	LIMIT $3 OFFSET $4
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, pubKey)
+	rows, err := r.q.Query(ctx, query, userPubKey, contactPubKey, limit, offset)
 	if err != nil {
-		r.logger.Error("Failed to delete user messages", zap.Error(err), zap.String("pubkey", pubKey))
-		return 0, errors.NewInternalError("Failed to delete messages", err)
+		r.logger.Error("Failed to get conversation messages",
+			zap.Error(err),
+			zap.String("user_pubkey", userPubKey),
+			zap.String("contact_pubkey", contactPubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// GetConversationBefore gets the newest messages between two users sent before the given time
+func (r *MessageRepository) GetConversationBefore(ctx context.Context, userPubKey, contactPubKey string, before time.Time, limit int) ([]*domain.Message, error) {
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE ((sender_pubkey = $1 AND recipient_pubkey = $2)
+	    OR (sender_pubkey = $2 AND recipient_pubkey = $1))
+	  AND timestamp < $3
+	  AND ` + messageVisible + `
+	ORDER BY timestamp DESC
+	LIMIT $4
+	`
+
+	rows, err := r.q.Query(ctx, query, userPubKey, contactPubKey, before, limit)
+	if err != nil {
+		r.logger.Error("Failed to get conversation messages before cursor",
+			zap.Error(err),
+			zap.String("user_pubkey", userPubKey),
+			zap.String("contact_pubkey", contactPubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// GetConversationAfter gets the oldest messages between two users sent after the given time
+func (r *MessageRepository) GetConversationAfter(ctx context.Context, userPubKey, contactPubKey string, after time.Time, limit int) ([]*domain.Message, error) {
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE ((sender_pubkey = $1 AND recipient_pubkey = $2)
+	    OR (sender_pubkey = $2 AND recipient_pubkey = $1))
+	  AND timestamp > $3
+	  AND ` + messageVisible + `
+	ORDER BY timestamp ASC
+	LIMIT $4
+	`
+
+	rows, err := r.q.Query(ctx, query, userPubKey, contactPubKey, after, limit)
+	if err != nil {
+		r.logger.Error("Failed to get conversation messages after cursor",
+			zap.Error(err),
+			zap.String("user_pubkey", userPubKey),
+			zap.String("contact_pubkey", contactPubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// GetUserMessagesAfter gets messages a user sent or received, oldest first, after a (timestamp, message ID) cursor
+// Passing the last message's timestamp and ID fetches the next page; the zero time and uuid.Nil start from the beginning
+func (r *MessageRepository) GetUserMessagesAfter(ctx context.Context, userPubKey string, afterTimestamp time.Time, afterID uuid.UUID, limit int) ([]*domain.Message, error) {
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE (sender_pubkey = $1 OR recipient_pubkey = $1)
+	  AND (timestamp, message_id) > ($2, $3)
+	  AND ` + messageVisible + `
+	ORDER BY timestamp ASC, message_id ASC
+	LIMIT $4
+	`
+
+	rows, err := r.q.Query(ctx, query, userPubKey, afterTimestamp, afterID, limit)
+	if err != nil {
+		r.logger.Error("Failed to get user messages after cursor", zap.Error(err), zap.String("user_pubkey", userPubKey))
+		return nil, errors.NewInternalError("Failed to get messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// ForEachUserMessage calls fn with every message a user sent or received, oldest first
+// Messages are read pageSize at a time, so no page limit truncates the results and memory use stays flat
+// Iteration stops at the first error from fn, which is returned
+func (r *MessageRepository) ForEachUserMessage(ctx context.Context, userPubKey string, pageSize int, fn func(*domain.Message) error) error {
+	var afterTimestamp time.Time
+	afterID := uuid.Nil
+	for {
+		page, err := r.GetUserMessagesAfter(ctx, userPubKey, afterTimestamp, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range page {
+			if err := fn(message); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < pageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterTimestamp, afterID = last.Timestamp, last.MessageID
+	}
+}
+
+// CountForUser counts the messages a user sent or received; a message to oneself is counted once
+func (r *MessageRepository) CountForUser(ctx context.Context, userPubKey string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM messages
+	WHERE (sender_pubkey = $1 OR recipient_pubkey = $1) AND ` + messageVisible + `
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, userPubKey).Scan(&count); err != nil {
+		r.logger.Error("Failed to count user messages", zap.Error(err), zap.String("user_pubkey", userPubKey))
+		return 0, errors.NewInternalError("Failed to count messages", err)
+	}
+
+	return count, nil
+}
+
+// CountConversation counts the messages exchanged between two users, in either direction
+func (r *MessageRepository) CountConversation(ctx context.Context, userPubKey, contactPubKey string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM messages
+	WHERE ((sender_pubkey = $1 AND recipient_pubkey = $2)
+	    OR (sender_pubkey = $2 AND recipient_pubkey = $1))
+	  AND ` + messageVisible + `
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, userPubKey, contactPubKey).Scan(&count); err != nil {
+		r.logger.Error("Failed to count conversation messages",
+			zap.Error(err),
+			zap.String("user_pubkey", userPubKey),
+			zap.String("contact_pubkey", contactPubKey))
+		return 0, errors.NewInternalError("Failed to count messages", err)
+	}
+
+	return count, nil
+}
+
+// CountUnread counts messages for a recipient that have not been delivered or read
+func (r *MessageRepository) CountUnread(ctx context.Context, recipientPubKey string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM messages
+	WHERE recipient_pubkey = $1 AND status = $2 AND ` + messageVisible + `
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, recipientPubKey, domain.MessageStatusSent).Scan(&count); err != nil {
+		r.logger.Error("Failed to count unread messages", zap.Error(err), zap.String("recipient_pubkey", recipientPubKey))
+		return 0, errors.NewInternalError("Failed to count messages", err)
+	}
+
+	return count, nil
+}
+
+// CountUnreadBySender counts a recipient's unread messages per sender public key
+func (r *MessageRepository) CountUnreadBySender(ctx context.Context, recipientPubKey string) (map[string]int, error) {
+	query := `
+	SELECT sender_pubkey, COUNT(*)
+	FROM messages
+	WHERE recipient_pubkey = $1 AND status = $2 AND ` + messageVisible + `
+	GROUP BY sender_pubkey
+	`
+
+	rows, err := r.q.Query(ctx, query, recipientPubKey, domain.MessageStatusSent)
+	if err != nil {
+		r.logger.Error("Failed to count unread messages by sender", zap.Error(err), zap.String("recipient_pubkey", recipientPubKey))
+		return nil, errors.NewInternalError("Failed to count messages", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var senderPubKey string
+		var count int
+		if err := rows.Scan(&senderPubKey, &count); err != nil {
+			r.logger.Error("Failed to scan unread count row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		counts[senderPubKey] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating unread count rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return counts, nil
+}
+
+// ListConversations summarizes each peer a user has exchanged messages with, most recent first
+// Messages are grouped on the same normalized pair expression as idx_messages_conversation
+func (r *MessageRepository) ListConversations(ctx context.Context, userPubKey string) ([]*domain.ConversationSummary, error) {
+	query := `
+	SELECT
+		CASE WHEN sender_pubkey = $1 THEN recipient_pubkey ELSE sender_pubkey END AS peer_pubkey,
+		MAX(timestamp) AS last_message_at,
+		COUNT(*) FILTER (WHERE recipient_pubkey = $1 AND status = $2) AS unread_count
+	FROM messages
+	WHERE (sender_pubkey = $1 OR recipient_pubkey = $1) AND ` + messageVisible + `
+	GROUP BY (
+		CASE WHEN sender_pubkey < recipient_pubkey
+			THEN sender_pubkey || recipient_pubkey
+			ELSE recipient_pubkey || sender_pubkey
+		END
+	), peer_pubkey
+	ORDER BY last_message_at DESC
+	`
+
+	rows, err := r.q.Query(ctx, query, userPubKey, domain.MessageStatusSent)
+	if err != nil {
+		r.logger.Error("Failed to list conversations", zap.Error(err), zap.String("user_pubkey", userPubKey))
+		return nil, errors.NewInternalError("Failed to list conversations", err)
+	}
+	defer rows.Close()
+
+	var conversations []*domain.ConversationSummary
+	for rows.Next() {
+		conversation := &domain.ConversationSummary{}
+		if err := rows.Scan(&conversation.PeerPubKey, &conversation.LastMessageAt, &conversation.UnreadCount); err != nil {
+			r.logger.Error("Failed to scan conversation row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read conversation data", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating conversation rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read conversation data", err)
+	}
+
+	return conversations, nil
+}
+
+// SearchOptions narrows a message search; zero values leave that filter off
+type SearchOptions struct {
+	From   time.Time            // Only messages sent at or after this time
+	To     time.Time            // Only messages sent before this time
+	Peer   string               // Only messages exchanged with this public key
+	Status domain.MessageStatus // Only messages with this status
+	Limit  int
+	Offset int
+}
+
+// Search finds a user's sent and received messages by metadata, newest first
+// Message bodies are end-to-end encrypted, so only time, peer and status can be matched
+func (r *MessageRepository) Search(ctx context.Context, userPubKey string, opts SearchOptions) ([]*domain.Message, error) {
+	args := []any{userPubKey}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := []string{"(sender_pubkey = $1 OR recipient_pubkey = $1)", messageVisible}
+	if !opts.From.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(opts.From))
+	}
+	if !opts.To.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(opts.To))
+	}
+	if opts.Peer != "" {
+		peer := arg(opts.Peer)
+		conditions = append(conditions, "((sender_pubkey = $1 AND recipient_pubkey = "+peer+") OR (sender_pubkey = "+peer+" AND recipient_pubkey = $1))")
+	}
+	if opts.Status != "" {
+		conditions = append(conditions, "status = "+arg(opts.Status))
+	}
+
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY timestamp DESC
+	LIMIT ` + arg(opts.Limit) + ` OFFSET ` + arg(opts.Offset)
+
+	rows, err := r.q.Query(ctx, query, args...)
+	if err != nil {
+		r.logger.Error("Failed to search messages", zap.Error(err), zap.String("user_pubkey", userPubKey))
+		return nil, errors.NewInternalError("Failed to search messages", err)
+	}
+	defer rows.Close()
+
+	var messages []*domain.Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan message row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read message data", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating message rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read message data", err)
+	}
+
+	return messages, nil
+}
+
+// UpdateStatus updates a message's status
+// The first transition to delivered or read records its time; reading a message also marks it delivered
+// It reports false, without an error, when the message can't move to status, such as a read message marked delivered
+func (r *MessageRepository) UpdateStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) (bool, error) {
+	query := `
+	UPDATE messages
+	SET status = $1,
+		delivered_at = CASE WHEN $3 THEN COALESCE(delivered_at, $5) ELSE delivered_at END,
+		read_at = CASE WHEN $4 THEN COALESCE(read_at, $5) ELSE read_at END
+	WHERE message_id = $2 AND deleted_at IS NULL AND status = ANY($6)
+	`
+
+	existsQuery := `
+	SELECT EXISTS (SELECT 1 FROM messages WHERE message_id = $1 AND deleted_at IS NULL)
+	`
+
+	markDelivered := status == domain.MessageStatusDelivered || status == domain.MessageStatusRead
+	markRead := status == domain.MessageStatusRead
+
+	result, err := r.q.Exec(ctx, query, status, messageID, markDelivered, markRead, time.Now(), statusesBefore[status])
+	if err != nil {
+		r.logger.Error("Failed to update message status",
+			zap.Error(err),
+			zap.String("message_id", messageID.String()),
+			zap.String("status", string(status)))
+		return false, errors.NewInternalError("Failed to update message status", err)
+	}
+
+	if result.RowsAffected() > 0 {
+		return true, nil
+	}
+
+	// Nothing was updated: either there is no such message, or it is already at or past status
+	var exists bool
+	if err := r.q.QueryRow(ctx, existsQuery, messageID).Scan(&exists); err != nil {
+		r.logger.Error("Failed to check message exists", zap.Error(err), zap.String("message_id", messageID.String()))
+		return false, errors.NewInternalError("Failed to update message status", err)
+	}
+	if !exists {
+		return false, errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	return false, nil
+}
+
+// UpdateStatusBatch updates the status of several messages sent to recipientPubKey
+// It returns a receipt for each message updated; IDs that don't exist, belong to another recipient,
+// or are already at or past status are skipped. Timestamps are recorded the same way as UpdateStatus
+func (r *MessageRepository) UpdateStatusBatch(ctx context.Context, recipientPubKey string, messageIDs []uuid.UUID, status domain.MessageStatus) ([]*domain.MessageReceipt, error) {
+	query := `
+	UPDATE messages
+	SET status = $1,
+		delivered_at = CASE WHEN $3 THEN COALESCE(delivered_at, $5) ELSE delivered_at END,
+		read_at = CASE WHEN $4 THEN COALESCE(read_at, $5) ELSE read_at END
+	WHERE message_id = ANY($2) AND recipient_pubkey = $6 AND deleted_at IS NULL AND status = ANY($7)
+	RETURNING message_id, sender_pubkey
+	`
+
+	markDelivered := status == domain.MessageStatusDelivered || status == domain.MessageStatusRead
+	markRead := status == domain.MessageStatusRead
+	now := time.Now()
+
+	rows, err := r.q.Query(ctx, query, status, messageIDs, markDelivered, markRead, now, recipientPubKey, statusesBefore[status])
+	if err != nil {
+		r.logger.Error("Failed to update message statuses",
+			zap.Error(err),
+			zap.Int("count", len(messageIDs)),
+			zap.String("status", string(status)))
+		return nil, errors.NewInternalError("Failed to update message status", err)
+	}
+	defer rows.Close()
+
+	var receipts []*domain.MessageReceipt
+	for rows.Next() {
+		receipt := &domain.MessageReceipt{Status: status, UpdatedAt: now}
+		if err := rows.Scan(&receipt.MessageID, &receipt.SenderPubKey); err != nil {
+			r.logger.Error("Failed to scan updated message", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to update message status", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating updated messages", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to update message status", err)
+	}
+
+	return receipts, nil
+}
+
+// MarkDeliveredForRecipient moves messages sent to recipientPubKey from sent to delivered and records when
+// It returns a receipt for each message moved; messages already delivered or read, or sent to someone else, are skipped
+func (r *MessageRepository) MarkDeliveredForRecipient(ctx context.Context, recipientPubKey string, messageIDs []uuid.UUID) ([]*domain.MessageReceipt, error) {
+	query := `
+	UPDATE messages
+	SET status = $1, delivered_at = COALESCE(delivered_at, $4)
+	WHERE message_id = ANY($2) AND recipient_pubkey = $3 AND status = $5 AND deleted_at IS NULL
+	RETURNING message_id, sender_pubkey
+	`
+
+	now := time.Now()
+	rows, err := r.q.Query(ctx, query, domain.MessageStatusDelivered, messageIDs, recipientPubKey, now, domain.MessageStatusSent)
+	if err != nil {
+		r.logger.Error("Failed to mark messages delivered",
+			zap.Error(err),
+			zap.Int("count", len(messageIDs)))
+		return nil, errors.NewInternalError("Failed to mark messages delivered", err)
+	}
+	defer rows.Close()
+
+	var receipts []*domain.MessageReceipt
+	for rows.Next() {
+		receipt := &domain.MessageReceipt{Status: domain.MessageStatusDelivered, UpdatedAt: now}
+		if err := rows.Scan(&receipt.MessageID, &receipt.SenderPubKey); err != nil {
+			r.logger.Error("Failed to scan delivered message", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to mark messages delivered", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating delivered messages", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to mark messages delivered", err)
+	}
+
+	return receipts, nil
+}
+
+// FailUndeliveredTo marks the messages sent to recipientPubKey that were never delivered as failed
+// It is used when the key is retired, so nobody can fetch them any more; it returns a receipt for each message marked
+func (r *MessageRepository) FailUndeliveredTo(ctx context.Context, recipientPubKey string) ([]*domain.MessageReceipt, error) {
+	query := `
+	UPDATE messages
+	SET status = $1
+	WHERE recipient_pubkey = $2 AND status = $3 AND deleted_at IS NULL
+	RETURNING message_id, sender_pubkey
+	`
+
+	now := time.Now()
+	rows, err := r.q.Query(ctx, query, domain.MessageStatusFailed, recipientPubKey, domain.MessageStatusSent)
+	if err != nil {
+		r.logger.Error("Failed to mark undelivered messages failed", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to update message status", err)
+	}
+	defer rows.Close()
+
+	var receipts []*domain.MessageReceipt
+	for rows.Next() {
+		receipt := &domain.MessageReceipt{Status: domain.MessageStatusFailed, UpdatedAt: now}
+		if err := rows.Scan(&receipt.MessageID, &receipt.SenderPubKey); err != nil {
+			r.logger.Error("Failed to scan failed message", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to update message status", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating failed messages", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to update message status", err)
+	}
+
+	return receipts, nil
+}
+
+// DeleteByID deletes a single message
+func (r *MessageRepository) DeleteByID(ctx context.Context, messageID uuid.UUID) error {
+	query := `
+	DELETE FROM messages
+	WHERE message_id = $1
+	`
+
+	result, err := r.q.Exec(ctx, query, messageID)
+	if err != nil {
+		r.logger.Error("Failed to delete message", zap.Error(err), zap.String("message_id", messageID.String()))
+		return errors.NewInternalError("Failed to delete message", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	return nil
+}
+
+// SoftDeleteByID marks a single message deleted, hiding it from reads until it is purged
+func (r *MessageRepository) SoftDeleteByID(ctx context.Context, messageID uuid.UUID) error {
+	query := `
+	UPDATE messages
+	SET deleted_at = NOW()
+	WHERE message_id = $1 AND deleted_at IS NULL
+	`
+
+	result, err := r.q.Exec(ctx, query, messageID)
+	if err != nil {
+		r.logger.Error("Failed to soft-delete message", zap.Error(err), zap.String("message_id", messageID.String()))
+		return errors.NewInternalError("Failed to delete message", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	return nil
+}
+
+// DeleteExpired deletes all messages whose expiry has passed
+func (r *MessageRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+	DELETE FROM messages
+	WHERE expires_at < NOW()
+	`
+
+	result, err := r.q.Exec(ctx, query)
+	if err != nil {
+		r.logger.Error("Failed to delete expired messages", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to delete expired messages", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// DeleteUserMessages deletes all messages where a user is sender or recipient
+func (r *MessageRepository) DeleteUserMessages(ctx context.Context, pubKey string) (int64, error) {
+	query := `
+	DELETE FROM messages
+	WHERE sender_pubkey = $1 OR recipient_pubkey = $1
+	`
+
+	result, err := r.q.Exec(ctx, query, pubKey)
+	if err != nil {
+		r.logger.Error("Failed to delete user messages", zap.Error(err), zap.String("pubkey", pubKey))
+		return 0, errors.NewInternalError("Failed to delete messages", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// SoftDeleteUserMessages marks all messages where a user is sender or recipient deleted
+func (r *MessageRepository) SoftDeleteUserMessages(ctx context.Context, pubKey string) (int64, error) {
+	query := `
+	UPDATE messages
+	SET deleted_at = NOW()
+	WHERE (sender_pubkey = $1 OR recipient_pubkey = $1) AND deleted_at IS NULL
+	`
+
+	result, err := r.q.Exec(ctx, query, pubKey)
+	if err != nil {
+		r.logger.Error("Failed to soft-delete user messages", zap.Error(err), zap.String("pubkey", pubKey))
+		return 0, errors.NewInternalError("Failed to delete messages", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// PurgeDeleted permanently deletes messages that were soft-deleted before the given time
+func (r *MessageRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+	DELETE FROM messages
+	WHERE deleted_at < $1
+	`
+
+	result, err := r.q.Exec(ctx, query, before)
+	if err != nil {
+		r.logger.Error("Failed to purge deleted messages", zap.Error(err))
+		return 0, errors.NewInternalError("Failed to purge deleted messages", err)
 	}
 
 	return result.RowsAffected(), nil
@@ -0,0 +1,647 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+// createTestMessage stores a message from sender to recipient
+func createTestMessage(t *testing.T, repo *MessageRepository, sender, recipient *domain.User) *domain.Message {
+	t.Helper()
+
+	message := domain.NewMessage(
+		base64.URLEncoding.EncodeToString(sender.PublicKey),
+		base64.URLEncoding.EncodeToString(recipient.PublicKey),
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"),
+	)
+	require.NoError(t, repo.Create(context.Background(), message))
+
+	return message
+}
+
+func TestMessageContentHashStored(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	message := createTestMessage(t, repo, sender, recipient)
+	expected := security.HashMessageContent([]byte("kem"), []byte("msg"), []byte("nonce"))
+	assert.Equal(t, expected, message.ContentHash)
+
+	stored, err := repo.GetByID(ctx, message.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, expected, stored.ContentHash)
+	assert.Equal(t, security.HashMessageContent(stored.CiphertextKEM, stored.CiphertextMsg, stored.Nonce), stored.ContentHash)
+}
+
+func TestMessageReplyToStored(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	original := createTestMessage(t, repo, sender, recipient)
+
+	reply := domain.NewMessage(
+		base64.URLEncoding.EncodeToString(recipient.PublicKey),
+		base64.URLEncoding.EncodeToString(sender.PublicKey),
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"),
+	)
+	reply.ReplyToMessageID = &original.MessageID
+	require.NoError(t, repo.Create(ctx, reply))
+
+	stored, err := repo.GetByID(ctx, reply.MessageID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.ReplyToMessageID)
+	assert.Equal(t, original.MessageID, *stored.ReplyToMessageID)
+
+	stored, err = repo.GetByID(ctx, original.MessageID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.ReplyToMessageID)
+
+	references, err := repo.GetByIDs(ctx, []uuid.UUID{original.MessageID, uuid.New()})
+	require.NoError(t, err)
+	require.Len(t, references, 1)
+	assert.Equal(t, original.MessageID, references[0].MessageID)
+}
+
+func TestMessageStatusTimestamps(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	message := createTestMessage(t, repo, sender, recipient)
+
+	stored, err := repo.GetByID(ctx, message.MessageID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.DeliveredAt)
+	assert.Nil(t, stored.ReadAt)
+
+	_, err = repo.UpdateStatus(ctx, message.MessageID, domain.MessageStatusDelivered)
+	require.NoError(t, err)
+	stored, err = repo.GetByID(ctx, message.MessageID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.DeliveredAt)
+	assert.Nil(t, stored.ReadAt)
+	deliveredAt := *stored.DeliveredAt
+
+	_, err = repo.UpdateStatus(ctx, message.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+	stored, err = repo.GetByID(ctx, message.MessageID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.ReadAt)
+	require.NotNil(t, stored.DeliveredAt)
+	assert.True(t, deliveredAt.Equal(*stored.DeliveredAt), "delivered_at should keep the first transition time")
+
+	// Reading an undelivered message marks it delivered too
+	unread := createTestMessage(t, repo, sender, recipient)
+	_, err = repo.UpdateStatus(ctx, unread.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+	stored, err = repo.GetByID(ctx, unread.MessageID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.DeliveredAt)
+	assert.NotNil(t, stored.ReadAt)
+}
+
+func TestMessageStatusOnlyMovesForward(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	read := createTestMessage(t, repo, sender, recipient)
+	updated, err := repo.UpdateStatus(ctx, read.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	// Going back, or staying put, is a no-op rather than an error
+	for _, status := range []domain.MessageStatus{domain.MessageStatusDelivered, domain.MessageStatusRead, domain.MessageStatusSent} {
+		updated, err = repo.UpdateStatus(ctx, read.MessageID, status)
+		require.NoError(t, err)
+		assert.False(t, updated, "read to %s", status)
+	}
+
+	receipts, err := repo.UpdateStatusBatch(ctx, recipientPubKey, []uuid.UUID{read.MessageID}, domain.MessageStatusDelivered)
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+
+	stored, err := repo.GetByID(ctx, read.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusRead, stored.Status)
+
+	// Unknown messages are still not found
+	_, err = repo.UpdateStatus(ctx, uuid.New(), domain.MessageStatusRead)
+	assert.Error(t, err)
+}
+
+func TestGetByRecipientSince(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	first := createTestMessage(t, repo, sender, recipient)
+	second := createTestMessage(t, repo, sender, recipient)
+	third := createTestMessage(t, repo, sender, recipient)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+
+	// Oldest first, strictly after the given time
+	messages, err := repo.GetByRecipientSince(ctx, recipientPubKey, first.Timestamp.Round(time.Microsecond), 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, second.MessageID, messages[0].MessageID)
+	assert.Equal(t, third.MessageID, messages[1].MessageID)
+
+	messages, err = repo.GetByRecipientSince(ctx, recipientPubKey, first.Timestamp.Add(-time.Second), 1)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, first.MessageID, messages[0].MessageID)
+}
+
+func TestCountUnread(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(alice.PublicKey))
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(bob.PublicKey))
+	})
+
+	createTestMessage(t, repo, alice, recipient)
+	createTestMessage(t, repo, alice, recipient)
+	read := createTestMessage(t, repo, alice, recipient)
+	createTestMessage(t, repo, bob, recipient)
+	_, err := repo.UpdateStatus(ctx, read.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	count, err := repo.CountUnread(ctx, recipientPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	counts, err := repo.CountUnreadBySender(ctx, recipientPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		base64.URLEncoding.EncodeToString(alice.PublicKey): 2,
+		base64.URLEncoding.EncodeToString(bob.PublicKey):   1,
+	}, counts)
+}
+
+func TestListConversations(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	user := createTestUser(t, db)
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), userPubKey)
+	})
+
+	createTestMessage(t, repo, alice, user)
+	createTestMessage(t, repo, user, bob)
+	createTestMessage(t, repo, alice, user)
+	latest := createTestMessage(t, repo, user, alice)
+
+	conversations, err := repo.ListConversations(ctx, userPubKey)
+	require.NoError(t, err)
+	require.Len(t, conversations, 2)
+
+	// Alice's conversation has the latest message and her two unread messages
+	assert.Equal(t, base64.URLEncoding.EncodeToString(alice.PublicKey), conversations[0].PeerPubKey)
+	assert.WithinDuration(t, latest.Timestamp, conversations[0].LastMessageAt, time.Millisecond)
+	assert.Equal(t, 2, conversations[0].UnreadCount)
+
+	// Messages the user sent are never unread for them
+	assert.Equal(t, base64.URLEncoding.EncodeToString(bob.PublicKey), conversations[1].PeerPubKey)
+	assert.Equal(t, 0, conversations[1].UnreadCount)
+}
+
+func TestExpiredMessagesHiddenAndDeleted(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	kept := createTestMessage(t, repo, sender, recipient)
+
+	expired := domain.NewMessage(
+		base64.URLEncoding.EncodeToString(sender.PublicKey),
+		base64.URLEncoding.EncodeToString(recipient.PublicKey),
+		[]byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil,
+	)
+	expired.Timestamp = time.Now().Add(-time.Hour)
+	expired.ExpireAfter(time.Minute)
+	require.NoError(t, repo.Create(ctx, expired))
+
+	// Expired messages are hidden before they are collected
+	_, err := repo.GetByID(ctx, expired.MessageID)
+	assert.Error(t, err)
+	messages, err := repo.GetByRecipient(ctx, base64.URLEncoding.EncodeToString(recipient.PublicKey), 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, kept.MessageID, messages[0].MessageID)
+
+	count, err := repo.DeleteExpired(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, count, int64(1))
+
+	_, err = repo.GetByID(ctx, kept.MessageID)
+	assert.NoError(t, err)
+}
+
+func TestSoftDeletedMessagesHiddenAndPurged(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	kept := createTestMessage(t, repo, sender, recipient)
+	deleted := createTestMessage(t, repo, sender, recipient)
+
+	// Soft-deleted messages are hidden and can't be deleted or updated again
+	require.NoError(t, repo.SoftDeleteByID(ctx, deleted.MessageID))
+	_, err := repo.GetByID(ctx, deleted.MessageID)
+	assert.Error(t, err)
+	assert.Error(t, repo.SoftDeleteByID(ctx, deleted.MessageID))
+	_, err = repo.UpdateStatus(ctx, deleted.MessageID, domain.MessageStatusRead)
+	assert.Error(t, err)
+
+	messages, err := repo.GetByRecipient(ctx, recipientPubKey, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, kept.MessageID, messages[0].MessageID)
+
+	// Purging only removes messages deleted before the cutoff
+	count, err := repo.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	count, err = repo.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, count, int64(1))
+
+	// Deleting a user's messages softly leaves them for the purge
+	count, err = repo.SoftDeleteUserMessages(ctx, recipientPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	_, err = repo.GetByID(ctx, kept.MessageID)
+	assert.Error(t, err)
+}
+
+func TestSearch(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	other := createTestUser(t, db)
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), senderPubKey)
+	})
+
+	toRecipient := createTestMessage(t, repo, sender, recipient)
+	toOther := createTestMessage(t, repo, sender, other)
+	_, err := repo.UpdateStatus(ctx, toOther.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+
+	messages, err := repo.Search(ctx, senderPubKey, SearchOptions{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, messages, 2)
+
+	// Filters combine
+	messages, err = repo.Search(ctx, senderPubKey, SearchOptions{Peer: base64.URLEncoding.EncodeToString(recipient.PublicKey), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, toRecipient.MessageID, messages[0].MessageID)
+
+	messages, err = repo.Search(ctx, senderPubKey, SearchOptions{Status: domain.MessageStatusRead, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, toOther.MessageID, messages[0].MessageID)
+
+	messages, err = repo.Search(ctx, senderPubKey, SearchOptions{From: time.Now().Add(time.Minute), Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	messages, err = repo.Search(ctx, senderPubKey, SearchOptions{To: time.Now().Add(time.Minute), Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, messages, 2)
+}
+
+func TestUpdateStatusBatch(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	first := createTestMessage(t, repo, sender, recipient)
+	second := createTestMessage(t, repo, sender, recipient)
+	untouched := createTestMessage(t, repo, sender, recipient)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+
+	// Only the recipient can update, and unknown IDs are skipped
+	receipts, err := repo.UpdateStatusBatch(ctx, senderPubKey, []uuid.UUID{untouched.MessageID}, domain.MessageStatusRead)
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+
+	receipts, err = repo.UpdateStatusBatch(ctx, recipientPubKey, []uuid.UUID{first.MessageID, second.MessageID, uuid.New()}, domain.MessageStatusRead)
+	require.NoError(t, err)
+	require.Len(t, receipts, 2)
+	for _, receipt := range receipts {
+		assert.Equal(t, senderPubKey, receipt.SenderPubKey)
+		assert.Equal(t, domain.MessageStatusRead, receipt.Status)
+	}
+
+	for _, id := range []uuid.UUID{first.MessageID, second.MessageID} {
+		stored, err := repo.GetByID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, domain.MessageStatusRead, stored.Status)
+		assert.NotNil(t, stored.DeliveredAt)
+		assert.NotNil(t, stored.ReadAt)
+	}
+
+	stored, err := repo.GetByID(ctx, untouched.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusSent, stored.Status)
+}
+
+func TestMarkDeliveredForRecipient(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	fresh := createTestMessage(t, repo, sender, recipient)
+	alreadyRead := createTestMessage(t, repo, sender, recipient)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	_, err := repo.UpdateStatus(ctx, alreadyRead.MessageID, domain.MessageStatusRead)
+	require.NoError(t, err)
+
+	// Only the recipient's fetches deliver messages
+	receipts, err := repo.MarkDeliveredForRecipient(ctx, senderPubKey, []uuid.UUID{fresh.MessageID})
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+
+	// Read messages don't go back to delivered
+	receipts, err = repo.MarkDeliveredForRecipient(ctx, recipientPubKey, []uuid.UUID{fresh.MessageID, alreadyRead.MessageID})
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	assert.Equal(t, fresh.MessageID, receipts[0].MessageID)
+	assert.Equal(t, senderPubKey, receipts[0].SenderPubKey)
+	assert.Equal(t, domain.MessageStatusDelivered, receipts[0].Status)
+
+	stored, err := repo.GetByID(ctx, fresh.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusDelivered, stored.Status)
+	assert.NotNil(t, stored.DeliveredAt)
+
+	stored, err = repo.GetByID(ctx, alreadyRead.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusRead, stored.Status)
+
+	// A second fetch has nothing left to deliver
+	receipts, err = repo.MarkDeliveredForRecipient(ctx, recipientPubKey, []uuid.UUID{fresh.MessageID})
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+}
+
+func TestCountMessages(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	alice := createTestUser(t, db)
+	bob := createTestUser(t, db)
+	carol := createTestUser(t, db)
+	alicePubKey := base64.URLEncoding.EncodeToString(alice.PublicKey)
+	bobPubKey := base64.URLEncoding.EncodeToString(bob.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), alicePubKey)
+		_, _ = repo.DeleteUserMessages(context.Background(), bobPubKey)
+	})
+
+	createTestMessage(t, repo, alice, bob)
+	createTestMessage(t, repo, bob, alice)
+	createTestMessage(t, repo, alice, carol)
+	createTestMessage(t, repo, alice, alice)
+
+	total, err := repo.CountForUser(ctx, alicePubKey)
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+
+	total, err = repo.CountConversation(ctx, alicePubKey, bobPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+}
+
+func TestCreateBatchSkipsExisting(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	existing := createTestMessage(t, repo, sender, recipient)
+	fresh := domain.NewMessage(existing.SenderPubKey, existing.RecipientPubKey,
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+
+	created, err := repo.CreateBatch(ctx, []*domain.Message{existing, fresh})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), created)
+
+	stored, err := repo.GetByID(ctx, fresh.MessageID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, stored.ContentHash)
+
+	// A message repeated within one batch is stored once
+	repeated := domain.NewMessage(existing.SenderPubKey, existing.RecipientPubKey,
+		[]byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	created, err = repo.CreateBatch(ctx, []*domain.Message{repeated, repeated})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), created)
+
+	created, err = repo.CreateBatch(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), created)
+}
+
+func TestGetUserMessagesAfterPages(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	user := createTestUser(t, db)
+	peer := createTestUser(t, db)
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), userPubKey)
+	})
+
+	// Sent, received and self messages are each returned once
+	sent := createTestMessage(t, repo, user, peer)
+	received := createTestMessage(t, repo, peer, user)
+	self := createTestMessage(t, repo, user, user)
+
+	var seen []uuid.UUID
+	var afterTimestamp time.Time
+	afterID := uuid.Nil
+	for {
+		page, err := repo.GetUserMessagesAfter(ctx, userPubKey, afterTimestamp, afterID, 2)
+		require.NoError(t, err)
+		for _, msg := range page {
+			seen = append(seen, msg.MessageID)
+		}
+		if len(page) < 2 {
+			break
+		}
+		last := page[len(page)-1]
+		afterTimestamp, afterID = last.Timestamp, last.MessageID
+	}
+
+	assert.ElementsMatch(t, []uuid.UUID{sent.MessageID, received.MessageID, self.MessageID}, seen)
+}
+
+func TestForEachUserMessageVisitsEveryPage(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	user := createTestUser(t, db)
+	peer := createTestUser(t, db)
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), userPubKey)
+	})
+
+	var created []uuid.UUID
+	for i := 0; i < 5; i++ {
+		created = append(created, createTestMessage(t, repo, user, peer).MessageID)
+	}
+
+	// A page size that doesn't divide the total still reaches the last message
+	var seen []uuid.UUID
+	require.NoError(t, repo.ForEachUserMessage(ctx, userPubKey, 2, func(msg *domain.Message) error {
+		seen = append(seen, msg.MessageID)
+		return nil
+	}))
+	assert.ElementsMatch(t, created, seen)
+
+	// Errors from the callback stop the iteration
+	stop := assert.AnError
+	visited := 0
+	err := repo.ForEachUserMessage(ctx, userPubKey, 2, func(*domain.Message) error {
+		visited++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, visited)
+}
+
+func TestCreateDuplicateMessageID(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewMessageRepository(db)
+
+	sender := createTestUser(t, db)
+	recipient := createTestUser(t, db)
+	other := createTestUser(t, db)
+	t.Cleanup(func() {
+		_, _ = repo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+	})
+
+	original := createTestMessage(t, repo, sender, recipient)
+
+	// A retry with the same ID yields the stored message instead of an error
+	retry := domain.NewMessage(original.SenderPubKey, original.RecipientPubKey,
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+	retry.MessageID = original.MessageID
+	require.NoError(t, repo.Create(ctx, retry))
+	assert.True(t, original.Timestamp.Equal(retry.Timestamp))
+
+	// The same ID in another conversation is a conflict
+	clash := domain.NewMessage(base64.URLEncoding.EncodeToString(other.PublicKey), original.RecipientPubKey,
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+	clash.MessageID = original.MessageID
+	appErr, ok := errors.IsAppError(repo.Create(ctx, clash))
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeConflict, appErr.Code)
+}
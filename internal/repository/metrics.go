@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/pzkpfw44/wave-server/pkg/metrics"
+)
+
+// instrumentedQuerier records the count and duration of each statement run by a repository
+// Statements are labelled with the repository's table and their kind, so cardinality stays bounded
+type instrumentedQuerier struct {
+	Querier
+	table string
+}
+
+// instrument wraps q so its statements are recorded against table
+func instrument(q Querier, table string) Querier {
+	return &instrumentedQuerier{Querier: q, table: table}
+}
+
+// Exec runs a statement and records it
+func (q *instrumentedQuerier) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	start := time.Now()
+	defer q.record(sql, start)
+	return q.Querier.Exec(ctx, sql, arguments...)
+}
+
+// Query runs a query and records the time until its rows are available
+func (q *instrumentedQuerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	start := time.Now()
+	defer q.record(sql, start)
+	return q.Querier.Query(ctx, sql, args...)
+}
+
+// QueryRow runs a query for a single row; it is recorded when the row is scanned
+func (q *instrumentedQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &instrumentedRow{querier: q, row: q.Querier.QueryRow(ctx, sql, args...), sql: sql, start: time.Now()}
+}
+
+// record records a statement that started at start
+func (q *instrumentedQuerier) record(sql string, start time.Time) {
+	metrics.RecordDatabaseMetrics(operation(sql), q.table, time.Since(start).Seconds())
+}
+
+// instrumentedRow records its query once it is scanned
+type instrumentedRow struct {
+	querier *instrumentedQuerier
+	row     pgx.Row
+	sql     string
+	start   time.Time
+}
+
+// Scan scans the row into dest and records the query
+func (r *instrumentedRow) Scan(dest ...any) error {
+	defer r.querier.record(r.sql, r.start)
+	return r.row.Scan(dest...)
+}
+
+// operation returns the kind of statement sql is: select, insert, update, delete, or other
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "other"
+	}
+
+	switch op := strings.ToLower(fields[0]); op {
+	case "select", "insert", "update", "delete":
+		return op
+	}
+	return "other"
+}
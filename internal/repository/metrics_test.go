@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperation(t *testing.T) {
+	assert.Equal(t, "select", operation("\n\tSELECT "+messageColumns+" FROM messages"))
+	assert.Equal(t, "insert", operation("insert into blocks values ($1)"))
+	assert.Equal(t, "update", operation("UPDATE users SET last_active = NOW()"))
+	assert.Equal(t, "delete", operation("DELETE FROM tokens"))
+	assert.Equal(t, "other", operation("CREATE TEMP TABLE messages_import (LIKE messages)"))
+	assert.Equal(t, "other", operation(""))
+}
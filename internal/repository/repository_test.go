@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+// newTestDatabase connects to the test database and runs migrations
+// Repository tests are skipped when testutil.DatabaseEnv is not set
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db := &Database{
+		Pool:   testutil.NewPool(t),
+		Logger: zaptest.NewLogger(t),
+		Config: &config.Config{},
+	}
+	require.NoError(t, db.RunMigrations(context.Background()))
+
+	return db
+}
+
+// createTestUser inserts a user with a unique ID and public key and removes it when the test ends
+func createTestUser(t *testing.T, db *Database) *domain.User {
+	t.Helper()
+
+	user := testutil.NewUser()
+	repo := NewUserRepository(db)
+	require.NoError(t, repo.Create(context.Background(), user))
+	t.Cleanup(func() {
+		_, _ = db.Pool.Exec(context.Background(), "DELETE FROM contacts WHERE user_id = $1", user.UserID)
+		_ = repo.Delete(context.Background(), user.UserID)
+	})
+
+	return user
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	retryBaseDelay = 50 * time.Millisecond // Delay before the first retry; doubled for each one after
+	retryMaxDelay  = 2 * time.Second       // Upper bound on the delay between retries
+)
+
+// isTransient reports whether err is a database error that is likely to go away on its own,
+// such as a dropped connection or a serialization conflict while a distributed cluster rebalances
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return true
+		case len(pgErr.Code) == 5 && pgErr.Code[:2] == "08": // connection_exception class
+			return true
+		case pgErr.Code == "57P01", pgErr.Code == "57P03": // admin_shutdown, cannot_connect_now
+			return true
+		}
+	}
+
+	return false
+}
+
+// retry calls fn until it succeeds, returns an error that isn't transient, or has been retried maxRetries times
+// The delay between attempts grows exponentially, and retrying stops early when ctx is done
+func retry(ctx context.Context, maxRetries int, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxRetries || !isTransient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// retryingPool retries statements on a Pool that fail with transient errors
+// Reads are retried on any transient error; writes only when the statement never reached the server
+type retryingPool struct {
+	Pool
+	maxRetries int
+}
+
+// newRetryingPool wraps pool so its statements are retried up to maxRetries times
+func newRetryingPool(pool Pool, maxRetries int) Pool {
+	if maxRetries <= 0 {
+		return pool
+	}
+	return &retryingPool{Pool: pool, maxRetries: maxRetries}
+}
+
+// Exec runs a statement, retrying only if it was not sent, since it may not be idempotent
+func (p *retryingPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	var tag pgconn.CommandTag
+	err := retry(ctx, p.maxRetries, func() error {
+		var err error
+		tag, err = p.Pool.Exec(ctx, sql, arguments...)
+		if err != nil && !pgconn.SafeToRetry(err) {
+			return permanent{err}
+		}
+		return err
+	})
+	return tag, unwrapPermanent(err)
+}
+
+// Query runs a query, retrying if it fails before returning rows
+func (p *retryingPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	var rows pgx.Rows
+	err := retry(ctx, p.maxRetries, func() error {
+		var err error
+		rows, err = p.Pool.Query(ctx, sql, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryRow runs a query for a single row; it is retried when the row is scanned
+func (p *retryingPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &retryingRow{pool: p, ctx: ctx, sql: sql, args: args}
+}
+
+// Begin starts a transaction, retrying if the connection fails
+// Statements inside the transaction are not retried, since a failure aborts the transaction
+func (p *retryingPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	var tx pgx.Tx
+	err := retry(ctx, p.maxRetries, func() error {
+		var err error
+		tx, err = p.Pool.Begin(ctx)
+		return err
+	})
+	return tx, err
+}
+
+// retryingRow runs its query when scanned, so transient failures can be retried
+type retryingRow struct {
+	pool *retryingPool
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+// Scan runs the query and scans the row into dest
+// QueryRow statements that write, such as INSERT ... RETURNING, are retried only if they were not sent
+func (r *retryingRow) Scan(dest ...any) error {
+	err := retry(r.ctx, r.pool.maxRetries, func() error {
+		err := r.pool.Pool.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+		if err != nil && !isReadOnly(r.sql) && !pgconn.SafeToRetry(err) {
+			return permanent{err}
+		}
+		return err
+	})
+	return unwrapPermanent(err)
+}
+
+// isReadOnly reports whether sql is a plain SELECT
+func isReadOnly(sql string) bool {
+	for i := 0; i < len(sql); i++ {
+		switch sql[i] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return len(sql)-i >= 6 && (sql[i:i+6] == "SELECT" || sql[i:i+6] == "select")
+	}
+	return false
+}
+
+// permanent marks an error that must not be retried even though it looks transient
+// It deliberately doesn't unwrap, so isTransient can't see the error inside
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string { return p.err.Error() }
+
+// unwrapPermanent returns the error inside a permanent, or err itself
+func unwrapPermanent(err error) error {
+	var p permanent
+	if errors.As(err, &p) {
+		return p.err
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serializationFailure is the error YugabyteDB returns for conflicting transactions
+var serializationFailure = &pgconn.PgError{Code: "40001"}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(serializationFailure))
+	assert.True(t, isTransient(fmt.Errorf("query failed: %w", &pgconn.PgError{Code: "08006"})))
+	assert.True(t, isTransient(&pgconn.PgError{Code: "57P01"}))
+
+	assert.False(t, isTransient(&pgconn.PgError{Code: uniqueViolationCode}))
+	assert.False(t, isTransient(pgx.ErrNoRows))
+	assert.False(t, isTransient(context.Canceled))
+	assert.False(t, isTransient(nil))
+}
+
+func TestRetryStopsOnSuccessOrPermanentError(t *testing.T) {
+	ctx := context.Background()
+
+	calls := 0
+	err := retry(ctx, 3, func() error {
+		calls++
+		if calls < 3 {
+			return serializationFailure
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = retry(ctx, 3, func() error {
+		calls++
+		return serializationFailure
+	})
+	assert.ErrorIs(t, err, serializationFailure)
+	assert.Equal(t, 4, calls, "the first attempt plus three retries")
+
+	calls = 0
+	err = retry(ctx, 3, func() error {
+		calls++
+		return pgx.ErrNoRows
+	})
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := retry(ctx, 3, func() error {
+		calls++
+		return serializationFailure
+	})
+	assert.ErrorIs(t, err, serializationFailure)
+	assert.Equal(t, 1, calls)
+}
+
+// failingPool fails every statement with err
+type failingPool struct {
+	Pool
+	err   error
+	calls int
+}
+
+func (p *failingPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	p.calls++
+	return pgconn.CommandTag{}, p.err
+}
+
+func (p *failingPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	p.calls++
+	return errRow{p.err}
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(dest ...any) error { return r.err }
+
+func TestRetryingPoolRetriesOnlyReads(t *testing.T) {
+	ctx := context.Background()
+
+	// A write that reached the server may have been applied, so it is not repeated
+	fake := &failingPool{err: serializationFailure}
+	pool := newRetryingPool(fake, 2)
+
+	_, err := pool.Exec(ctx, "UPDATE users SET last_active = NOW()")
+	assert.ErrorIs(t, err, serializationFailure)
+	assert.Equal(t, 1, fake.calls)
+
+	fake.calls = 0
+	err = pool.QueryRow(ctx, "INSERT INTO blocks VALUES ($1) RETURNING created_at").Scan()
+	assert.ErrorIs(t, err, serializationFailure)
+	assert.Equal(t, 1, fake.calls)
+
+	// Reads are retried
+	fake.calls = 0
+	err = pool.QueryRow(ctx, "\n\tSELECT username FROM users").Scan()
+	assert.ErrorIs(t, err, serializationFailure)
+	assert.Equal(t, 3, fake.calls)
+
+	// Without retries the pool is used as it is
+	assert.Same(t, fake, newRetryingPool(fake, 0))
+}
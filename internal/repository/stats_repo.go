@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+// StatsRepository counts what the server holds across all users
+type StatsRepository struct {
+	db     *Database
+	q      Querier
+	logger *zap.Logger
+}
+
+// NewStatsRepository creates a new StatsRepository
+func NewStatsRepository(db *Database) *StatsRepository {
+	return &StatsRepository{
+		db:     db,
+		q:      instrument(db.Pool, "stats"),
+		logger: db.Logger.With(zap.String("repository", "stats")),
+	}
+}
+
+// GetStats counts users, visible messages, contacts and active sessions in a single round trip
+// Every count scans its table, so callers should cache the result rather than call this per request
+func (r *StatsRepository) GetStats(ctx context.Context) (*domain.ServerStats, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM messages WHERE ` + messageVisible + `),
+		(SELECT COUNT(*) FROM contacts),
+		(SELECT COUNT(*) FROM tokens WHERE ` + sessionToken + ` AND expires_at > NOW())
+	`
+
+	stats := &domain.ServerStats{CollectedAt: time.Now()}
+	err := r.q.QueryRow(ctx, query).Scan(&stats.Users, &stats.Messages, &stats.Contacts, &stats.ActiveSessions)
+	if err != nil {
+		r.logger.Error("Failed to get server stats", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to get server stats", err)
+	}
+
+	return stats, nil
+}
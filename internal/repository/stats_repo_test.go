@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStatsCountsNewRows(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewStatsRepository(db)
+
+	before, err := repo.GetStats(ctx)
+	require.NoError(t, err)
+
+	// Other tests may be writing to the same database, so only check that the new rows are counted
+	user := createTestUser(t, db)
+	createTestSession(t, NewTokenRepository(db), user.UserID)
+
+	after, err := repo.GetStats(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, after.Users, before.Users+1)
+	assert.GreaterOrEqual(t, after.ActiveSessions, before.ActiveSessions+1)
+	assert.False(t, after.CollectedAt.Before(before.CollectedAt))
+}
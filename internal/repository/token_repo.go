@@ -7,7 +7,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
@@ -18,6 +17,7 @@ import (
 // TokenRepository handles token data storage operations
 type TokenRepository struct {
 	db     *Database
+	q      Querier
 	logger *zap.Logger
 }
 
@@ -25,24 +25,81 @@ type TokenRepository struct {
 func NewTokenRepository(db *Database) *TokenRepository {
 	return &TokenRepository{
 		db:     db,
+		q:      instrument(db.Pool, "tokens"),
 		logger: db.Logger.With(zap.String("repository", "token")),
 	}
 }
 
+// NewTokenRepositoryTx creates a TokenRepository whose statements run in tx
+func NewTokenRepositoryTx(db *Database, tx pgx.Tx) *TokenRepository {
+	r := NewTokenRepository(db)
+	r.q = instrument(tx, "tokens")
+	return r
+}
+
+// tokenColumns is the column list selected for tokens, in the order scanToken reads them
+const tokenColumns = `token_id, user_id, token_hash, created_at, expires_at, last_used, COALESCE(device_name, ''),
+	token_type, refresh_token_id, family_id, used`
+
+// sessionToken matches the tokens that stand for a session: unused refresh tokens, and access tokens not issued from one
+const sessionToken = `(refresh_token_id IS NULL AND NOT used)`
+
+// scanToken reads a token selected with tokenColumns
+func scanToken(row pgx.Row) (*domain.Token, error) {
+	token := &domain.Token{}
+	err := row.Scan(
+		&token.TokenID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.LastUsed,
+		&token.DeviceName,
+		&token.TokenType,
+		&token.RefreshTokenID,
+		&token.FamilyID,
+		&token.Used,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// expiryGrace returns the configured clock skew tolerance for token expiry
+func (r *TokenRepository) expiryGrace() time.Duration {
+	if r.db.Config == nil {
+		return 0
+	}
+	return r.db.Config.Auth.ExpiryGrace
+}
+
+// idleTimeout returns how long a session may go unused before it ends, or 0 if it never does
+func (r *TokenRepository) idleTimeout() time.Duration {
+	if r.db.Config == nil {
+		return 0
+	}
+	return r.db.Config.Auth.IdleTimeout
+}
+
 // Create creates a new token
 func (r *TokenRepository) Create(ctx context.Context, token *domain.Token) error {
 	query := `
-	INSERT INTO tokens (token_id, user_id, token_hash, created_at, expires_at, last_used)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	INSERT INTO tokens (token_id, user_id, token_hash, created_at, expires_at, last_used, device_name, token_type, refresh_token_id, family_id)
+	VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10)
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query,
+	_, err := r.q.Exec(ctx, query,
 		token.TokenID,
 		token.UserID,
 		token.TokenHash,
 		token.CreatedAt,
 		token.ExpiresAt,
 		token.LastUsed,
+		token.DeviceName,
+		token.TokenType,
+		token.RefreshTokenID,
+		token.FamilyID,
 	)
 
 	if err != nil {
@@ -52,7 +109,7 @@ func (r *TokenRepository) Create(ctx context.Context, token *domain.Token) error
 			zap.String("token_id", token.TokenID.String()))
 
 		// Check for unique constraint violation
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		if isUniqueViolation(err) {
 			return errors.NewConflictError("Token hash already exists")
 		}
 
@@ -65,23 +122,12 @@ func (r *TokenRepository) Create(ctx context.Context, token *domain.Token) error
 // GetByTokenHash gets a token by its hash
 func (r *TokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*domain.Token, error) {
 	query := `
-	SELECT token_id, user_id, token_hash, created_at, expires_at, last_used
+	SELECT ` + tokenColumns + `
 	FROM tokens
 	WHERE token_hash = $1
 	`
 
-	row := r.db.Pool.QueryRow(ctx, query, tokenHash)
-
-	token := &domain.Token{}
-	err := row.Scan(
-		&token.TokenID,
-		&token.UserID,
-		&token.TokenHash,
-		&token.CreatedAt,
-		&token.ExpiresAt,
-		&token.LastUsed,
-	)
-
+	token, err := scanToken(r.q.QueryRow(ctx, query, tokenHash))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, errors.NewNotFoundError("Token")
@@ -93,16 +139,18 @@ func (r *TokenRepository) GetByTokenHash(ctx context.Context, tokenHash string)
 	return token, nil
 }
 
-// GetByUserID gets all tokens for a user
-func (r *TokenRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Token, error) {
+// GetByUserID gets a page of a user's tokens, newest first
+// A limit of 0 returns all of them
+func (r *TokenRepository) GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*domain.Token, error) {
 	query := `
-	SELECT token_id, user_id, token_hash, created_at, expires_at, last_used
+	SELECT ` + tokenColumns + `
 	FROM tokens
 	WHERE user_id = $1
 	ORDER BY created_at DESC
+	LIMIT NULLIF($2, 0) OFFSET $3
 	`
 
-	rows, err := r.db.Pool.Query(ctx, query, userID)
+	rows, err := r.q.Query(ctx, query, userID, limit, offset)
 	if err != nil {
 		r.logger.Error("Failed to get tokens by user ID", zap.Error(err), zap.String("user_id", userID))
 		return nil, errors.NewInternalError("Failed to get tokens", err)
@@ -111,15 +159,44 @@ func (r *TokenRepository) GetByUserID(ctx context.Context, userID string) ([]*do
 
 	var tokens []*domain.Token
 	for rows.Next() {
-		token := &domain.Token{}
-		err := rows.Scan(
-			&token.TokenID,
-			&token.UserID,
-			&token.TokenHash,
-			&token.CreatedAt,
-			&token.ExpiresAt,
-			&token.LastUsed,
-		)
+		token, err := scanToken(rows)
+		if err != nil {
+			r.logger.Error("Failed to scan token row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read token data", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating token rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read token data", err)
+	}
+
+	return tokens, nil
+}
+
+// GetSessionsByUserID gets a page of a user's sessions, most recently used first
+// A session is an unused refresh token, or an access token that wasn't issued from one; access tokens issued by refreshing are part of their refresh token's session
+// When activeOnly is set, sessions past their expiry grace period are excluded
+func (r *TokenRepository) GetSessionsByUserID(ctx context.Context, userID string, limit, offset int, activeOnly bool) ([]*domain.Token, error) {
+	query := `
+	SELECT ` + tokenColumns + `
+	FROM tokens
+	WHERE user_id = $1 AND ` + sessionToken + ` AND (NOT $2 OR expires_at > $3)
+	ORDER BY last_used DESC
+	LIMIT $4 OFFSET $5
+	`
+
+	rows, err := r.q.Query(ctx, query, userID, activeOnly, time.Now().Add(-r.expiryGrace()), limit, offset)
+	if err != nil {
+		r.logger.Error("Failed to get sessions by user ID", zap.Error(err), zap.String("user_id", userID))
+		return nil, errors.NewInternalError("Failed to get sessions", err)
+	}
+	defer rows.Close()
+
+	var tokens []*domain.Token
+	for rows.Next() {
+		token, err := scanToken(rows)
 		if err != nil {
 			r.logger.Error("Failed to scan token row", zap.Error(err))
 			return nil, errors.NewInternalError("Failed to read token data", err)
@@ -135,15 +212,32 @@ func (r *TokenRepository) GetByUserID(ctx context.Context, userID string) ([]*do
 	return tokens, nil
 }
 
-// UpdateLastUsed updates a token's last_used timestamp
+// CountActive counts a user's sessions that have not expired past the grace period
+func (r *TokenRepository) CountActive(ctx context.Context, userID string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM tokens
+	WHERE user_id = $1 AND ` + sessionToken + ` AND expires_at > $2
+	`
+
+	var count int
+	if err := r.q.QueryRow(ctx, query, userID, time.Now().Add(-r.expiryGrace())).Scan(&count); err != nil {
+		r.logger.Error("Failed to count active sessions", zap.Error(err), zap.String("user_id", userID))
+		return 0, errors.NewInternalError("Failed to count sessions", err)
+	}
+
+	return count, nil
+}
+
+// UpdateLastUsed updates the last_used timestamp of a token and of the refresh token it was issued from
 func (r *TokenRepository) UpdateLastUsed(ctx context.Context, tokenID uuid.UUID) error {
 	query := `
 	UPDATE tokens
 	SET last_used = $1
-	WHERE token_id = $2
+	WHERE token_id = $2 OR token_id = (SELECT refresh_token_id FROM tokens WHERE token_id = $2)
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query, time.Now(), tokenID)
+	_, err := r.q.Exec(ctx, query, time.Now(), tokenID)
 	if err != nil {
 		r.logger.Error("Failed to update token's last_used timestamp",
 			zap.Error(err),
@@ -154,6 +248,46 @@ func (r *TokenRepository) UpdateLastUsed(ctx context.Context, tokenID uuid.UUID)
 	return nil
 }
 
+// Rotate marks the refresh token used and stores next, the refresh token replacing it, in one transaction
+// Used tokens are kept until they expire so they can be recognized if presented again
+// It fails as unauthenticated if used was already rotated, so each refresh token is rotated only once even when requests race
+func (r *TokenRepository) Rotate(ctx context.Context, used, next *domain.Token) error {
+	query := `
+	UPDATE tokens
+	SET used = TRUE, last_used = $1
+	WHERE token_id = $2 AND token_type = $3 AND NOT used
+	`
+
+	tx, err := r.q.Begin(ctx)
+	if err != nil {
+		r.logger.Error("Failed to begin token rotation", zap.Error(err))
+		return errors.NewInternalError("Failed to refresh token", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	result, err := tx.Exec(ctx, query, time.Now(), used.TokenID, domain.TokenTypeRefresh)
+	if err != nil {
+		r.logger.Error("Failed to rotate token",
+			zap.Error(err),
+			zap.String("token_id", used.TokenID.String()))
+		return errors.NewInternalError("Failed to refresh token", err)
+	}
+	if result.RowsAffected() == 0 {
+		return errors.NewUnauthenticatedError("Invalid or expired refresh token")
+	}
+
+	if err := NewTokenRepositoryTx(r.db, tx).Create(ctx, next); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		r.logger.Error("Failed to commit token rotation", zap.Error(err))
+		return errors.NewInternalError("Failed to refresh token", err)
+	}
+
+	return nil
+}
+
 // Delete deletes a token
 func (r *TokenRepository) Delete(ctx context.Context, tokenHash string) error {
 	query := `
@@ -161,7 +295,7 @@ func (r *TokenRepository) Delete(ctx context.Context, tokenHash string) error {
 	WHERE token_hash = $1
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, tokenHash)
+	result, err := r.q.Exec(ctx, query, tokenHash)
 	if err != nil {
 		r.logger.Error("Failed to delete token", zap.Error(err))
 		return errors.NewInternalError("Failed to delete token", err)
@@ -174,6 +308,51 @@ func (r *TokenRepository) Delete(ctx context.Context, tokenHash string) error {
 	return nil
 }
 
+// DeleteSession deletes the session a token belongs to: every refresh and access token in its family
+func (r *TokenRepository) DeleteSession(ctx context.Context, tokenHash string) error {
+	query := `
+	DELETE FROM tokens
+	WHERE token_hash = $1 OR family_id = (SELECT family_id FROM tokens WHERE token_hash = $1)
+	`
+
+	result, err := r.q.Exec(ctx, query, tokenHash)
+	if err != nil {
+		r.logger.Error("Failed to delete session", zap.Error(err))
+		return errors.NewInternalError("Failed to delete token", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError("Token")
+	}
+
+	return nil
+}
+
+// DeleteByID deletes one of a user's tokens by its ID, along with the rest of its family
+// Tokens belonging to other users are reported as not found
+func (r *TokenRepository) DeleteByID(ctx context.Context, userID string, tokenID uuid.UUID) error {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $2
+	  AND (token_id = $1 OR family_id = (SELECT family_id FROM tokens WHERE token_id = $1 AND user_id = $2))
+	`
+
+	result, err := r.q.Exec(ctx, query, tokenID, userID)
+	if err != nil {
+		r.logger.Error("Failed to delete token by ID",
+			zap.Error(err),
+			zap.String("user_id", userID),
+			zap.String("token_id", tokenID.String()))
+		return errors.NewInternalError("Failed to delete token", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError(fmt.Sprintf("Session with ID '%s'", tokenID))
+	}
+
+	return nil
+}
+
 // DeleteUserTokens deletes all tokens for a user
 func (r *TokenRepository) DeleteUserTokens(ctx context.Context, userID string) (int64, error) {
 	query := `
@@ -181,7 +360,7 @@ func (r *TokenRepository) DeleteUserTokens(ctx context.Context, userID string) (
 	WHERE user_id = $1
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, userID)
+	result, err := r.q.Exec(ctx, query, userID)
 	if err != nil {
 		r.logger.Error("Failed to delete user tokens", zap.Error(err), zap.String("user_id", userID))
 		return 0, errors.NewInternalError("Failed to delete tokens", err)
@@ -190,14 +369,18 @@ func (r *TokenRepository) DeleteUserTokens(ctx context.Context, userID string) (
 	return result.RowsAffected(), nil
 }
 
-// CleanupExpired deletes all expired tokens
+// CleanupExpired deletes all expired tokens, and unused ones that have been idle past the idle timeout
+// Used refresh tokens are kept until they expire, so reusing one is still caught
 func (r *TokenRepository) CleanupExpired(ctx context.Context) (int64, error) {
 	query := `
 	DELETE FROM tokens
-	WHERE expires_at < $1
+	WHERE expires_at < $1 OR ($2 AND NOT used AND last_used < $3)
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, time.Now())
+	// Keep tokens that are still within the grace period
+	now := time.Now()
+	idleTimeout := r.idleTimeout()
+	result, err := r.q.Exec(ctx, query, now.Add(-r.expiryGrace()), idleTimeout > 0, now.Add(-idleTimeout))
 	if err != nil {
 		r.logger.Error("Failed to cleanup expired tokens", zap.Error(err))
 		return 0, errors.NewInternalError("Failed to cleanup tokens", err)
@@ -211,48 +394,36 @@ func (r *TokenRepository) CleanupExpired(ctx context.Context) (int64, error) {
 	return count, nil
 }
 
-// CreateForUser generates a new token for a user
-func (r *TokenRepository) CreateForUser(ctx context.Context, userID string, expiryDuration time.Duration) (string, error) {
-	// Generate a random token
-	tokenStr, err := security.GenerateRandomToken(32) // 32 bytes = 64 hex chars
-	if err != nil {
-		r.logger.Error("Failed to generate random token", zap.Error(err))
-		return "", errors.NewInternalError("Failed to create token", err)
-	}
-
-	// Hash the token for storage
-	tokenHash := security.HashToken(tokenStr)
-
-	// Create the token
-	token := domain.NewToken(userID, tokenHash, time.Now().Add(expiryDuration))
-
-	// Store the token
-	err = r.Create(ctx, token)
-	if err != nil {
-		return "", fmt.Errorf("failed to store token: %w", err)
-	}
-
-	// Return the unhashed token to the caller
-	return tokenStr, nil
-}
-
 // ValidateToken validates a token and returns the user ID
 func (r *TokenRepository) ValidateToken(ctx context.Context, tokenStr string) (string, error) {
 	// Hash the token for lookup
 	tokenHash := security.HashToken(tokenStr)
 
-	// Get the token
+	// Get the token; refresh tokens can't authenticate requests
 	token, err := r.GetByTokenHash(ctx, tokenHash)
-	if err != nil {
+	if err != nil || token.TokenType != domain.TokenTypeAccess {
 		return "", errors.NewUnauthenticatedError("Invalid or expired token")
 	}
 
-	// Check if the token is expired
-	if token.IsExpired() {
+	// Check if the token is expired, tolerating small clock skew
+	now := time.Now()
+	grace := r.expiryGrace()
+	if token.IsExpiredAt(now, grace) {
 		// Try to delete the expired token
 		_ = r.Delete(ctx, tokenHash)
 		return "", errors.NewUnauthenticatedError("Token expired")
 	}
+	if token.IsIdleAt(now, r.idleTimeout()) {
+		// An abandoned session ends as a whole, so its refresh token can't revive it
+		_ = r.DeleteSession(ctx, tokenHash)
+		return "", errors.NewUnauthenticatedError("Session expired due to inactivity")
+	}
+	if token.InGracePeriod(now, grace) {
+		r.logger.Info("Accepted token within expiry grace period",
+			zap.String("user_id", token.UserID),
+			zap.String("token_id", token.TokenID.String()),
+			zap.Duration("expired_for", now.Sub(token.ExpiresAt)))
+	}
 
 	// Update last used timestamp
 	_ = r.UpdateLastUsed(ctx, token.TokenID)
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+// createTestToken inserts a token for the user with the given expiry and last use
+func createTestToken(t *testing.T, repo *TokenRepository, userID string, expiresAt, lastUsed time.Time) *domain.Token {
+	t.Helper()
+
+	token := domain.NewToken(userID, uuid.NewString(), expiresAt)
+	token.LastUsed = lastUsed
+	require.NoError(t, repo.Create(context.Background(), token))
+
+	return token
+}
+
+func TestGetSessionsByUserID(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewTokenRepository(db)
+
+	user := createTestUser(t, db)
+	now := time.Now()
+
+	activeOld := createTestToken(t, repo, user.UserID, now.Add(time.Hour), now.Add(-3*time.Hour))
+	expired := createTestToken(t, repo, user.UserID, now.Add(-time.Hour), now.Add(-2*time.Hour))
+	activeNew := createTestToken(t, repo, user.UserID, now.Add(time.Hour), now.Add(-time.Minute))
+
+	t.Run("all sessions ordered by last used", func(t *testing.T) {
+		sessions, err := repo.GetSessionsByUserID(ctx, user.UserID, 10, 0, false)
+		require.NoError(t, err)
+		require.Len(t, sessions, 3)
+		assert.Equal(t, activeNew.TokenID, sessions[0].TokenID)
+		assert.Equal(t, expired.TokenID, sessions[1].TokenID)
+		assert.Equal(t, activeOld.TokenID, sessions[2].TokenID)
+	})
+
+	t.Run("active filter excludes expired sessions", func(t *testing.T) {
+		sessions, err := repo.GetSessionsByUserID(ctx, user.UserID, 10, 0, true)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, activeNew.TokenID, sessions[0].TokenID)
+		assert.Equal(t, activeOld.TokenID, sessions[1].TokenID)
+	})
+
+	t.Run("limit and offset page through sessions", func(t *testing.T) {
+		sessions, err := repo.GetSessionsByUserID(ctx, user.UserID, 1, 1, true)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, activeOld.TokenID, sessions[0].TokenID)
+
+		sessions, err = repo.GetSessionsByUserID(ctx, user.UserID, 1, 2, true)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("count excludes expired sessions", func(t *testing.T) {
+		count, err := repo.CountActive(ctx, user.UserID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("tokens page newest first, a limit of 0 returning all", func(t *testing.T) {
+		tokens, err := repo.GetByUserID(ctx, user.UserID, 0, 0)
+		require.NoError(t, err)
+		assert.Len(t, tokens, 3)
+
+		tokens, err = repo.GetByUserID(ctx, user.UserID, 1, 0)
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		assert.Equal(t, activeNew.TokenID, tokens[0].TokenID)
+	})
+}
+
+func TestDeleteByIDChecksOwnership(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewTokenRepository(db)
+
+	owner := createTestUser(t, db)
+	other := createTestUser(t, db)
+	now := time.Now()
+	token := createTestToken(t, repo, owner.UserID, now.Add(time.Hour), now)
+
+	// Another user's token is reported as not found and left in place
+	err := repo.DeleteByID(ctx, other.UserID, token.TokenID)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+	_, err = repo.GetByTokenHash(ctx, token.TokenHash)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.DeleteByID(ctx, owner.UserID, token.TokenID))
+	_, err = repo.GetByTokenHash(ctx, token.TokenHash)
+	assert.Error(t, err)
+
+	err = repo.DeleteByID(ctx, owner.UserID, token.TokenID)
+	appErr, ok = errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+}
+
+// createTestSession inserts a refresh token for the user and an access token issued from it
+func createTestSession(t *testing.T, repo *TokenRepository, userID string) (refresh, access *domain.Token) {
+	t.Helper()
+
+	now := time.Now()
+	refresh = domain.NewToken(userID, uuid.NewString(), now.Add(24*time.Hour))
+	refresh.TokenType = domain.TokenTypeRefresh
+	refresh.FamilyID = &refresh.TokenID
+	require.NoError(t, repo.Create(context.Background(), refresh))
+
+	access = domain.NewToken(userID, uuid.NewString(), now.Add(time.Hour))
+	access.RefreshTokenID = &refresh.TokenID
+	access.FamilyID = refresh.FamilyID
+	require.NoError(t, repo.Create(context.Background(), access))
+
+	return refresh, access
+}
+
+func TestSessionsGroupAccessTokensUnderRefreshToken(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewTokenRepository(db)
+
+	user := createTestUser(t, db)
+	refresh, access := createTestSession(t, repo, user.UserID)
+
+	// Only the refresh token is listed, and only access tokens authenticate
+	sessions, err := repo.GetSessionsByUserID(ctx, user.UserID, 10, 0, false)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, refresh.TokenID, sessions[0].TokenID)
+
+	refreshTokenStr := uuid.NewString()
+	standalone := domain.NewToken(user.UserID, security.HashToken(refreshTokenStr), time.Now().Add(time.Hour))
+	standalone.TokenType = domain.TokenTypeRefresh
+	require.NoError(t, repo.Create(ctx, standalone))
+	_, err = repo.ValidateToken(ctx, refreshTokenStr)
+	assert.Error(t, err)
+
+	// Logging out with the access token ends the whole session
+	require.NoError(t, repo.DeleteSession(ctx, access.TokenHash))
+	_, err = repo.GetByTokenHash(ctx, refresh.TokenHash)
+	assert.Error(t, err)
+	_, err = repo.GetByTokenHash(ctx, access.TokenHash)
+	assert.Error(t, err)
+}
+
+func TestRotateIsSingleUse(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewTokenRepository(db)
+
+	user := createTestUser(t, db)
+	refresh, _ := createTestSession(t, repo, user.UserID)
+
+	next := domain.NewToken(user.UserID, uuid.NewString(), time.Now().Add(24*time.Hour))
+	next.TokenType = domain.TokenTypeRefresh
+	next.FamilyID = refresh.FamilyID
+	require.NoError(t, repo.Rotate(ctx, refresh, next))
+
+	// The used token is kept so reuse can be detected, but it is no longer a session
+	used, err := repo.GetByTokenHash(ctx, refresh.TokenHash)
+	require.NoError(t, err)
+	assert.True(t, used.Used)
+
+	sessions, err := repo.GetSessionsByUserID(ctx, user.UserID, 10, 0, false)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, next.TokenID, sessions[0].TokenID)
+
+	// A used token can't be rotated again
+	another := domain.NewToken(user.UserID, uuid.NewString(), time.Now().Add(24*time.Hour))
+	another.TokenType = domain.TokenTypeRefresh
+	err = repo.Rotate(ctx, refresh, another)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeUnauthenticated, appErr.Code)
+	_, err = repo.GetByTokenHash(ctx, another.TokenHash)
+	assert.Error(t, err)
+
+	// Revoking the session removes the whole family
+	require.NoError(t, repo.DeleteByID(ctx, user.UserID, next.TokenID))
+	_, err = repo.GetByTokenHash(ctx, refresh.TokenHash)
+	assert.Error(t, err)
+}
+
+func TestValidateTokenEndsIdleSessions(t *testing.T) {
+	db := newTestDatabase(t)
+	db.Config.Auth.IdleTimeout = time.Hour
+	ctx := context.Background()
+	repo := NewTokenRepository(db)
+
+	user := createTestUser(t, db)
+	now := time.Now()
+
+	activeStr, idleStr := uuid.NewString(), uuid.NewString()
+	active := domain.NewToken(user.UserID, security.HashToken(activeStr), now.Add(24*time.Hour))
+	active.LastUsed = now.Add(-30 * time.Minute)
+	idle := domain.NewToken(user.UserID, security.HashToken(idleStr), now.Add(24*time.Hour))
+	idle.LastUsed = now.Add(-2 * time.Hour)
+	require.NoError(t, repo.Create(ctx, active))
+	require.NoError(t, repo.Create(ctx, idle))
+
+	userID, err := repo.ValidateToken(ctx, activeStr)
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, userID)
+
+	// An idle token is rejected and deleted, even though it hasn't expired
+	_, err = repo.ValidateToken(ctx, idleStr)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeUnauthenticated, appErr.Code)
+	_, err = repo.GetByTokenHash(ctx, idle.TokenHash)
+	assert.Error(t, err)
+}
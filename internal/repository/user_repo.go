@@ -2,31 +2,51 @@ package repository
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/cache"
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
 )
 
 // UserRepository handles user data storage operations
 type UserRepository struct {
-	db     *Database
-	logger *zap.Logger
+	db        *Database
+	q         Querier
+	tx        pgx.Tx // Set when statements run in a transaction
+	userCache cache.Cache
+	logger    *zap.Logger
 }
 
 // NewUserRepository creates a new UserRepository
 func NewUserRepository(db *Database) *UserRepository {
+	userCache := db.UserCache
+	if userCache == nil {
+		userCache = cache.Noop()
+	}
+
 	return &UserRepository{
-		db:     db,
-		logger: db.Logger.With(zap.String("repository", "user")),
+		db:        db,
+		q:         instrument(db.Pool, "users"),
+		userCache: userCache,
+		logger:    db.Logger.With(zap.String("repository", "user")),
 	}
 }
 
+// NewUserRepositoryTx creates a UserRepository whose statements run in tx
+func NewUserRepositoryTx(db *Database, tx pgx.Tx) *UserRepository {
+	r := NewUserRepository(db)
+	r.q = instrument(tx, "users")
+	r.tx = tx
+	return r
+}
+
 // Create creates a new user
 func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
 	query := `
@@ -34,7 +54,7 @@ func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
 	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query,
+	_, err := r.q.Exec(ctx, query,
 		user.UserID,
 		user.Username,
 		user.PublicKey,
@@ -47,28 +67,45 @@ func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
 	if err != nil {
 		r.logger.Error("Failed to create user", zap.Error(err), zap.String("username", user.Username))
 
-		// Check for unique constraint violation
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
-			if pgErr.ConstraintName == "users_username_key" {
-				return errors.NewConflictError(fmt.Sprintf("User with username '%s' already exists", user.Username))
+		// The user ID is derived from the username, so either of those unique constraints means the user exists
+		if isUniqueViolation(err) {
+			if isConstraintViolation(err, publicKeyUniqueIndex) {
+				return errors.NewConflictError("Public key is already registered")
 			}
+			return errors.NewConflictError("User already exists")
 		}
 
 		return errors.NewInternalError("Failed to create user", err)
 	}
 
+	// A recovered account may reuse the username with a new key
+	r.invalidate(ctx, user.Username, user.PublicKey)
+
 	return nil
 }
 
-// GetByUsername gets a user by username
+// GetPublicKeyByUsername gets a user's public key by username, through the user cache
+func (r *UserRepository) GetPublicKeyByUsername(ctx context.Context, username string) ([]byte, error) {
+	user, err := r.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	return user.PublicKey, nil
+}
+
+// GetByUsername gets a user by username, through the user cache
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	if user, ok := r.cached(ctx, usernameCacheKey(username)); ok {
+		return user, nil
+	}
+
 	query := `
 	SELECT user_id, username, public_key, encrypted_private_key, salt, created_at, last_active
 	FROM users
 	WHERE username = $1
 	`
 
-	row := r.db.Pool.QueryRow(ctx, query, username)
+	row := r.q.QueryRow(ctx, query, username)
 
 	user := &domain.User{}
 	err := row.Scan(
@@ -89,6 +126,64 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*d
 		return nil, errors.NewInternalError("Failed to get user", err)
 	}
 
+	r.cache(ctx, user)
+	return user, nil
+}
+
+// ExistsByPublicKey checks whether a user with the public key exists
+func (r *UserRepository) ExistsByPublicKey(ctx context.Context, publicKey []byte) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM users WHERE public_key = $1)
+	`
+
+	var exists bool
+	if err := r.q.QueryRow(ctx, query, publicKey).Scan(&exists); err != nil {
+		r.logger.Error("Failed to check user by public key", zap.Error(err))
+		return false, errors.NewInternalError("Failed to get user", err)
+	}
+
+	return exists, nil
+}
+
+// GetByPublicKey gets a user by their base64 URL-encoded public key, through the user cache
+func (r *UserRepository) GetByPublicKey(ctx context.Context, publicKeyB64 string) (*domain.User, error) {
+	publicKey, err := base64.URLEncoding.DecodeString(publicKeyB64)
+	if err != nil {
+		return nil, errors.NewValidationError("Invalid public key format", err)
+	}
+
+	if user, ok := r.cached(ctx, publicKeyCacheKey(publicKey)); ok {
+		return user, nil
+	}
+
+	query := `
+	SELECT user_id, username, public_key, encrypted_private_key, salt, created_at, last_active
+	FROM users
+	WHERE public_key = $1
+	`
+
+	row := r.q.QueryRow(ctx, query, publicKey)
+
+	user := &domain.User{}
+	err = row.Scan(
+		&user.UserID,
+		&user.Username,
+		&user.PublicKey,
+		&user.EncryptedPrivateKey,
+		&user.Salt,
+		&user.CreatedAt,
+		&user.LastActive,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.NewNotFoundError("User with the given public key")
+		}
+		r.logger.Error("Failed to get user by public key", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to get user", err)
+	}
+
+	r.cache(ctx, user)
 	return user, nil
 }
 
@@ -100,7 +195,7 @@ func (r *UserRepository) GetByID(ctx context.Context, userID string) (*domain.Us
 	WHERE user_id = $1
 	`
 
-	row := r.db.Pool.QueryRow(ctx, query, userID)
+	row := r.q.QueryRow(ctx, query, userID)
 
 	user := &domain.User{}
 	err := row.Scan(
@@ -125,6 +220,7 @@ func (r *UserRepository) GetByID(ctx context.Context, userID string) (*domain.Us
 }
 
 // UpdateLastActive updates a user's last active timestamp
+// It runs on every login, so cached entries are kept and their last active time lags until they expire
 func (r *UserRepository) UpdateLastActive(ctx context.Context, userID string) error {
 	query := `
 	UPDATE users
@@ -132,11 +228,120 @@ func (r *UserRepository) UpdateLastActive(ctx context.Context, userID string) er
 	WHERE user_id = $2
 	`
 
-	_, err := r.db.Pool.Exec(ctx, query, time.Now(), userID)
-	if err != nil {
+	if _, err := r.q.Exec(ctx, query, time.Now(), userID); err != nil {
 		r.logger.Error("Failed to update user's last active timestamp", zap.Error(err), zap.String("user_id", userID))
 		return errors.NewInternalError("Failed to update user", err)
 	}
+	return nil
+}
+
+// ListInactive gets up to limit users whose last active time is before the given time, least recently active first
+// A limit of 0 returns all of them
+func (r *UserRepository) ListInactive(ctx context.Context, before time.Time, limit int) ([]*domain.User, error) {
+	query := `
+	SELECT user_id, username, public_key, encrypted_private_key, salt, created_at, last_active
+	FROM users
+	WHERE last_active < $1
+	ORDER BY last_active, user_id
+	LIMIT NULLIF($2, 0)
+	`
+
+	rows, err := r.q.Query(ctx, query, before, limit)
+	if err != nil {
+		r.logger.Error("Failed to list inactive users", zap.Error(err), zap.Time("before", before))
+		return nil, errors.NewInternalError("Failed to list inactive users", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		user := &domain.User{}
+		if err := rows.Scan(
+			&user.UserID,
+			&user.Username,
+			&user.PublicKey,
+			&user.EncryptedPrivateKey,
+			&user.Salt,
+			&user.CreatedAt,
+			&user.LastActive,
+		); err != nil {
+			r.logger.Error("Failed to scan user row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read user data", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating user rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read user data", err)
+	}
+
+	return users, nil
+}
+
+// GetUsernamesByPublicKeys looks up the usernames registered for the given base64 public keys in a single query
+// The result maps each key that belongs to a discoverable user to their username; other keys, and ones that aren't valid base64, are left out
+func (r *UserRepository) GetUsernamesByPublicKeys(ctx context.Context, publicKeysB64 []string) (map[string]string, error) {
+	publicKeys := make([][]byte, 0, len(publicKeysB64))
+	for _, publicKeyB64 := range publicKeysB64 {
+		if publicKey, err := base64.URLEncoding.DecodeString(publicKeyB64); err == nil {
+			publicKeys = append(publicKeys, publicKey)
+		}
+	}
+
+	usernames := make(map[string]string)
+	if len(publicKeys) == 0 {
+		return usernames, nil
+	}
+
+	query := `
+	SELECT public_key, username
+	FROM users
+	WHERE public_key = ANY($1) AND discoverable
+	`
+
+	rows, err := r.q.Query(ctx, query, publicKeys)
+	if err != nil {
+		r.logger.Error("Failed to get usernames by public key", zap.Error(err), zap.Int("count", len(publicKeys)))
+		return nil, errors.NewInternalError("Failed to get usernames", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var publicKey []byte
+		var username string
+		if err := rows.Scan(&publicKey, &username); err != nil {
+			r.logger.Error("Failed to scan username row", zap.Error(err))
+			return nil, errors.NewInternalError("Failed to read user data", err)
+		}
+		usernames[base64.URLEncoding.EncodeToString(publicKey)] = username
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("Error iterating username rows", zap.Error(err))
+		return nil, errors.NewInternalError("Failed to read user data", err)
+	}
+
+	return usernames, nil
+}
+
+// SetDiscoverable sets whether a user can be discovered by users they have added as contacts
+func (r *UserRepository) SetDiscoverable(ctx context.Context, userID string, discoverable bool) error {
+	query := `
+	UPDATE users
+	SET discoverable = $1
+	WHERE user_id = $2
+	`
+
+	result, err := r.q.Exec(ctx, query, discoverable, userID)
+	if err != nil {
+		r.logger.Error("Failed to update user's discoverable setting", zap.Error(err), zap.String("user_id", userID))
+		return errors.NewInternalError("Failed to update user", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.NewNotFoundError(fmt.Sprintf("User with ID '%s'", userID))
+	}
 
 	return nil
 }
@@ -146,17 +351,102 @@ func (r *UserRepository) Delete(ctx context.Context, userID string) error {
 	query := `
 	DELETE FROM users
 	WHERE user_id = $1
+	RETURNING username, public_key
 	`
 
-	result, err := r.db.Pool.Exec(ctx, query, userID)
+	var username string
+	var publicKey []byte
+	err := r.q.QueryRow(ctx, query, userID).Scan(&username, &publicKey)
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return errors.NewNotFoundError(fmt.Sprintf("User with ID '%s'", userID))
+		}
 		r.logger.Error("Failed to delete user", zap.Error(err), zap.String("user_id", userID))
 		return errors.NewInternalError("Failed to delete user", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return errors.NewNotFoundError(fmt.Sprintf("User with ID '%s'", userID))
-	}
+	r.invalidate(ctx, username, publicKey)
 
 	return nil
 }
+
+// cachedUser is how a user is stored in the user cache
+// domain.User leaves its binary fields out of JSON, so they are listed here
+type cachedUser struct {
+	UserID              string    `json:"user_id"`
+	Username            string    `json:"username"`
+	PublicKey           []byte    `json:"public_key"`
+	EncryptedPrivateKey []byte    `json:"encrypted_private_key"`
+	Salt                []byte    `json:"salt"`
+	CreatedAt           time.Time `json:"created_at"`
+	LastActive          time.Time `json:"last_active"`
+}
+
+// usernameCacheKey is the user cache key for lookups by username
+func usernameCacheKey(username string) string {
+	return "username:" + username
+}
+
+// publicKeyCacheKey is the user cache key for lookups by public key
+func publicKeyCacheKey(publicKey []byte) string {
+	return "public_key:" + base64.URLEncoding.EncodeToString(publicKey)
+}
+
+// cached looks a user up in the user cache
+func (r *UserRepository) cached(ctx context.Context, key string) (*domain.User, bool) {
+	value, ok := r.userCache.Get(ctx, key)
+	if !ok {
+		return nil, false
+	}
+
+	var entry cachedUser
+	if err := json.Unmarshal(value, &entry); err != nil {
+		r.logger.Warn("Dropping unreadable user cache entry", zap.Error(err))
+		r.userCache.Delete(ctx, key)
+		return nil, false
+	}
+
+	return &domain.User{
+		UserID:              entry.UserID,
+		Username:            entry.Username,
+		PublicKey:           entry.PublicKey,
+		EncryptedPrivateKey: entry.EncryptedPrivateKey,
+		Salt:                entry.Salt,
+		CreatedAt:           entry.CreatedAt,
+		LastActive:          entry.LastActive,
+	}, true
+}
+
+// cache stores a user in the user cache under both its username and public key
+func (r *UserRepository) cache(ctx context.Context, user *domain.User) {
+	value, err := json.Marshal(cachedUser{
+		UserID:              user.UserID,
+		Username:            user.Username,
+		PublicKey:           user.PublicKey,
+		EncryptedPrivateKey: user.EncryptedPrivateKey,
+		Salt:                user.Salt,
+		CreatedAt:           user.CreatedAt,
+		LastActive:          user.LastActive,
+	})
+	if err != nil {
+		return
+	}
+
+	r.userCache.Set(ctx, usernameCacheKey(user.Username), value)
+	r.userCache.Set(ctx, publicKeyCacheKey(user.PublicKey), value)
+}
+
+// invalidate drops a user's user cache entries
+// Every statement that changes a cached column other than last_active must call it with the user's username and public key
+// In a transaction they are dropped once it commits, since a lookup in between would cache the old row again
+func (r *UserRepository) invalidate(ctx context.Context, username string, publicKey []byte) {
+	drop := func() {
+		r.userCache.Delete(ctx, usernameCacheKey(username))
+		r.userCache.Delete(ctx, publicKeyCacheKey(publicKey))
+	}
+	if r.tx != nil {
+		afterCommit(r.tx, drop)
+		return
+	}
+	drop()
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/cache"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+)
+
+func TestMultibyteUsernameAtServiceLimit(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	// The service accepts up to 50 characters; the column must store them regardless of byte length
+	id := uuid.NewString()
+	user := &domain.User{
+		UserID:              id,
+		Username:            strings.Repeat("ж", 42) + id[:8],
+		PublicKey:           []byte("pubkey-" + id),
+		EncryptedPrivateKey: []byte("privkey-" + id),
+		Salt:                []byte("salt-" + id),
+		CreatedAt:           time.Now(),
+		LastActive:          time.Now(),
+	}
+	require.NoError(t, repo.Create(ctx, user))
+	t.Cleanup(func() { _ = repo.Delete(context.Background(), id) })
+
+	stored, err := repo.GetByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, user.Username, stored.Username)
+}
+
+func TestConcurrentCreateSameUser(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	id := uuid.NewString()
+	t.Cleanup(func() { _ = repo.Delete(context.Background(), id) })
+
+	// Both registrations start together; exactly one may win
+	start := make(chan struct{})
+	results := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := &domain.User{
+				UserID:              id,
+				Username:            "test_" + id[:8],
+				PublicKey:           []byte("pubkey-" + id),
+				EncryptedPrivateKey: []byte("privkey-" + id),
+				Salt:                []byte("salt-" + id),
+				CreatedAt:           time.Now(),
+				LastActive:          time.Now(),
+			}
+			<-start
+			results[i] = repo.Create(ctx, user)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	var succeeded, conflicted int
+	for _, err := range results {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, http.StatusConflict, appErr.Status)
+		conflicted++
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 1, conflicted)
+}
+
+func TestUserCacheRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	userCache := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() { _ = userCache.Close() })
+	// No database: every lookup below must be served from the cache
+	repo := &UserRepository{userCache: userCache, logger: zap.NewNop()}
+
+	user := &domain.User{
+		UserID:              "user-1",
+		Username:            "alice",
+		PublicKey:           []byte("pubkey-1"),
+		EncryptedPrivateKey: []byte("privkey-1"),
+		Salt:                []byte("salt-1"),
+		CreatedAt:           time.Now().UTC().Truncate(time.Second),
+		LastActive:          time.Now().UTC().Truncate(time.Second),
+	}
+	repo.cache(ctx, user)
+
+	byUsername, err := repo.GetByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, user, byUsername)
+
+	byPublicKey, err := repo.GetByPublicKey(ctx, base64.URLEncoding.EncodeToString(user.PublicKey))
+	require.NoError(t, err)
+	assert.Equal(t, user, byPublicKey)
+
+	publicKey, err := repo.GetPublicKeyByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, user.PublicKey, publicKey)
+
+	repo.invalidate(ctx, user.Username, user.PublicKey)
+	_, ok := userCache.Get(ctx, usernameCacheKey(user.Username))
+	assert.False(t, ok)
+	_, ok = userCache.Get(ctx, publicKeyCacheKey(user.PublicKey))
+	assert.False(t, ok)
+}
+
+func TestUserCacheInvalidation(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userCache := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() { _ = userCache.Close() })
+	db.UserCache = userCache
+	repo := NewUserRepository(db)
+
+	user := createTestUser(t, db)
+	publicKeyB64 := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	isCached := func() (byUsername, byPublicKey bool) {
+		_, byUsername = userCache.Get(ctx, usernameCacheKey(user.Username))
+		_, byPublicKey = userCache.Get(ctx, publicKeyCacheKey(user.PublicKey))
+		return byUsername, byPublicKey
+	}
+
+	// Each lookup reads through to the database and fills both entries
+	publicKey, err := repo.GetPublicKeyByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, user.PublicKey, publicKey)
+	byUsername, byPublicKey := isCached()
+	assert.True(t, byUsername)
+	assert.True(t, byPublicKey)
+
+	repo.invalidate(ctx, user.Username, user.PublicKey)
+	stored, err := repo.GetByPublicKey(ctx, publicKeyB64)
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, stored.UserID)
+	byUsername, byPublicKey = isCached()
+	assert.True(t, byUsername)
+	assert.True(t, byPublicKey)
+
+	// Updating the last active time, on every login, keeps them
+	require.NoError(t, repo.UpdateLastActive(ctx, user.UserID))
+	byUsername, byPublicKey = isCached()
+	assert.True(t, byUsername)
+	assert.True(t, byPublicKey)
+
+	// Deleting the account in a transaction drops them once it commits
+	require.NoError(t, db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := NewUserRepositoryTx(db, tx).Delete(ctx, user.UserID); err != nil {
+			return err
+		}
+		byUsername, byPublicKey := isCached()
+		assert.True(t, byUsername)
+		assert.True(t, byPublicKey)
+		return nil
+	}))
+	byUsername, byPublicKey = isCached()
+	assert.False(t, byUsername)
+	assert.False(t, byPublicKey)
+	_, err = repo.GetByUsername(ctx, user.Username)
+	assert.Error(t, err)
+	_, err = repo.GetByPublicKey(ctx, publicKeyB64)
+	assert.Error(t, err)
+
+	// Recovery recreates the user with a new key, which must replace any cached entry
+	repo.cache(ctx, user)
+	user.PublicKey = []byte("rotated-" + user.UserID)
+	require.NoError(t, repo.Create(ctx, user))
+	_, ok := userCache.Get(ctx, usernameCacheKey(user.Username))
+	assert.False(t, ok)
+
+	publicKey, err = repo.GetPublicKeyByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, user.PublicKey, publicKey)
+}
@@ -0,0 +1,33 @@
+package security
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// safetyNumberGroups is how many five digit groups a safety number has
+const safetyNumberGroups = 8
+
+// SafetyNumber derives the number two users compare to check they hold each other's real public keys
+// The keys are sorted before hashing, so both users get the same number whichever order they are passed in
+func SafetyNumber(aPub, bPub []byte) string {
+	if bytes.Compare(aPub, bPub) > 0 {
+		aPub, bPub = bPub, aPub
+	}
+
+	h := sha256.New()
+	h.Write(aPub)
+	h.Write(bPub)
+	digest := h.Sum(nil)
+
+	// Each group comes from four bytes of the digest
+	groups := make([]string, safetyNumberGroups)
+	for i := range groups {
+		chunk := binary.BigEndian.Uint32(digest[i*4 : i*4+4])
+		groups[i] = fmt.Sprintf("%05d", chunk%100000)
+	}
+	return strings.Join(groups, " ")
+}
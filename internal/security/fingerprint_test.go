@@ -0,0 +1,34 @@
+package security
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafetyNumberSymmetric(t *testing.T) {
+	alice := []byte("alice-public-key")
+	bob := []byte("bob-public-key")
+
+	// Both parties compute the same number
+	assert.Equal(t, SafetyNumber(alice, bob), SafetyNumber(bob, alice))
+
+	// Stable across calls
+	assert.Equal(t, SafetyNumber(alice, bob), SafetyNumber(alice, bob))
+}
+
+func TestSafetyNumberFormat(t *testing.T) {
+	number := SafetyNumber([]byte("alice-public-key"), []byte("bob-public-key"))
+	assert.Regexp(t, regexp.MustCompile(`^\d{5}( \d{5}){7}$`), number)
+}
+
+func TestSafetyNumberChangesWithKeys(t *testing.T) {
+	alice := []byte("alice-public-key")
+	bob := []byte("bob-public-key")
+	mallory := []byte("mallory-public-key")
+
+	// A substituted key gives a different number
+	assert.NotEqual(t, SafetyNumber(alice, bob), SafetyNumber(alice, mallory))
+	assert.NotEqual(t, SafetyNumber(alice, bob), SafetyNumber(bob, mallory))
+}
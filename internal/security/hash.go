@@ -1,6 +1,7 @@
 package security
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -10,10 +11,19 @@ import (
 )
 
 // HashUsername creates a deterministic hash of a username for use as user_id
-func HashUsername(username string) string {
-	// Simple SHA-256 hash of username (lowercased for consistency)
-	hash := sha256.Sum256([]byte(strings.ToLower(username)))
-	return hex.EncodeToString(hash[:])
+// With a secret it is an HMAC, so IDs can't be computed from usernames without the secret;
+// without one it is a plain SHA-256, as in deployments created before the secret existed
+func HashUsername(username, secret string) string {
+	// Usernames are lowercased for consistency
+	normalized := []byte(strings.ToLower(username))
+	if secret == "" {
+		hash := sha256.Sum256(normalized)
+		return hex.EncodeToString(hash[:])
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(normalized)
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 // HashToken creates a secure hash of a token for storage
@@ -22,6 +32,16 @@ func HashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// HashMessageContent computes the content hash of a message's immutable ciphertext fields
+// The hash is SHA-256 over ciphertext_kem || ciphertext_msg || nonce, hex encoded
+func HashMessageContent(ciphertextKEM, ciphertextMsg, nonce []byte) string {
+	h := sha256.New()
+	h.Write(ciphertextKEM)
+	h.Write(ciphertextMsg)
+	h.Write(nonce)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // GenerateRandomToken generates a random token string
 func GenerateRandomToken(byteLength int) (string, error) {
 	randomBytes := make([]byte, byteLength)
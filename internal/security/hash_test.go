@@ -0,0 +1,42 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashUsername(t *testing.T) {
+	// Without a secret IDs stay the plain hash that existing deployments were created with
+	plain := sha256.Sum256([]byte("alice"))
+	assert.Equal(t, hex.EncodeToString(plain[:]), HashUsername("Alice", ""))
+
+	// With one they are deterministic per secret, case-insensitive, and differ from the plain hash
+	keyed := HashUsername("alice", "secret")
+	assert.Equal(t, keyed, HashUsername("ALICE", "secret"))
+	assert.NotEqual(t, HashUsername("alice", ""), keyed)
+	assert.NotEqual(t, HashUsername("alice", "other-secret"), keyed)
+	assert.Len(t, keyed, 64)
+}
+
+func TestHashMessageContent(t *testing.T) {
+	kem := []byte("ciphertext-kem")
+	msg := []byte("ciphertext-msg")
+	nonce := []byte("nonce")
+
+	hash := HashMessageContent(kem, msg, nonce)
+
+	// Matches SHA-256 over the concatenated fields so clients can recompute it
+	expected := sha256.Sum256([]byte("ciphertext-kemciphertext-msgnonce"))
+	assert.Equal(t, hex.EncodeToString(expected[:]), hash)
+
+	// Stable across calls
+	assert.Equal(t, hash, HashMessageContent(kem, msg, nonce))
+
+	// Changes when any field changes
+	assert.NotEqual(t, hash, HashMessageContent([]byte("ciphertext-kem!"), msg, nonce))
+	assert.NotEqual(t, hash, HashMessageContent(kem, []byte("ciphertext-msg!"), nonce))
+	assert.NotEqual(t, hash, HashMessageContent(kem, msg, []byte("nonce!")))
+}
@@ -14,11 +14,13 @@ type Claims struct {
 }
 
 // GenerateToken generates a new JWT token
-func GenerateToken(userID, secret string, expiry time.Duration) (string, error) {
+// The token ID is carried in the jti claim so the token can be matched to its session
+func GenerateToken(userID, tokenID, secret string, expiry time.Duration) (string, error) {
 	now := time.Now()
 	claims := &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
@@ -30,7 +32,8 @@ func GenerateToken(userID, secret string, expiry time.Duration) (string, error)
 }
 
 // ParseToken parses and validates a JWT token
-func ParseToken(tokenString, secret string) (*Claims, error) {
+// leeway tolerates clock skew when checking the expiry and not-before claims
+func ParseToken(tokenString, secret string, leeway time.Duration) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		// Validate signing algorithm
@@ -38,7 +41,7 @@ func ParseToken(tokenString, secret string) (*Claims, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(secret), nil
-	})
+	}, jwt.WithLeeway(leeway))
 
 	if err != nil {
 		return nil, err
@@ -48,5 +51,10 @@ func ParseToken(tokenString, secret string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// Tokens without an expiry would never expire
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+
 	return claims, nil
 }
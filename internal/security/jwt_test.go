@@ -0,0 +1,48 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAndParseToken(t *testing.T) {
+	tokenStr, err := GenerateToken("user-1", "token-1", "secret", time.Hour)
+	require.NoError(t, err)
+
+	claims, err := ParseToken(tokenStr, "secret", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "token-1", claims.ID)
+}
+
+func TestParseTokenRejectsWrongSecret(t *testing.T) {
+	tokenStr, err := GenerateToken("user-1", "token-1", "secret", time.Hour)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenStr, "other-secret", 0)
+	assert.Error(t, err)
+}
+
+func TestParseTokenExpiry(t *testing.T) {
+	tokenStr, err := GenerateToken("user-1", "token-1", "secret", -2*time.Second)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenStr, "secret", 0)
+	assert.Error(t, err)
+
+	// Within the leeway an expired token is still accepted
+	_, err = ParseToken(tokenStr, "secret", 5*time.Second)
+	assert.NoError(t, err)
+}
+
+func TestParseTokenRequiresExpiry(t *testing.T) {
+	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "user-1"}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenStr, "secret", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// solvePoW finds a nonce for the challenge by brute force
+func solvePoW(t *testing.T, secret, challenge, username string) string {
+	t.Helper()
+
+	now := time.Now()
+	for i := 0; i < 1<<20; i++ {
+		nonce := strconv.Itoa(i)
+		if VerifyPoW(secret, challenge, username, nonce, now) == nil {
+			return nonce
+		}
+	}
+	t.Fatal("no nonce found")
+	return ""
+}
+
+func TestVerifyPoWAcceptsValidNonce(t *testing.T) {
+	challenge, err := NewPoWChallenge("secret", 8, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 8, challenge.Difficulty)
+
+	nonce := solvePoW(t, "secret", challenge.Challenge, "alice")
+	assert.NoError(t, VerifyPoW("secret", challenge.Challenge, "alice", nonce, time.Now()))
+
+	// Usernames are compared case-insensitively, like everywhere else
+	assert.NoError(t, VerifyPoW("secret", challenge.Challenge, "Alice", nonce, time.Now()))
+}
+
+func TestVerifyPoWRejectsInvalidNonces(t *testing.T) {
+	// A difficulty no nonce will reach by chance, so only the nonce found for it passes
+	challenge, err := NewPoWChallenge("secret", 16, time.Minute)
+	require.NoError(t, err)
+	nonce := solvePoW(t, "secret", challenge.Challenge, "alice")
+	now := time.Now()
+
+	tampered := []byte(challenge.Challenge)
+	tampered[0] ^= 1
+
+	for name, check := range map[string]func() error{
+		"other username":  func() error { return VerifyPoW("secret", challenge.Challenge, "bob", nonce, now) },
+		"wrong nonce":     func() error { return VerifyPoW("secret", challenge.Challenge, "alice", nonce+"x", now) },
+		"empty nonce":     func() error { return VerifyPoW("secret", challenge.Challenge, "alice", "", now) },
+		"other secret":    func() error { return VerifyPoW("other", challenge.Challenge, "alice", nonce, now) },
+		"tampered":        func() error { return VerifyPoW("secret", string(tampered), "alice", nonce, now) },
+		"malformed":       func() error { return VerifyPoW("secret", "not-a-challenge", "alice", nonce, now) },
+		"expired":         func() error { return VerifyPoW("secret", challenge.Challenge, "alice", nonce, now.Add(2*time.Minute)) },
+		"oversized nonce": func() error { return VerifyPoW("secret", challenge.Challenge, "alice", string(make([]byte, 65)), now) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, check())
+		})
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	assert.Equal(t, 0, leadingZeroBits([]byte{0x80}))
+	assert.Equal(t, 7, leadingZeroBits([]byte{0x01}))
+	assert.Equal(t, 12, leadingZeroBits([]byte{0x00, 0x08}))
+	assert.Equal(t, 16, leadingZeroBits([]byte{0x00, 0x00}))
+}
@@ -3,7 +3,7 @@ package security
 // SecurityTestHelper provides test helper methods for security functions
 type SecurityTestHelper struct{}
 
-// HashUsername returns a deterministic hash of a username for testing
+// HashUsername returns a deterministic hash of a username for testing, as computed without a user ID secret
 func (h *SecurityTestHelper) HashUsername(username string) string {
-	return HashUsername(username)
+	return HashUsername(username, "")
 }
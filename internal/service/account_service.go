@@ -1,149 +1,166 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"fmt"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/security"
 )
 
-// BackupData represents a complete account backup
-type BackupData struct {
+// BackupHeader is the first record of an account backup; the user's messages follow it
+type BackupHeader struct {
 	PublicKey           string                 `json:"public_key"`
 	EncryptedPrivateKey map[string]string      `json:"encrypted_private_key"`
 	Contacts            map[string]interface{} `json:"contacts"`
-	Messages            []interface{}          `json:"messages"`
+}
+
+// backupPageSize is how many messages are read at a time while writing a backup
+const backupPageSize = 500
+
+// restoreBatchSize is how many backed up messages are stored per round trip during recovery
+const restoreBatchSize = 500
+
+// RecoverySummary counts what was restored from a backup
+type RecoverySummary struct {
+	ContactsRestored int `json:"contacts_restored"`
+	MessagesRestored int `json:"messages_restored"`
+	MessagesSkipped  int `json:"messages_skipped"` // Not sent by the user, already stored, or expired
+	MessagesFailed   int `json:"messages_failed"`  // Malformed or not stored
 }
 
 // AccountService provides account management business logic
 type AccountService struct {
+	db          *repository.Database
 	userRepo    *repository.UserRepository
 	contactRepo *repository.ContactRepository
 	messageRepo *repository.MessageRepository
 	tokenRepo   *repository.TokenRepository
+	hub         *realtime.Hub
+	config      *config.Config
 	logger      *zap.Logger
 }
 
 // NewAccountService creates a new AccountService
 func NewAccountService(
+	db *repository.Database,
 	userRepo *repository.UserRepository,
 	contactRepo *repository.ContactRepository,
 	messageRepo *repository.MessageRepository,
 	tokenRepo *repository.TokenRepository,
+	hub *realtime.Hub,
+	config *config.Config,
 	logger *zap.Logger,
 ) *AccountService {
 	return &AccountService{
+		db:          db,
 		userRepo:    userRepo,
 		contactRepo: contactRepo,
 		messageRepo: messageRepo,
 		tokenRepo:   tokenRepo,
+		hub:         hub,
+		config:      config,
 		logger:      logger.With(zap.String("service", "account")),
 	}
 }
 
-// BackupAccount creates a full backup of a user's account data
-func (s *AccountService) BackupAccount(ctx context.Context, userID string) (*BackupData, error) {
+// BackupAccount writes a full backup of a user's account: the header, then each message oldest first
+// Messages are read a page at a time so memory use doesn't grow with the size of the account
+// It returns how many messages were written
+func (s *AccountService) BackupAccount(ctx context.Context, userID string,
+	writeHeader func(*BackupHeader) error, writeMessage func(map[string]interface{}) error) (int, error) {
+
 	// Get the user
 	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	// Get the user's contacts
-	contacts, err := s.contactRepo.GetByUserID(ctx, userID)
+	contacts, err := s.contactRepo.GetByUserID(ctx, userID, 0, 0)
 	if err != nil {
-		return nil, errors.NewInternalError("Failed to get contacts", err)
+		return 0, errors.NewInternalError("Failed to get contacts", err)
 	}
 
 	// Get the user's public key
 	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
 
-	// Get sent messages
-	sentMessages, err := s.messageRepo.GetBySender(ctx, userPubKey, 10000, 0)
-	if err != nil {
-		return nil, errors.NewInternalError("Failed to get sent messages", err)
-	}
-
-	// Get received messages
-	receivedMessages, err := s.messageRepo.GetByRecipient(ctx, userPubKey, 10000, 0)
-	if err != nil {
-		return nil, errors.NewInternalError("Failed to get received messages", err)
-	}
-
-	// Combine all messages
-	allMessages := append(sentMessages, receivedMessages...)
-
-	// Create the backup data
-	backup := &BackupData{
+	header := &BackupHeader{
 		PublicKey: userPubKey,
 		EncryptedPrivateKey: map[string]string{
 			"salt":          base64.URLEncoding.EncodeToString(user.Salt),
 			"encrypted_key": base64.URLEncoding.EncodeToString(user.EncryptedPrivateKey),
 		},
-		Contacts: make(map[string]interface{}),
-		Messages: make([]interface{}, 0, len(allMessages)),
+		Contacts: make(map[string]interface{}, len(contacts)),
 	}
 
 	// Format contacts for the backup
 	for _, contact := range contacts {
-		backup.Contacts[contact.ContactPubKey] = map[string]interface{}{
+		header.Contacts[contact.ContactPubKey] = map[string]interface{}{
 			"nickname":   contact.Nickname,
+			"group_name": contact.GroupName,
 			"created_at": contact.CreatedAt,
 		}
 	}
 
-	// Format messages for the backup
-	for _, msg := range allMessages {
-		backup.Messages = append(backup.Messages, map[string]interface{}{
-			"message_id":            msg.MessageID.String(),
-			"sender_pubkey":         msg.SenderPubKey,
-			"recipient_pubkey":      msg.RecipientPubKey,
-			"ciphertext_kem":        base64.URLEncoding.EncodeToString(msg.CiphertextKEM),
-			"ciphertext_msg":        base64.URLEncoding.EncodeToString(msg.CiphertextMsg),
-			"nonce":                 base64.URLEncoding.EncodeToString(msg.Nonce),
-			"sender_ciphertext_kem": base64.URLEncoding.EncodeToString(msg.SenderCiphertextKEM),
-			"sender_ciphertext_msg": base64.URLEncoding.EncodeToString(msg.SenderCiphertextMsg),
-			"sender_nonce":          base64.URLEncoding.EncodeToString(msg.SenderNonce),
-			"timestamp":             msg.Timestamp,
-			"status":                msg.Status,
-		})
+	if err := writeHeader(header); err != nil {
+		return 0, err
+	}
+
+	// Write every message; the repository pages through them so none are left out
+	written := 0
+	err = s.messageRepo.ForEachUserMessage(ctx, userPubKey, backupPageSize, func(msg *domain.Message) error {
+		if err := writeMessage(messageToBackup(msg)); err != nil {
+			return err
+		}
+		written++
+		return nil
+	})
+	if err != nil {
+		return written, err
 	}
 
 	s.logger.Info("Account backup created",
 		zap.String("user_id", userID),
 		zap.Int("contacts", len(contacts)),
-		zap.Int("messages", len(allMessages)),
+		zap.Int("messages", written),
 	)
 
-	return backup, nil
+	return written, nil
 }
 
 // RecoverAccount recovers an account from backup data
+// Malformed contacts and messages are skipped with a warning rather than failing the recovery
 func (s *AccountService) RecoverAccount(ctx context.Context, username, publicKeyB64 string,
 	encryptedPrivateKey map[string]string, contactsData map[string]interface{},
-	messagesData []interface{}) (*domain.User, error) {
+	messagesData []interface{}) (*domain.User, *RecoverySummary, error) {
 
 	// Validate input
-	if username == "" {
-		return nil, errors.NewValidationError("Username is required", nil)
+	if err := validateUsername(username); err != nil {
+		return nil, nil, err
 	}
 	if publicKeyB64 == "" {
-		return nil, errors.NewValidationError("Public key is required", nil)
+		return nil, nil, errors.NewValidationError("Public key is required", nil)
 	}
 	if encryptedPrivateKey == nil || encryptedPrivateKey["salt"] == "" || encryptedPrivateKey["encrypted_key"] == "" {
-		return nil, errors.NewValidationError("Encrypted private key data is required", nil)
+		return nil, nil, errors.NewValidationError("Encrypted private key data is required", nil)
 	}
 
 	// Decode the public key
 	publicKey, err := base64.URLEncoding.DecodeString(publicKeyB64)
 	if err != nil {
-		return nil, errors.NewValidationError("Invalid public key format", err)
+		return nil, nil, errors.NewValidationError("Invalid public key format", err)
 	}
 
 	// Decode the encrypted private key and salt
@@ -152,27 +169,27 @@ func (s *AccountService) RecoverAccount(ctx context.Context, username, publicKey
 
 	salt, err := base64.URLEncoding.DecodeString(saltB64)
 	if err != nil {
-		return nil, errors.NewValidationError("Invalid salt format", err)
+		return nil, nil, errors.NewValidationError("Invalid salt format", err)
 	}
 
 	encPrivKey, err := base64.URLEncoding.DecodeString(encPrivKeyB64)
 	if err != nil {
-		return nil, errors.NewValidationError("Invalid encrypted private key format", err)
+		return nil, nil, errors.NewValidationError("Invalid encrypted private key format", err)
 	}
 
 	// Validate key formats
 	if err := security.ValidatePublicKeyFormat(publicKey); err != nil {
-		return nil, errors.NewValidationError("Invalid public key", err)
+		return nil, nil, errors.NewValidationError("Invalid public key", err)
 	}
 	if err := security.ValidateEncryptedPrivateKeyFormat(encPrivKey); err != nil {
-		return nil, errors.NewValidationError("Invalid encrypted private key", err)
+		return nil, nil, errors.NewValidationError("Invalid encrypted private key", err)
 	}
 	if err := security.ValidateSaltFormat(salt); err != nil {
-		return nil, errors.NewValidationError("Invalid salt", err)
+		return nil, nil, errors.NewValidationError("Invalid salt", err)
 	}
 
 	// Calculate user ID
-	userID := security.HashUsername(username)
+	userID := security.HashUsername(username, s.config.Auth.UserIDSecret)
 
 	// Check if user already exists
 	existingUser, err := s.userRepo.GetByID(ctx, userID)
@@ -195,19 +212,55 @@ func (s *AccountService) RecoverAccount(ctx context.Context, username, publicKey
 		LastActive:          now,
 	}
 
-	// If user exists, delete it first to ensure clean slate
-	if existingUser != nil {
-		if err := s.userRepo.Delete(ctx, userID); err != nil {
-			return nil, errors.NewInternalError("Failed to replace existing user", err)
+	// Replacing the user and restoring their data happen in one transaction, so a failure partway through
+	// leaves the existing account as it was rather than deleted
+	summary := &RecoverySummary{}
+	err = s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		users := repository.NewUserRepositoryTx(s.db, tx)
+
+		// If user exists, delete it first to ensure clean slate
+		if existingUser != nil {
+			if err := users.Delete(ctx, userID); err != nil {
+				return errors.NewInternalError("Failed to replace existing user", err)
+			}
+		}
+
+		// Create the user
+		if err := users.Create(ctx, user); err != nil {
+			return err
 		}
+
+		summary.ContactsRestored = s.restoreContacts(ctx, repository.NewContactRepositoryTx(s.db, tx), userID, contactsData)
+		s.restoreBackupMessages(ctx, repository.NewMessageRepositoryTx(s.db, tx), publicKeyB64, messagesData, summary)
+		return nil
+	})
+	if err != nil {
+		s.logger.Warn("Account recovery rolled back", zap.Error(err), zap.String("user_id", userID))
+		return nil, nil, err
 	}
 
-	// Create the user
-	if err := s.userRepo.Create(ctx, user); err != nil {
-		return nil, err
+	// Recovering with a new key retires the old one, so messages still waiting for it can never be fetched
+	if existingUser != nil && !bytes.Equal(existingUser.PublicKey, publicKey) {
+		s.failRetiredKeyMessages(ctx, base64.URLEncoding.EncodeToString(existingUser.PublicKey))
 	}
 
-	// Process contacts
+	s.logger.Info("Account recovered",
+		zap.String("username", username),
+		zap.String("user_id", userID),
+		zap.Int("contacts", summary.ContactsRestored),
+		zap.Int("messages", summary.MessagesRestored),
+		zap.Int("messages_skipped", summary.MessagesSkipped),
+		zap.Int("messages_failed", summary.MessagesFailed),
+	)
+
+	return user, summary, nil
+}
+
+// restoreContacts stores the contacts from a backup and returns how many were stored
+// Malformed entries are skipped, and a failed import is only logged, since the rest of the backup can still be restored
+func (s *AccountService) restoreContacts(ctx context.Context, contactRepo *repository.ContactRepository,
+	userID string, contactsData map[string]interface{}) int {
+	contacts := make([]*domain.Contact, 0, len(contactsData))
 	for pubKey, contactData := range contactsData {
 		data, ok := contactData.(map[string]interface{})
 		if !ok {
@@ -222,84 +275,236 @@ func (s *AccountService) RecoverAccount(ctx context.Context, username, publicKey
 		}
 
 		contact := domain.NewContact(userID, pubKey, nickname)
-		if err := s.contactRepo.Create(ctx, contact); err != nil {
-			s.logger.Warn("Failed to restore contact",
-				zap.Error(err),
-				zap.String("public_key", pubKey))
-			// Continue with other contacts
+		if group, ok := data["group_name"].(string); ok && validateGroupName(group) == nil {
+			contact.GroupName = group
 		}
+		contacts = append(contacts, contact)
 	}
 
-	// Process messages (simplified, as this could be a lot of data)
-	// In a production app, this would be handled by background workers
-	for _, msgData := range messagesData {
+	created, err := contactRepo.CreateMany(ctx, contacts)
+	if err != nil {
+		s.logger.Warn("Failed to restore contacts", zap.Error(err), zap.Int("count", len(contacts)))
+		return 0
+	}
+	return created
+}
+
+// restoreBackupMessages stores the messages from a backup in batches, since backups can be large, and adds the outcome to the summary
+// The recovery request isn't authenticated, so a backup can claim any sender; only messages the user sent are restored
+func (s *AccountService) restoreBackupMessages(ctx context.Context, messageRepo *repository.MessageRepository,
+	userPubKey string, messagesData []interface{}, summary *RecoverySummary) {
+	now := time.Now()
+	batch := make([]*domain.Message, 0, restoreBatchSize)
+	for i, msgData := range messagesData {
 		data, ok := msgData.(map[string]interface{})
 		if !ok {
-			s.logger.Warn("Invalid message data format, skipping")
+			s.logger.Warn("Invalid message data format, skipping", zap.Int("index", i))
+			summary.MessagesFailed++
+			continue
+		}
+
+		message, err := messageFromBackup(data)
+		if err != nil {
+			s.logger.Warn("Malformed message in backup, skipping", zap.Error(err), zap.Int("index", i))
+			summary.MessagesFailed++
 			continue
 		}
 
-		// Skip messages that don't involve this user
-		senderPubKey, _ := data["sender_pubkey"].(string)
-		recipientPubKey, _ := data["recipient_pubkey"].(string)
-		if senderPubKey != publicKeyB64 && recipientPubKey != publicKeyB64 {
+		// Skip messages the user didn't send, and ones that have expired
+		if message.SenderPubKey != userPubKey || message.IsExpiredAt(now) {
+			summary.MessagesSkipped++
 			continue
 		}
+		resetRestoredMessage(message, now)
 
-		// Skip message processing for now...
-		// In a real implementation, we would process these messages
-		// But this would make the handler very complex and slow
+		batch = append(batch, message)
+		if len(batch) == restoreBatchSize {
+			s.restoreMessages(ctx, messageRepo, batch, summary)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		s.restoreMessages(ctx, messageRepo, batch, summary)
 	}
+}
 
-	s.logger.Info("Account recovered",
-		zap.String("username", username),
-		zap.String("user_id", userID),
-		zap.Int("contacts", len(contactsData)),
-		zap.Int("messages", len(messagesData)),
-	)
+// failRetiredKeyMessages marks the undelivered messages sent to a retired key as failed and tells their senders
+// A failure is logged rather than returned, since the account has already been recovered
+func (s *AccountService) failRetiredKeyMessages(ctx context.Context, retiredPubKey string) {
+	receipts, err := s.messageRepo.FailUndeliveredTo(ctx, retiredPubKey)
+	if err != nil {
+		s.logger.Warn("Failed to mark messages to retired key failed", zap.Error(err))
+		return
+	}
 
-	return user, nil
+	for _, receipt := range receipts {
+		s.hub.PublishReceipt(receipt)
+	}
+	if len(receipts) > 0 {
+		s.logger.Info("Marked messages to retired key failed", zap.Int("count", len(receipts)))
+	}
 }
 
-// DeleteAccount completely deletes a user's account and all associated data
-func (s *AccountService) DeleteAccount(ctx context.Context, userID string) error {
-	// Get the user to get their public key
-	user, err := s.userRepo.GetByID(ctx, userID)
+// restoreMessages stores a batch of backed up messages and adds the outcome to the summary
+// A failed batch is counted as failed so the rest of the backup can still be restored
+func (s *AccountService) restoreMessages(ctx context.Context, messageRepo *repository.MessageRepository,
+	messages []*domain.Message, summary *RecoverySummary) {
+	created, err := messageRepo.CreateBatch(ctx, messages)
 	if err != nil {
-		return err
+		s.logger.Warn("Failed to restore message batch", zap.Error(err), zap.Int("count", len(messages)))
+		summary.MessagesFailed += len(messages)
+		return
 	}
+	summary.MessagesRestored += int(created)
+	summary.MessagesSkipped += len(messages) - int(created)
+}
 
-	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+// resetRestoredMessage replaces the parts of a backed up message the user could have made up
+// Only the recipient moves a message past sent, so the message starts over as sent, and nothing is dated in the future
+func resetRestoredMessage(message *domain.Message, now time.Time) {
+	message.Status = domain.MessageStatusSent
+	if message.Timestamp.After(now) {
+		message.Timestamp = now
+	}
+}
+
+// messageToBackup formats a message for BackupAccount; messageFromBackup reverses it
+func messageToBackup(msg *domain.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":            msg.MessageID.String(),
+		"sender_pubkey":         msg.SenderPubKey,
+		"recipient_pubkey":      msg.RecipientPubKey,
+		"ciphertext_kem":        base64.URLEncoding.EncodeToString(msg.CiphertextKEM),
+		"ciphertext_msg":        base64.URLEncoding.EncodeToString(msg.CiphertextMsg),
+		"nonce":                 base64.URLEncoding.EncodeToString(msg.Nonce),
+		"sender_ciphertext_kem": base64.URLEncoding.EncodeToString(msg.SenderCiphertextKEM),
+		"sender_ciphertext_msg": base64.URLEncoding.EncodeToString(msg.SenderCiphertextMsg),
+		"sender_nonce":          base64.URLEncoding.EncodeToString(msg.SenderNonce),
+		"timestamp":             msg.Timestamp,
+		"status":                msg.Status,
+		"content_hash":          msg.ContentHash,
+		"reply_to_message_id":   msg.ReplyToMessageID,
+		"expires_at":            msg.ExpiresAt,
+	}
+}
+
+// messageFromBackup rebuilds a message from its BackupAccount form
+// Backups arrive as decoded JSON, so binary fields are base64 strings and timestamps are RFC 3339 strings
+func messageFromBackup(data map[string]interface{}) (*domain.Message, error) {
+	str := func(key string) string {
+		value, _ := data[key].(string)
+		return value
+	}
+	decode := func(key string) ([]byte, error) {
+		value := str(key)
+		if value == "" {
+			return nil, fmt.Errorf("%s is missing", key)
+		}
+		decoded, err := base64.URLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not valid base64: %w", key, err)
+		}
+		return decoded, nil
+	}
 
-	// Delete messages
-	messageCount, err := s.messageRepo.DeleteUserMessages(ctx, userPubKey)
+	messageID, err := uuid.Parse(str("message_id"))
 	if err != nil {
-		s.logger.Warn("Failed to delete user messages during account deletion",
-			zap.Error(err),
-			zap.String("user_id", userID))
-		// Continue with deletion
+		return nil, fmt.Errorf("invalid message_id: %w", err)
 	}
 
-	// Delete contacts
-	contactCount, err := s.contactRepo.DeleteUserContacts(ctx, userID)
+	senderPubKey, recipientPubKey := str("sender_pubkey"), str("recipient_pubkey")
+	if senderPubKey == "" || recipientPubKey == "" {
+		return nil, fmt.Errorf("sender_pubkey and recipient_pubkey are required")
+	}
+
+	fields := make(map[string][]byte)
+	for _, key := range []string{"ciphertext_kem", "ciphertext_msg", "nonce", "sender_ciphertext_kem", "sender_ciphertext_msg", "sender_nonce"} {
+		value, err := decode(key)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, str("timestamp"))
 	if err != nil {
-		s.logger.Warn("Failed to delete user contacts during account deletion",
-			zap.Error(err),
-			zap.String("user_id", userID))
-		// Continue with deletion
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
 	}
 
-	// Delete tokens
-	tokenCount, err := s.tokenRepo.DeleteUserTokens(ctx, userID)
+	status := domain.MessageStatus(str("status"))
+	switch status {
+	case domain.MessageStatusSent, domain.MessageStatusDelivered, domain.MessageStatusRead, domain.MessageStatusFailed:
+	default:
+		return nil, fmt.Errorf("invalid status %q", status)
+	}
+
+	message := domain.NewMessage(senderPubKey, recipientPubKey,
+		fields["ciphertext_kem"], fields["ciphertext_msg"], fields["nonce"],
+		fields["sender_ciphertext_kem"], fields["sender_ciphertext_msg"], fields["sender_nonce"])
+	message.MessageID = messageID
+	message.Timestamp = timestamp
+	message.Status = status
+
+	if replyTo := str("reply_to_message_id"); replyTo != "" {
+		replyToID, err := uuid.Parse(replyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply_to_message_id: %w", err)
+		}
+		message.ReplyToMessageID = &replyToID
+	}
+
+	if expiresAt := str("expires_at"); expiresAt != "" {
+		expiry, err := time.Parse(time.RFC3339Nano, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		message.ExpiresAt = &expiry
+	}
+
+	return message, nil
+}
+
+// DeleteAccount completely deletes a user's account and all associated data
+// Everything is deleted in one transaction, so a failure leaves the account as it was
+func (s *AccountService) DeleteAccount(ctx context.Context, userID string) error {
+	// Get the user to get their public key
+	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
-		s.logger.Warn("Failed to delete user tokens during account deletion",
-			zap.Error(err),
-			zap.String("user_id", userID))
-		// Continue with deletion
+		return err
 	}
 
-	// Delete the user
-	if err := s.userRepo.Delete(ctx, userID); err != nil {
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	var messageCount, contactCount, tokenCount int64
+	err = s.db.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+
+		// Delete messages, or only mark them deleted so they are kept for the retention window
+		messages := repository.NewMessageRepositoryTx(s.db, tx)
+		if s.config.Messages.HardDelete {
+			messageCount, err = messages.DeleteUserMessages(ctx, userPubKey)
+		} else {
+			messageCount, err = messages.SoftDeleteUserMessages(ctx, userPubKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Delete contacts
+		if contactCount, err = repository.NewContactRepositoryTx(s.db, tx).DeleteUserContacts(ctx, userID); err != nil {
+			return err
+		}
+
+		// Delete tokens
+		if tokenCount, err = repository.NewTokenRepositoryTx(s.db, tx).DeleteUserTokens(ctx, userID); err != nil {
+			return err
+		}
+
+		// Delete the user
+		return repository.NewUserRepositoryTx(s.db, tx).Delete(ctx, userID)
+	})
+	if err != nil {
+		s.logger.Warn("Account deletion rolled back", zap.Error(err), zap.String("user_id", userID))
 		return err
 	}
 
@@ -312,3 +517,17 @@ func (s *AccountService) DeleteAccount(ctx context.Context, userID string) error
 
 	return nil
 }
+
+// UpdatePrivacy updates a user's privacy settings
+func (s *AccountService) UpdatePrivacy(ctx context.Context, userID string, discoverable bool) error {
+	if err := s.userRepo.SetDiscoverable(ctx, userID, discoverable); err != nil {
+		return err
+	}
+
+	s.logger.Info("Privacy settings updated",
+		zap.String("user_id", userID),
+		zap.Bool("discoverable", discoverable),
+	)
+
+	return nil
+}
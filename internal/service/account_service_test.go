@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/security"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+// backupEntry encodes a message the way BackupAccount does and decodes it as a recovery request would
+func backupEntry(t *testing.T, msg *domain.Message) map[string]interface{} {
+	t.Helper()
+
+	raw, err := json.Marshal(messageToBackup(msg))
+	require.NoError(t, err)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &entry))
+	return entry
+}
+
+func TestMessageFromBackupRoundTrip(t *testing.T) {
+	original := domain.NewMessage("alice", "bob",
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+	original.Status = domain.MessageStatusRead
+	replyTo := uuid.New()
+	original.ReplyToMessageID = &replyTo
+	original.ExpireAfter(time.Hour)
+
+	restored, err := messageFromBackup(backupEntry(t, original))
+	require.NoError(t, err)
+
+	assert.Equal(t, original.MessageID, restored.MessageID)
+	assert.Equal(t, original.SenderPubKey, restored.SenderPubKey)
+	assert.Equal(t, original.RecipientPubKey, restored.RecipientPubKey)
+	assert.Equal(t, original.CiphertextKEM, restored.CiphertextKEM)
+	assert.Equal(t, original.CiphertextMsg, restored.CiphertextMsg)
+	assert.Equal(t, original.Nonce, restored.Nonce)
+	assert.Equal(t, original.SenderCiphertextKEM, restored.SenderCiphertextKEM)
+	assert.Equal(t, original.SenderCiphertextMsg, restored.SenderCiphertextMsg)
+	assert.Equal(t, original.SenderNonce, restored.SenderNonce)
+	assert.True(t, original.Timestamp.Equal(restored.Timestamp))
+	assert.Equal(t, domain.MessageStatusRead, restored.Status)
+	require.NotNil(t, restored.ReplyToMessageID)
+	assert.Equal(t, replyTo, *restored.ReplyToMessageID)
+	require.NotNil(t, restored.ExpiresAt)
+	assert.True(t, original.ExpiresAt.Equal(*restored.ExpiresAt))
+}
+
+func TestMessageFromBackupRejectsMalformed(t *testing.T) {
+	valid := domain.NewMessage("alice", "bob",
+		[]byte("kem"), []byte("msg"), []byte("nonce"),
+		[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+	}{
+		{"missing message ID", "message_id", nil},
+		{"bad message ID", "message_id", "not-a-uuid"},
+		{"missing ciphertext", "ciphertext_msg", nil},
+		{"bad base64", "nonce", "!!!"},
+		{"bad timestamp", "timestamp", "yesterday"},
+		{"unknown status", "status", "lost"},
+		{"bad reply reference", "reply_to_message_id", "nope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := backupEntry(t, valid)
+			if tt.value == nil {
+				delete(entry, tt.key)
+			} else {
+				entry[tt.key] = tt.value
+			}
+
+			_, err := messageFromBackup(entry)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRecoverWithNewKeyFailsPendingMessages(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	accounts := NewAccountService(db, userRepo, repository.NewContactRepository(db), messageRepo,
+		repository.NewTokenRepository(db), hub, cfg, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	recipient.UserID = security.HashUsername(recipient.Username, cfg.Auth.UserIDSecret)
+	require.NoError(t, userRepo.Create(ctx, sender))
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	retiredPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		_ = userRepo.Delete(context.Background(), sender.UserID)
+		_ = userRepo.Delete(context.Background(), recipient.UserID)
+	})
+
+	pending := domain.NewMessage(senderPubKey, retiredPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	require.NoError(t, messageRepo.Create(ctx, pending))
+	delivered := domain.NewMessage(senderPubKey, retiredPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	delivered.Status = domain.MessageStatusDelivered
+	require.NoError(t, messageRepo.Create(ctx, delivered))
+
+	sub := hub.Subscribe(senderPubKey)
+	defer hub.Unsubscribe(sub)
+
+	// Recover the recipient's account with a new key, retiring the old one
+	newPubKey := base64.URLEncoding.EncodeToString([]byte("rotated-" + recipient.UserID + strings.Repeat("0", security.Kyber512PublicKeyMinSize)))
+	_, _, err := accounts.RecoverAccount(ctx, recipient.Username, newPubKey, map[string]string{
+		"salt":          base64.URLEncoding.EncodeToString([]byte(strings.Repeat("s", 16))),
+		"encrypted_key": base64.URLEncoding.EncodeToString([]byte(strings.Repeat("k", security.Kyber512PrivateKeyMinSize))),
+	}, nil, nil)
+	require.NoError(t, err)
+
+	// The message nobody fetched can no longer be, so it fails and its sender is told
+	stored, err := messageRepo.GetByID(ctx, pending.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusFailed, stored.Status)
+	select {
+	case receipt := <-sub.Receipts():
+		assert.Equal(t, pending.MessageID, receipt.MessageID)
+		assert.Equal(t, domain.MessageStatusFailed, receipt.Status)
+	default:
+		t.Fatal("expected failed receipt for sender")
+	}
+
+	// Messages already delivered keep their status
+	stored, err = messageRepo.GetByID(ctx, delivered.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusDelivered, stored.Status)
+	assert.Empty(t, sub.Receipts())
+}
+
+func TestResetRestoredMessage(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		status    domain.MessageStatus
+		timestamp time.Time
+		wantTime  time.Time
+	}{
+		{"claiming read", domain.MessageStatusRead, now, now},
+		{"claiming failed", domain.MessageStatusFailed, now, now},
+		{"future timestamp", domain.MessageStatusSent, later, now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := domain.NewMessage("alice", "bob", []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+			message.Status = tt.status
+			message.Timestamp = tt.timestamp
+
+			resetRestoredMessage(message, now)
+			assert.Equal(t, domain.MessageStatusSent, message.Status)
+			assert.True(t, tt.wantTime.Equal(message.Timestamp))
+		})
+	}
+}
+
+func TestRecoverRestoresOnlyOwnMessages(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	accounts := NewAccountService(db, userRepo, repository.NewContactRepository(db), messageRepo,
+		repository.NewTokenRepository(db), nil, cfg, zaptest.NewLogger(t))
+
+	user := testutil.NewUser()
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+	peer := "peer-" + uuid.NewString()
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), userPubKey)
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), peer)
+		_ = userRepo.Delete(context.Background(), security.HashUsername(user.Username, cfg.Auth.UserIDSecret))
+	})
+
+	newMessage := func(sender, recipient string) *domain.Message {
+		message := domain.NewMessage(sender, recipient, []byte("kem"), []byte("msg"), []byte("nonce"),
+			[]byte("sender-kem"), []byte("sender-msg"), []byte("sender-nonce"))
+		message.Status = domain.MessageStatusRead
+		return message
+	}
+	sent := newMessage(userPubKey, peer)
+	received := newMessage(peer, userPubKey)
+	// A backup can hold anything, including messages between other users
+	forged := newMessage(peer, "someone-else")
+
+	_, summary, err := accounts.RecoverAccount(ctx, user.Username, userPubKey, map[string]string{
+		"salt":          base64.URLEncoding.EncodeToString([]byte(strings.Repeat("s", 16))),
+		"encrypted_key": base64.URLEncoding.EncodeToString([]byte(strings.Repeat("k", security.Kyber512PrivateKeyMinSize))),
+	}, nil, []interface{}{backupEntry(t, sent), backupEntry(t, received), backupEntry(t, forged)})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, summary.MessagesRestored)
+	assert.Equal(t, 2, summary.MessagesSkipped)
+
+	_, err = messageRepo.GetByID(ctx, forged.MessageID)
+	assert.Error(t, err)
+
+	// The recipient never saw the restored copy of the user's message
+	stored, err := messageRepo.GetByID(ctx, sent.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusSent, stored.Status)
+
+	// Anyone can claim to have received a message, so received ones aren't restored
+	_, err = messageRepo.GetByID(ctx, received.MessageID)
+	assert.Error(t, err)
+}
+
+func TestFailedRecoveryKeepsExistingAccount(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	userRepo := repository.NewUserRepository(db)
+	accounts := NewAccountService(db, userRepo, repository.NewContactRepository(db), repository.NewMessageRepository(db),
+		repository.NewTokenRepository(db), nil, cfg, zaptest.NewLogger(t))
+
+	existing := testutil.NewUser()
+	existing.UserID = security.HashUsername(existing.Username, cfg.Auth.UserIDSecret)
+	other := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, existing))
+	require.NoError(t, userRepo.Create(ctx, other))
+	t.Cleanup(func() {
+		_ = userRepo.Delete(context.Background(), existing.UserID)
+		_ = userRepo.Delete(context.Background(), other.UserID)
+	})
+
+	// Recreating the user fails after the existing row was deleted, since the key belongs to someone else
+	_, _, err := accounts.RecoverAccount(ctx, existing.Username, base64.URLEncoding.EncodeToString(other.PublicKey), map[string]string{
+		"salt":          base64.URLEncoding.EncodeToString([]byte(strings.Repeat("s", 16))),
+		"encrypted_key": base64.URLEncoding.EncodeToString([]byte(strings.Repeat("k", security.Kyber512PrivateKeyMinSize))),
+	}, nil, nil)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeConflict, appErr.Code)
+
+	stored, err := userRepo.GetByID(ctx, existing.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, existing.PublicKey, stored.PublicKey)
+}
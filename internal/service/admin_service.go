@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+)
+
+// statsCacheTTL is how long server stats are reused before they are counted again
+const statsCacheTTL = 30 * time.Second
+
+// AdminService provides operator-facing business logic
+type AdminService struct {
+	statsRepo *repository.StatsRepository
+	logger    *zap.Logger
+
+	statsMutex sync.Mutex
+	stats      *domain.ServerStats // Last stats counted; nil until the first request
+}
+
+// NewAdminService creates a new AdminService
+func NewAdminService(statsRepo *repository.StatsRepository, logger *zap.Logger) *AdminService {
+	return &AdminService{
+		statsRepo: statsRepo,
+		logger:    logger.With(zap.String("service", "admin")),
+	}
+}
+
+// GetStats returns the server stats, counting them again only when the cached ones are older than statsCacheTTL
+// Concurrent callers wait for a single count instead of each running their own
+func (s *AdminService) GetStats(ctx context.Context) (*domain.ServerStats, error) {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+
+	if s.stats != nil && time.Since(s.stats.CollectedAt) < statsCacheTTL {
+		return s.stats, nil
+	}
+
+	stats, err := s.statsRepo.GetStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stats = stats
+	s.logger.Debug("Server stats counted",
+		zap.Int64("users", stats.Users),
+		zap.Int64("messages", stats.Messages),
+	)
+
+	return stats, nil
+}
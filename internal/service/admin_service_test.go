@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+func TestGetStatsIsCached(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	svc := NewAdminService(repository.NewStatsRepository(db), zaptest.NewLogger(t))
+
+	first, err := svc.GetStats(ctx)
+	require.NoError(t, err)
+
+	userRepo := repository.NewUserRepository(db)
+	user := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, user))
+	t.Cleanup(func() { _ = userRepo.Delete(context.Background(), user.UserID) })
+
+	// Within the cache TTL the new user isn't counted yet
+	second, err := svc.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+}
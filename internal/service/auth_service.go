@@ -4,14 +4,20 @@ import (
 	"context"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/security"
 )
 
+// minLoginDuration is the least time a login takes, whether it succeeds or not
+// It is well above the database work of a successful login, so a rejected one can't be told apart by finishing sooner
+const minLoginDuration = 100 * time.Millisecond
+
 // AuthService provides authentication business logic
 type AuthService struct {
 	userRepo  *repository.UserRepository
@@ -20,6 +26,14 @@ type AuthService struct {
 	logger    *zap.Logger
 }
 
+// TokenPair is a session's short-lived access token and the refresh token that renews it
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
 // NewAuthService creates a new AuthService
 func NewAuthService(
 	userRepo *repository.UserRepository,
@@ -35,14 +49,24 @@ func NewAuthService(
 	}
 }
 
-// Login authenticates a user and returns a token
+// Login authenticates a user and returns the tokens of a new session
 // Note: In our zero-knowledge architecture, we don't verify the password server-side
 // Password verification happens client-side by attempting to decrypt the private key
-func (s *AuthService) Login(ctx context.Context, username string) (string, error) {
+// deviceName labels the new session and may be empty
+func (s *AuthService) Login(ctx context.Context, username, deviceName string) (*TokenPair, error) {
+	// Pad every outcome to the same duration, so the time taken doesn't say whether the username exists
+	defer waitUntil(ctx, time.Now().Add(minLoginDuration))
+
 	// Find the user
 	user, err := s.userRepo.GetByUsername(ctx, username)
 	if err != nil {
-		return "", errors.NewUnauthenticatedError("Invalid username")
+		if appErr, ok := errors.IsAppError(err); !ok || appErr.Code != errors.ErrCodeNotFound {
+			return nil, err
+		}
+
+		// Do the work of a real login anyway, so a missing user costs about as much to reject
+		s.mintDiscardedTokens(deviceName)
+		return nil, errors.NewUnauthenticatedError(invalidLoginMessage)
 	}
 
 	// Update last active timestamp
@@ -52,50 +76,209 @@ func (s *AuthService) Login(ctx context.Context, username string) (string, error
 		// Non-critical error, continue
 	}
 
-	// Create a token
-	token, err := s.tokenRepo.CreateForUser(ctx, user.UserID, s.config.Auth.TokenExpiry)
+	// Create a session
+	tokens, err := s.issueTokens(ctx, user.UserID, deviceName)
 	if err != nil {
-		return "", errors.NewInternalError("Failed to create token", err)
+		return nil, errors.NewInternalError("Failed to create token", err)
 	}
 
 	s.logger.Info("User logged in", zap.String("username", username), zap.String("user_id", user.UserID))
-	return token, nil
+	return tokens, nil
+}
+
+// invalidLoginMessage is the error for every rejected login, so it doesn't say whether the username exists
+const invalidLoginMessage = "Invalid credentials"
+
+// mintDiscardedTokens generates and hashes a session's tokens without storing them
+// It stands in for issueTokens when a login is rejected
+func (s *AuthService) mintDiscardedTokens(deviceName string) {
+	_, refresh, err := s.newRefreshToken(uuid.NewString(), deviceName, nil)
+	if err != nil {
+		return
+	}
+	_, _, _ = s.newAccessToken(refresh)
+}
+
+// waitUntil blocks until deadline, or until ctx is done
+func waitUntil(ctx context.Context, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// issueTokens creates a session for the user: a refresh token, and a first access token issued from it
+func (s *AuthService) issueTokens(ctx context.Context, userID, deviceName string) (*TokenPair, error) {
+	refreshToken, refresh, err := s.newRefreshToken(userID, deviceName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokenRepo.Create(ctx, refresh); err != nil {
+		return nil, err
+	}
+
+	accessToken, access, err := s.newAccessToken(refresh)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokenRepo.Create(ctx, access); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  access.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refresh.ExpiresAt,
+	}, nil
+}
+
+// newRefreshToken generates a refresh token lasting the configured refresh expiry
+// It joins the given token family, or starts its own when familyID is nil
+// Refresh tokens are always opaque, since they are only ever checked against the database
+func (s *AuthService) newRefreshToken(userID, deviceName string, familyID *uuid.UUID) (string, *domain.Token, error) {
+	tokenStr, err := security.GenerateRandomToken(32) // 32 bytes = 64 hex chars
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := domain.NewToken(userID, security.HashToken(tokenStr), time.Now().Add(s.config.Auth.RefreshExpiry))
+	token.TokenType = domain.TokenTypeRefresh
+	token.DeviceName = deviceName
+	token.FamilyID = familyID
+	if familyID == nil {
+		token.FamilyID = &token.TokenID
+	}
+
+	return tokenStr, token, nil
+}
+
+// newAccessToken generates an access token issued from the refresh token, lasting the configured token expiry
+// In jwt mode the token is a signed JWT; its hash is stored like an opaque token so sessions can be listed and revoked
+func (s *AuthService) newAccessToken(refresh *domain.Token) (string, *domain.Token, error) {
+	token := domain.NewToken(refresh.UserID, "", time.Now().Add(s.config.Auth.TokenExpiry))
+	token.DeviceName = refresh.DeviceName
+	token.RefreshTokenID = &refresh.TokenID
+	token.FamilyID = refresh.FamilyID
+
+	var tokenStr string
+	var err error
+	if s.config.Auth.TokenMode == config.TokenModeJWT {
+		tokenStr, err = security.GenerateToken(token.UserID, token.TokenID.String(), s.config.Auth.JWTSecret, s.config.Auth.TokenExpiry)
+	} else {
+		tokenStr, err = security.GenerateRandomToken(32)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	token.TokenHash = security.HashToken(tokenStr)
+
+	return tokenStr, token, nil
 }
 
 // ValidateToken validates a token and returns the user ID
+// JWTs are verified without touching the database unless the revocation check is enabled
 func (s *AuthService) ValidateToken(ctx context.Context, tokenStr string) (string, error) {
-	return s.tokenRepo.ValidateToken(ctx, tokenStr)
+	if s.config.Auth.TokenMode != config.TokenModeJWT {
+		return s.tokenRepo.ValidateToken(ctx, tokenStr)
+	}
+
+	claims, err := security.ParseToken(tokenStr, s.config.Auth.JWTSecret, s.config.Auth.ExpiryGrace)
+	if err != nil {
+		return "", errors.NewUnauthenticatedError("Invalid or expired token")
+	}
+
+	if s.config.Auth.JWTRevocationCheck {
+		userID, err := s.tokenRepo.ValidateToken(ctx, tokenStr)
+		if err != nil {
+			return "", err
+		}
+		if userID != claims.UserID {
+			return "", errors.NewUnauthenticatedError("Invalid or expired token")
+		}
+	}
+
+	return claims.UserID, nil
 }
 
-// RefreshToken validates a token and issues a new one
-func (s *AuthService) RefreshToken(ctx context.Context, tokenStr string) (string, error) {
-	// Validate the current token
-	userID, err := s.tokenRepo.ValidateToken(ctx, tokenStr)
+// RefreshToken exchanges a refresh token for a new access token
+// The refresh token keeps its expiry, unless rotation is enabled: then it is used up and replaced by a new one with a full lifetime
+// A used refresh token presented again means one of its copies was stolen, so every session of the user is revoked
+func (s *AuthService) RefreshToken(ctx context.Context, refreshTokenStr string) (*TokenPair, error) {
+	refreshTokenHash := security.HashToken(refreshTokenStr)
+	refresh, err := s.tokenRepo.GetByTokenHash(ctx, refreshTokenHash)
 	if err != nil {
-		return "", err
+		if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+			return nil, errors.NewUnauthenticatedError("Invalid or expired refresh token")
+		}
+		return nil, err
 	}
 
-	// Delete the old token
-	oldTokenHash := security.HashToken(tokenStr)
-	if err := s.tokenRepo.Delete(ctx, oldTokenHash); err != nil {
-		s.logger.Warn("Failed to delete old token", zap.Error(err))
-		// Non-critical error, continue
+	// Access tokens can't be used to refresh, and expired refresh tokens tolerate the same clock skew as access tokens
+	if refresh.TokenType != domain.TokenTypeRefresh || refresh.IsExpiredAt(time.Now(), s.config.Auth.ExpiryGrace) {
+		return nil, errors.NewUnauthenticatedError("Invalid or expired refresh token")
+	}
+
+	if refresh.Used {
+		s.logger.Warn("Rotated refresh token reused, revoking all sessions",
+			zap.String("user_id", refresh.UserID),
+			zap.String("token_id", refresh.TokenID.String()))
+		if _, err := s.tokenRepo.DeleteUserTokens(ctx, refresh.UserID); err != nil {
+			return nil, err
+		}
+		return nil, errors.NewUnauthenticatedError("Invalid or expired refresh token")
+	}
+
+	// Checked after reuse, so a stolen copy of an abandoned session's token still revokes everything
+	if refresh.IsIdleAt(time.Now(), s.config.Auth.IdleTimeout) {
+		if err := s.tokenRepo.DeleteSession(ctx, refreshTokenHash); err != nil {
+			s.logger.Warn("Failed to delete idle session", zap.Error(err), zap.String("token_id", refresh.TokenID.String()))
+		}
+		return nil, errors.NewUnauthenticatedError("Session expired due to inactivity")
+	}
+
+	if s.config.Auth.RefreshRotation {
+		// Rotating first means a refresh token raced by two requests only issues one access token
+		var next *domain.Token
+		refreshTokenStr, next, err = s.newRefreshToken(refresh.UserID, refresh.DeviceName, refresh.FamilyID)
+		if err != nil {
+			return nil, errors.NewInternalError("Failed to create token", err)
+		}
+		if err := s.tokenRepo.Rotate(ctx, refresh, next); err != nil {
+			return nil, err
+		}
+		refresh = next
 	}
 
-	// Create a new token
-	newToken, err := s.tokenRepo.CreateForUser(ctx, userID, s.config.Auth.TokenExpiry)
+	accessToken, access, err := s.newAccessToken(refresh)
 	if err != nil {
-		return "", errors.NewInternalError("Failed to create token", err)
+		return nil, errors.NewInternalError("Failed to create token", err)
+	}
+	if err := s.tokenRepo.Create(ctx, access); err != nil {
+		return nil, err
+	}
+
+	// A refresh uses the session; in jwt mode access tokens are checked without the database, so it may be the only sign of use
+	if err := s.tokenRepo.UpdateLastUsed(ctx, refresh.TokenID); err != nil {
+		s.logger.Warn("Failed to update session's last use", zap.Error(err), zap.String("token_id", refresh.TokenID.String()))
 	}
 
-	s.logger.Info("Token refreshed", zap.String("user_id", userID))
-	return newToken, nil
+	s.logger.Info("Token refreshed", zap.String("user_id", refresh.UserID), zap.String("token_id", refresh.TokenID.String()))
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  access.ExpiresAt,
+		RefreshToken:     refreshTokenStr,
+		RefreshExpiresAt: refresh.ExpiresAt,
+	}, nil
 }
 
-// Logout invalidates a token
+// Logout ends the session a token belongs to
 func (s *AuthService) Logout(ctx context.Context, tokenStr string) error {
 	tokenHash := security.HashToken(tokenStr)
-	if err := s.tokenRepo.Delete(ctx, tokenHash); err != nil {
+	if err := s.tokenRepo.DeleteSession(ctx, tokenHash); err != nil {
 		// Don't return an error if the token was not found
 		if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
 			s.logger.Warn("Token not found during logout", zap.String("token_hash", tokenHash))
@@ -116,6 +299,34 @@ func (s *AuthService) LogoutAll(ctx context.Context, userID string) error {
 	return nil
 }
 
+// RevokeSession logs out one of a user's sessions
+func (s *AuthService) RevokeSession(ctx context.Context, userID string, tokenID uuid.UUID) error {
+	if err := s.tokenRepo.DeleteByID(ctx, userID, tokenID); err != nil {
+		return err
+	}
+	s.logger.Info("Session revoked", zap.String("user_id", userID), zap.String("token_id", tokenID.String()))
+	return nil
+}
+
+// CurrentSessionID returns the ID of the session the token with the given hash belongs to
+func (s *AuthService) CurrentSessionID(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	token, err := s.tokenRepo.GetByTokenHash(ctx, tokenHash)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return token.SessionID(), nil
+}
+
+// ListSessions gets a page of a user's sessions, most recently used first
+func (s *AuthService) ListSessions(ctx context.Context, userID string, limit, offset int, activeOnly bool) ([]*domain.Token, error) {
+	return s.tokenRepo.GetSessionsByUserID(ctx, userID, limit, offset, activeOnly)
+}
+
+// CountActiveSessions counts a user's sessions that have not expired
+func (s *AuthService) CountActiveSessions(ctx context.Context, userID string) (int, error) {
+	return s.tokenRepo.CountActive(ctx, userID)
+}
+
 // CleanupExpiredTokens removes all expired tokens
 func (s *AuthService) CleanupExpiredTokens(ctx context.Context) error {
 	count, err := s.tokenRepo.CleanupExpired(ctx)
@@ -128,10 +339,13 @@ func (s *AuthService) CleanupExpiredTokens(ctx context.Context) error {
 	return nil
 }
 
-// ScheduleTokenCleanup starts a goroutine to periodically clean up expired tokens
-func (s *AuthService) ScheduleTokenCleanup(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Hour)
+// ScheduleTokenCleanup starts a goroutine to clean up expired tokens every configured cleanup interval
+// The goroutine runs until ctx is cancelled; the returned channel is closed once it has returned
+func (s *AuthService) ScheduleTokenCleanup(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	ticker := time.NewTicker(s.config.Auth.CleanupInterval)
 	go func() {
+		defer close(done)
 		for {
 			select {
 			case <-ticker.C:
@@ -144,7 +358,8 @@ func (s *AuthService) ScheduleTokenCleanup(ctx context.Context) {
 			}
 		}
 	}()
-	s.logger.Info("Scheduled token cleanup")
+	s.logger.Info("Scheduled token cleanup", zap.Duration("interval", s.config.Auth.CleanupInterval))
+	return done
 }
 
 // UpdateUserActivity updates a user's last active timestamp
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/security"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+func newJWTAuthService(t *testing.T) *AuthService {
+	cfg := &config.Config{}
+	cfg.Auth.TokenMode = config.TokenModeJWT
+	cfg.Auth.JWTSecret = "test-secret"
+	cfg.Auth.TokenExpiry = time.Hour
+	cfg.Auth.RefreshExpiry = 30 * 24 * time.Hour
+
+	// No repositories: JWT validation must not need the database
+	return NewAuthService(nil, nil, cfg, zaptest.NewLogger(t))
+}
+
+func TestValidateTokenJWTWithoutDatabase(t *testing.T) {
+	svc := newJWTAuthService(t)
+
+	tokenStr, err := security.GenerateToken("user-1", "token-1", "test-secret", time.Hour)
+	require.NoError(t, err)
+
+	userID, err := svc.ValidateToken(context.Background(), tokenStr)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+}
+
+func TestValidateTokenJWTRejectsInvalidTokens(t *testing.T) {
+	svc := newJWTAuthService(t)
+
+	forged, err := security.GenerateToken("user-1", "token-1", "wrong-secret", time.Hour)
+	require.NoError(t, err)
+	expired, err := security.GenerateToken("user-1", "token-1", "test-secret", -time.Hour)
+	require.NoError(t, err)
+
+	for name, tokenStr := range map[string]string{
+		"forged":  forged,
+		"expired": expired,
+		"opaque":  "0123456789abcdef",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.ValidateToken(context.Background(), tokenStr)
+			appErr, ok := errors.IsAppError(err)
+			require.True(t, ok)
+			assert.Equal(t, errors.ErrCodeUnauthenticated, appErr.Code)
+		})
+	}
+}
+
+func TestNewAccessAndRefreshTokens(t *testing.T) {
+	svc := newJWTAuthService(t)
+
+	refreshToken, refresh, err := svc.newRefreshToken("user-1", "Phone", nil)
+	require.NoError(t, err)
+	assert.Equal(t, domain.TokenTypeRefresh, refresh.TokenType)
+	require.NotNil(t, refresh.FamilyID)
+	assert.Equal(t, refresh.TokenID, *refresh.FamilyID, "a new login starts its own family")
+	assert.Equal(t, security.HashToken(refreshToken), refresh.TokenHash)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), refresh.ExpiresAt, time.Minute)
+
+	// The access token is a short-lived JWT in the refresh token's session
+	accessToken, access, err := svc.newAccessToken(refresh)
+	require.NoError(t, err)
+	assert.Equal(t, domain.TokenTypeAccess, access.TokenType)
+	assert.Equal(t, security.HashToken(accessToken), access.TokenHash)
+	assert.Equal(t, refresh.TokenID, access.SessionID())
+	assert.Equal(t, refresh.FamilyID, access.FamilyID)
+	assert.Equal(t, "Phone", access.DeviceName)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), access.ExpiresAt, time.Minute)
+
+	claims, err := security.ParseToken(accessToken, "test-secret", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+
+	// A refresh token is never accepted as an access token
+	_, err = svc.ValidateToken(context.Background(), refreshToken)
+	assert.Error(t, err)
+
+	// Rotated refresh tokens stay in the family
+	_, next, err := svc.newRefreshToken("user-1", "Phone", refresh.FamilyID)
+	require.NoError(t, err)
+	assert.NotEqual(t, refresh.TokenID, next.TokenID)
+	assert.Equal(t, refresh.FamilyID, next.FamilyID)
+}
+
+func TestLoginUnknownUsernameIsIndistinguishable(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.Auth.TokenExpiry = time.Hour
+	cfg.Auth.RefreshExpiry = 30 * 24 * time.Hour
+	userRepo := repository.NewUserRepository(db)
+	svc := NewAuthService(userRepo, repository.NewTokenRepository(db), cfg, zaptest.NewLogger(t))
+
+	user := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, user))
+	t.Cleanup(func() { _ = userRepo.Delete(context.Background(), user.UserID) })
+
+	start := time.Now()
+	_, err := svc.Login(ctx, user.Username, "Phone")
+	require.NoError(t, err)
+	known := time.Since(start)
+
+	start = time.Now()
+	_, err = svc.Login(ctx, "no_such_user_"+uuid.NewString()[:8], "Phone")
+	unknown := time.Since(start)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeUnauthenticated, appErr.Code)
+	assert.Equal(t, invalidLoginMessage, appErr.Message, "the error must not depend on the username")
+
+	// Both are padded to the same duration, so timing doesn't tell them apart either
+	assert.GreaterOrEqual(t, known, minLoginDuration)
+	assert.GreaterOrEqual(t, unknown, minLoginDuration)
+	assert.InDelta(t, float64(known), float64(unknown), float64(minLoginDuration/4))
+}
+
+func TestWaitUntil(t *testing.T) {
+	start := time.Now()
+	waitUntil(context.Background(), start.Add(20*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// A cancelled request stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	waitUntil(ctx, start.Add(time.Hour))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRefreshTokenKeepsJWTSessionActive(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.Auth.TokenMode = config.TokenModeJWT
+	cfg.Auth.JWTSecret = "test-secret"
+	cfg.Auth.TokenExpiry = time.Hour
+	cfg.Auth.RefreshExpiry = 30 * 24 * time.Hour
+	cfg.Auth.IdleTimeout = time.Hour
+	db.Config = cfg
+	userRepo := repository.NewUserRepository(db)
+	tokenRepo := repository.NewTokenRepository(db)
+	svc := NewAuthService(userRepo, tokenRepo, cfg, zaptest.NewLogger(t))
+
+	user := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, user))
+	t.Cleanup(func() { _ = userRepo.Delete(context.Background(), user.UserID) })
+
+	tokens, err := svc.Login(ctx, user.Username, "Phone")
+	require.NoError(t, err)
+
+	// Access tokens are JWTs checked without the database, so only refreshing shows the session is in use
+	refreshHash := security.HashToken(tokens.RefreshToken)
+	_, err = db.Pool.Exec(ctx, "UPDATE tokens SET last_used = $1 WHERE token_hash = $2", time.Now().Add(-50*time.Minute), refreshHash)
+	require.NoError(t, err)
+
+	refreshed, err := svc.RefreshToken(ctx, tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, tokens.RefreshToken, refreshed.RefreshToken, "without rotation the refresh token is kept")
+
+	stored, err := tokenRepo.GetByTokenHash(ctx, refreshHash)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), stored.LastUsed, time.Minute)
+}
+
+func TestScheduleTokenCleanupStopsWhenCancelled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.CleanupInterval = time.Hour
+	service := NewAuthService(nil, nil, cfg, zaptest.NewLogger(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := service.ScheduleTokenCleanup(ctx)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("token cleanup still running after its context was cancelled")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+)
+
+// BlockService provides blocklist business logic
+type BlockService struct {
+	blockRepo *repository.BlockRepository
+	logger    *zap.Logger
+}
+
+// NewBlockService creates a new BlockService
+func NewBlockService(
+	blockRepo *repository.BlockRepository,
+	logger *zap.Logger,
+) *BlockService {
+	return &BlockService{
+		blockRepo: blockRepo,
+		logger:    logger.With(zap.String("service", "block")),
+	}
+}
+
+// Block stops a public key from sending messages to a user
+func (s *BlockService) Block(ctx context.Context, userID, blockedPubKey string) (*domain.Block, error) {
+	if blockedPubKey == "" {
+		return nil, errors.NewValidationError("Blocked public key is required", nil)
+	}
+
+	block := domain.NewBlock(userID, blockedPubKey)
+	if err := s.blockRepo.Create(ctx, block); err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("Public key blocked",
+		zap.String("user_id", userID),
+		zap.String("blocked_pubkey", blockedPubKey),
+	)
+
+	return block, nil
+}
+
+// Unblock lets a blocked public key send messages to a user again
+func (s *BlockService) Unblock(ctx context.Context, userID, blockedPubKey string) error {
+	if blockedPubKey == "" {
+		return errors.NewValidationError("Blocked public key is required", nil)
+	}
+
+	if err := s.blockRepo.Delete(ctx, userID, blockedPubKey); err != nil {
+		return err
+	}
+
+	s.logger.Debug("Public key unblocked",
+		zap.String("user_id", userID),
+		zap.String("blocked_pubkey", blockedPubKey),
+	)
+
+	return nil
+}
+
+// IsBlocked checks whether a user has blocked a sender's public key
+func (s *BlockService) IsBlocked(ctx context.Context, userID, senderPubKey string) (bool, error) {
+	return s.blockRepo.Exists(ctx, userID, senderPubKey)
+}
+
+// GetBlocks gets all public keys a user has blocked
+func (s *BlockService) GetBlocks(ctx context.Context, userID string) ([]*domain.Block, error) {
+	return s.blockRepo.GetByUserID(ctx, userID)
+}
@@ -2,53 +2,65 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
 
 	"go.uber.org/zap"
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/security"
 )
 
 // ContactService provides contact business logic
 type ContactService struct {
 	contactRepo *repository.ContactRepository
+	userRepo    *repository.UserRepository
 	logger      *zap.Logger
 }
 
 // NewContactService creates a new ContactService
 func NewContactService(
 	contactRepo *repository.ContactRepository,
+	userRepo *repository.UserRepository,
 	logger *zap.Logger,
 ) *ContactService {
 	return &ContactService{
 		contactRepo: contactRepo,
+		userRepo:    userRepo,
 		logger:      logger.With(zap.String("service", "contact")),
 	}
 }
 
 // AddContact adds a new contact for a user
-func (s *ContactService) AddContact(ctx context.Context, userID, contactPubKey, nickname string) (*domain.Contact, error) {
+// An empty group leaves the contact ungrouped
+func (s *ContactService) AddContact(ctx context.Context, userID, contactPubKey, nickname, group string) (*domain.Contact, error) {
 	// Validate inputs
 	if contactPubKey == "" {
 		return nil, errors.NewValidationError("Contact public key is required", nil)
 	}
 
-	if nickname == "" {
-		return nil, errors.NewValidationError("Nickname is required", nil)
+	if err := validatePublicKey(contactPubKey, "contact public key"); err != nil {
+		return nil, err
+	}
+
+	if err := validateNickname(nickname); err != nil {
+		return nil, err
 	}
 
-	if len(nickname) > 50 {
-		return nil, errors.NewValidationError("Nickname must be at most 50 characters", nil)
+	if err := validateGroupName(group); err != nil {
+		return nil, err
 	}
 
 	// Create the contact
 	contact := domain.NewContact(userID, contactPubKey, nickname)
+	contact.GroupName = group
 
 	// Store the contact
 	if err := s.contactRepo.Create(ctx, contact); err != nil {
 		return nil, err
 	}
+	s.addUsernames(ctx, contact)
 
 	s.logger.Debug("Contact added",
 		zap.String("user_id", userID),
@@ -59,9 +71,107 @@ func (s *ContactService) AddContact(ctx context.Context, userID, contactPubKey,
 	return contact, nil
 }
 
-// GetContacts gets all contacts for a user
-func (s *ContactService) GetContacts(ctx context.Context, userID string) ([]*domain.Contact, error) {
-	return s.contactRepo.GetByUserID(ctx, userID)
+// ContactInput is a contact to import
+type ContactInput struct {
+	ContactPubKey string
+	Nickname      string
+	GroupName     string
+}
+
+// ImportContacts adds many contacts for a user at once
+// Invalid entries are counted and left out; contacts the user already has are counted as skipped
+func (s *ContactService) ImportContacts(ctx context.Context, userID string, inputs []ContactInput) (created, skipped, invalid int, err error) {
+	contacts := make([]*domain.Contact, 0, len(inputs))
+	for _, input := range inputs {
+		if validatePublicKey(input.ContactPubKey, "contact public key") != nil || validateNickname(input.Nickname) != nil || validateGroupName(input.GroupName) != nil {
+			invalid++
+			continue
+		}
+
+		contact := domain.NewContact(userID, input.ContactPubKey, input.Nickname)
+		contact.GroupName = input.GroupName
+		contacts = append(contacts, contact)
+	}
+
+	if len(contacts) > 0 {
+		created, err = s.contactRepo.CreateMany(ctx, contacts)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	skipped = len(contacts) - created
+
+	s.logger.Debug("Contacts imported",
+		zap.String("user_id", userID),
+		zap.Int("created", created),
+		zap.Int("skipped", skipped),
+		zap.Int("invalid", invalid),
+	)
+
+	return created, skipped, invalid, nil
+}
+
+// GetContacts gets a page of a user's contacts, along with how many the user has in total
+func (s *ContactService) GetContacts(ctx context.Context, userID string, limit, offset int) ([]*domain.Contact, int, error) {
+	contacts, err := s.contactRepo.GetByUserID(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.contactRepo.CountByUserID(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.addUsernames(ctx, contacts...)
+	return contacts, total, nil
+}
+
+// GetContactsByGroup gets a page of a user's contacts filed under a group, along with how many the group holds
+func (s *ContactService) GetContactsByGroup(ctx context.Context, userID, group string, limit, offset int) ([]*domain.Contact, int, error) {
+	if err := validateGroupName(group); err != nil {
+		return nil, 0, err
+	}
+	contacts, err := s.contactRepo.GetByGroup(ctx, userID, group, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.contactRepo.CountByGroup(ctx, userID, group)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.addUsernames(ctx, contacts...)
+	return contacts, total, nil
+}
+
+// addUsernames fills in the username registered for each contact's key, looking them all up at once
+// Usernames are only a convenience, so a failed lookup leaves them empty rather than failing the request
+func (s *ContactService) addUsernames(ctx context.Context, contacts ...*domain.Contact) {
+	if len(contacts) == 0 {
+		return
+	}
+
+	keys := make([]string, len(contacts))
+	for i, contact := range contacts {
+		keys[i] = contact.ContactPubKey
+	}
+
+	usernames, err := s.userRepo.GetUsernamesByPublicKeys(ctx, keys)
+	if err != nil {
+		s.logger.Warn("Failed to look up contact usernames", zap.Error(err))
+		return
+	}
+
+	for _, contact := range contacts {
+		contact.Username = usernames[contact.ContactPubKey]
+	}
+}
+
+// GetGroups gets a user's contact groups with how many contacts each holds
+func (s *ContactService) GetGroups(ctx context.Context, userID string) ([]*domain.ContactGroup, error) {
+	return s.contactRepo.GetGroups(ctx, userID)
 }
 
 // GetContact gets a specific contact
@@ -70,22 +180,61 @@ func (s *ContactService) GetContact(ctx context.Context, userID, contactPubKey s
 		return nil, errors.NewValidationError("Contact public key is required", nil)
 	}
 
-	return s.contactRepo.GetByContactPubKey(ctx, userID, contactPubKey)
+	contact, err := s.contactRepo.GetByContactPubKey(ctx, userID, contactPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addUsernames(ctx, contact)
+	return contact, nil
 }
 
-// UpdateContact updates a contact's nickname
-func (s *ContactService) UpdateContact(ctx context.Context, userID, contactPubKey, nickname string) (*domain.Contact, error) {
+// GetIncomingContacts gets the users who have added the current user as a contact
+func (s *ContactService) GetIncomingContacts(ctx context.Context, userID string) ([]*domain.IncomingContact, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.contactRepo.GetUsersWhoAddedKey(ctx, base64.URLEncoding.EncodeToString(user.PublicKey))
+}
+
+// GetSafetyNumber gets the safety number for the user and a contact's public key
+// Both users get the same number, so comparing it out of band confirms neither key was substituted
+func (s *ContactService) GetSafetyNumber(ctx context.Context, userID, contactPubKey string) (string, error) {
+	if contactPubKey == "" {
+		return "", errors.NewValidationError("Contact public key is required", nil)
+	}
+
+	contactKey, err := base64.URLEncoding.DecodeString(contactPubKey)
+	if err != nil {
+		return "", errors.NewValidationError("Invalid contact public key format", err)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return security.SafetyNumber(user.PublicKey, contactKey), nil
+}
+
+// UpdateContact updates a contact's nickname and group
+// A nil group keeps the current group and an empty one ungroups the contact
+func (s *ContactService) UpdateContact(ctx context.Context, userID, contactPubKey, nickname string, group *string) (*domain.Contact, error) {
 	// Validate inputs
 	if contactPubKey == "" {
 		return nil, errors.NewValidationError("Contact public key is required", nil)
 	}
 
-	if nickname == "" {
-		return nil, errors.NewValidationError("Nickname is required", nil)
+	if err := validateNickname(nickname); err != nil {
+		return nil, err
 	}
 
-	if len(nickname) > 50 {
-		return nil, errors.NewValidationError("Nickname must be at most 50 characters", nil)
+	if group != nil {
+		if err := validateGroupName(*group); err != nil {
+			return nil, err
+		}
 	}
 
 	// Get the current contact
@@ -94,18 +243,23 @@ func (s *ContactService) UpdateContact(ctx context.Context, userID, contactPubKe
 		return nil, err
 	}
 
-	// Update the nickname
+	// Update the nickname and group
 	contact.Nickname = nickname
+	if group != nil {
+		contact.GroupName = *group
+	}
 
 	// Store the updated contact
 	if err := s.contactRepo.Update(ctx, contact); err != nil {
 		return nil, err
 	}
+	s.addUsernames(ctx, contact)
 
 	s.logger.Debug("Contact updated",
 		zap.String("user_id", userID),
 		zap.String("contact_pubkey", contactPubKey),
 		zap.String("nickname", nickname),
+		zap.String("group", contact.GroupName),
 	)
 
 	return contact, nil
@@ -129,6 +283,31 @@ func (s *ContactService) DeleteContact(ctx context.Context, userID, contactPubKe
 	return nil
 }
 
+// CountContacts counts the user's contacts
+func (s *ContactService) CountContacts(ctx context.Context, userID string) (int, error) {
+	return s.contactRepo.CountByUserID(ctx, userID)
+}
+
+// DeleteContacts deletes the user's contacts with the given public keys and returns how many were deleted
+func (s *ContactService) DeleteContacts(ctx context.Context, userID string, contactPubKeys []string) (int64, error) {
+	if len(contactPubKeys) == 0 {
+		return 0, errors.NewValidationError("At least one contact public key is required", nil)
+	}
+
+	count, err := s.contactRepo.DeleteMany(ctx, userID, contactPubKeys)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Debug("Contacts deleted",
+		zap.String("user_id", userID),
+		zap.Int("requested", len(contactPubKeys)),
+		zap.Int64("deleted", count),
+	)
+
+	return count, nil
+}
+
 // DeleteUserContacts deletes all contacts for a user
 func (s *ContactService) DeleteUserContacts(ctx context.Context, userID string) (int64, error) {
 	count, err := s.contactRepo.DeleteUserContacts(ctx, userID)
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestImportContactsCountsInvalid(t *testing.T) {
+	// Nothing valid is left to store, so the repository is never reached
+	svc := NewContactService(nil, nil, zaptest.NewLogger(t))
+
+	created, skipped, invalid, err := svc.ImportContacts(context.Background(), "user", []ContactInput{
+		{ContactPubKey: "", Nickname: "no key"},
+		{ContactPubKey: "key-1", Nickname: ""},
+		{ContactPubKey: "key-2", Nickname: strings.Repeat("a", 51)},
+		{ContactPubKey: "key-3", Nickname: "bad group", GroupName: strings.Repeat("g", 51)},
+		{ContactPubKey: "key-4", Nickname: "not a public key"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, created)
+	assert.Equal(t, 0, skipped)
+	assert.Equal(t, 5, invalid)
+}
@@ -3,20 +3,39 @@ package service
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"sort"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
 	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/pkg/metrics"
+)
+
+const (
+	// maxMessageTTL is the longest lifetime a sender may give a message
+	maxMessageTTL = 30 * 24 * time.Hour
+	// expiredMessageCleanupInterval is how often expired messages are deleted
+	expiredMessageCleanupInterval = time.Minute
+	// deletedMessagePurgeInterval is how often soft-deleted messages past their retention are purged
+	deletedMessagePurgeInterval = time.Hour
+	// deliveryUpdateTimeout bounds the background write that marks fetched messages delivered
+	deliveryUpdateTimeout = 5 * time.Second
 )
 
 // MessageService provides message business logic
 type MessageService struct {
 	messageRepo *repository.MessageRepository
 	userRepo    *repository.UserRepository
+	blocks      *BlockService
+	hub         *realtime.Hub // Notified of delivered messages; may be nil
+	config      *config.Config
 	logger      *zap.Logger
 }
 
@@ -24,26 +43,39 @@ type MessageService struct {
 func NewMessageService(
 	messageRepo *repository.MessageRepository,
 	userRepo *repository.UserRepository,
+	blocks *BlockService,
+	hub *realtime.Hub,
+	config *config.Config,
 	logger *zap.Logger,
 ) *MessageService {
 	return &MessageService{
 		messageRepo: messageRepo,
 		userRepo:    userRepo,
+		blocks:      blocks,
+		hub:         hub,
+		config:      config,
 		logger:      logger.With(zap.String("service", "message")),
 	}
 }
 
 // SendMessage sends a new message
 // Note: In zero-knowledge architecture, message is encrypted client-side
+// A non-zero expiresIn deletes the message that long after it is sent
+// A client-chosen messageID makes retries safe: sending the same ID again returns the stored message
 func (s *MessageService) SendMessage(ctx context.Context, userID, recipientPubKey string,
 	ciphertextKEMB64, ciphertextMsgB64, nonceB64 string,
-	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string) (*domain.Message, error) {
+	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string,
+	replyToMessageID string, expiresIn time.Duration, messageID string) (*domain.Message, error) {
 
 	// Validate inputs
 	if recipientPubKey == "" {
 		return nil, errors.NewValidationError("Recipient public key is required", nil)
 	}
 
+	if expiresIn < 0 || expiresIn > maxMessageTTL {
+		return nil, errors.NewValidationError("Message lifetime must be between 1 second and 30 days", nil)
+	}
+
 	if ciphertextKEMB64 == "" || ciphertextMsgB64 == "" || nonceB64 == "" {
 		return nil, errors.NewValidationError("Ciphertext KEM, ciphertext message, and nonce are required", nil)
 	}
@@ -83,15 +115,86 @@ func (s *MessageService) SendMessage(ctx context.Context, userID, recipientPubKe
 		return nil, errors.NewValidationError("Invalid sender nonce format", err)
 	}
 
+	// Both copies of the message are stored, so each is bounded
+	if maxBytes := s.config.Messages.MaxCiphertextBytes; maxBytes > 0 && (len(ciphertextMsg) > maxBytes || len(senderCiphertextMsg) > maxBytes) {
+		return nil, errors.NewValidationError(fmt.Sprintf("Ciphertext message must be at most %d bytes", maxBytes), nil)
+	}
+
+	// A key that isn't a valid public key can never receive the message
+	if err := validatePublicKey(recipientPubKey, "recipient public key"); err != nil {
+		return nil, err
+	}
+
+	clientMessageID := uuid.Nil
+	if messageID != "" {
+		id, err := uuid.Parse(messageID)
+		if err != nil {
+			return nil, errors.NewValidationError("Invalid message ID format", err)
+		}
+		clientMessageID = id
+	}
+
+	var replyToID *uuid.UUID
+	if replyToMessageID != "" {
+		id, err := uuid.Parse(replyToMessageID)
+		if err != nil {
+			return nil, errors.NewValidationError("Invalid reply-to message ID format", err)
+		}
+		replyToID = &id
+	}
+
+	recipient, err := s.findRecipient(ctx, recipientPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// In strict mode, messages to unknown public keys are rejected rather than stored as failed
+	if recipient == nil && s.config.Messages.RequireKnownRecipient {
+		return nil, errors.NewNotFoundError("Recipient")
+	}
+
 	// Get the sender's public key
 	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		return nil, errors.NewInternalError("Failed to get sender information", err)
 	}
+	senderPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	// A retry of a message that was already stored gets the stored message back
+	if clientMessageID != uuid.Nil {
+		existing, err := s.messageRepo.GetByID(ctx, clientMessageID)
+		if err == nil {
+			if existing.SenderPubKey != senderPubKey || existing.RecipientPubKey != recipientPubKey {
+				return nil, errors.NewConflictError(fmt.Sprintf("Message with ID '%s' already exists", clientMessageID))
+			}
+			return existing, nil
+		}
+		if appErr, ok := errors.IsAppError(err); !ok || appErr.Code != errors.ErrCodeNotFound {
+			return nil, err
+		}
+	}
+
+	if err := s.checkNotBlocked(ctx, recipient, senderPubKey); err != nil {
+		return nil, err
+	}
+
+	// Check the replied-to message belongs to this conversation
+	if replyToID != nil {
+		replyTo, err := s.messageRepo.GetByID(ctx, *replyToID)
+		if err != nil {
+			if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+				return nil, errors.NewValidationError("Reply-to message not found", nil)
+			}
+			return nil, err
+		}
+		if err := validateReplyReference(replyTo, senderPubKey, recipientPubKey); err != nil {
+			return nil, err
+		}
+	}
 
 	// Create the message
 	message := domain.NewMessage(
-		base64.URLEncoding.EncodeToString(user.PublicKey),
+		senderPubKey,
 		recipientPubKey,
 		ciphertextKEM,
 		ciphertextMsg,
@@ -100,104 +203,738 @@ func (s *MessageService) SendMessage(ctx context.Context, userID, recipientPubKe
 		senderCiphertextMsg,
 		senderNonce,
 	)
+	if clientMessageID != uuid.Nil {
+		message.MessageID = clientMessageID
+	}
+	message.ReplyToMessageID = replyToID
+	if expiresIn > 0 {
+		message.ExpireAfter(expiresIn)
+	}
+
+	// Messages to a recipient who can't receive them are kept as failed so the sender can resend them
+	if recipient == nil {
+		message.Status = domain.MessageStatusFailed
+	}
 
 	// Store the message
 	if err := s.messageRepo.Create(ctx, message); err != nil {
 		return nil, errors.NewInternalError("Failed to store message", err)
 	}
 
-	s.logger.Debug("Message sent",
-		zap.String("message_id", message.MessageID.String()),
+	if message.Status == domain.MessageStatusFailed {
+		metrics.RecordMessage("undeliverable")
+		s.logger.Info("Message undeliverable, recipient not found",
+			zap.String("message_id", message.MessageID.String()),
+			zap.String("sender", userID),
+			zap.String("recipient", recipientPubKey),
+		)
+		s.publishFailed(message)
+	} else {
+		metrics.RecordMessage("sent")
+		s.logger.Debug("Message sent",
+			zap.String("message_id", message.MessageID.String()),
+			zap.String("sender", userID),
+			zap.String("recipient", recipientPubKey),
+		)
+		s.hub.Publish(message)
+	}
+
+	return message, nil
+}
+
+// RecipientCopy is a message encrypted for one of its recipients
+type RecipientCopy struct {
+	RecipientPubKey string
+	CiphertextKEM   string
+	CiphertextMsg   string
+	Nonce           string
+}
+
+// SendMultiMessage sends the same message to several recipients, each with their own ciphertext, sharing one sender copy
+// Every copy is checked before any is stored, and all of them are stored in one transaction, so either all are sent or none are
+// Copies to recipients who can't receive them are kept as failed, as with SendMessage; the messages are returned in the order of copies
+func (s *MessageService) SendMultiMessage(ctx context.Context, userID string, copies []RecipientCopy,
+	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string, expiresIn time.Duration) ([]*domain.Message, error) {
+
+	if len(copies) == 0 || len(copies) > domain.MaxMessageRecipients {
+		return nil, errors.NewValidationError(fmt.Sprintf("Between 1 and %d recipients are required", domain.MaxMessageRecipients), nil)
+	}
+
+	if expiresIn < 0 || expiresIn > maxMessageTTL {
+		return nil, errors.NewValidationError("Message lifetime must be between 1 second and 30 days", nil)
+	}
+
+	senderCiphertextKEM, senderCiphertextMsg, senderNonce, err := s.decodeCiphertext(senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64, "sender ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode and check every copy before looking anything up
+	type ciphertext struct{ kem, msg, nonce []byte }
+	decoded := make([]ciphertext, len(copies))
+	seen := make(map[string]bool, len(copies))
+	for i, cp := range copies {
+		if err := validatePublicKey(cp.RecipientPubKey, "recipient public key"); err != nil {
+			return nil, recipientError(i, err)
+		}
+		if seen[cp.RecipientPubKey] {
+			return nil, errors.NewValidationError(fmt.Sprintf("Recipient %d: duplicate recipient public key", i), nil)
+		}
+		seen[cp.RecipientPubKey] = true
+
+		ciphertextKEM, ciphertextMsg, nonce, err := s.decodeCiphertext(cp.CiphertextKEM, cp.CiphertextMsg, cp.Nonce, "")
+		if err != nil {
+			return nil, recipientError(i, err)
+		}
+		decoded[i] = ciphertext{ciphertextKEM, ciphertextMsg, nonce}
+	}
+
+	// Get the sender's public key
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, errors.NewInternalError("Failed to get sender information", err)
+	}
+	senderPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	messages := make([]*domain.Message, len(copies))
+	for i, cp := range copies {
+		recipient, err := s.findRecipient(ctx, cp.RecipientPubKey)
+		if err != nil {
+			return nil, err
+		}
+		if recipient == nil && s.config.Messages.RequireKnownRecipient {
+			return nil, errors.NewNotFoundError("Recipient")
+		}
+		if err := s.checkNotBlocked(ctx, recipient, senderPubKey); err != nil {
+			return nil, err
+		}
+
+		message := domain.NewMessage(
+			senderPubKey,
+			cp.RecipientPubKey,
+			decoded[i].kem,
+			decoded[i].msg,
+			decoded[i].nonce,
+			senderCiphertextKEM,
+			senderCiphertextMsg,
+			senderNonce,
+		)
+		if expiresIn > 0 {
+			message.ExpireAfter(expiresIn)
+		}
+		if recipient == nil {
+			message.Status = domain.MessageStatusFailed
+		}
+		messages[i] = message
+	}
+
+	// Store every copy at once
+	if _, err := s.messageRepo.CreateBatch(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	failed := 0
+	for _, message := range messages {
+		if message.Status == domain.MessageStatusFailed {
+			metrics.RecordMessage("undeliverable")
+			s.publishFailed(message)
+			failed++
+			continue
+		}
+		metrics.RecordMessage("sent")
+		s.hub.Publish(message)
+	}
+
+	s.logger.Debug("Message sent to multiple recipients",
 		zap.String("sender", userID),
-		zap.String("recipient", recipientPubKey),
+		zap.Int("recipients", len(messages)),
+		zap.Int("undeliverable", failed),
 	)
 
+	return messages, nil
+}
+
+// decodeCiphertext decodes the base64 KEM ciphertext, message ciphertext and nonce of one copy of a message
+// prefix names the copy in errors, such as "sender "; the message ciphertext is bounded by MAX_CIPHERTEXT_BYTES
+func (s *MessageService) decodeCiphertext(kemB64, msgB64, nonceB64, prefix string) (kem, msg, nonce []byte, err error) {
+	if kemB64 == "" || msgB64 == "" || nonceB64 == "" {
+		return nil, nil, nil, errors.NewValidationError(fmt.Sprintf("The %sciphertext KEM, ciphertext message, and nonce are required", prefix), nil)
+	}
+
+	if kem, err = base64.URLEncoding.DecodeString(kemB64); err != nil {
+		return nil, nil, nil, errors.NewValidationError(fmt.Sprintf("Invalid %sciphertext KEM format", prefix), err)
+	}
+	if msg, err = base64.URLEncoding.DecodeString(msgB64); err != nil {
+		return nil, nil, nil, errors.NewValidationError(fmt.Sprintf("Invalid %sciphertext message format", prefix), err)
+	}
+	if nonce, err = base64.URLEncoding.DecodeString(nonceB64); err != nil {
+		return nil, nil, nil, errors.NewValidationError(fmt.Sprintf("Invalid %snonce format", prefix), err)
+	}
+
+	if maxBytes := s.config.Messages.MaxCiphertextBytes; maxBytes > 0 && len(msg) > maxBytes {
+		return nil, nil, nil, errors.NewValidationError(fmt.Sprintf("Ciphertext message must be at most %d bytes", maxBytes), nil)
+	}
+
+	return kem, msg, nonce, nil
+}
+
+// recipientError prefixes a validation error with the index of the recipient it is about
+func recipientError(index int, err error) error {
+	if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeValidation {
+		return errors.NewValidationError(fmt.Sprintf("Recipient %d: %s", index, appErr.Message), appErr.Err)
+	}
+	return err
+}
+
+// findRecipient gets the user who receives messages sent to the public key
+// It returns nil without an error when no such user exists; a key that isn't valid base64 is a validation error
+func (s *MessageService) findRecipient(ctx context.Context, recipientPubKey string) (*domain.User, error) {
+	recipient, err := s.userRepo.GetByPublicKey(ctx, recipientPubKey)
+	if err != nil {
+		if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return recipient, nil
+}
+
+// publishFailed tells the sender's sockets that a message they sent could not be delivered
+func (s *MessageService) publishFailed(message *domain.Message) {
+	s.hub.PublishReceipt(&domain.MessageReceipt{
+		MessageID:    message.MessageID,
+		SenderPubKey: message.SenderPubKey,
+		Status:       domain.MessageStatusFailed,
+		UpdatedAt:    time.Now(),
+	})
+}
+
+// checkNotBlocked refuses messages from senders the recipient has blocked
+// The error doesn't mention the block so senders can't tell they are blocked
+func (s *MessageService) checkNotBlocked(ctx context.Context, recipient *domain.User, senderPubKey string) error {
+	if recipient == nil {
+		return nil
+	}
+
+	blocked, err := s.blocks.IsBlocked(ctx, recipient.UserID, senderPubKey)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errors.NewUnauthorizedError("Message could not be sent")
+	}
+	return nil
+}
+
+// GetFailedMessages gets the messages a user sent that could not be delivered, with pagination
+func (s *MessageService) GetFailedMessages(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
+	return s.messageRepo.GetBySenderAndStatus(ctx, userPubKey, domain.MessageStatusFailed, limit, offset)
+}
+
+// ResendMessage retries delivery of a failed message once its recipient is available again
+// Only the sender can resend a message; anyone else gets not found
+func (s *MessageService) ResendMessage(ctx context.Context, userPubKey string, messageID uuid.UUID) (*domain.Message, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.SenderPubKey != userPubKey {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	if message.Status != domain.MessageStatusFailed {
+		return nil, errors.NewValidationError("Only failed messages can be resent", nil)
+	}
+
+	recipient, err := s.findRecipient(ctx, message.RecipientPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, errors.NewValidationError("Recipient is still unavailable", nil)
+	}
+	if err := s.checkNotBlocked(ctx, recipient, userPubKey); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.messageRepo.UpdateStatus(ctx, messageID, domain.MessageStatusSent)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		// Resent by another request in the meantime
+		return nil, errors.NewValidationError("Only failed messages can be resent", nil)
+	}
+	message.Status = domain.MessageStatusSent
+	s.hub.Publish(message)
+
+	s.logger.Debug("Message resent", zap.String("message_id", messageID.String()))
+
 	return message, nil
 }
 
-// GetMessageByID gets a message by its ID
-func (s *MessageService) GetMessageByID(ctx context.Context, messageID uuid.UUID) (*domain.Message, error) {
-	return s.messageRepo.GetByID(ctx, messageID)
+// validateReplyReference checks that a replied-to message was exchanged between the sender and recipient
+// Messages from other conversations are reported as not found so their existence is not leaked
+func validateReplyReference(replyTo *domain.Message, senderPubKey, recipientPubKey string) error {
+	if !replyTo.IsBetween(senderPubKey, recipientPubKey) {
+		return errors.NewValidationError("Reply-to message not found", nil)
+	}
+	return nil
 }
 
-// GetMessagesForUser gets all messages for a user (both sent and received) with pagination
-func (s *MessageService) GetMessagesForUser(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
-	if limit <= 0 {
-		limit = 100 // Default limit
+// GetReplyReferences gets the messages replied to by the given messages, keyed by message ID
+func (s *MessageService) GetReplyReferences(ctx context.Context, messages []*domain.Message) (map[uuid.UUID]*domain.Message, error) {
+	references := make(map[uuid.UUID]*domain.Message)
+
+	// Messages already in the page don't need to be fetched again
+	loaded := make(map[uuid.UUID]*domain.Message, len(messages))
+	for _, msg := range messages {
+		loaded[msg.MessageID] = msg
 	}
-	if limit > 1000 {
-		limit = 1000 // Max limit
+
+	var missing []uuid.UUID
+	for _, msg := range messages {
+		if msg.ReplyToMessageID == nil {
+			continue
+		}
+		if ref, ok := loaded[*msg.ReplyToMessageID]; ok {
+			references[ref.MessageID] = ref
+		} else {
+			missing = append(missing, *msg.ReplyToMessageID)
+		}
 	}
 
-	// Get messages where user is recipient
-	receivedMessages, err := s.messageRepo.GetByRecipient(ctx, userPubKey, limit, offset)
+	fetched, err := s.messageRepo.GetByIDs(ctx, missing)
 	if err != nil {
 		return nil, err
 	}
+	for _, ref := range fetched {
+		references[ref.MessageID] = ref
+	}
+
+	return references, nil
+}
 
-	// Get messages where user is sender
-	sentMessages, err := s.messageRepo.GetBySender(ctx, userPubKey, limit, offset)
+// GetMessageByID gets a message by its ID
+func (s *MessageService) GetMessageByID(ctx context.Context, messageID uuid.UUID) (*domain.Message, error) {
+	return s.messageRepo.GetByID(ctx, messageID)
+}
+
+// GetMessageTimeline gets a message for its delivery timeline
+// Only the sender and recipient can see it; anyone else gets not found
+func (s *MessageService) GetMessageTimeline(ctx context.Context, userPubKey string, messageID uuid.UUID) (*domain.Message, error) {
+	message, err := s.messageRepo.GetByID(ctx, messageID)
 	if err != nil {
 		return nil, err
 	}
 
+	if !message.HasParty(userPubKey) {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	return message, nil
+}
+
+// resolveCursor converts a `before` cursor into the timestamp to page back from
+// The cursor is either a message timestamp in RFC 3339 format or the ID of a message the user sent or received
+func (s *MessageService) resolveCursor(ctx context.Context, userPubKey, before string) (time.Time, error) {
+	if messageID, err := uuid.Parse(before); err == nil {
+		message, err := s.messageRepo.GetByID(ctx, messageID)
+		if err != nil {
+			if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+				return time.Time{}, errors.NewValidationError("Cursor message not found", nil)
+			}
+			return time.Time{}, err
+		}
+		if !message.HasParty(userPubKey) {
+			return time.Time{}, errors.NewValidationError("Cursor message not found", nil)
+		}
+		return message.Timestamp, nil
+	}
+
+	cursor, err := time.Parse(time.RFC3339Nano, before)
+	if err != nil {
+		return time.Time{}, errors.NewValidationError("Invalid cursor, expected a message timestamp or message ID", err)
+	}
+	return cursor, nil
+}
+
+// GetMessagesForUser gets all messages for a user (both sent and received) with pagination
+// It also returns how many messages the user has in total, across all pages
+// A non-empty before cursor pages back from that point and the offset is ignored
+func (s *MessageService) GetMessagesForUser(ctx context.Context, userPubKey, before string, limit, offset int) ([]*domain.Message, int, error) {
+	var receivedMessages, sentMessages []*domain.Message
+	if before != "" {
+		cursor, err := s.resolveCursor(ctx, userPubKey, before)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = 0
+
+		// Get messages where user is recipient
+		receivedMessages, err = s.messageRepo.GetByRecipientBefore(ctx, userPubKey, cursor, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Get messages where user is sender
+		sentMessages, err = s.messageRepo.GetBySenderBefore(ctx, userPubKey, cursor, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+	} else {
+		var err error
+
+		// The offset applies to the merged list, so each side is read from its start
+		// far enough to cover the page; the combined results are sliced once below
+
+		// Get messages where user is recipient
+		receivedMessages, err = s.messageRepo.GetByRecipient(ctx, userPubKey, offset+limit, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Get messages where user is sender
+		sentMessages, err = s.messageRepo.GetBySender(ctx, userPubKey, offset+limit, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
 	// Combine messages and sort by timestamp (newest first)
 	allMessages := append(receivedMessages, sentMessages...)
 	sort.Slice(allMessages, func(i, j int) bool {
 		return allMessages[i].Timestamp.After(allMessages[j].Timestamp)
 	})
 
+	total, err := s.messageRepo.CountForUser(ctx, userPubKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// Apply pagination to combined results
 	end := offset + limit
 	if end > len(allMessages) {
 		end = len(allMessages)
 	}
 	if offset >= len(allMessages) {
-		return []*domain.Message{}, nil
+		return []*domain.Message{}, total, nil
 	}
 
-	return allMessages[offset:end], nil
+	return allMessages[offset:end], total, nil
+}
+
+// GetMessagesReceivedSince gets the oldest messages received by a user after the given time
+func (s *MessageService) GetMessagesReceivedSince(ctx context.Context, userPubKey string, since time.Time, limit int) ([]*domain.Message, error) {
+	messages, err := s.messageRepo.GetByRecipientSince(ctx, userPubKey, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkDelivered moves the messages just returned to the user as recipient from sent to delivered, and sends their senders receipts
+// Callers pass only the rows the user is given, not the extra row fetched to tell whether another page follows
+// It runs in the background with its own context so the read isn't slowed down; a failure is only logged,
+// since the messages are marked on the next fetch. Read stays a client action
+func (s *MessageService) MarkDelivered(userPubKey string, messages []*domain.Message) {
+	var ids []uuid.UUID
+	for _, message := range messages {
+		if message.RecipientPubKey == userPubKey && message.Status == domain.MessageStatusSent {
+			ids = append(ids, message.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryUpdateTimeout)
+		defer cancel()
+
+		receipts, err := s.messageRepo.MarkDeliveredForRecipient(ctx, userPubKey, ids)
+		if err != nil {
+			s.logger.Warn("Failed to mark fetched messages delivered", zap.Error(err), zap.Int("count", len(ids)))
+			return
+		}
+		for _, receipt := range receipts {
+			s.hub.PublishReceipt(receipt)
+		}
+	}()
+}
+
+// SearchMessages finds a user's messages by time range, peer and status, newest first
+func (s *MessageService) SearchMessages(ctx context.Context, userPubKey string, opts repository.SearchOptions) ([]*domain.Message, error) {
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return nil, errors.NewValidationError("Search range must start before it ends", nil)
+	}
+	return s.messageRepo.Search(ctx, userPubKey, opts)
+}
+
+// CountUnread counts the messages a user has received but not yet had delivered or read
+// When bySender is set, the count is also broken down per sender public key
+func (s *MessageService) CountUnread(ctx context.Context, userPubKey string, bySender bool) (int, map[string]int, error) {
+	if !bySender {
+		count, err := s.messageRepo.CountUnread(ctx, userPubKey)
+		return count, nil, err
+	}
+
+	counts, err := s.messageRepo.CountUnreadBySender(ctx, userPubKey)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	return total, counts, nil
+}
+
+// ListConversations summarizes each of a user's conversations, most recent first
+func (s *MessageService) ListConversations(ctx context.Context, userPubKey string) ([]*domain.ConversationSummary, error) {
+	return s.messageRepo.ListConversations(ctx, userPubKey)
 }
 
 // GetMessagesSentByUser gets all messages sent by a user with pagination
 func (s *MessageService) GetMessagesSentByUser(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
-	if limit <= 0 {
-		limit = 100 // Default limit
+	return s.messageRepo.GetBySender(ctx, userPubKey, limit, offset)
+}
+
+// GetConversation gets messages between two users with pagination, newest first unless ascending is set
+// It also returns how many messages the conversation holds in total, across all pages
+// A non-empty cursor pages from that point in the chosen order, back in time or forward, and the offset is ignored
+func (s *MessageService) GetConversation(ctx context.Context, userPubKey, contactPubKey, cursor string, ascending bool, limit, offset int) ([]*domain.Message, int, error) {
+	var messages []*domain.Message
+	if cursor != "" {
+		at, err := s.resolveCursor(ctx, userPubKey, cursor)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ascending {
+			messages, err = s.messageRepo.GetConversationAfter(ctx, userPubKey, contactPubKey, at, limit)
+		} else {
+			messages, err = s.messageRepo.GetConversationBefore(ctx, userPubKey, contactPubKey, at, limit)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	} else {
+		var err error
+		messages, err = s.messageRepo.GetConversation(ctx, userPubKey, contactPubKey, ascending, limit, offset)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
-	if limit > 1000 {
-		limit = 1000 // Max limit
+
+	total, err := s.messageRepo.CountConversation(ctx, userPubKey, contactPubKey)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return s.messageRepo.GetBySender(ctx, userPubKey, limit, offset)
+	return messages, total, nil
 }
 
-// GetConversation gets messages between two users with pagination
-func (s *MessageService) GetConversation(ctx context.Context, userPubKey, contactPubKey string, limit, offset int) ([]*domain.Message, error) {
-	if limit <= 0 {
-		limit = 100 // Default limit
+// UpdateMessageStatus updates the status of a message the user received and sends the sender a receipt
+// Senders may not change the status of their own messages; users who are neither party are told the message doesn't exist
+func (s *MessageService) UpdateMessageStatus(ctx context.Context, userID string, messageID uuid.UUID, status domain.MessageStatus) error {
+	if err := validateMessageStatus(status); err != nil {
+		return err
 	}
-	if limit > 1000 {
-		limit = 1000 // Max limit
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return err
 	}
 
-	return s.messageRepo.GetConversation(ctx, userPubKey, contactPubKey, limit, offset)
+	if message.RecipientPubKey != userPubKey {
+		if message.SenderPubKey == userPubKey {
+			return errors.NewUnauthorizedError("Only the recipient can update a message's status")
+		}
+		return errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
+
+	updated, err := s.messageRepo.UpdateStatus(ctx, messageID, status)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		// Already at or past this status; statuses don't move backwards, and the sender was told before
+		return nil
+	}
+
+	s.hub.PublishReceipt(&domain.MessageReceipt{
+		MessageID:    messageID,
+		SenderPubKey: message.SenderPubKey,
+		Status:       status,
+		UpdatedAt:    time.Now(),
+	})
+	return nil
 }
 
-// UpdateMessageStatus updates a message's status
-func (s *MessageService) UpdateMessageStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) error {
-	// Validate status
-	if status != domain.MessageStatusSent &&
-		status != domain.MessageStatusDelivered &&
+// UpdateMessageStatuses updates the status of several messages the user received and returns how many were updated
+// Unknown message IDs and messages sent to someone else are skipped rather than failing the batch
+func (s *MessageService) UpdateMessageStatuses(ctx context.Context, userID string, messageIDs []uuid.UUID, status domain.MessageStatus) (int64, error) {
+	if err := validateMessageStatus(status); err != nil {
+		return 0, err
+	}
+	if len(messageIDs) == 0 {
+		return 0, nil
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	receipts, err := s.messageRepo.UpdateStatusBatch(ctx, base64.URLEncoding.EncodeToString(user.PublicKey), messageIDs, status)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, receipt := range receipts {
+		s.hub.PublishReceipt(receipt)
+	}
+	return int64(len(receipts)), nil
+}
+
+// validateMessageStatus checks the status is one clients may set
+// Sent and failed are set by the server only
+func validateMessageStatus(status domain.MessageStatus) error {
+	if status != domain.MessageStatusDelivered &&
 		status != domain.MessageStatusRead {
 		return errors.NewValidationError("Invalid message status", nil)
 	}
+	return nil
+}
+
+// DeleteMessage permanently deletes a message the user sent
+// Recipients may not delete messages; users who are neither party are told the message doesn't exist
+func (s *MessageService) DeleteMessage(ctx context.Context, userID string, messageID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	userPubKey := base64.URLEncoding.EncodeToString(user.PublicKey)
+
+	message, err := s.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.SenderPubKey != userPubKey {
+		if message.RecipientPubKey == userPubKey {
+			return errors.NewUnauthorizedError("Only the sender can delete a message")
+		}
+		return errors.NewNotFoundError(fmt.Sprintf("Message with ID '%s'", messageID))
+	}
 
-	return s.messageRepo.UpdateStatus(ctx, messageID, status)
+	if s.config.Messages.HardDelete {
+		err = s.messageRepo.DeleteByID(ctx, messageID)
+	} else {
+		err = s.messageRepo.SoftDeleteByID(ctx, messageID)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug("Message deleted", zap.String("message_id", messageID.String()), zap.String("sender", userID))
+	return nil
+}
+
+// CleanupExpiredMessages deletes all messages whose expiry has passed
+func (s *MessageService) CleanupExpiredMessages(ctx context.Context) error {
+	count, err := s.messageRepo.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		s.logger.Info("Cleaned up expired messages", zap.Int64("count", count))
+	}
+	return nil
+}
+
+// ScheduleExpiredCleanup starts a goroutine to periodically delete expired messages
+// The goroutine runs until ctx is cancelled; the returned channel is closed once it has returned
+func (s *MessageService) ScheduleExpiredCleanup(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	ticker := time.NewTicker(expiredMessageCleanupInterval)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.CleanupExpiredMessages(ctx); err != nil {
+					s.logger.Error("Failed to clean up expired messages", zap.Error(err))
+				}
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	s.logger.Info("Scheduled expired message cleanup")
+	return done
+}
+
+// PurgeDeletedMessages permanently deletes soft-deleted messages older than the retention window
+func (s *MessageService) PurgeDeletedMessages(ctx context.Context) error {
+	count, err := s.messageRepo.PurgeDeleted(ctx, time.Now().Add(-s.config.Messages.DeletedRetention))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		s.logger.Info("Purged deleted messages", zap.Int64("count", count))
+	}
+	return nil
+}
+
+// ScheduleDeletedPurge starts a goroutine to periodically purge soft-deleted messages
+// It does nothing when messages are hard deleted, since none are ever soft-deleted
+// The goroutine runs until ctx is cancelled; the returned channel is closed once it has returned
+func (s *MessageService) ScheduleDeletedPurge(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if s.config.Messages.HardDelete {
+		close(done)
+		return done
+	}
+
+	ticker := time.NewTicker(deletedMessagePurgeInterval)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.PurgeDeletedMessages(ctx); err != nil {
+					s.logger.Error("Failed to purge deleted messages", zap.Error(err))
+				}
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	s.logger.Info("Scheduled deleted message purge", zap.Duration("retention", s.config.Messages.DeletedRetention))
+	return done
 }
 
 // DeleteUserMessages deletes all messages where a user is sender or recipient
 func (s *MessageService) DeleteUserMessages(ctx context.Context, userPubKey string) (int64, error) {
-	count, err := s.messageRepo.DeleteUserMessages(ctx, userPubKey)
+	var count int64
+	var err error
+	if s.config.Messages.HardDelete {
+		count, err = s.messageRepo.DeleteUserMessages(ctx, userPubKey)
+	} else {
+		count, err = s.messageRepo.SoftDeleteUserMessages(ctx, userPubKey)
+	}
 	if err != nil {
 		return 0, err
 	}
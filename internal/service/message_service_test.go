@@ -0,0 +1,722 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+func TestValidateReplyReference(t *testing.T) {
+	original := domain.NewMessage("alice", "bob", nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name      string
+		sender    string
+		recipient string
+		valid     bool
+	}{
+		{"reply from original sender", "alice", "bob", true},
+		{"reply from original recipient", "bob", "alice", true},
+		{"reply to a third party", "alice", "carol", false},
+		{"reply from a non-party", "carol", "bob", false},
+		{"reply between strangers", "carol", "dave", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateReplyReference(original, tt.sender, tt.recipient)
+			if tt.valid {
+				assert.NoError(t, err)
+				return
+			}
+
+			appErr, ok := errors.IsAppError(err)
+			if assert.True(t, ok) {
+				assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+			}
+		})
+	}
+}
+
+func TestSendToDeletedRecipientFails(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), hub, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, sender))
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		_ = userRepo.Delete(context.Background(), sender.UserID)
+		_ = userRepo.Delete(context.Background(), recipient.UserID)
+	})
+
+	sub := hub.Subscribe(senderPubKey)
+	defer hub.Unsubscribe(sub)
+
+	send := func() *domain.Message {
+		encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+		msg, err := svc.SendMessage(ctx, sender.UserID, recipientPubKey,
+			encoded, encoded, encoded, encoded, encoded, encoded, "", 0, "")
+		require.NoError(t, err)
+		return msg
+	}
+
+	// While the recipient exists, messages are sent normally
+	assert.Equal(t, domain.MessageStatusSent, send().Status)
+
+	// Once the recipient's account is gone, new messages fail
+	require.NoError(t, userRepo.Delete(ctx, recipient.UserID))
+	failed := send()
+	assert.Equal(t, domain.MessageStatusFailed, failed.Status)
+
+	// The sender's sockets are told it failed
+	select {
+	case receipt := <-sub.Receipts():
+		assert.Equal(t, failed.MessageID, receipt.MessageID)
+		assert.Equal(t, domain.MessageStatusFailed, receipt.Status)
+	default:
+		t.Fatal("expected failed receipt for sender")
+	}
+
+	stored, err := messageRepo.GetByID(ctx, failed.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusFailed, stored.Status)
+
+	failedMessages, err := svc.GetFailedMessages(ctx, senderPubKey, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, failedMessages, 1)
+	assert.Equal(t, failed.MessageID, failedMessages[0].MessageID)
+
+	// Resending fails until the recipient is back
+	_, err = svc.ResendMessage(ctx, senderPubKey, failed.MessageID)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+
+	// Only the sender can resend
+	_, err = svc.ResendMessage(ctx, recipientPubKey, failed.MessageID)
+	appErr, ok = errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	resent, err := svc.ResendMessage(ctx, senderPubKey, failed.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusSent, resent.Status)
+
+	failedMessages, err = svc.GetFailedMessages(ctx, senderPubKey, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, failedMessages)
+}
+
+func TestFindRecipientRejectsMalformedKey(t *testing.T) {
+	userRepo := repository.NewUserRepository(&repository.Database{Logger: zaptest.NewLogger(t)})
+	svc := NewMessageService(nil, userRepo, nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	// A key that doesn't decode is the caller's mistake, not an unavailable recipient
+	recipient, err := svc.findRecipient(context.Background(), "not base64!")
+	assert.Nil(t, recipient)
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+}
+
+func TestResolveCursorTimestamp(t *testing.T) {
+	// Timestamp cursors are parsed without touching the database
+	svc := NewMessageService(nil, nil, nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	cursor, err := svc.resolveCursor(context.Background(), "alice", "2024-01-01T12:00:00.123456Z")
+	require.NoError(t, err)
+	assert.True(t, cursor.Equal(time.Date(2024, 1, 1, 12, 0, 0, 123456000, time.UTC)))
+
+	_, err = svc.resolveCursor(context.Background(), "alice", "yesterday")
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+}
+
+func TestConversationCursorPaging(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), nil, &config.Config{}, zaptest.NewLogger(t))
+
+	alice := testutil.NewUser()
+	bob := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, alice))
+	require.NoError(t, userRepo.Create(ctx, bob))
+	alicePubKey := base64.URLEncoding.EncodeToString(alice.PublicKey)
+	bobPubKey := base64.URLEncoding.EncodeToString(bob.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), alicePubKey)
+		_ = userRepo.Delete(context.Background(), alice.UserID)
+		_ = userRepo.Delete(context.Background(), bob.UserID)
+	})
+
+	var sent []*domain.Message
+	for i := 0; i < 3; i++ {
+		msg := domain.NewMessage(alicePubKey, bobPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+		msg.Timestamp = time.Now().Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, messageRepo.Create(ctx, msg))
+		sent = append(sent, msg)
+	}
+
+	// Paging back from the newest message by ID skips it and anything newer
+	page, total, err := svc.GetConversation(ctx, alicePubKey, bobPubKey, sent[2].MessageID.String(), false, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, sent[1].MessageID, page[0].MessageID)
+	assert.Equal(t, sent[0].MessageID, page[1].MessageID)
+
+	// A message arriving between pages doesn't shift the next page
+	newer := domain.NewMessage(bobPubKey, alicePubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	require.NoError(t, messageRepo.Create(ctx, newer))
+
+	page, total, err = svc.GetMessagesForUser(ctx, alicePubKey, page[0].Timestamp.Format(time.RFC3339Nano), 10, 0)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, sent[0].MessageID, page[0].MessageID)
+}
+
+func TestMessagesForUserOffsetPaging(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), nil, &config.Config{}, zaptest.NewLogger(t))
+
+	alice := testutil.NewUser()
+	bob := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, alice))
+	require.NoError(t, userRepo.Create(ctx, bob))
+	alicePubKey := base64.URLEncoding.EncodeToString(alice.PublicKey)
+	bobPubKey := base64.URLEncoding.EncodeToString(bob.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), alicePubKey)
+		_ = userRepo.Delete(context.Background(), alice.UserID)
+		_ = userRepo.Delete(context.Background(), bob.UserID)
+	})
+
+	// Five messages alternating direction, newest first
+	var newestFirst []*domain.Message
+	for i := 0; i < 5; i++ {
+		msg := domain.NewMessage(alicePubKey, bobPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+		if i%2 == 1 {
+			msg = domain.NewMessage(bobPubKey, alicePubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+		}
+		msg.Timestamp = time.Now().Add(-time.Duration(i+1) * time.Minute)
+		require.NoError(t, messageRepo.Create(ctx, msg))
+		newestFirst = append(newestFirst, msg)
+	}
+
+	// Pages of two, fetching one extra row as the handler does to tell whether there are more
+	const limit = 2
+	for _, tt := range []struct {
+		offset  int
+		want    []*domain.Message
+		hasMore bool
+	}{
+		{0, newestFirst[0:2], true},
+		{2, newestFirst[2:4], true},
+		{4, newestFirst[4:5], false},
+	} {
+		messages, total, err := svc.GetMessagesForUser(ctx, alicePubKey, "", limit+1, tt.offset)
+		require.NoError(t, err)
+		assert.Equal(t, 5, total)
+
+		page, pagination := response.Paginate(messages, limit, tt.offset)
+		require.Len(t, page, len(tt.want), "offset %d", tt.offset)
+		assert.Equal(t, tt.hasMore, pagination.HasMore, "offset %d", tt.offset)
+		for i, msg := range tt.want {
+			assert.Equal(t, msg.MessageID, page[i].MessageID, "offset %d", tt.offset)
+		}
+	}
+}
+
+func TestConversationAscendingPaging(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), nil, &config.Config{}, zaptest.NewLogger(t))
+
+	alice := testutil.NewUser()
+	bob := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, alice))
+	require.NoError(t, userRepo.Create(ctx, bob))
+	alicePubKey := base64.URLEncoding.EncodeToString(alice.PublicKey)
+	bobPubKey := base64.URLEncoding.EncodeToString(bob.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), alicePubKey)
+		_ = userRepo.Delete(context.Background(), alice.UserID)
+		_ = userRepo.Delete(context.Background(), bob.UserID)
+	})
+
+	var sent []*domain.Message
+	for i := 0; i < 3; i++ {
+		msg := domain.NewMessage(alicePubKey, bobPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+		msg.Timestamp = time.Now().Add(time.Duration(i-3) * time.Minute)
+		require.NoError(t, messageRepo.Create(ctx, msg))
+		sent = append(sent, msg)
+	}
+
+	// Without a cursor the conversation starts from its oldest message
+	page, total, err := svc.GetConversation(ctx, alicePubKey, bobPubKey, "", true, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, sent[0].MessageID, page[0].MessageID)
+	assert.Equal(t, sent[1].MessageID, page[1].MessageID)
+
+	// Paging forward from the last message returns only what came after it
+	page, _, err = svc.GetConversation(ctx, alicePubKey, bobPubKey, page[1].MessageID.String(), true, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, sent[2].MessageID, page[0].MessageID)
+}
+
+func TestSendMessageRejectsInvalidLifetime(t *testing.T) {
+	// Lifetimes are checked before anything is decoded or stored
+	svc := NewMessageService(nil, nil, nil, nil, &config.Config{}, zaptest.NewLogger(t))
+	encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+
+	for _, expiresIn := range []time.Duration{-time.Second, maxMessageTTL + time.Second} {
+		_, err := svc.SendMessage(context.Background(), "user", "recipient",
+			encoded, encoded, encoded, encoded, encoded, encoded, "", expiresIn, "")
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+	}
+}
+
+func TestSendMessageRejectsOversizedCiphertext(t *testing.T) {
+	// Sizes are checked before anything is stored
+	cfg := &config.Config{}
+	cfg.Messages.MaxCiphertextBytes = 8
+	svc := NewMessageService(nil, nil, nil, nil, cfg, zaptest.NewLogger(t))
+	small := base64.URLEncoding.EncodeToString([]byte("12345678"))
+	large := base64.URLEncoding.EncodeToString([]byte("123456789"))
+
+	for _, tt := range []struct{ msg, senderMsg string }{{large, small}, {small, large}} {
+		_, err := svc.SendMessage(context.Background(), "user", "recipient",
+			small, tt.msg, small, small, tt.senderMsg, small, "", 0, "")
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+		assert.Contains(t, appErr.Message, "at most 8 bytes")
+	}
+}
+
+func TestDeleteMessageSenderOnly(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), nil, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	stranger := testutil.NewUser()
+	for _, user := range []*domain.User{sender, recipient, stranger} {
+		require.NoError(t, userRepo.Create(ctx, user))
+	}
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		for _, user := range []*domain.User{sender, recipient, stranger} {
+			_ = userRepo.Delete(context.Background(), user.UserID)
+		}
+	})
+
+	msg := domain.NewMessage(senderPubKey, base64.URLEncoding.EncodeToString(recipient.PublicKey),
+		[]byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	require.NoError(t, messageRepo.Create(ctx, msg))
+
+	assertCode := func(err error, code string) {
+		t.Helper()
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, code, appErr.Code)
+	}
+
+	assertCode(svc.DeleteMessage(ctx, recipient.UserID, msg.MessageID), errors.ErrCodeUnauthorized)
+	assertCode(svc.DeleteMessage(ctx, stranger.UserID, msg.MessageID), errors.ErrCodeNotFound)
+
+	require.NoError(t, svc.DeleteMessage(ctx, sender.UserID, msg.MessageID))
+	assertCode(svc.DeleteMessage(ctx, sender.UserID, msg.MessageID), errors.ErrCodeNotFound)
+}
+
+func TestUpdateMessageStatusSendsReceipt(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), hub, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	stranger := testutil.NewUser()
+	for _, user := range []*domain.User{sender, recipient, stranger} {
+		require.NoError(t, userRepo.Create(ctx, user))
+	}
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		for _, user := range []*domain.User{sender, recipient, stranger} {
+			_ = userRepo.Delete(context.Background(), user.UserID)
+		}
+	})
+
+	msg := domain.NewMessage(senderPubKey, base64.URLEncoding.EncodeToString(recipient.PublicKey),
+		[]byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	require.NoError(t, messageRepo.Create(ctx, msg))
+
+	sub := hub.Subscribe(senderPubKey)
+	defer hub.Unsubscribe(sub)
+
+	assertCode := func(err error, code string) {
+		t.Helper()
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, code, appErr.Code)
+	}
+
+	assertCode(svc.UpdateMessageStatus(ctx, sender.UserID, msg.MessageID, domain.MessageStatusRead), errors.ErrCodeUnauthorized)
+	assertCode(svc.UpdateMessageStatus(ctx, stranger.UserID, msg.MessageID, domain.MessageStatusRead), errors.ErrCodeNotFound)
+	assert.Empty(t, sub.Receipts())
+
+	require.NoError(t, svc.UpdateMessageStatus(ctx, recipient.UserID, msg.MessageID, domain.MessageStatusRead))
+	select {
+	case receipt := <-sub.Receipts():
+		assert.Equal(t, msg.MessageID, receipt.MessageID)
+		assert.Equal(t, domain.MessageStatusRead, receipt.Status)
+	default:
+		t.Fatal("expected receipt for sender")
+	}
+
+	// A read message doesn't go back to delivered, and the sender isn't told again
+	require.NoError(t, svc.UpdateMessageStatus(ctx, recipient.UserID, msg.MessageID, domain.MessageStatusDelivered))
+	assert.Empty(t, sub.Receipts())
+	stored, err := messageRepo.GetByID(ctx, msg.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusRead, stored.Status)
+}
+
+func TestFetchingMessagesMarksThemDelivered(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	hub := realtime.NewHub(zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), hub, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	for _, user := range []*domain.User{sender, recipient} {
+		require.NoError(t, userRepo.Create(ctx, user))
+	}
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		for _, user := range []*domain.User{sender, recipient} {
+			_ = userRepo.Delete(context.Background(), user.UserID)
+		}
+	})
+
+	older := domain.NewMessage(senderPubKey, recipientPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	older.Timestamp = time.Now().Add(-time.Minute)
+	require.NoError(t, messageRepo.Create(ctx, older))
+	msg := domain.NewMessage(senderPubKey, recipientPubKey, []byte("kem"), []byte("msg"), []byte("nonce"), nil, nil, nil)
+	require.NoError(t, messageRepo.Create(ctx, msg))
+
+	sub := hub.Subscribe(senderPubKey)
+	defer hub.Unsubscribe(sub)
+
+	// The sender listing their own messages delivers nothing
+	messages, _, err := svc.GetMessagesForUser(ctx, senderPubKey, "", 10, 0)
+	require.NoError(t, err)
+	svc.MarkDelivered(senderPubKey, messages)
+
+	// A page of one, fetched with the extra row that tells whether another page follows
+	messages, _, err = svc.GetMessagesForUser(ctx, recipientPubKey, "", 2, 0)
+	require.NoError(t, err)
+	page, _ := response.Paginate(messages, 1, 0)
+	require.Len(t, page, 1)
+	svc.MarkDelivered(recipientPubKey, page)
+
+	select {
+	case receipt := <-sub.Receipts():
+		assert.Equal(t, msg.MessageID, receipt.MessageID)
+		assert.Equal(t, domain.MessageStatusDelivered, receipt.Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected delivered receipt for sender")
+	}
+
+	stored, err := messageRepo.GetByID(ctx, msg.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusDelivered, stored.Status)
+
+	// The extra row wasn't returned, so it isn't delivered yet
+	stored, err = messageRepo.GetByID(ctx, older.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusSent, stored.Status)
+	assert.Empty(t, sub.Receipts())
+}
+
+func TestUpdateMessageStatusesValidatesStatus(t *testing.T) {
+	svc := NewMessageService(nil, nil, nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	// Only the server sets a message sent or failed
+	for _, status := range []domain.MessageStatus{domain.MessageStatusSent, domain.MessageStatusFailed} {
+		_, err := svc.UpdateMessageStatuses(context.Background(), "user", []uuid.UUID{uuid.New()}, status)
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+
+		err = svc.UpdateMessageStatus(context.Background(), "user", uuid.New(), status)
+		appErr, ok = errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+	}
+
+	updated, err := svc.UpdateMessageStatuses(context.Background(), "user", nil, domain.MessageStatusRead)
+	require.NoError(t, err)
+	assert.Zero(t, updated)
+}
+
+func TestSendMessageRequireKnownRecipient(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	messageRepo := repository.NewMessageRepository(db)
+	userRepo := repository.NewUserRepository(db)
+
+	cfg := &config.Config{}
+	cfg.Messages.RequireKnownRecipient = true
+	svc := NewMessageService(messageRepo, userRepo, NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t)), nil, cfg, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, sender))
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), base64.URLEncoding.EncodeToString(sender.PublicKey))
+		_ = userRepo.Delete(context.Background(), sender.UserID)
+		_ = userRepo.Delete(context.Background(), recipient.UserID)
+	})
+
+	encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+	send := func(recipientPubKey string) (*domain.Message, error) {
+		return svc.SendMessage(ctx, sender.UserID, recipientPubKey,
+			encoded, encoded, encoded, encoded, encoded, encoded, "", 0, "")
+	}
+
+	msg, err := send(base64.URLEncoding.EncodeToString(recipient.PublicKey))
+	require.NoError(t, err)
+	assert.Equal(t, domain.MessageStatusSent, msg.Status)
+
+	// Unknown recipients are rejected and nothing is stored
+	_, err = send(base64.URLEncoding.EncodeToString([]byte("nobody")))
+	appErr, ok := errors.IsAppError(err)
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+
+	failed, err := svc.GetFailedMessages(ctx, base64.URLEncoding.EncodeToString(sender.PublicKey), 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+}
+
+func TestSendMessageFromBlockedSender(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	messageRepo := repository.NewMessageRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	blocks := NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, blocks, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, sender))
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		_ = userRepo.Delete(context.Background(), sender.UserID)
+		_ = userRepo.Delete(context.Background(), recipient.UserID)
+	})
+
+	encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+	send := func() error {
+		_, err := svc.SendMessage(ctx, sender.UserID, recipientPubKey,
+			encoded, encoded, encoded, encoded, encoded, encoded, "", 0, "")
+		return err
+	}
+
+	_, err := blocks.Block(ctx, recipient.UserID, senderPubKey)
+	require.NoError(t, err)
+
+	appErr, ok := errors.IsAppError(send())
+	require.True(t, ok)
+	assert.Equal(t, errors.ErrCodeUnauthorized, appErr.Code)
+	assert.NotContains(t, appErr.Message, "block")
+
+	require.NoError(t, blocks.Unblock(ctx, recipient.UserID, senderPubKey))
+	require.NoError(t, send())
+}
+
+func TestSendMessageWithClientIDIsIdempotent(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	messageRepo := repository.NewMessageRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	blocks := NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, blocks, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	recipient := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, sender))
+	require.NoError(t, userRepo.Create(ctx, recipient))
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	recipientPubKey := base64.URLEncoding.EncodeToString(recipient.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		_ = userRepo.Delete(context.Background(), sender.UserID)
+		_ = userRepo.Delete(context.Background(), recipient.UserID)
+	})
+
+	encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+	messageID := uuid.NewString()
+	send := func() *domain.Message {
+		msg, err := svc.SendMessage(ctx, sender.UserID, recipientPubKey,
+			encoded, encoded, encoded, encoded, encoded, encoded, "", 0, messageID)
+		require.NoError(t, err)
+		return msg
+	}
+
+	first := send()
+	second := send()
+	assert.Equal(t, messageID, first.MessageID.String())
+	assert.Equal(t, first.MessageID, second.MessageID)
+	assert.True(t, first.Timestamp.Equal(second.Timestamp))
+
+	sent, err := messageRepo.GetBySender(ctx, senderPubKey, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, sent, 1)
+}
+
+func TestSendMultiMessageValidatesEveryCopy(t *testing.T) {
+	// Every copy is checked before anything is looked up or stored
+	svc := NewMessageService(nil, nil, nil, nil, &config.Config{}, zaptest.NewLogger(t))
+	encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext"))
+	copyFor := func() RecipientCopy {
+		return RecipientCopy{
+			RecipientPubKey: base64.URLEncoding.EncodeToString(testutil.NewUser().PublicKey),
+			CiphertextKEM:   encoded,
+			CiphertextMsg:   encoded,
+			Nonce:           encoded,
+		}
+	}
+
+	badNonce := copyFor()
+	badNonce.Nonce = "not base64!"
+	duplicate := copyFor()
+
+	tooMany := make([]RecipientCopy, domain.MaxMessageRecipients+1)
+	for i := range tooMany {
+		tooMany[i] = copyFor()
+	}
+
+	for _, tt := range []struct {
+		copies  []RecipientCopy
+		message string
+	}{
+		{nil, "recipients are required"},
+		{tooMany, "recipients are required"},
+		{[]RecipientCopy{copyFor(), badNonce}, "Recipient 1: Invalid nonce format"},
+		{[]RecipientCopy{duplicate, copyFor(), duplicate}, "Recipient 2: duplicate recipient"},
+	} {
+		_, err := svc.SendMultiMessage(context.Background(), "user", tt.copies, encoded, encoded, encoded, 0)
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+		assert.Contains(t, appErr.Message, tt.message)
+	}
+}
+
+func TestSendMultiMessage(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	messageRepo := repository.NewMessageRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	blocks := NewBlockService(repository.NewBlockRepository(db), zaptest.NewLogger(t))
+	svc := NewMessageService(messageRepo, userRepo, blocks, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	sender := testutil.NewUser()
+	alice := testutil.NewUser()
+	bob := testutil.NewUser()
+	for _, user := range []*domain.User{sender, alice, bob} {
+		require.NoError(t, userRepo.Create(ctx, user))
+	}
+	senderPubKey := base64.URLEncoding.EncodeToString(sender.PublicKey)
+	t.Cleanup(func() {
+		_, _ = messageRepo.DeleteUserMessages(context.Background(), senderPubKey)
+		for _, user := range []*domain.User{sender, alice, bob} {
+			_ = userRepo.Delete(context.Background(), user.UserID)
+		}
+	})
+
+	recipientPubKeys := []string{
+		base64.URLEncoding.EncodeToString(alice.PublicKey),
+		base64.URLEncoding.EncodeToString(testutil.NewUser().PublicKey), // No such user
+		base64.URLEncoding.EncodeToString(bob.PublicKey),
+	}
+	copies := make([]RecipientCopy, len(recipientPubKeys))
+	for i, recipientPubKey := range recipientPubKeys {
+		encoded := base64.URLEncoding.EncodeToString([]byte("ciphertext for " + recipientPubKey[:8]))
+		copies[i] = RecipientCopy{RecipientPubKey: recipientPubKey, CiphertextKEM: encoded, CiphertextMsg: encoded, Nonce: encoded}
+	}
+	senderCopy := base64.URLEncoding.EncodeToString([]byte("sender copy"))
+
+	messages, err := svc.SendMultiMessage(ctx, sender.UserID, copies, senderCopy, senderCopy, senderCopy, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+
+	for i, msg := range messages {
+		stored, err := messageRepo.GetByID(ctx, msg.MessageID)
+		require.NoError(t, err)
+		assert.Equal(t, senderPubKey, stored.SenderPubKey)
+		assert.Equal(t, recipientPubKeys[i], stored.RecipientPubKey)
+		assert.Equal(t, []byte("sender copy"), stored.SenderCiphertextMsg)
+		assert.NotNil(t, stored.ExpiresAt)
+	}
+	assert.Equal(t, domain.MessageStatusSent, messages[0].Status)
+	assert.Equal(t, domain.MessageStatusFailed, messages[1].Status)
+	assert.Equal(t, domain.MessageStatusSent, messages[2].Status)
+}
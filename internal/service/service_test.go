@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+// newTestDatabase connects to the test database and runs migrations
+// Service tests that need a database are skipped when testutil.DatabaseEnv is not set
+func newTestDatabase(t *testing.T) *repository.Database {
+	t.Helper()
+
+	db := &repository.Database{
+		Pool:   testutil.NewPool(t),
+		Logger: zaptest.NewLogger(t),
+		Config: &config.Config{},
+	}
+	require.NoError(t, db.RunMigrations(context.Background()))
+
+	return db
+}
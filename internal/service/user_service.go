@@ -7,23 +7,32 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/domain"
 	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/security"
 )
 
+// inactiveUserBatchSize is how many inactive users are listed at a time while deleting them
+const inactiveUserBatchSize = 100
+
 // UserService provides user business logic
 type UserService struct {
-	userRepo *repository.UserRepository
-	logger   *zap.Logger
+	userRepo       *repository.UserRepository
+	accountService *AccountService
+	config         *config.Config
+	logger         *zap.Logger
 }
 
 // NewUserService creates a new UserService
-func NewUserService(userRepo *repository.UserRepository, logger *zap.Logger) *UserService {
+// Inactive users are deleted through accountService, so their messages, contacts and sessions go with them
+func NewUserService(userRepo *repository.UserRepository, accountService *AccountService, config *config.Config, logger *zap.Logger) *UserService {
 	return &UserService{
-		userRepo: userRepo,
-		logger:   logger.With(zap.String("service", "user")),
+		userRepo:       userRepo,
+		accountService: accountService,
+		config:         config,
+		logger:         logger.With(zap.String("service", "user")),
 	}
 }
 
@@ -31,11 +40,8 @@ func NewUserService(userRepo *repository.UserRepository, logger *zap.Logger) *Us
 // Note: Keys are already generated and encrypted client-side in zero-knowledge architecture
 func (s *UserService) Register(ctx context.Context, username string, publicKeyB64, encPrivateKeyB64, saltB64 string) (*domain.User, error) {
 	// Validate input
-	if username == "" {
-		return nil, errors.NewValidationError("Username is required", nil)
-	}
-	if len(username) < 3 || len(username) > 50 {
-		return nil, errors.NewValidationError("Username must be between 3 and 50 characters", nil)
+	if err := validateUsername(username); err != nil {
+		return nil, err
 	}
 	if publicKeyB64 == "" || encPrivateKeyB64 == "" || saltB64 == "" {
 		return nil, errors.NewValidationError("Public key, encrypted private key, and salt are required", nil)
@@ -68,19 +74,9 @@ func (s *UserService) Register(ctx context.Context, username string, publicKeyB6
 		return nil, errors.NewValidationError("Invalid salt", err)
 	}
 
-	// Check if user already exists
-	userID := security.HashUsername(username)
-	_, err = s.userRepo.GetByID(ctx, userID)
-	if err == nil {
-		return nil, errors.NewConflictError("User already exists")
-	}
-
-	// Only proceed if we got a "not found" error
-	if appErr, ok := errors.IsAppError(err); !ok || appErr.Code != errors.ErrCodeNotFound {
-		return nil, errors.NewInternalError("Error checking user existence", err)
-	}
-
 	// Create new user
+	// Existing users are caught by the unique constraints rather than a pre-check, which would race
+	userID := security.HashUsername(username, s.config.Auth.UserIDSecret)
 	now := time.Now()
 	user := &domain.User{
 		UserID:              userID,
@@ -100,6 +96,23 @@ func (s *UserService) Register(ctx context.Context, username string, publicKeyB6
 	return user, nil
 }
 
+// IsUsernameAvailable reports whether a username is valid and not yet registered
+// The answer is only a hint for signup forms: registration can still lose a race for the name
+func (s *UserService) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	if err := validateUsername(username); err != nil {
+		return false, err
+	}
+
+	_, err := s.userRepo.GetByID(ctx, security.HashUsername(username, s.config.Auth.UserIDSecret))
+	if err == nil {
+		return false, nil
+	}
+	if appErr, ok := errors.IsAppError(err); ok && appErr.Code == errors.ErrCodeNotFound {
+		return true, nil
+	}
+	return false, err
+}
+
 // GetByID gets a user by ID
 func (s *UserService) GetByID(ctx context.Context, userID string) (*domain.User, error) {
 	return s.userRepo.GetByID(ctx, userID)
@@ -110,13 +123,14 @@ func (s *UserService) GetByUsername(ctx context.Context, username string) (*doma
 	return s.userRepo.GetByUsername(ctx, username)
 }
 
+// GetByPublicKey gets a user by their base64 URL-encoded public key
+func (s *UserService) GetByPublicKey(ctx context.Context, publicKeyB64 string) (*domain.User, error) {
+	return s.userRepo.GetByPublicKey(ctx, publicKeyB64)
+}
+
 // GetPublicKey gets a user's public key
 func (s *UserService) GetPublicKey(ctx context.Context, username string) ([]byte, error) {
-	user, err := s.userRepo.GetByUsername(ctx, username)
-	if err != nil {
-		return nil, err
-	}
-	return user.PublicKey, nil
+	return s.userRepo.GetPublicKeyByUsername(ctx, username)
 }
 
 // GetEncryptedPrivateKey gets a user's encrypted private key and salt
@@ -139,3 +153,79 @@ func (s *UserService) UpdateLastActive(ctx context.Context, userID string) error
 func (s *UserService) DeleteUser(ctx context.Context, userID string) error {
 	return s.userRepo.Delete(ctx, userID)
 }
+
+// ListInactiveUsers gets up to limit users who haven't been active since before, least recently active first
+func (s *UserService) ListInactiveUsers(ctx context.Context, before time.Time, limit int) ([]*domain.User, error) {
+	return s.userRepo.ListInactive(ctx, before, limit)
+}
+
+// DeleteInactiveUsers deletes the accounts of up to limit users who haven't been active since before; 0 deletes all of them
+// Each account is deleted with its messages, contacts and sessions
+// An account that fails to delete is logged and skipped; it returns how many were deleted
+func (s *UserService) DeleteInactiveUsers(ctx context.Context, before time.Time, limit int) (int, error) {
+	deleted := 0
+	for limit == 0 || deleted < limit {
+		batchSize := inactiveUserBatchSize
+		if limit > 0 && limit-deleted < batchSize {
+			batchSize = limit - deleted
+		}
+
+		users, err := s.userRepo.ListInactive(ctx, before, batchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		batchDeleted := 0
+		for _, user := range users {
+			if err := s.accountService.DeleteAccount(ctx, user.UserID); err != nil {
+				if ctx.Err() != nil {
+					return deleted + batchDeleted, ctx.Err()
+				}
+				s.logger.Warn("Failed to delete inactive user", zap.Error(err), zap.String("user_id", user.UserID))
+				continue
+			}
+			batchDeleted++
+		}
+		deleted += batchDeleted
+
+		// Stop once there are no more, or when nothing listed could be deleted, since it would be listed again
+		if len(users) < batchSize || batchDeleted == 0 {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		s.logger.Info("Deleted inactive users", zap.Int("count", deleted), zap.Time("before", before))
+	}
+	return deleted, nil
+}
+
+// ScheduleInactiveCleanup starts a goroutine to periodically delete users inactive for longer than INACTIVE_USER_TTL
+// It does nothing when INACTIVE_USER_TTL is 0
+// The goroutine runs until ctx is cancelled; the returned channel is closed once it has returned
+func (s *UserService) ScheduleInactiveCleanup(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	ttl := s.config.Auth.InactiveUserTTL
+	if ttl <= 0 {
+		close(done)
+		return done
+	}
+
+	ticker := time.NewTicker(s.config.Auth.InactiveCleanupInterval)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.DeleteInactiveUsers(ctx, time.Now().Add(-ttl), 0); err != nil {
+					s.logger.Error("Failed to clean up inactive users", zap.Error(err))
+				}
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	s.logger.Info("Scheduled inactive user cleanup", zap.Duration("ttl", ttl))
+	return done
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/repository"
+	"github.com/pzkpfw44/wave-server/internal/security"
+	"github.com/pzkpfw44/wave-server/internal/testutil"
+)
+
+func TestIsUsernameAvailableRejectsInvalidNames(t *testing.T) {
+	// Names are checked before the repository is reached
+	svc := NewUserService(nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	for _, username := range []string{"", "ab", "user\xff"} {
+		_, err := svc.IsUsernameAvailable(context.Background(), username)
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeValidation, appErr.Code)
+	}
+}
+
+func TestIsUsernameAvailable(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	cfg := &config.Config{}
+	cfg.Auth.UserIDSecret = "test-secret"
+	svc := NewUserService(userRepo, nil, cfg, zaptest.NewLogger(t))
+
+	user := testutil.NewUser()
+	user.UserID = security.HashUsername(user.Username, cfg.Auth.UserIDSecret)
+	require.NoError(t, userRepo.Create(ctx, user))
+	t.Cleanup(func() {
+		_ = userRepo.Delete(context.Background(), user.UserID)
+	})
+
+	available, err := svc.IsUsernameAvailable(ctx, user.Username)
+	require.NoError(t, err)
+	assert.False(t, available)
+
+	available, err = svc.IsUsernameAvailable(ctx, user.Username+"_new")
+	require.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestDeleteInactiveUsers(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	cfg := &config.Config{}
+	cfg.Messages.HardDelete = true
+	logger := zaptest.NewLogger(t)
+	accounts := NewAccountService(db, userRepo, repository.NewContactRepository(db),
+		repository.NewMessageRepository(db), repository.NewTokenRepository(db), nil, cfg, logger)
+	svc := NewUserService(userRepo, accounts, cfg, logger)
+
+	// Backdate far enough that no other test's users are deleted
+	epoch := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	var inactive []string
+	for i := 0; i < 3; i++ {
+		user := testutil.NewUser()
+		require.NoError(t, userRepo.Create(ctx, user))
+		t.Cleanup(func() { _ = userRepo.Delete(context.Background(), user.UserID) })
+		_, err := db.Pool.Exec(ctx, "UPDATE users SET last_active = $1 WHERE user_id = $2", epoch, user.UserID)
+		require.NoError(t, err)
+		inactive = append(inactive, user.UserID)
+	}
+	active := testutil.NewUser()
+	require.NoError(t, userRepo.Create(ctx, active))
+	t.Cleanup(func() { _ = userRepo.Delete(context.Background(), active.UserID) })
+
+	cutoff := epoch.Add(time.Hour)
+	deleted, err := svc.DeleteInactiveUsers(ctx, cutoff, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	deleted, err = svc.DeleteInactiveUsers(ctx, cutoff, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	for _, userID := range inactive {
+		_, err := userRepo.GetByID(ctx, userID)
+		appErr, ok := errors.IsAppError(err)
+		require.True(t, ok)
+		assert.Equal(t, errors.ErrCodeNotFound, appErr.Code)
+	}
+	_, err = userRepo.GetByID(ctx, active.UserID)
+	assert.NoError(t, err)
+}
+
+func TestScheduleInactiveCleanupDisabledByDefault(t *testing.T) {
+	svc := NewUserService(nil, nil, &config.Config{}, zaptest.NewLogger(t))
+
+	select {
+	case <-svc.ScheduleInactiveCleanup(context.Background()):
+	default:
+		t.Fatal("inactive user cleanup started without INACTIVE_USER_TTL")
+	}
+}
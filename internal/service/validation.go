@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/pzkpfw44/wave-server/internal/errors"
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+// Length limits are counted in characters (runes), matching VARCHAR semantics in the database
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 50
+	maxNicknameLength = 50
+	maxGroupLength    = 50
+)
+
+// validateUsername checks that a username is valid UTF-8 and within the length limits
+func validateUsername(username string) error {
+	if username == "" {
+		return errors.NewValidationError("Username is required", nil)
+	}
+	if !utf8.ValidString(username) {
+		return errors.NewValidationError("Username must be valid UTF-8", nil)
+	}
+
+	length := utf8.RuneCountInString(username)
+	if length < minUsernameLength || length > maxUsernameLength {
+		return errors.NewValidationError(
+			fmt.Sprintf("Username must be between %d and %d characters", minUsernameLength, maxUsernameLength), nil)
+	}
+
+	return nil
+}
+
+// validateNickname checks that a contact nickname is valid UTF-8 and within the length limit
+func validateNickname(nickname string) error {
+	if nickname == "" {
+		return errors.NewValidationError("Nickname is required", nil)
+	}
+	if !utf8.ValidString(nickname) {
+		return errors.NewValidationError("Nickname must be valid UTF-8", nil)
+	}
+	if utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return errors.NewValidationError(fmt.Sprintf("Nickname must be at most %d characters", maxNicknameLength), nil)
+	}
+
+	return nil
+}
+
+// validateGroupName checks that a contact group name is valid UTF-8 and within the length limit
+// An empty name means the contact is ungrouped
+func validateGroupName(group string) error {
+	if !utf8.ValidString(group) {
+		return errors.NewValidationError("Group name must be valid UTF-8", nil)
+	}
+	if utf8.RuneCountInString(group) > maxGroupLength {
+		return errors.NewValidationError(fmt.Sprintf("Group name must be at most %d characters", maxGroupLength), nil)
+	}
+
+	return nil
+}
+
+// validatePublicKey checks that a base64-encoded public key decodes to a key of the expected format
+// name describes the key in error messages, such as "recipient public key"
+func validatePublicKey(publicKeyB64, name string) error {
+	publicKey, err := base64.URLEncoding.DecodeString(publicKeyB64)
+	if err != nil {
+		return errors.NewValidationError(fmt.Sprintf("Invalid %s format", name), err)
+	}
+	if err := security.ValidatePublicKeyFormat(publicKey); err != nil {
+		return errors.NewValidationError(fmt.Sprintf("Invalid %s", name), err)
+	}
+
+	return nil
+}
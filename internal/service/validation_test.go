@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+func TestValidateNicknameCountsRunes(t *testing.T) {
+	tests := []struct {
+		name     string
+		nickname string
+		valid    bool
+	}{
+		{"ascii at limit", strings.Repeat("a", 50), true},
+		{"ascii over limit", strings.Repeat("a", 51), false},
+		{"two-byte runes at limit", strings.Repeat("é", 50), true},
+		{"two-byte runes over limit", strings.Repeat("é", 51), false},
+		{"four-byte runes at limit", strings.Repeat("🌊", 50), true},
+		{"four-byte runes over limit", strings.Repeat("🌊", 51), false},
+		{"empty", "", false},
+		{"invalid utf-8", "nick\xff", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNickname(tt.nickname)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateUsernameCountsRunes(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		valid    bool
+	}{
+		{"ascii at minimum", "abc", true},
+		{"ascii under minimum", "ab", false},
+		{"multibyte at minimum", "日本語", true},
+		{"multibyte under minimum", "日本", false},
+		{"ascii at maximum", strings.Repeat("a", 50), true},
+		{"ascii over maximum", strings.Repeat("a", 51), false},
+		{"multibyte at maximum", strings.Repeat("ж", 50), true},
+		{"multibyte over maximum", strings.Repeat("ж", 51), false},
+		{"invalid utf-8", "user\xc3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUsername(tt.username)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateGroupName(t *testing.T) {
+	tests := []struct {
+		name  string
+		group string
+		valid bool
+	}{
+		{"empty means ungrouped", "", true},
+		{"ascii at limit", strings.Repeat("a", 50), true},
+		{"ascii over limit", strings.Repeat("a", 51), false},
+		{"multibyte at limit", strings.Repeat("ж", 50), true},
+		{"invalid utf-8", "work\xff", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGroupName(tt.group)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatePublicKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"valid key", base64.URLEncoding.EncodeToString(make([]byte, security.Kyber512PublicKeyMinSize)), true},
+		{"too short", base64.URLEncoding.EncodeToString([]byte("pubkey")), false},
+		{"too long", base64.URLEncoding.EncodeToString(make([]byte, security.Kyber512PublicKeyMaxSize+1)), false},
+		{"not base64", "not a key!", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePublicKey(tt.key, "public key")
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package testutil holds the database fixtures shared by the repository, service and handler tests
+// It doesn't import the repository package, so the repository's own tests can use it too
+package testutil
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/security"
+)
+
+// DatabaseEnv names the environment variable holding the test database DSN
+// Tests that need a database are skipped when it is not set
+const DatabaseEnv = "WAVE_TEST_DATABASE_URL"
+
+// NewPool connects to the test database, skipping the test if DatabaseEnv isn't set
+// The pool is closed when the test ends; callers wrap it in a repository.Database and run the migrations
+func NewPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv(DatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skipping database test", DatabaseEnv)
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+// NewUser builds a user with a unique ID, username and public key without storing it
+func NewUser() *domain.User {
+	id := uuid.NewString()
+	now := time.Now()
+	return &domain.User{
+		UserID:              id,
+		Username:            "test_" + id[:8],
+		PublicKey:           []byte("pubkey-" + id + strings.Repeat("0", security.Kyber512PublicKeyMinSize)), // Padded to a valid key size
+		EncryptedPrivateKey: []byte("privkey-" + id),
+		Salt:                []byte("salt-" + id),
+		CreatedAt:           now,
+		LastActive:          now,
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_contacts_contact_pubkey;
+ALTER TABLE users DROP COLUMN IF EXISTS discoverable;
@@ -0,0 +1,6 @@
+-- Hex SHA-256 of ciphertext_kem || ciphertext_msg || nonce, computed by the server on insert
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+
+UPDATE messages
+SET content_hash = encode(sha256(ciphertext_kem || ciphertext_msg || nonce), 'hex')
+WHERE content_hash IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id UUID;
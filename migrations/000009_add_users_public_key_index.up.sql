@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_users_public_key ON users USING HASH (public_key);
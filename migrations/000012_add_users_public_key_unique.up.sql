@@ -0,0 +1,22 @@
+-- The unique index can't be built while users share a public key, so name them rather than fail with only the index's name
+DO $$
+DECLARE
+    shared TEXT;
+BEGIN
+    IF to_regclass('idx_users_public_key_unique') IS NULL THEN
+        SELECT string_agg(usernames, '; ') INTO shared
+        FROM (
+            SELECT string_agg(username, ', ' ORDER BY created_at) AS usernames
+            FROM users
+            GROUP BY sha256(public_key)
+            HAVING COUNT(*) > 1
+        ) AS duplicates;
+
+        IF shared IS NOT NULL THEN
+            RAISE EXCEPTION 'Users share a public key, so idx_users_public_key_unique cannot be created: %', shared
+                USING HINT = 'Delete or recover with a new key all but one user in each group, then run the migrations again';
+        END IF;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_public_key_unique ON users (sha256(public_key));
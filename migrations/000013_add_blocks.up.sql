@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS blocks (
+    user_id VARCHAR(64) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    blocked_pubkey VARCHAR(1200) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, blocked_pubkey)
+);
@@ -0,0 +1 @@
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS group_name VARCHAR(64);
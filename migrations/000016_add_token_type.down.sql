@@ -0,0 +1,4 @@
+DELETE FROM tokens WHERE token_type = 'refresh';
+DROP INDEX IF EXISTS idx_tokens_refresh_token_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS refresh_token_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS token_type;
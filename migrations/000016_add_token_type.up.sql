@@ -0,0 +1,3 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS token_type VARCHAR(16) NOT NULL DEFAULT 'access';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS refresh_token_id UUID REFERENCES tokens(token_id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_tokens_refresh_token_id ON tokens(refresh_token_id);
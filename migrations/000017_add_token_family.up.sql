@@ -0,0 +1,7 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens(family_id);
+
+-- Existing refresh tokens have never been rotated, so each starts its own family
+UPDATE tokens SET family_id = token_id WHERE token_type = 'refresh' AND family_id IS NULL;
+UPDATE tokens SET family_id = refresh_token_id WHERE token_type = 'access' AND family_id IS NULL;
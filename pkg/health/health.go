@@ -10,12 +10,27 @@ import (
 	"go.uber.org/zap"
 )
 
+// checkInterval is how long a readiness check result is reused before the database is checked again
+const checkInterval = 10 * time.Second
+
+// Pinger is a database connection that can be checked for liveness
+// If it also has a Stat method, like pgxpool.Pool, its pool statistics are reported too
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// poolStater reports connection pool statistics
+type poolStater interface {
+	Stat() *pgxpool.Stat
+}
+
 // Checker performs health checks
 type Checker struct {
-	dbPool     *pgxpool.Pool
-	logger     *zap.Logger
-	lastStatus Status
-	mu         sync.RWMutex
+	dbPool      Pinger
+	logger      *zap.Logger
+	lastStatus  Status
+	lastChecked time.Time // Zero until the first check has run
+	mu          sync.RWMutex
 }
 
 // Status represents the health status
@@ -23,12 +38,21 @@ type Status struct {
 	Healthy bool   `json:"healthy"`
 	Message string `json:"message,omitempty"`
 	Details struct {
-		Database bool `json:"database"`
+		Database bool       `json:"database"`
+		Pool     *PoolStats `json:"pool,omitempty"`
 	} `json:"details"`
 }
 
+// PoolStats describes the database connection pool
+type PoolStats struct {
+	TotalConns    int32 `json:"total_conns"`
+	AcquiredConns int32 `json:"acquired_conns"`
+	IdleConns     int32 `json:"idle_conns"`
+	MaxConns      int32 `json:"max_conns"`
+}
+
 // New creates a new health checker
-func New(dbPool *pgxpool.Pool, logger *zap.Logger) *Checker {
+func New(dbPool Pinger, logger *zap.Logger) *Checker {
 	return &Checker{
 		dbPool: dbPool,
 		logger: logger,
@@ -64,18 +88,19 @@ func (c *Checker) HandleReadiness(ctx echo.Context) error {
 }
 
 // Check performs a health check
+// The result is reused for checkInterval, so frequent probes don't each hit the database
 func (c *Checker) Check(ctx context.Context) Status {
 	c.mu.RLock()
-	cached := c.lastStatus
+	cached, lastChecked := c.lastStatus, c.lastChecked
 	c.mu.RUnlock()
 
-	// Don't perform health checks too frequently
-	if time.Now().Unix()%10 != 0 {
+	if !lastChecked.IsZero() && time.Since(lastChecked) < checkInterval {
 		return cached
 	}
 
 	status := Status{}
 	status.Details.Database = c.checkDatabase(ctx)
+	status.Details.Pool = c.poolStats()
 	status.Healthy = status.Details.Database
 
 	if !status.Healthy {
@@ -84,11 +109,32 @@ func (c *Checker) Check(ctx context.Context) Status {
 
 	c.mu.Lock()
 	c.lastStatus = status
+	c.lastChecked = time.Now()
 	c.mu.Unlock()
 
 	return status
 }
 
+// poolStats returns the database pool statistics, or nil if the pool doesn't report any
+func (c *Checker) poolStats() *PoolStats {
+	stater, ok := c.dbPool.(poolStater)
+	if !ok {
+		return nil
+	}
+
+	stat := stater.Stat()
+	if stat == nil {
+		return nil
+	}
+
+	return &PoolStats{
+		TotalConns:    stat.TotalConns(),
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		MaxConns:      stat.MaxConns(),
+	}
+}
+
 // checkDatabase checks database connectivity
 func (c *Checker) checkDatabase(ctx context.Context) bool {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -2,7 +2,10 @@ package metrics
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -18,8 +21,17 @@ const (
 	ActiveConnections  = "wave_active_connections"
 	MessageCount       = "wave_messages_total"
 	ErrorsTotal        = "wave_errors_total"
+	CacheLookups       = "wave_cache_lookups_total"
+	PoolTotalConns     = "wave_db_pool_total_conns"
+	PoolAcquiredConns  = "wave_db_pool_acquired_conns"
+	PoolIdleConns      = "wave_db_pool_idle_conns"
+	PoolNewConns       = "wave_db_pool_new_conns_total"
 )
 
+// DefaultLatencyBuckets are the request duration buckets, in seconds, until SetRequestDurationBuckets is called
+// Chat requests mostly take milliseconds, so the buckets are finest there instead of spread up to 10s like prometheus.DefBuckets
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
 var (
 	// Registry for all metrics
 	registry = prometheus.NewRegistry()
@@ -33,14 +45,9 @@ var (
 		[]string{"method", "path", "status"},
 	)
 
-	requestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    RequestDuration,
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "path"},
-	)
+	// requestDuration is replaced by SetRequestDurationBuckets, so it is read under requestDurationMutex
+	requestDurationMutex sync.RWMutex
+	requestDuration      = newRequestDuration(DefaultLatencyBuckets)
 
 	responseSize = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -92,8 +99,80 @@ var (
 		},
 		[]string{"type"},
 	)
+
+	// Cache metrics
+	cacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: CacheLookups,
+			Help: "Total number of cache lookups",
+		},
+		[]string{"cache", "result"},
+	)
 )
 
+// Connection pool metrics are read from the pool stats provider on each scrape
+var (
+	poolStatsMutex    sync.RWMutex
+	poolStatsProvider func() *pgxpool.Stat
+
+	poolTotalConns = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: PoolTotalConns,
+			Help: "Current number of connections in the database pool",
+		},
+		poolStat(func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+	)
+
+	poolAcquiredConns = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: PoolAcquiredConns,
+			Help: "Current number of database pool connections in use",
+		},
+		poolStat(func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+	)
+
+	poolIdleConns = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: PoolIdleConns,
+			Help: "Current number of idle database pool connections",
+		},
+		poolStat(func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+	)
+
+	poolNewConns = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: PoolNewConns,
+			Help: "Total number of connections opened by the database pool",
+		},
+		poolStat(func(s *pgxpool.Stat) float64 { return float64(s.NewConnsCount()) }),
+	)
+)
+
+// poolStat returns a function reading one value from the current pool stats, or 0 without a provider
+func poolStat(value func(*pgxpool.Stat) float64) func() float64 {
+	return func() float64 {
+		poolStatsMutex.RLock()
+		provider := poolStatsProvider
+		poolStatsMutex.RUnlock()
+
+		if provider == nil {
+			return 0
+		}
+		stat := provider()
+		if stat == nil {
+			return 0
+		}
+		return value(stat)
+	}
+}
+
+// SetPoolStatsProvider sets the function the connection pool metrics are read from
+func SetPoolStatsProvider(provider func() *pgxpool.Stat) {
+	poolStatsMutex.Lock()
+	defer poolStatsMutex.Unlock()
+	poolStatsProvider = provider
+}
+
 func init() {
 	// Register metrics with the registry
 	registry.MustRegister(requestsTotal)
@@ -104,6 +183,52 @@ func init() {
 	registry.MustRegister(activeConnections)
 	registry.MustRegister(messageCount)
 	registry.MustRegister(errorsTotal)
+	registry.MustRegister(cacheLookups)
+	registry.MustRegister(poolTotalConns)
+	registry.MustRegister(poolAcquiredConns)
+	registry.MustRegister(poolIdleConns)
+	registry.MustRegister(poolNewConns)
+}
+
+// newRequestDuration creates the request duration histogram with the given buckets
+func newRequestDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    RequestDuration,
+			Help:    "HTTP request duration in seconds",
+			Buckets: buckets,
+		},
+		[]string{"method", "path"},
+	)
+}
+
+// SetRequestDurationBuckets replaces the request duration histogram with one using buckets, or the defaults when empty
+// A positive slo, in seconds, is added as a bucket bound so the share of requests meeting it can be read directly
+// Observations made so far are dropped, so call it at startup before serving requests
+func SetRequestDurationBuckets(buckets []float64, slo float64) {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = withBound(buckets, slo)
+
+	requestDurationMutex.Lock()
+	defer requestDurationMutex.Unlock()
+
+	registry.Unregister(requestDuration)
+	requestDuration = newRequestDuration(buckets)
+	registry.MustRegister(requestDuration)
+}
+
+// withBound returns a copy of the increasing buckets with bound inserted in order, unless it is already there or isn't positive
+func withBound(buckets []float64, bound float64) []float64 {
+	result := append([]float64(nil), buckets...)
+	if bound > 0 {
+		i := sort.SearchFloat64s(result, bound)
+		if i == len(result) || result[i] != bound {
+			result = append(result[:i], append([]float64{bound}, result[i:]...)...)
+		}
+	}
+	return result
 }
 
 // RegisterMetricsHandler registers the metrics endpoint with Echo
@@ -114,7 +239,9 @@ func RegisterMetricsHandler(e *echo.Echo) {
 // RecordRequestMetrics records metrics for an HTTP request
 func RecordRequestMetrics(method, path string, status int, duration float64, size int) {
 	requestsTotal.WithLabelValues(method, path, fmt.Sprintf("%d", status)).Inc()
+	requestDurationMutex.RLock()
 	requestDuration.WithLabelValues(method, path).Observe(duration)
+	requestDurationMutex.RUnlock()
 	responseSize.WithLabelValues(method, path).Observe(float64(size))
 }
 
@@ -138,3 +265,12 @@ func RecordMessage(messageType string) {
 func RecordError(errorType string) {
 	errorsTotal.WithLabelValues(errorType).Inc()
 }
+
+// RecordCacheLookup records a cache hit or miss
+func RecordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithBound(t *testing.T) {
+	buckets := []float64{0.01, 0.1, 1}
+
+	assert.Equal(t, []float64{0.01, 0.1, 0.3, 1}, withBound(buckets, 0.3))
+	assert.Equal(t, []float64{0.005, 0.01, 0.1, 1}, withBound(buckets, 0.005))
+	assert.Equal(t, []float64{0.01, 0.1, 1, 5}, withBound(buckets, 5))
+	assert.Equal(t, buckets, withBound(buckets, 0.1))
+	assert.Equal(t, buckets, withBound(buckets, 0))
+
+	// The caller's buckets are left alone
+	assert.Equal(t, []float64{0.01, 0.1, 1}, buckets)
+}
+
+func TestSetRequestDurationBuckets(t *testing.T) {
+	t.Cleanup(func() { SetRequestDurationBuckets(nil, 0) })
+
+	SetRequestDurationBuckets([]float64{0.005, 0.05}, 0.3)
+	RecordRequestMetrics("GET", "/api/v1/messages", 200, 0.004, 10)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var bounds []float64
+	for _, family := range families {
+		if family.GetName() != RequestDuration {
+			continue
+		}
+		for _, bucket := range family.GetMetric()[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, bucket.GetUpperBound())
+		}
+	}
+	assert.Equal(t, []float64{0.005, 0.05, 0.3}, bounds)
+}
@@ -0,0 +1,316 @@
+// Package waveclient is a Go client for the Wave API
+// It keeps the session's tokens, refreshing the access token when it expires, and decodes responses into the types of this package, which mirror the API's JSON
+package waveclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeout bounds each request made with the default HTTP client
+const defaultTimeout = 10 * time.Second
+
+// APIError is an error response from the server
+type APIError struct {
+	StatusCode int
+	Code       string            // Machine-readable error code, such as NOT_FOUND
+	Message    string            // Human-readable description
+	Details    map[string]string // Per-field problems, such as validation failures
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wave: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// Client calls the Wave API on behalf of one user
+// It is safe for concurrent use
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	mutex  sync.Mutex
+	tokens TokenResponse
+
+	// refreshMutex makes concurrent requests that find the access token expired refresh it only once,
+	// since presenting a rotated refresh token again would revoke every session of the user
+	refreshMutex sync.Mutex
+}
+
+// New creates a client for the server at baseURL, such as https://wave.example.com
+// A nil httpClient uses one with a 10 second timeout
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    httpClient,
+	}
+}
+
+// Tokens returns the session's current tokens
+func (c *Client) Tokens() TokenResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.tokens
+}
+
+// SetTokens resumes a session from tokens saved earlier
+func (c *Client) SetTokens(tokens TokenResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tokens = tokens
+}
+
+// Register creates an account and starts a session for it
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (*TokenResponse, error) {
+	return c.startSession(ctx, "/api/v1/auth/register", req)
+}
+
+// Login starts a session for an existing account; deviceName may be empty
+func (c *Client) Login(ctx context.Context, username, deviceName string) (*TokenResponse, error) {
+	return c.startSession(ctx, "/api/v1/auth/login", loginRequest{Username: username, DeviceName: deviceName})
+}
+
+// startSession posts body to a route that returns tokens and keeps them
+func (c *Client) startSession(ctx context.Context, path string, body any) (*TokenResponse, error) {
+	var tokens TokenResponse
+	if err := c.do(ctx, http.MethodPost, path, nil, body, &tokens, false); err != nil {
+		return nil, err
+	}
+	c.SetTokens(tokens)
+	return &tokens, nil
+}
+
+// Refresh exchanges the refresh token for new tokens
+// Requests refresh on their own when the access token has expired, so this is rarely needed
+func (c *Client) Refresh(ctx context.Context) (*TokenResponse, error) {
+	current := c.Tokens()
+	if current.RefreshToken == "" {
+		return nil, fmt.Errorf("wave: no refresh token")
+	}
+
+	var tokens TokenResponse
+	err := c.do(ctx, http.MethodPost, "/api/v1/auth/refresh", nil, refreshRequest{RefreshToken: current.RefreshToken}, &tokens, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// With rotation off the server keeps the refresh token and returns none
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = current.RefreshToken
+		tokens.RefreshExpiresIn = current.RefreshExpiresIn
+	}
+	c.SetTokens(tokens)
+	return &tokens, nil
+}
+
+// Logout ends the session and forgets its tokens
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, "/api/v1/auth/logout", nil, nil, nil, true); err != nil {
+		return err
+	}
+	c.SetTokens(TokenResponse{})
+	return nil
+}
+
+// SendMessage sends a message encrypted by the caller
+func (c *Client) SendMessage(ctx context.Context, req SendMessageRequest) (*MessageResponse, error) {
+	var message MessageResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/messages/send", nil, req, &message, true); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// SendMultiMessage sends a message encrypted by the caller for each of several recipients, with one copy for the sender
+// Either every copy is stored or none is
+func (c *Client) SendMultiMessage(ctx context.Context, req SendMultiMessageRequest) (*SendMultiMessageResponse, error) {
+	var sent SendMultiMessageResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/messages/send-multi", nil, req, &sent, true); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// GetMessages gets a page of the messages the user sent or received
+func (c *Client) GetMessages(ctx context.Context, req GetMessagesRequest) (*MessagesResponse, error) {
+	var messages MessagesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/messages", messagesQuery(req), nil, &messages, true); err != nil {
+		return nil, err
+	}
+	return &messages, nil
+}
+
+// GetConversation gets a page of the messages exchanged with contactPubKey
+func (c *Client) GetConversation(ctx context.Context, contactPubKey string, req GetMessagesRequest) (*MessagesResponse, error) {
+	var messages MessagesResponse
+	path := "/api/v1/messages/conversation/" + url.PathEscape(contactPubKey)
+	if err := c.do(ctx, http.MethodGet, path, messagesQuery(req), nil, &messages, true); err != nil {
+		return nil, err
+	}
+	return &messages, nil
+}
+
+// UpdateMessageStatus marks a received message delivered or read
+func (c *Client) UpdateMessageStatus(ctx context.Context, messageID, status string) error {
+	path := "/api/v1/messages/" + url.PathEscape(messageID) + "/status"
+	return c.do(ctx, http.MethodPatch, path, nil, statusRequest{Status: status}, nil, true)
+}
+
+// AddContact adds a contact
+func (c *Client) AddContact(ctx context.Context, req AddContactRequest) (*ContactResponse, error) {
+	var contact ContactResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/contacts", nil, req, &contact, true); err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// GetContacts gets a page of the user's contacts
+func (c *Client) GetContacts(ctx context.Context, req GetContactsRequest) (*ContactsResponse, error) {
+	query := url.Values{}
+	setInt(query, "limit", req.Limit)
+	setInt(query, "offset", req.Offset)
+	if req.Group != "" {
+		query.Set("group", req.Group)
+	}
+
+	var contacts ContactsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/contacts", query, nil, &contacts, true); err != nil {
+		return nil, err
+	}
+	return &contacts, nil
+}
+
+// DeleteContact removes a contact
+func (c *Client) DeleteContact(ctx context.Context, contactPubKey string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/contacts/"+url.PathEscape(contactPubKey), nil, nil, nil, true)
+}
+
+// messagesQuery encodes the paging parameters of a message listing
+func messagesQuery(req GetMessagesRequest) url.Values {
+	query := url.Values{}
+	setInt(query, "limit", req.Limit)
+	setInt(query, "offset", req.Offset)
+	for key, value := range map[string]string{"before": req.Before, "since": req.Since, "order": req.Order} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+// setInt sets a query parameter unless value is 0, which the server treats as unset
+func setInt(query url.Values, key string, value int) {
+	if value != 0 {
+		query.Set(key, strconv.Itoa(value))
+	}
+}
+
+// do sends a request and decodes the data of a successful response into out, which may be nil
+// Authenticated requests that fail because the access token expired are refreshed and sent once more
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any, authenticated bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("wave: failed to encode request: %w", err)
+		}
+	}
+
+	accessToken := c.Tokens().AccessToken
+	err := c.send(ctx, method, path, query, payload, out, authenticated)
+
+	var apiErr *APIError
+	if !authenticated || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	if refreshErr := c.refreshExpired(ctx, accessToken); refreshErr != nil {
+		return err
+	}
+	return c.send(ctx, method, path, query, payload, out, authenticated)
+}
+
+// refreshExpired refreshes the tokens after expired was rejected, unless another request already has
+func (c *Client) refreshExpired(ctx context.Context, expired string) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	if c.Tokens().AccessToken != expired {
+		return nil
+	}
+	_, err := c.Refresh(ctx)
+	return err
+}
+
+// send makes a single attempt at a request
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, payload []byte, out any, authenticated bool) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Errorf("wave: failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if authenticated {
+		if token := c.Tokens().AccessToken; token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("wave: %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   *errorInfo      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("wave: failed to decode response: %w", err)
+	}
+
+	if !envelope.Success || resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		if envelope.Error != nil {
+			apiErr.Code = envelope.Error.Code
+			apiErr.Message = envelope.Error.Message
+			apiErr.Details = envelope.Error.Details
+		}
+		return apiErr
+	}
+
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return fmt.Errorf("wave: failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package waveclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pzkpfw44/wave-server/internal/api/request"
+	"github.com/pzkpfw44/wave-server/internal/api/response"
+)
+
+// fakeServer answers login, refresh and contact listing; its first access token is already expired
+type fakeServer struct {
+	refreshes atomic.Int32
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	write := func(status int, body response.Response) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/auth/login":
+		write(http.StatusOK, response.NewSuccessResponse(response.TokenResponse{AccessToken: "expired", RefreshToken: "refresh-1"}))
+
+	case "/api/v1/auth/refresh":
+		var req request.RefreshTokenRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.RefreshToken != "refresh-1" {
+			write(http.StatusUnauthorized, response.NewErrorResponse("Refresh token reused", "UNAUTHENTICATED"))
+			return
+		}
+		f.refreshes.Add(1)
+		write(http.StatusOK, response.NewSuccessResponse(response.TokenResponse{AccessToken: "fresh", RefreshToken: "refresh-2"}))
+
+	case "/api/v1/contacts":
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			write(http.StatusUnauthorized, response.NewErrorResponse("Token expired", "UNAUTHENTICATED"))
+			return
+		}
+		if r.URL.Query().Get("group") == "missing" {
+			write(http.StatusNotFound, response.NewErrorResponse("Group not found", "NOT_FOUND"))
+			return
+		}
+		write(http.StatusOK, response.NewSuccessResponse(response.ContactsResponse{
+			Contacts: []response.ContactResponse{{ContactPubKey: "key", Nickname: "alice"}},
+		}))
+
+	default:
+		write(http.StatusNotFound, response.NewErrorResponse("Not found", "NOT_FOUND"))
+	}
+}
+
+func TestClientRefreshesExpiredAccessToken(t *testing.T) {
+	fake := &fakeServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx := context.Background()
+	client := New(server.URL+"/", nil)
+
+	_, err := client.Login(ctx, "alice", "")
+	require.NoError(t, err)
+
+	// Concurrent requests that all find the token expired share a single refresh
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contacts, err := client.GetContacts(ctx, GetContactsRequest{Limit: 10})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "alice", contacts.Contacts[0].Nickname)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, fake.refreshes.Load())
+	assert.Equal(t, "fresh", client.Tokens().AccessToken)
+	assert.Equal(t, "refresh-2", client.Tokens().RefreshToken)
+}
+
+func TestClientReturnsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(&fakeServer{})
+	defer server.Close()
+
+	client := New(server.URL, nil)
+	client.SetTokens(TokenResponse{AccessToken: "fresh"})
+
+	_, err := client.GetContacts(context.Background(), GetContactsRequest{Group: "missing"})
+
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "NOT_FOUND", apiErr.Code)
+	assert.Equal(t, "Group not found", apiErr.Message)
+}
+
+// TestTypesMatchServer checks the client's types encode the same JSON as the server's, so neither drifts from the other
+func TestTypesMatchServer(t *testing.T) {
+	total := 2
+	replyTo := &MessageReference{MessageID: "reply", SenderPubKey: "bob", Timestamp: "then"}
+	message := MessageResponse{
+		MessageID: "id", SenderPubKey: "alice", RecipientPubKey: "bob",
+		CiphertextKEM: "kem", CiphertextMsg: "msg", Nonce: "nonce",
+		SenderCiphertextKEM: "skem", SenderCiphertextMsg: "smsg", SenderNonce: "snonce",
+		Timestamp: "now", Status: "read", ContentHash: "hash", ReplyToMessageID: "reply", ExpiresAt: "later",
+		ReplyTo: replyTo,
+	}
+
+	tests := []struct {
+		name   string
+		client any
+		server any
+	}{
+		{"register", RegisterRequest{"alice", "pk", "epk", "salt", "laptop", "challenge", "nonce"}, &request.RegisterRequest{}},
+		{"login", loginRequest{"alice", "laptop"}, &request.LoginRequest{}},
+		{"refresh", refreshRequest{"token"}, &request.RefreshTokenRequest{}},
+		{"status", statusRequest{"read"}, &request.UpdateMessageStatusRequest{}},
+		{"send", SendMessageRequest{"id", "bob", "kem", "msg", "nonce", "skem", "smsg", "snonce", "reply", 60}, &request.SendMessageRequest{}},
+		{"send multi", SendMultiMessageRequest{
+			Recipients:          []MessageRecipient{{"bob", "kem", "msg", "nonce"}},
+			SenderCiphertextKEM: "skem", SenderCiphertextMsg: "smsg", SenderNonce: "snonce", ExpiresInSeconds: 60,
+		}, &request.SendMultiMessageRequest{}},
+		{"add contact", AddContactRequest{"key", "bob", "friends"}, &request.AddContactRequest{}},
+		{"tokens", TokenResponse{"access", "Bearer", 900, "refresh", 3600}, &response.TokenResponse{}},
+		{"messages", MessagesResponse{
+			Messages:   []MessageResponse{message},
+			Pagination: Pagination{Limit: 1, Offset: 1, Total: &total, HasMore: true},
+			NextCursor: "cursor",
+		}, &response.MessagesResponse{}},
+		{"send multi result", SendMultiMessageResponse{[]string{"a", "b"}, "now", 1}, &response.SendMultiMessageResponse{}},
+		{"contacts", ContactsResponse{
+			Contacts:   []ContactResponse{{"key", "bob", "friends", "bob_user", "then"}},
+			Pagination: Pagination{Limit: 1, HasMore: true},
+		}, &response.ContactsResponse{}},
+		{"error", errorInfo{"Not found", "NOT_FOUND", map[string]string{"field": "problem"}}, &response.ErrorInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.client)
+			require.NoError(t, err)
+
+			// A field the server doesn't know would be dropped here, and one the client lacks would be missing
+			require.NoError(t, json.Unmarshal(encoded, tt.server))
+			reencoded, err := json.Marshal(tt.server)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(encoded), string(reencoded))
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package waveclient
+
+// The types below mirror the JSON the server reads and writes, so programs outside this module can use them
+
+// RegisterRequest creates an account; the keys are generated and the private key encrypted by the caller
+type RegisterRequest struct {
+	Username            string `json:"username"`
+	PublicKey           string `json:"public_key"`
+	EncryptedPrivateKey string `json:"encrypted_private_key"`
+	Salt                string `json:"salt"`
+	DeviceName          string `json:"device_name,omitempty"`
+
+	// PoWChallenge and PoWNonce solve a challenge from GET /auth/challenge; required only when the server asks for proof of work
+	PoWChallenge string `json:"pow_challenge,omitempty"`
+	PoWNonce     string `json:"pow_nonce,omitempty"`
+}
+
+// SendMessageRequest is a message encrypted by the caller for its recipient, with a copy for the sender
+type SendMessageRequest struct {
+	MessageID           string `json:"message_id,omitempty"` // Client-chosen ID; resending with the same ID doesn't create a duplicate
+	RecipientPubKey     string `json:"recipient_pubkey"`
+	CiphertextKEM       string `json:"ciphertext_kem"`
+	CiphertextMsg       string `json:"ciphertext_msg"`
+	Nonce               string `json:"nonce"`
+	SenderCiphertextKEM string `json:"sender_ciphertext_kem"`
+	SenderCiphertextMsg string `json:"sender_ciphertext_msg"`
+	SenderNonce         string `json:"sender_nonce"`
+	ReplyToMessageID    string `json:"reply_to_message_id,omitempty"`
+	ExpiresInSeconds    int    `json:"expires_in_seconds,omitempty"` // Delete the message this long after sending; at most 30 days
+}
+
+// SendMultiMessageRequest is one message encrypted by the caller for each of up to 100 recipients, with one copy for the sender
+type SendMultiMessageRequest struct {
+	Recipients          []MessageRecipient `json:"recipients"`
+	SenderCiphertextKEM string             `json:"sender_ciphertext_kem"`
+	SenderCiphertextMsg string             `json:"sender_ciphertext_msg"`
+	SenderNonce         string             `json:"sender_nonce"`
+	ExpiresInSeconds    int                `json:"expires_in_seconds,omitempty"` // Delete the messages this long after sending; at most 30 days
+}
+
+// MessageRecipient is a message encrypted for one of its recipients
+type MessageRecipient struct {
+	RecipientPubKey string `json:"recipient_pubkey"`
+	CiphertextKEM   string `json:"ciphertext_kem"`
+	CiphertextMsg   string `json:"ciphertext_msg"`
+	Nonce           string `json:"nonce"`
+}
+
+// GetMessagesRequest pages through messages; zero values are left out
+type GetMessagesRequest struct {
+	Limit  int
+	Offset int
+	Before string // Cursor: a message timestamp or message ID; replaces offset when set
+	Since  string // Cursor for ascending order, paging forward from a message timestamp (or, in conversations, a message ID)
+	Order  string // asc or desc (the default)
+}
+
+// AddContactRequest adds a contact
+type AddContactRequest struct {
+	ContactPublicKey string `json:"contact_public_key"`
+	Nickname         string `json:"nickname"`
+	GroupName        string `json:"group_name,omitempty"`
+}
+
+// GetContactsRequest pages through contacts; zero values are left out
+type GetContactsRequest struct {
+	Limit  int
+	Offset int
+	Group  string // Only list contacts in this group
+}
+
+// TokenResponse holds a session's tokens
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`                   // Seconds
+	RefreshToken     string `json:"refresh_token,omitempty"`      // Exchanged for new tokens when the access token expires
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty"` // Seconds
+}
+
+// MessageResponse is a stored message
+type MessageResponse struct {
+	MessageID           string `json:"message_id"`
+	SenderPubKey        string `json:"sender_pubkey"`
+	RecipientPubKey     string `json:"recipient_pubkey"`
+	CiphertextKEM       string `json:"ciphertext_kem"`
+	CiphertextMsg       string `json:"ciphertext_msg"`
+	Nonce               string `json:"nonce"`
+	SenderCiphertextKEM string `json:"sender_ciphertext_kem,omitempty"`
+	SenderCiphertextMsg string `json:"sender_ciphertext_msg,omitempty"`
+	SenderNonce         string `json:"sender_nonce,omitempty"`
+	Timestamp           string `json:"timestamp"`
+	Status              string `json:"status"`
+	ContentHash         string `json:"content_hash,omitempty"`
+	ReplyToMessageID    string `json:"reply_to_message_id,omitempty"`
+	ExpiresAt           string `json:"expires_at,omitempty"`
+
+	// ReplyTo describes the replied-to message; only included in conversations
+	ReplyTo *MessageReference `json:"reply_to,omitempty"`
+}
+
+// MessageReference identifies a replied-to message
+type MessageReference struct {
+	MessageID    string `json:"message_id"`
+	SenderPubKey string `json:"sender_pubkey"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// SendMultiMessageResponse is the outcome of sending a message to several recipients
+type SendMultiMessageResponse struct {
+	MessageIDs []string `json:"message_ids"` // In the order the recipients were given
+	Timestamp  string   `json:"timestamp"`
+	Failed     int      `json:"failed"` // Copies to recipients who can't receive them; they are kept as failed for resending
+}
+
+// MessagesResponse is a page of messages
+type MessagesResponse struct {
+	Messages   []MessageResponse `json:"messages"`
+	Pagination Pagination        `json:"pagination"`
+	NextCursor string            `json:"next_cursor,omitempty"` // Pass as Before to get the next page
+}
+
+// ContactResponse is a contact
+type ContactResponse struct {
+	ContactPubKey string `json:"contact_pubkey"`
+	Nickname      string `json:"nickname"`
+	GroupName     string `json:"group_name,omitempty"`
+	Username      string `json:"username,omitempty"` // Registered owner of the public key, if discoverable
+	CreatedAt     string `json:"created_at"`
+}
+
+// ContactsResponse is a page of contacts
+type ContactsResponse struct {
+	Contacts   []ContactResponse `json:"contacts"`
+	Pagination Pagination        `json:"pagination"`
+}
+
+// Pagination describes a page of a listing
+type Pagination struct {
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	Total   *int `json:"total,omitempty"` // Only set when the total is known
+	HasMore bool `json:"has_more"`
+}
+
+// loginRequest, refreshRequest and statusRequest are request bodies the client builds itself
+type loginRequest struct {
+	Username   string `json:"username"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type statusRequest struct {
+	Status string `json:"status"`
+}
+
+// errorInfo is the error of a failed response
+type errorInfo struct {
+	Message string            `json:"message"`
+	Code    string            `json:"code"`
+	Details map[string]string `json:"details,omitempty"`
+}
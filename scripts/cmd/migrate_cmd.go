@@ -4,18 +4,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 
 	"github.com/pzkpfw44/wave-server/scripts/migration_tool"
 )
 
 func main() {
 	sourceDir := flag.String("source", "./old_data", "Source directory for old data")
+	checkpoint := flag.String("checkpoint", "", "Progress file for resuming an interrupted migration (default: <source>/.wave_migration_progress)")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of concurrent workers reading and storing messages")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	isDev := flag.Bool("dev", true, "Development mode")
 
 	flag.Parse()
 
-	if err := migration_tool.RunMigrationTool(*sourceDir, *logLevel, *isDev); err != nil {
+	if err := migration_tool.RunMigrationTool(*sourceDir, *checkpoint, *logLevel, *isDev, *workers); err != nil {
 		fmt.Printf("Migration failed: %v\n", err)
 		os.Exit(1)
 	}
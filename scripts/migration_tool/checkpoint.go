@@ -0,0 +1,89 @@
+package migration_tool
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Checkpoint records which source files have been imported, so a restarted migration skips them
+// The file is append-only, one source path per line, so a crash loses at most the batch in flight
+type Checkpoint struct {
+	file  *os.File
+	mutex sync.Mutex
+	done  map[string]bool
+}
+
+// OpenCheckpoint loads the checkpoint at path, creating it if it doesn't exist
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// A torn last line from a crash is just a file that gets imported again
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return &Checkpoint{file: file, done: done}, nil
+}
+
+// Done reports whether the source file has already been imported
+func (c *Checkpoint) Done(sourceFile string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done[sourceFile]
+}
+
+// Len returns the number of imported source files
+func (c *Checkpoint) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.done)
+}
+
+// MarkDone records the source files as imported and syncs the checkpoint to disk
+func (c *Checkpoint) MarkDone(sourceFiles ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var b strings.Builder
+	for _, sourceFile := range sourceFiles {
+		if c.done[sourceFile] {
+			continue
+		}
+		b.WriteString(sourceFile)
+		b.WriteByte('\n')
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	if _, err := c.file.WriteString(b.String()); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+
+	for _, sourceFile := range sourceFiles {
+		c.done[sourceFile] = true
+	}
+	return nil
+}
+
+// Close closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/pzkpfw44/wave-server/internal/config"
 	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/errors"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/security"
 	"github.com/pzkpfw44/wave-server/pkg/logger"
@@ -26,11 +28,26 @@ var (
 	sourceDir = getEnvOrDefault("SOURCE_DIR", "./old_data")
 )
 
+// checkpointFileName is the default checkpoint file, kept in the source directory
+const checkpointFileName = ".wave_migration_progress"
+
+// messageBatchSize is the number of messages stored per round trip
+const messageBatchSize = 500
+
 // RunMigrationTool is the main entry point for the migration tool
-func RunMigrationTool(source string, logLevel string, isDev bool) error {
+// Progress is recorded in the checkpoint file so a failed run can be restarted where it stopped;
+// an empty checkpointPath keeps it in the source directory
+// Messages are read and stored by the given number of concurrent workers
+func RunMigrationTool(source, checkpointPath, logLevel string, isDev bool, workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
 	if source != "" {
 		sourceDir = source
 	}
+	if checkpointPath == "" {
+		checkpointPath = filepath.Join(sourceDir, checkpointFileName)
+	}
 
 	// Load .env file if it exists
 	_ = godotenv.Load()
@@ -65,18 +82,29 @@ func RunMigrationTool(source string, logLevel string, isDev bool) error {
 	messageRepo := repository.NewMessageRepository(db)
 	contactRepo := repository.NewContactRepository(db)
 
-	// Create extractor
-	extractor := NewExtractorTool(sourceDir, log)
-
 	// Verify that source directory exists
 	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
 		log.Fatal("Source directory does not exist", zap.String("directory", sourceDir))
 		return fmt.Errorf("source directory does not exist: %s", sourceDir)
 	}
 
+	// Load the progress of earlier runs
+	checkpoint, err := OpenCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+
+	if imported := checkpoint.Len(); imported > 0 {
+		log.Info("Resuming migration", zap.String("checkpoint", checkpointPath), zap.Int("files_imported", imported))
+	}
+
+	// Create extractor
+	extractor := NewExtractorTool(sourceDir, checkpoint, workers, cfg.Auth.UserIDSecret, log)
+
 	// Run migration
 	dryRun := false
-	err = RunMigration(ctx, extractor, userRepo, messageRepo, contactRepo, log, dryRun)
+	err = RunMigration(ctx, extractor, checkpoint, userRepo, messageRepo, contactRepo, log, dryRun)
 	if err != nil {
 		log.Fatal("Migration failed", zap.Error(err))
 		return fmt.Errorf("migration failed: %v", err)
@@ -87,21 +115,55 @@ func RunMigrationTool(source string, logLevel string, isDev bool) error {
 }
 
 // ExtractorTool extracts data from the old file-based storage system
+// Source files already recorded in the checkpoint are skipped
 type ExtractorTool struct {
-	sourceDir string
-	logger    *zap.Logger
+	sourceDir    string
+	checkpoint   *Checkpoint
+	workers      int
+	userIDSecret string // Must match the server's USER_ID_SECRET, or imported users get IDs the server won't find
+	logger       *zap.Logger
 }
 
-// NewExtractorTool creates a new extractor
-func NewExtractorTool(sourceDir string, logger *zap.Logger) *ExtractorTool {
+// NewExtractorTool creates a new extractor that reads message files with the given number of workers
+func NewExtractorTool(sourceDir string, checkpoint *Checkpoint, workers int, userIDSecret string, logger *zap.Logger) *ExtractorTool {
 	return &ExtractorTool{
-		sourceDir: sourceDir,
-		logger:    logger.With(zap.String("component", "extractor")),
+		sourceDir:    sourceDir,
+		checkpoint:   checkpoint,
+		workers:      workers,
+		userIDSecret: userIDSecret,
+		logger:       logger.With(zap.String("component", "extractor")),
 	}
 }
 
+// UserRecord is a user and the source file it was extracted from
+type UserRecord struct {
+	SourceFile string
+	User       *domain.User
+}
+
+// ContactRecord is the contacts extracted from one source file
+type ContactRecord struct {
+	SourceFile string
+	Contacts   []*domain.Contact
+}
+
+// MessageRecord is a message and the source file it was extracted from
+type MessageRecord struct {
+	SourceFile string
+	Message    *domain.Message
+}
+
+// sourceFile returns the checkpoint key for a path under the source directory
+func (e *ExtractorTool) sourceFile(path string) string {
+	rel, err := filepath.Rel(e.sourceDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
 // ExtractUsers extracts users from the old file-based storage
-func (e *ExtractorTool) ExtractUsers() ([]*domain.User, error) {
+func (e *ExtractorTool) ExtractUsers() ([]*UserRecord, error) {
 	userDir := filepath.Join(e.sourceDir, "extension_wave_keys")
 	e.logger.Info("Extracting users", zap.String("directory", userDir))
 
@@ -110,13 +172,20 @@ func (e *ExtractorTool) ExtractUsers() ([]*domain.User, error) {
 		return nil, fmt.Errorf("failed to read user directory: %w", err)
 	}
 
-	var users []*domain.User
+	var users []*UserRecord
+	skipped := 0
 
 	// Process public key files
 	for _, file := range files {
 		if strings.HasSuffix(file.Name(), "_public.key") {
 			username := strings.TrimSuffix(file.Name(), "_public.key")
 
+			source := e.sourceFile(filepath.Join(userDir, file.Name()))
+			if e.checkpoint.Done(source) {
+				skipped++
+				continue
+			}
+
 			// Check if private key file also exists
 			privateKeyFile := username + "_private.json"
 			privateKeyPath := filepath.Join(userDir, privateKeyFile)
@@ -164,7 +233,7 @@ func (e *ExtractorTool) ExtractUsers() ([]*domain.User, error) {
 			}
 
 			// Create user object
-			userID := security.HashUsername(username)
+			userID := security.HashUsername(username, e.userIDSecret)
 			now := time.Now()
 			user := &domain.User{
 				UserID:              userID,
@@ -176,17 +245,17 @@ func (e *ExtractorTool) ExtractUsers() ([]*domain.User, error) {
 				LastActive:          now,
 			}
 
-			users = append(users, user)
+			users = append(users, &UserRecord{SourceFile: source, User: user})
 			e.logger.Info("Extracted user", zap.String("username", username))
 		}
 	}
 
-	e.logger.Info("User extraction completed", zap.Int("count", len(users)))
+	e.logger.Info("User extraction completed", zap.Int("count", len(users)), zap.Int("already_imported", skipped))
 	return users, nil
 }
 
 // ExtractContacts extracts contacts from the old file-based storage
-func (e *ExtractorTool) ExtractContacts() ([]*domain.Contact, error) {
+func (e *ExtractorTool) ExtractContacts() ([]*ContactRecord, error) {
 	contactDir := filepath.Join(e.sourceDir, "extension_wave_contacts")
 	e.logger.Info("Extracting contacts", zap.String("directory", contactDir))
 
@@ -195,15 +264,23 @@ func (e *ExtractorTool) ExtractContacts() ([]*domain.Contact, error) {
 		return nil, fmt.Errorf("failed to read contact directory: %w", err)
 	}
 
-	var contacts []*domain.Contact
+	var contacts []*ContactRecord
+	count := 0
+	skipped := 0
 
 	// Process contact files
 	for _, file := range files {
 		if strings.HasSuffix(file.Name(), ".json") {
 			username := strings.TrimSuffix(file.Name(), ".json")
 
-			// Read contact file
 			contactPath := filepath.Join(contactDir, file.Name())
+			source := e.sourceFile(contactPath)
+			if e.checkpoint.Done(source) {
+				skipped++
+				continue
+			}
+
+			// Read contact file
 			contactData, err := ioutil.ReadFile(contactPath)
 			if err != nil {
 				e.logger.Error("Failed to read contact file", zap.String("file", contactPath), zap.Error(err))
@@ -220,9 +297,10 @@ func (e *ExtractorTool) ExtractContacts() ([]*domain.Contact, error) {
 			}
 
 			// Create user ID
-			userID := security.HashUsername(username)
+			userID := security.HashUsername(username, e.userIDSecret)
 
 			// Create contacts
+			record := &ContactRecord{SourceFile: source}
 			for pubKey, contactInfo := range contactMap {
 				nickname := contactInfo.Nickname
 				if nickname == "" {
@@ -236,8 +314,10 @@ func (e *ExtractorTool) ExtractContacts() ([]*domain.Contact, error) {
 					CreatedAt:     time.Now(),
 				}
 
-				contacts = append(contacts, contact)
+				record.Contacts = append(record.Contacts, contact)
 			}
+			contacts = append(contacts, record)
+			count += len(record.Contacts)
 
 			e.logger.Info("Extracted contacts for user",
 				zap.String("username", username),
@@ -245,23 +325,25 @@ func (e *ExtractorTool) ExtractContacts() ([]*domain.Contact, error) {
 		}
 	}
 
-	e.logger.Info("Contact extraction completed", zap.Int("count", len(contacts)))
+	e.logger.Info("Contact extraction completed", zap.Int("count", count), zap.Int("already_imported", skipped))
 	return contacts, nil
 }
 
 // ExtractMessages extracts messages from the old file-based storage
-func (e *ExtractorTool) ExtractMessages() ([]*domain.Message, error) {
+// Message files are read and parsed by the extractor's workers concurrently
+func (e *ExtractorTool) ExtractMessages() ([]*MessageRecord, error) {
 	messageDir := filepath.Join(e.sourceDir, "extension_wave_messages")
-	e.logger.Info("Extracting messages", zap.String("directory", messageDir))
+	e.logger.Info("Extracting messages", zap.String("directory", messageDir), zap.Int("workers", e.workers))
 
 	folders, err := ioutil.ReadDir(messageDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read message directory: %w", err)
 	}
 
-	var messages []*domain.Message
+	var messagePaths []string
+	skipped := 0
 
-	// Process each user folder
+	// Collect the message files of each user folder
 	for _, folder := range folders {
 		if !folder.IsDir() {
 			continue
@@ -274,128 +356,169 @@ func (e *ExtractorTool) ExtractMessages() ([]*domain.Message, error) {
 			continue
 		}
 
-		// Process each message file
 		for _, messageFile := range messageFiles {
 			if messageFile.IsDir() {
 				continue
 			}
 
-			// Read message file
 			messagePath := filepath.Join(userFolder, messageFile.Name())
-			messageData, err := ioutil.ReadFile(messagePath)
-			if err != nil {
-				e.logger.Error("Failed to read message file", zap.String("file", messagePath), zap.Error(err))
+			if e.checkpoint.Done(e.sourceFile(messagePath)) {
+				skipped++
 				continue
 			}
+			messagePaths = append(messagePaths, messagePath)
+		}
+	}
 
-			var msgData struct {
-				MessageID           string  `json:"message_id"`
-				SenderPubKey        string  `json:"sender_pubkey_b64"`
-				RecipientPubKey     string  `json:"recipient_pubkey_b64"`
-				CiphertextKEM       string  `json:"ciphertext_kem"`
-				CiphertextMsg       string  `json:"ciphertext_msg"`
-				Nonce               string  `json:"nonce"`
-				SenderCiphertextKEM string  `json:"sender_ciphertext_kem,omitempty"`
-				SenderCiphertextMsg string  `json:"sender_ciphertext_msg,omitempty"`
-				SenderNonce         string  `json:"sender_nonce,omitempty"`
-				Timestamp           float64 `json:"timestamp"`
-				Status              string  `json:"status,omitempty"`
+	// Each worker writes to its own slots, so the result keeps the order of the source files
+	records := make([]*MessageRecord, len(messagePaths))
+	paths := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range paths {
+				records[i] = e.extractMessage(messagePaths[i])
 			}
+		}()
+	}
+	for i := range messagePaths {
+		paths <- i
+	}
+	close(paths)
+	wg.Wait()
+
+	// Files that failed to parse were logged and left out
+	messages := make([]*MessageRecord, 0, len(records))
+	for _, record := range records {
+		if record != nil {
+			messages = append(messages, record)
+		}
+	}
 
-			if err := json.Unmarshal(messageData, &msgData); err != nil {
-				e.logger.Error("Failed to parse message JSON", zap.String("file", messagePath), zap.Error(err))
-				continue
-			}
+	e.logger.Info("Message extraction completed", zap.Int("count", len(messages)), zap.Int("already_imported", skipped))
+	return messages, nil
+}
 
-			// Decode base64 fields
-			ciphertextKEM, err := base64.URLEncoding.DecodeString(msgData.CiphertextKEM)
-			if err != nil {
-				e.logger.Error("Failed to decode ciphertext KEM", zap.Error(err))
-				continue
-			}
+// extractMessage reads and parses one message file
+// It logs and returns nil if the file can't be read or parsed
+func (e *ExtractorTool) extractMessage(messagePath string) *MessageRecord {
+	source := e.sourceFile(messagePath)
 
-			ciphertextMsg, err := base64.URLEncoding.DecodeString(msgData.CiphertextMsg)
-			if err != nil {
-				e.logger.Error("Failed to decode ciphertext message", zap.Error(err))
-				continue
-			}
+	// Read message file
+	messageData, err := ioutil.ReadFile(messagePath)
+	if err != nil {
+		e.logger.Error("Failed to read message file", zap.String("file", messagePath), zap.Error(err))
+		return nil
+	}
 
-			nonce, err := base64.URLEncoding.DecodeString(msgData.Nonce)
-			if err != nil {
-				e.logger.Error("Failed to decode nonce", zap.Error(err))
-				continue
-			}
+	var msgData struct {
+		MessageID           string  `json:"message_id"`
+		SenderPubKey        string  `json:"sender_pubkey_b64"`
+		RecipientPubKey     string  `json:"recipient_pubkey_b64"`
+		CiphertextKEM       string  `json:"ciphertext_kem"`
+		CiphertextMsg       string  `json:"ciphertext_msg"`
+		Nonce               string  `json:"nonce"`
+		SenderCiphertextKEM string  `json:"sender_ciphertext_kem,omitempty"`
+		SenderCiphertextMsg string  `json:"sender_ciphertext_msg,omitempty"`
+		SenderNonce         string  `json:"sender_nonce,omitempty"`
+		Timestamp           float64 `json:"timestamp"`
+		Status              string  `json:"status,omitempty"`
+	}
 
-			// Decode sender fields if present
-			var senderCiphertextKEM, senderCiphertextMsg, senderNonce []byte
+	if err := json.Unmarshal(messageData, &msgData); err != nil {
+		e.logger.Error("Failed to parse message JSON", zap.String("file", messagePath), zap.Error(err))
+		return nil
+	}
 
-			if msgData.SenderCiphertextKEM != "" {
-				senderCiphertextKEM, err = base64.URLEncoding.DecodeString(msgData.SenderCiphertextKEM)
-				if err != nil {
-					e.logger.Error("Failed to decode sender ciphertext KEM", zap.Error(err))
-					continue
-				}
-			}
+	// Decode base64 fields
+	ciphertextKEM, err := base64.URLEncoding.DecodeString(msgData.CiphertextKEM)
+	if err != nil {
+		e.logger.Error("Failed to decode ciphertext KEM", zap.Error(err))
+		return nil
+	}
 
-			if msgData.SenderCiphertextMsg != "" {
-				senderCiphertextMsg, err = base64.URLEncoding.DecodeString(msgData.SenderCiphertextMsg)
-				if err != nil {
-					e.logger.Error("Failed to decode sender ciphertext message", zap.Error(err))
-					continue
-				}
-			}
+	ciphertextMsg, err := base64.URLEncoding.DecodeString(msgData.CiphertextMsg)
+	if err != nil {
+		e.logger.Error("Failed to decode ciphertext message", zap.Error(err))
+		return nil
+	}
 
-			if msgData.SenderNonce != "" {
-				senderNonce, err = base64.URLEncoding.DecodeString(msgData.SenderNonce)
-				if err != nil {
-					e.logger.Error("Failed to decode sender nonce", zap.Error(err))
-					continue
-				}
-			}
+	nonce, err := base64.URLEncoding.DecodeString(msgData.Nonce)
+	if err != nil {
+		e.logger.Error("Failed to decode nonce", zap.Error(err))
+		return nil
+	}
 
-			// Parse message ID or generate a new one
-			var messageID uuid.UUID
-			if msgData.MessageID != "" {
-				messageID, err = uuid.Parse(msgData.MessageID)
-				if err != nil {
-					e.logger.Warn("Invalid message ID, generating new one", zap.String("invalid_id", msgData.MessageID))
-					messageID = uuid.New()
-				}
-			} else {
-				messageID = uuid.New()
-			}
+	// Decode sender fields if present
+	var senderCiphertextKEM, senderCiphertextMsg, senderNonce []byte
 
-			// Use "sent" as default status if not specified
-			status := domain.MessageStatusSent
-			if msgData.Status != "" {
-				status = domain.MessageStatus(msgData.Status)
-			}
+	if msgData.SenderCiphertextKEM != "" {
+		senderCiphertextKEM, err = base64.URLEncoding.DecodeString(msgData.SenderCiphertextKEM)
+		if err != nil {
+			e.logger.Error("Failed to decode sender ciphertext KEM", zap.Error(err))
+			return nil
+		}
+	}
 
-			// Create message object
-			message := &domain.Message{
-				MessageID:           messageID,
-				SenderPubKey:        msgData.SenderPubKey,
-				RecipientPubKey:     msgData.RecipientPubKey,
-				CiphertextKEM:       ciphertextKEM,
-				CiphertextMsg:       ciphertextMsg,
-				Nonce:               nonce,
-				SenderCiphertextKEM: senderCiphertextKEM,
-				SenderCiphertextMsg: senderCiphertextMsg,
-				SenderNonce:         senderNonce,
-				Timestamp:           time.Unix(int64(msgData.Timestamp), 0),
-				Status:              status,
-			}
+	if msgData.SenderCiphertextMsg != "" {
+		senderCiphertextMsg, err = base64.URLEncoding.DecodeString(msgData.SenderCiphertextMsg)
+		if err != nil {
+			e.logger.Error("Failed to decode sender ciphertext message", zap.Error(err))
+			return nil
+		}
+	}
+
+	if msgData.SenderNonce != "" {
+		senderNonce, err = base64.URLEncoding.DecodeString(msgData.SenderNonce)
+		if err != nil {
+			e.logger.Error("Failed to decode sender nonce", zap.Error(err))
+			return nil
+		}
+	}
 
-			messages = append(messages, message)
+	// The message ID is the dedup key on re-runs, so a missing or invalid one is derived
+	// from the source file rather than generated at random
+	var messageID uuid.UUID
+	if msgData.MessageID != "" {
+		messageID, err = uuid.Parse(msgData.MessageID)
+		if err != nil {
+			e.logger.Warn("Invalid message ID, deriving one from the source file", zap.String("invalid_id", msgData.MessageID))
+			messageID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(source))
 		}
+	} else {
+		messageID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(source))
 	}
 
-	e.logger.Info("Message extraction completed", zap.Int("count", len(messages)))
-	return messages, nil
+	// Use "sent" as default status if not specified
+	status := domain.MessageStatusSent
+	if msgData.Status != "" {
+		status = domain.MessageStatus(msgData.Status)
+	}
+
+	// Create message object
+	message := &domain.Message{
+		MessageID:           messageID,
+		SenderPubKey:        msgData.SenderPubKey,
+		RecipientPubKey:     msgData.RecipientPubKey,
+		CiphertextKEM:       ciphertextKEM,
+		CiphertextMsg:       ciphertextMsg,
+		Nonce:               nonce,
+		SenderCiphertextKEM: senderCiphertextKEM,
+		SenderCiphertextMsg: senderCiphertextMsg,
+		SenderNonce:         senderNonce,
+		Timestamp:           time.Unix(int64(msgData.Timestamp), 0),
+		Status:              status,
+	}
+
+	return &MessageRecord{SourceFile: source, Message: message}
 }
 
 // RunMigration runs the migration process
-func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repository.UserRepository,
+// Each source file is recorded in the checkpoint once its data is stored, and data that is
+// already stored is left as it is, so the migration can be re-run safely after a failure
+func RunMigration(ctx context.Context, extractor *ExtractorTool, checkpoint *Checkpoint, userRepo *repository.UserRepository,
 	messageRepo *repository.MessageRepository, contactRepo *repository.ContactRepository,
 	log *zap.Logger, dryRun bool) error {
 
@@ -406,7 +529,7 @@ func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repos
 		ContactsExtracted int
 		ContactsCreated   int
 		MessagesExtracted int
-		MessagesCreated   int
+		MessagesCreated   int64
 	}
 
 	// Extract users
@@ -419,18 +542,30 @@ func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repos
 	log.Info("Extracted users", zap.Int("count", stats.UsersExtracted))
 
 	// Process users
-	for _, user := range users {
-		if !dryRun {
-			err = userRepo.Create(ctx, user)
-			if err != nil {
+	for _, record := range users {
+		if dryRun {
+			continue
+		}
+
+		user := record.User
+		err = userRepo.Create(ctx, user)
+		if err != nil {
+			// The user ID is derived from the username, so a conflict means an earlier run stored the user
+			if appErr, ok := errors.IsAppError(err); !ok || appErr.Code != errors.ErrCodeConflict {
 				log.Warn("Failed to create user",
 					zap.Error(err),
 					zap.String("username", user.Username),
 					zap.String("user_id", user.UserID))
 				continue
 			}
+			log.Info("User already exists, skipping", zap.String("username", user.Username))
+		} else {
 			stats.UsersCreated++
 		}
+
+		if err := checkpoint.MarkDone(record.SourceFile); err != nil {
+			return err
+		}
 	}
 
 	// Extract contacts
@@ -438,25 +573,31 @@ func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repos
 	if err != nil {
 		return fmt.Errorf("failed to extract contacts: %w", err)
 	}
-	stats.ContactsExtracted = len(contacts)
-
-	log.Info("Extracted contacts", zap.Int("count", stats.ContactsExtracted))
 
 	// Process contacts
-	for _, contact := range contacts {
-		if !dryRun {
-			err = contactRepo.Create(ctx, contact)
-			if err != nil {
-				log.Warn("Failed to create contact",
-					zap.Error(err),
-					zap.String("user_id", contact.UserID),
-					zap.String("contact_pubkey", contact.ContactPubKey))
-				continue
-			}
-			stats.ContactsCreated++
+	for _, record := range contacts {
+		stats.ContactsExtracted += len(record.Contacts)
+		if dryRun {
+			continue
+		}
+
+		// Contacts that already exist are skipped by CreateMany
+		created, err := contactRepo.CreateMany(ctx, record.Contacts)
+		if err != nil {
+			log.Warn("Failed to create contacts",
+				zap.Error(err),
+				zap.String("source_file", record.SourceFile))
+			continue
+		}
+		stats.ContactsCreated += created
+
+		if err := checkpoint.MarkDone(record.SourceFile); err != nil {
+			return err
 		}
 	}
 
+	log.Info("Extracted contacts", zap.Int("count", stats.ContactsExtracted))
+
 	// Extract messages
 	messages, err := extractor.ExtractMessages()
 	if err != nil {
@@ -466,18 +607,14 @@ func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repos
 
 	log.Info("Extracted messages", zap.Int("count", stats.MessagesExtracted))
 
-	// Process messages
-	for _, message := range messages {
-		if !dryRun {
-			err = messageRepo.Create(ctx, message)
-			if err != nil {
-				log.Warn("Failed to create message",
-					zap.Error(err),
-					zap.String("message_id", message.MessageID.String()))
-				continue
-			}
-			stats.MessagesCreated++
+	// Store messages in batches, one batch per worker at a time
+	// Messages whose ID is already stored are skipped by CreateBatch
+	if !dryRun {
+		created, err := storeMessages(ctx, messages, extractor.workers, messageRepo, checkpoint, log)
+		if err != nil {
+			return err
 		}
+		stats.MessagesCreated = created
 	}
 
 	log.Info("Migration statistics",
@@ -486,13 +623,99 @@ func RunMigration(ctx context.Context, extractor *ExtractorTool, userRepo *repos
 		zap.Int("contacts_extracted", stats.ContactsExtracted),
 		zap.Int("contacts_created", stats.ContactsCreated),
 		zap.Int("messages_extracted", stats.MessagesExtracted),
-		zap.Int("messages_created", stats.MessagesCreated),
+		zap.Int64("messages_created", stats.MessagesCreated),
 		zap.Bool("dry_run", dryRun),
 	)
 
 	return nil
 }
 
+// storeMessages stores messages in batches with the given number of workers and returns how many were created
+// A batch that fails is stored one message at a time instead, so one bad message doesn't hold back the rest;
+// a message that still fails is logged and left out of the checkpoint so the next run retries it.
+// Only a checkpoint write failure stops the run
+func storeMessages(ctx context.Context, messages []*MessageRecord, workers int,
+	messageRepo *repository.MessageRepository, checkpoint *Checkpoint, log *zap.Logger) (int64, error) {
+
+	batches := make(chan []*MessageRecord)
+	var (
+		wg            sync.WaitGroup
+		mutex         sync.Mutex
+		created       int64
+		checkpointErr error
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				n, sourceFiles := storeMessageBatch(ctx, batch, messageRepo, log)
+				err := checkpoint.MarkDone(sourceFiles...)
+
+				mutex.Lock()
+				created += n
+				if err != nil && checkpointErr == nil {
+					checkpointErr = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for start := 0; start < len(messages); start += messageBatchSize {
+		end := start + messageBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		batches <- messages[start:end]
+	}
+	close(batches)
+	wg.Wait()
+
+	return created, checkpointErr
+}
+
+// storeMessageBatch stores a batch of messages and returns how many were created and the source files now stored
+// If the batch fails as a whole, each message is stored on its own
+func storeMessageBatch(ctx context.Context, batch []*MessageRecord,
+	messageRepo *repository.MessageRepository, log *zap.Logger) (int64, []string) {
+
+	batchMessages := make([]*domain.Message, len(batch))
+	sourceFiles := make([]string, len(batch))
+	for i, record := range batch {
+		batchMessages[i] = record.Message
+		sourceFiles[i] = record.SourceFile
+	}
+
+	n, err := messageRepo.CreateBatch(ctx, batchMessages)
+	if err == nil {
+		return n, sourceFiles
+	}
+	log.Warn("Failed to create message batch, storing its messages one at a time",
+		zap.Error(err),
+		zap.String("first_source_file", sourceFiles[0]),
+		zap.Int("count", len(batch)))
+
+	var created int64
+	stored := make([]string, 0, len(batch))
+	for _, record := range batch {
+		if err := messageRepo.Create(ctx, record.Message); err != nil {
+			// A conflict means an earlier run stored the message
+			if appErr, ok := errors.IsAppError(err); !ok || appErr.Code != errors.ErrCodeConflict {
+				log.Warn("Failed to create message",
+					zap.Error(err),
+					zap.String("source_file", record.SourceFile))
+				continue
+			}
+		} else {
+			created++
+		}
+		stored = append(stored, record.SourceFile)
+	}
+	return created, stored
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
 	if !exists {
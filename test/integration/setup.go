@@ -4,15 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http/httptest"
 	"testing"
 	"time"
-	"unsafe"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -22,6 +21,7 @@ import (
 	"github.com/pzkpfw44/wave-server/internal/api/handlers"
 	"github.com/pzkpfw44/wave-server/internal/api/middleware"
 	"github.com/pzkpfw44/wave-server/internal/config"
+	"github.com/pzkpfw44/wave-server/internal/realtime"
 	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/security"
 	"github.com/pzkpfw44/wave-server/internal/service"
@@ -46,7 +46,7 @@ type TestEnv struct {
 
 var testEnv *TestEnv
 
-// mockDBPool is a simplified mock for pgxpool.Pool
+// mockDBPool is a simplified repository.Pool that stores nothing
 type mockDBPool struct {
 	t *testing.T
 }
@@ -61,8 +61,11 @@ func setupTestServer(t *testing.T) (*httptest.Server, func()) {
 	// Create test config
 	cfg := &config.Config{}
 	cfg.Server.Port = 8081
-	cfg.Auth.JWTSecret = "test_secret_key"
+	cfg.Auth.TokenMode = config.TokenModeOpaque
 	cfg.Auth.TokenExpiry = 24 * time.Hour
+	cfg.Auth.RefreshExpiry = 30 * 24 * time.Hour
+	cfg.Auth.CleanupInterval = time.Hour
+	cfg.Messages.HardDelete = true
 
 	// Create logger
 	logger := zaptest.NewLogger(t)
@@ -75,24 +78,38 @@ func setupTestServer(t *testing.T) (*httptest.Server, func()) {
 	messageRepo := repository.NewMessageRepository(db)
 	contactRepo := repository.NewContactRepository(db)
 	tokenRepo := repository.NewTokenRepository(db)
+	blockRepo := repository.NewBlockRepository(db)
 
 	// Create services
-	userService := service.NewUserService(userRepo, logger)
 	authService := service.NewAuthService(userRepo, tokenRepo, cfg, logger)
-	messageService := service.NewMessageService(messageRepo, userRepo, logger)
-	contactService := service.NewContactService(contactRepo, logger)
-	accountService := service.NewAccountService(userRepo, contactRepo, messageRepo, tokenRepo, logger)
+	blockService := service.NewBlockService(blockRepo, logger)
+	hub := realtime.NewHub(logger)
+	messageService := service.NewMessageService(messageRepo, userRepo, blockService, hub, cfg, logger)
+	contactService := service.NewContactService(contactRepo, userRepo, logger)
+	accountService := service.NewAccountService(db, userRepo, contactRepo, messageRepo, tokenRepo, hub, cfg, logger)
+	userService := service.NewUserService(userRepo, accountService, cfg, logger)
 
 	// Create handlers
-	h := handlers.NewHandler(db, cfg, logger)
+	services := handlers.Services{
+		User:    userService,
+		Auth:    authService,
+		Block:   blockService,
+		Message: messageService,
+		Contact: contactService,
+		Account: accountService,
+		Admin:   service.NewAdminService(repository.NewStatsRepository(db), logger),
+	}
+	h := handlers.NewHandler(context.Background(), services, hub, cfg, logger)
 
 	// Create Echo instance
 	e := echo.New()
 	e.Validator = middleware.NewValidationMiddleware(logger).GetValidator()
 
 	// Configure routes
-	healthChecker := health.New(db.Pool, logger)
-	api.SetupRoutes(e, h, cfg, authService, healthChecker, logger)
+	healthChecker := health.New(db, logger)
+	authMiddleware := middleware.NewAuthMiddleware(authService, cfg, logger)
+	limiters := middleware.NewRateLimiters(cfg)
+	api.SetupRoutes(e, h, cfg, authMiddleware, limiters, healthChecker, logger)
 
 	// Create test server
 	server := httptest.NewServer(e)
@@ -116,6 +133,9 @@ func setupTestServer(t *testing.T) (*httptest.Server, func()) {
 	// Return cleanup function
 	cleanup := func() {
 		server.Close()
+		h.Close()
+		authMiddleware.Close()
+		_ = limiters.Close()
 	}
 
 	return server, cleanup
@@ -129,12 +149,9 @@ func setupTestDatabase(t *testing.T) *repository.Database {
 	// Create config
 	cfg := &config.Config{}
 
-	// Create mock pool
-	mockPool := &mockDBPool{t: t}
-
-	// Create database with mock pool
+	// Create database with a mock pool
 	db := &repository.Database{
-		Pool:   forceCastToPoolPtr(mockPool),
+		Pool:   &mockDBPool{t: t},
 		Logger: logger,
 		Config: cfg,
 	}
@@ -142,11 +159,6 @@ func setupTestDatabase(t *testing.T) *repository.Database {
 	return db
 }
 
-// forceCastToPoolPtr performs an unsafe cast for testing purposes
-func forceCastToPoolPtr(mock *mockDBPool) *pgxpool.Pool {
-	return (*pgxpool.Pool)(unsafe.Pointer(mock))
-}
-
 // Close implements the Pool.Close method
 func (m *mockDBPool) Close() {}
 
@@ -171,43 +183,11 @@ func (m *mockDBPool) QueryRow(ctx context.Context, sql string, args ...interface
 }
 
 // Begin implements the Pool.Begin method
+// The mock has no transactions, so anything that needs one fails cleanly
 func (m *mockDBPool) Begin(ctx context.Context) (pgx.Tx, error) {
-	return nil, nil
-}
-
-// BeginTx implements the Pool.BeginTx method
-func (m *mockDBPool) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
-	return nil, nil
-}
-
-// Acquire implements the Pool.Acquire method
-func (m *mockDBPool) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
-	return nil, nil
-}
-
-// AcquireFunc implements the Pool.AcquireFunc method
-func (m *mockDBPool) AcquireFunc(ctx context.Context, f func(*pgxpool.Conn) error) error {
-	return nil
-}
-
-// AcquireAllIdle implements the Pool.AcquireAllIdle method
-func (m *mockDBPool) AcquireAllIdle(ctx context.Context) []*pgxpool.Conn {
-	return nil
-}
-
-// Config implements the Pool.Config method
-func (m *mockDBPool) Config() *pgxpool.Config {
-	return nil
-}
-
-// Stat implements the Pool.Stat method
-func (m *mockDBPool) Stat() *pgxpool.Stat {
-	return nil
+	return nil, errors.New("transactions are not supported by the mock pool")
 }
 
-// Reset implements the Pool.Reset method
-func (m *mockDBPool) Reset() {}
-
 // MockRow implements pgx.Row for testing
 type MockRow struct{}
 
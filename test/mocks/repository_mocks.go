@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/repository"
 )
 
 // MockUserRepository is a mock implementation of the UserRepository
@@ -30,6 +31,39 @@ func (m *MockUserRepository) GetByUsername(ctx context.Context, username string)
 	return args.Get(0).(*domain.User), args.Error(1)
 }
 
+// GetPublicKeyByUsername mocks the GetPublicKeyByUsername method
+func (m *MockUserRepository) GetPublicKeyByUsername(ctx context.Context, username string) ([]byte, error) {
+	args := m.Called(ctx, username)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+// ExistsByPublicKey mocks the ExistsByPublicKey method
+func (m *MockUserRepository) ExistsByPublicKey(ctx context.Context, publicKey []byte) (bool, error) {
+	args := m.Called(ctx, publicKey)
+	return args.Bool(0), args.Error(1)
+}
+
+// GetByPublicKey mocks the GetByPublicKey method
+func (m *MockUserRepository) GetByPublicKey(ctx context.Context, publicKeyB64 string) (*domain.User, error) {
+	args := m.Called(ctx, publicKeyB64)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.User), args.Error(1)
+}
+
+// GetUsernamesByPublicKeys mocks the GetUsernamesByPublicKeys method
+func (m *MockUserRepository) GetUsernamesByPublicKeys(ctx context.Context, publicKeysB64 []string) (map[string]string, error) {
+	args := m.Called(ctx, publicKeysB64)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
 // GetByID mocks the GetByID method
 func (m *MockUserRepository) GetByID(ctx context.Context, userID string) (*domain.User, error) {
 	args := m.Called(ctx, userID)
@@ -45,6 +79,15 @@ func (m *MockUserRepository) UpdateLastActive(ctx context.Context, userID string
 	return args.Error(0)
 }
 
+// ListInactive mocks the ListInactive method
+func (m *MockUserRepository) ListInactive(ctx context.Context, before time.Time, limit int) ([]*domain.User, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.User), args.Error(1)
+}
+
 // Delete mocks the Delete method
 func (m *MockUserRepository) Delete(ctx context.Context, userID string) error {
 	args := m.Called(ctx, userID)
@@ -71,6 +114,36 @@ func (m *MockMessageRepository) GetByID(ctx context.Context, messageID uuid.UUID
 	return args.Get(0).(*domain.Message), args.Error(1)
 }
 
+// CreateBatch mocks the CreateBatch method
+func (m *MockMessageRepository) CreateBatch(ctx context.Context, messages []*domain.Message) (int64, error) {
+	args := m.Called(ctx, messages)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// GetUserMessagesAfter mocks the GetUserMessagesAfter method
+func (m *MockMessageRepository) GetUserMessagesAfter(ctx context.Context, userPubKey string, afterTimestamp time.Time, afterID uuid.UUID, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, afterTimestamp, afterID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// ForEachUserMessage mocks the ForEachUserMessage method
+func (m *MockMessageRepository) ForEachUserMessage(ctx context.Context, userPubKey string, pageSize int, fn func(*domain.Message) error) error {
+	args := m.Called(ctx, userPubKey, pageSize, fn)
+	return args.Error(0)
+}
+
+// GetByIDs mocks the GetByIDs method
+func (m *MockMessageRepository) GetByIDs(ctx context.Context, messageIDs []uuid.UUID) ([]*domain.Message, error) {
+	args := m.Called(ctx, messageIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
 // GetByRecipient mocks the GetByRecipient method
 func (m *MockMessageRepository) GetByRecipient(ctx context.Context, pubKey string, limit, offset int) ([]*domain.Message, error) {
 	args := m.Called(ctx, pubKey, limit, offset)
@@ -80,6 +153,33 @@ func (m *MockMessageRepository) GetByRecipient(ctx context.Context, pubKey strin
 	return args.Get(0).([]*domain.Message), args.Error(1)
 }
 
+// GetByRecipientSince mocks the GetByRecipientSince method
+func (m *MockMessageRepository) GetByRecipientSince(ctx context.Context, pubKey string, since time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, pubKey, since, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// Search mocks the Search method
+func (m *MockMessageRepository) Search(ctx context.Context, userPubKey string, opts repository.SearchOptions) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// GetByRecipientBefore mocks the GetByRecipientBefore method
+func (m *MockMessageRepository) GetByRecipientBefore(ctx context.Context, pubKey string, before time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, pubKey, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
 // GetBySender mocks the GetBySender method
 func (m *MockMessageRepository) GetBySender(ctx context.Context, pubKey string, limit, offset int) ([]*domain.Message, error) {
 	args := m.Called(ctx, pubKey, limit, offset)
@@ -89,27 +189,147 @@ func (m *MockMessageRepository) GetBySender(ctx context.Context, pubKey string,
 	return args.Get(0).([]*domain.Message), args.Error(1)
 }
 
+// GetBySenderBefore mocks the GetBySenderBefore method
+func (m *MockMessageRepository) GetBySenderBefore(ctx context.Context, pubKey string, before time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, pubKey, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// GetBySenderAndStatus mocks the GetBySenderAndStatus method
+func (m *MockMessageRepository) GetBySenderAndStatus(ctx context.Context, pubKey string, status domain.MessageStatus, limit, offset int) ([]*domain.Message, error) {
+	args := m.Called(ctx, pubKey, status, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
 // GetConversation mocks the GetConversation method
-func (m *MockMessageRepository) GetConversation(ctx context.Context, userPubKey, contactPubKey string, limit, offset int) ([]*domain.Message, error) {
-	args := m.Called(ctx, userPubKey, contactPubKey, limit, offset)
+func (m *MockMessageRepository) GetConversation(ctx context.Context, userPubKey, contactPubKey string, ascending bool, limit, offset int) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, contactPubKey, ascending, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// GetConversationAfter mocks the GetConversationAfter method
+func (m *MockMessageRepository) GetConversationAfter(ctx context.Context, userPubKey, contactPubKey string, after time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, contactPubKey, after, limit)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Message), args.Error(1)
 }
 
+// GetConversationBefore mocks the GetConversationBefore method
+func (m *MockMessageRepository) GetConversationBefore(ctx context.Context, userPubKey, contactPubKey string, before time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, contactPubKey, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// CountForUser mocks the CountForUser method
+func (m *MockMessageRepository) CountForUser(ctx context.Context, userPubKey string) (int, error) {
+	args := m.Called(ctx, userPubKey)
+	return args.Int(0), args.Error(1)
+}
+
+// CountConversation mocks the CountConversation method
+func (m *MockMessageRepository) CountConversation(ctx context.Context, userPubKey, contactPubKey string) (int, error) {
+	args := m.Called(ctx, userPubKey, contactPubKey)
+	return args.Int(0), args.Error(1)
+}
+
+// CountUnread mocks the CountUnread method
+func (m *MockMessageRepository) CountUnread(ctx context.Context, recipientPubKey string) (int, error) {
+	args := m.Called(ctx, recipientPubKey)
+	return args.Int(0), args.Error(1)
+}
+
+// CountUnreadBySender mocks the CountUnreadBySender method
+func (m *MockMessageRepository) CountUnreadBySender(ctx context.Context, recipientPubKey string) (map[string]int, error) {
+	args := m.Called(ctx, recipientPubKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
+// ListConversations mocks the ListConversations method
+func (m *MockMessageRepository) ListConversations(ctx context.Context, userPubKey string) ([]*domain.ConversationSummary, error) {
+	args := m.Called(ctx, userPubKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.ConversationSummary), args.Error(1)
+}
+
 // UpdateStatus mocks the UpdateStatus method
-func (m *MockMessageRepository) UpdateStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) error {
+func (m *MockMessageRepository) UpdateStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) (bool, error) {
 	args := m.Called(ctx, messageID, status)
+	return args.Bool(0), args.Error(1)
+}
+
+// UpdateStatusBatch mocks the UpdateStatusBatch method
+func (m *MockMessageRepository) UpdateStatusBatch(ctx context.Context, recipientPubKey string, messageIDs []uuid.UUID, status domain.MessageStatus) ([]*domain.MessageReceipt, error) {
+	args := m.Called(ctx, recipientPubKey, messageIDs, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.MessageReceipt), args.Error(1)
+}
+
+// MarkDeliveredForRecipient mocks the MarkDeliveredForRecipient method
+func (m *MockMessageRepository) MarkDeliveredForRecipient(ctx context.Context, recipientPubKey string, messageIDs []uuid.UUID) ([]*domain.MessageReceipt, error) {
+	args := m.Called(ctx, recipientPubKey, messageIDs)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.MessageReceipt), args.Error(1)
+}
+
+// DeleteByID mocks the DeleteByID method
+func (m *MockMessageRepository) DeleteByID(ctx context.Context, messageID uuid.UUID) error {
+	args := m.Called(ctx, messageID)
+	return args.Error(0)
+}
+
+// SoftDeleteByID mocks the SoftDeleteByID method
+func (m *MockMessageRepository) SoftDeleteByID(ctx context.Context, messageID uuid.UUID) error {
+	args := m.Called(ctx, messageID)
 	return args.Error(0)
 }
 
+// DeleteExpired mocks the DeleteExpired method
+func (m *MockMessageRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // DeleteUserMessages mocks the DeleteUserMessages method
 func (m *MockMessageRepository) DeleteUserMessages(ctx context.Context, pubKey string) (int64, error) {
 	args := m.Called(ctx, pubKey)
 	return args.Get(0).(int64), args.Error(1)
 }
 
+// SoftDeleteUserMessages mocks the SoftDeleteUserMessages method
+func (m *MockMessageRepository) SoftDeleteUserMessages(ctx context.Context, pubKey string) (int64, error) {
+	args := m.Called(ctx, pubKey)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// PurgeDeleted mocks the PurgeDeleted method
+func (m *MockMessageRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	args := m.Called(ctx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // MockContactRepository is a mock implementation of the ContactRepository
 type MockContactRepository struct {
 	mock.Mock
@@ -121,9 +341,15 @@ func (m *MockContactRepository) Create(ctx context.Context, contact *domain.Cont
 	return args.Error(0)
 }
 
+// CreateMany mocks the CreateMany method
+func (m *MockContactRepository) CreateMany(ctx context.Context, contacts []*domain.Contact) (int, error) {
+	args := m.Called(ctx, contacts)
+	return args.Int(0), args.Error(1)
+}
+
 // GetByUserID mocks the GetByUserID method
-func (m *MockContactRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Contact, error) {
-	args := m.Called(ctx, userID)
+func (m *MockContactRepository) GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*domain.Contact, error) {
+	args := m.Called(ctx, userID, limit, offset)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -139,6 +365,36 @@ func (m *MockContactRepository) GetByContactPubKey(ctx context.Context, userID,
 	return args.Get(0).(*domain.Contact), args.Error(1)
 }
 
+// CountByUserID mocks the CountByUserID method
+func (m *MockContactRepository) CountByUserID(ctx context.Context, userID string) (int, error) {
+	args := m.Called(ctx, userID)
+	return args.Int(0), args.Error(1)
+}
+
+// GetByGroup mocks the GetByGroup method
+func (m *MockContactRepository) GetByGroup(ctx context.Context, userID, group string, limit, offset int) ([]*domain.Contact, error) {
+	args := m.Called(ctx, userID, group, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Contact), args.Error(1)
+}
+
+// CountByGroup mocks the CountByGroup method
+func (m *MockContactRepository) CountByGroup(ctx context.Context, userID, group string) (int, error) {
+	args := m.Called(ctx, userID, group)
+	return args.Int(0), args.Error(1)
+}
+
+// GetGroups mocks the GetGroups method
+func (m *MockContactRepository) GetGroups(ctx context.Context, userID string) ([]*domain.ContactGroup, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.ContactGroup), args.Error(1)
+}
+
 // Update mocks the Update method
 func (m *MockContactRepository) Update(ctx context.Context, contact *domain.Contact) error {
 	args := m.Called(ctx, contact)
@@ -151,12 +407,50 @@ func (m *MockContactRepository) Delete(ctx context.Context, userID, contactPubKe
 	return args.Error(0)
 }
 
+// DeleteMany mocks the DeleteMany method
+func (m *MockContactRepository) DeleteMany(ctx context.Context, userID string, contactPubKeys []string) (int64, error) {
+	args := m.Called(ctx, userID, contactPubKeys)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // DeleteUserContacts mocks the DeleteUserContacts method
 func (m *MockContactRepository) DeleteUserContacts(ctx context.Context, userID string) (int64, error) {
 	args := m.Called(ctx, userID)
 	return args.Get(0).(int64), args.Error(1)
 }
 
+// MockBlockRepository is a mock implementation of the BlockRepository
+type MockBlockRepository struct {
+	mock.Mock
+}
+
+// Create mocks the Create method
+func (m *MockBlockRepository) Create(ctx context.Context, block *domain.Block) error {
+	args := m.Called(ctx, block)
+	return args.Error(0)
+}
+
+// GetByUserID mocks the GetByUserID method
+func (m *MockBlockRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Block, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Block), args.Error(1)
+}
+
+// Exists mocks the Exists method
+func (m *MockBlockRepository) Exists(ctx context.Context, userID, blockedPubKey string) (bool, error) {
+	args := m.Called(ctx, userID, blockedPubKey)
+	return args.Bool(0), args.Error(1)
+}
+
+// Delete mocks the Delete method
+func (m *MockBlockRepository) Delete(ctx context.Context, userID, blockedPubKey string) error {
+	args := m.Called(ctx, userID, blockedPubKey)
+	return args.Error(0)
+}
+
 // MockTokenRepository is a mock implementation of the TokenRepository
 type MockTokenRepository struct {
 	mock.Mock
@@ -178,26 +472,50 @@ func (m *MockTokenRepository) GetByTokenHash(ctx context.Context, tokenHash stri
 }
 
 // GetByUserID mocks the GetByUserID method
-func (m *MockTokenRepository) GetByUserID(ctx context.Context, userID string) ([]*domain.Token, error) {
-	args := m.Called(ctx, userID)
+func (m *MockTokenRepository) GetByUserID(ctx context.Context, userID string, limit, offset int) ([]*domain.Token, error) {
+	args := m.Called(ctx, userID, limit, offset)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).([]*domain.Token), args.Error(1)
 }
 
+// CountActive mocks the CountActive method
+func (m *MockTokenRepository) CountActive(ctx context.Context, userID string) (int, error) {
+	args := m.Called(ctx, userID)
+	return args.Int(0), args.Error(1)
+}
+
 // UpdateLastUsed mocks the UpdateLastUsed method
 func (m *MockTokenRepository) UpdateLastUsed(ctx context.Context, tokenID uuid.UUID) error {
 	args := m.Called(ctx, tokenID)
 	return args.Error(0)
 }
 
+// Rotate mocks the Rotate method
+func (m *MockTokenRepository) Rotate(ctx context.Context, used, next *domain.Token) error {
+	args := m.Called(ctx, used, next)
+	return args.Error(0)
+}
+
+// DeleteSession mocks the DeleteSession method
+func (m *MockTokenRepository) DeleteSession(ctx context.Context, tokenHash string) error {
+	args := m.Called(ctx, tokenHash)
+	return args.Error(0)
+}
+
 // Delete mocks the Delete method
 func (m *MockTokenRepository) Delete(ctx context.Context, tokenHash string) error {
 	args := m.Called(ctx, tokenHash)
 	return args.Error(0)
 }
 
+// DeleteByID mocks the DeleteByID method
+func (m *MockTokenRepository) DeleteByID(ctx context.Context, userID string, tokenID uuid.UUID) error {
+	args := m.Called(ctx, userID, tokenID)
+	return args.Error(0)
+}
+
 // DeleteUserTokens mocks the DeleteUserTokens method
 func (m *MockTokenRepository) DeleteUserTokens(ctx context.Context, userID string) (int64, error) {
 	args := m.Called(ctx, userID)
@@ -210,12 +528,6 @@ func (m *MockTokenRepository) CleanupExpired(ctx context.Context) (int64, error)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-// CreateForUser mocks the CreateForUser method
-func (m *MockTokenRepository) CreateForUser(ctx context.Context, userID string, expiryDuration time.Duration) (string, error) {
-	args := m.Called(ctx, userID, expiryDuration)
-	return args.String(0), args.Error(1)
-}
-
 // ValidateToken mocks the ValidateToken method
 func (m *MockTokenRepository) ValidateToken(ctx context.Context, tokenStr string) (string, error) {
 	args := m.Called(ctx, tokenStr)
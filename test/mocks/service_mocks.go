@@ -2,11 +2,13 @@ package mocks
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/pzkpfw44/wave-server/internal/domain"
+	"github.com/pzkpfw44/wave-server/internal/repository"
 	"github.com/pzkpfw44/wave-server/internal/service"
 )
 
@@ -24,6 +26,21 @@ func (m *MockUserService) Register(ctx context.Context, username, publicKeyB64,
 	return args.Get(0).(*domain.User), args.Error(1)
 }
 
+// IsUsernameAvailable mocks the IsUsernameAvailable method
+func (m *MockUserService) IsUsernameAvailable(ctx context.Context, username string) (bool, error) {
+	args := m.Called(ctx, username)
+	return args.Bool(0), args.Error(1)
+}
+
+// GetByPublicKey mocks the GetByPublicKey method
+func (m *MockUserService) GetByPublicKey(ctx context.Context, publicKeyB64 string) (*domain.User, error) {
+	args := m.Called(ctx, publicKeyB64)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.User), args.Error(1)
+}
+
 // GetByID mocks the GetByID method
 func (m *MockUserService) GetByID(ctx context.Context, userID string) (*domain.User, error) {
 	args := m.Called(ctx, userID)
@@ -72,15 +89,42 @@ func (m *MockUserService) DeleteUser(ctx context.Context, userID string) error {
 	return args.Error(0)
 }
 
+// ListInactiveUsers mocks the ListInactiveUsers method
+func (m *MockUserService) ListInactiveUsers(ctx context.Context, before time.Time, limit int) ([]*domain.User, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.User), args.Error(1)
+}
+
+// DeleteInactiveUsers mocks the DeleteInactiveUsers method
+func (m *MockUserService) DeleteInactiveUsers(ctx context.Context, before time.Time, limit int) (int, error) {
+	args := m.Called(ctx, before, limit)
+	return args.Int(0), args.Error(1)
+}
+
+// ScheduleInactiveCleanup mocks the ScheduleInactiveCleanup method
+func (m *MockUserService) ScheduleInactiveCleanup(ctx context.Context) <-chan struct{} {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(<-chan struct{})
+}
+
 // MockAuthService is a mock implementation of the AuthService
 type MockAuthService struct {
 	mock.Mock
 }
 
 // Login mocks the Login method
-func (m *MockAuthService) Login(ctx context.Context, username string) (string, error) {
-	args := m.Called(ctx, username)
-	return args.String(0), args.Error(1)
+func (m *MockAuthService) Login(ctx context.Context, username, deviceName string) (*service.TokenPair, error) {
+	args := m.Called(ctx, username, deviceName)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.TokenPair), args.Error(1)
 }
 
 // ValidateToken mocks the ValidateToken method
@@ -90,9 +134,12 @@ func (m *MockAuthService) ValidateToken(ctx context.Context, tokenStr string) (s
 }
 
 // RefreshToken mocks the RefreshToken method
-func (m *MockAuthService) RefreshToken(ctx context.Context, tokenStr string) (string, error) {
-	args := m.Called(ctx, tokenStr)
-	return args.String(0), args.Error(1)
+func (m *MockAuthService) RefreshToken(ctx context.Context, refreshTokenStr string) (*service.TokenPair, error) {
+	args := m.Called(ctx, refreshTokenStr)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.TokenPair), args.Error(1)
 }
 
 // Logout mocks the Logout method
@@ -107,6 +154,24 @@ func (m *MockAuthService) LogoutAll(ctx context.Context, userID string) error {
 	return args.Error(0)
 }
 
+// CurrentSessionID mocks the CurrentSessionID method
+func (m *MockAuthService) CurrentSessionID(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+// RevokeSession mocks the RevokeSession method
+func (m *MockAuthService) RevokeSession(ctx context.Context, userID string, tokenID uuid.UUID) error {
+	args := m.Called(ctx, userID, tokenID)
+	return args.Error(0)
+}
+
+// CountActiveSessions mocks the CountActiveSessions method
+func (m *MockAuthService) CountActiveSessions(ctx context.Context, userID string) (int, error) {
+	args := m.Called(ctx, userID)
+	return args.Int(0), args.Error(1)
+}
+
 // CleanupExpiredTokens mocks the CleanupExpiredTokens method
 func (m *MockAuthService) CleanupExpiredTokens(ctx context.Context) error {
 	args := m.Called(ctx)
@@ -114,8 +179,12 @@ func (m *MockAuthService) CleanupExpiredTokens(ctx context.Context) error {
 }
 
 // ScheduleTokenCleanup mocks the ScheduleTokenCleanup method
-func (m *MockAuthService) ScheduleTokenCleanup(ctx context.Context) {
-	m.Called(ctx)
+func (m *MockAuthService) ScheduleTokenCleanup(ctx context.Context) <-chan struct{} {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(<-chan struct{})
 }
 
 // UpdateUserActivity mocks the UpdateUserActivity method
@@ -132,15 +201,26 @@ type MockMessageService struct {
 // SendMessage mocks the SendMessage method
 func (m *MockMessageService) SendMessage(ctx context.Context, userID, recipientPubKey string,
 	ciphertextKEMB64, ciphertextMsgB64, nonceB64 string,
-	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string) (*domain.Message, error) {
+	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string,
+	replyToMessageID string, expiresIn time.Duration, messageID string) (*domain.Message, error) {
 	args := m.Called(ctx, userID, recipientPubKey, ciphertextKEMB64, ciphertextMsgB64, nonceB64,
-		senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64)
+		senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64, replyToMessageID, expiresIn, messageID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Message), args.Error(1)
 }
 
+// SendMultiMessage mocks the SendMultiMessage method
+func (m *MockMessageService) SendMultiMessage(ctx context.Context, userID string, copies []service.RecipientCopy,
+	senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64 string, expiresIn time.Duration) ([]*domain.Message, error) {
+	args := m.Called(ctx, userID, copies, senderCiphertextKEMB64, senderCiphertextMsgB64, senderNonceB64, expiresIn)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
 // GetMessageByID mocks the GetMessageByID method
 func (m *MockMessageService) GetMessageByID(ctx context.Context, messageID uuid.UUID) (*domain.Message, error) {
 	args := m.Called(ctx, messageID)
@@ -150,8 +230,26 @@ func (m *MockMessageService) GetMessageByID(ctx context.Context, messageID uuid.
 	return args.Get(0).(*domain.Message), args.Error(1)
 }
 
-// GetMessagesForUser mocks the GetMessagesForUser method
-func (m *MockMessageService) GetMessagesForUser(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
+// GetReplyReferences mocks the GetReplyReferences method
+func (m *MockMessageService) GetReplyReferences(ctx context.Context, messages []*domain.Message) (map[uuid.UUID]*domain.Message, error) {
+	args := m.Called(ctx, messages)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[uuid.UUID]*domain.Message), args.Error(1)
+}
+
+// GetMessageTimeline mocks the GetMessageTimeline method
+func (m *MockMessageService) GetMessageTimeline(ctx context.Context, userPubKey string, messageID uuid.UUID) (*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Message), args.Error(1)
+}
+
+// GetFailedMessages mocks the GetFailedMessages method
+func (m *MockMessageService) GetFailedMessages(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
 	args := m.Called(ctx, userPubKey, limit, offset)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -159,6 +257,60 @@ func (m *MockMessageService) GetMessagesForUser(ctx context.Context, userPubKey
 	return args.Get(0).([]*domain.Message), args.Error(1)
 }
 
+// ResendMessage mocks the ResendMessage method
+func (m *MockMessageService) ResendMessage(ctx context.Context, userPubKey string, messageID uuid.UUID) (*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, messageID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Message), args.Error(1)
+}
+
+// GetMessagesForUser mocks the GetMessagesForUser method
+func (m *MockMessageService) GetMessagesForUser(ctx context.Context, userPubKey, before string, limit, offset int) ([]*domain.Message, int, error) {
+	args := m.Called(ctx, userPubKey, before, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]*domain.Message), args.Int(1), args.Error(2)
+}
+
+// GetMessagesReceivedSince mocks the GetMessagesReceivedSince method
+func (m *MockMessageService) GetMessagesReceivedSince(ctx context.Context, userPubKey string, since time.Time, limit int) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, since, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// SearchMessages mocks the SearchMessages method
+func (m *MockMessageService) SearchMessages(ctx context.Context, userPubKey string, opts repository.SearchOptions) ([]*domain.Message, error) {
+	args := m.Called(ctx, userPubKey, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Message), args.Error(1)
+}
+
+// CountUnread mocks the CountUnread method
+func (m *MockMessageService) CountUnread(ctx context.Context, userPubKey string, bySender bool) (int, map[string]int, error) {
+	args := m.Called(ctx, userPubKey, bySender)
+	if args.Get(1) == nil {
+		return args.Int(0), nil, args.Error(2)
+	}
+	return args.Int(0), args.Get(1).(map[string]int), args.Error(2)
+}
+
+// ListConversations mocks the ListConversations method
+func (m *MockMessageService) ListConversations(ctx context.Context, userPubKey string) ([]*domain.ConversationSummary, error) {
+	args := m.Called(ctx, userPubKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.ConversationSummary), args.Error(1)
+}
+
 // GetMessagesSentByUser mocks the GetMessagesSentByUser method
 func (m *MockMessageService) GetMessagesSentByUser(ctx context.Context, userPubKey string, limit, offset int) ([]*domain.Message, error) {
 	args := m.Called(ctx, userPubKey, limit, offset)
@@ -169,17 +321,59 @@ func (m *MockMessageService) GetMessagesSentByUser(ctx context.Context, userPubK
 }
 
 // GetConversation mocks the GetConversation method
-func (m *MockMessageService) GetConversation(ctx context.Context, userPubKey, contactPubKey string, limit, offset int) ([]*domain.Message, error) {
-	args := m.Called(ctx, userPubKey, contactPubKey, limit, offset)
+func (m *MockMessageService) GetConversation(ctx context.Context, userPubKey, contactPubKey, cursor string, ascending bool, limit, offset int) ([]*domain.Message, int, error) {
+	args := m.Called(ctx, userPubKey, contactPubKey, cursor, ascending, limit, offset)
 	if args.Get(0) == nil {
-		return nil, args.Error(1)
+		return nil, args.Int(1), args.Error(2)
 	}
-	return args.Get(0).([]*domain.Message), args.Error(1)
+	return args.Get(0).([]*domain.Message), args.Int(1), args.Error(2)
+}
+
+// UpdateMessageStatuses mocks the UpdateMessageStatuses method
+func (m *MockMessageService) UpdateMessageStatuses(ctx context.Context, userID string, messageIDs []uuid.UUID, status domain.MessageStatus) (int64, error) {
+	args := m.Called(ctx, userID, messageIDs, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteMessage mocks the DeleteMessage method
+func (m *MockMessageService) DeleteMessage(ctx context.Context, userID string, messageID uuid.UUID) error {
+	args := m.Called(ctx, userID, messageID)
+	return args.Error(0)
+}
+
+// CleanupExpiredMessages mocks the CleanupExpiredMessages method
+func (m *MockMessageService) CleanupExpiredMessages(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+// ScheduleExpiredCleanup mocks the ScheduleExpiredCleanup method
+func (m *MockMessageService) ScheduleExpiredCleanup(ctx context.Context) <-chan struct{} {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(<-chan struct{})
+}
+
+// PurgeDeletedMessages mocks the PurgeDeletedMessages method
+func (m *MockMessageService) PurgeDeletedMessages(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+// ScheduleDeletedPurge mocks the ScheduleDeletedPurge method
+func (m *MockMessageService) ScheduleDeletedPurge(ctx context.Context) <-chan struct{} {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(<-chan struct{})
 }
 
 // UpdateMessageStatus mocks the UpdateMessageStatus method
-func (m *MockMessageService) UpdateMessageStatus(ctx context.Context, messageID uuid.UUID, status domain.MessageStatus) error {
-	args := m.Called(ctx, messageID, status)
+func (m *MockMessageService) UpdateMessageStatus(ctx context.Context, userID string, messageID uuid.UUID, status domain.MessageStatus) error {
+	args := m.Called(ctx, userID, messageID, status)
 	return args.Error(0)
 }
 
@@ -195,21 +389,45 @@ type MockContactService struct {
 }
 
 // AddContact mocks the AddContact method
-func (m *MockContactService) AddContact(ctx context.Context, userID, contactPubKey, nickname string) (*domain.Contact, error) {
-	args := m.Called(ctx, userID, contactPubKey, nickname)
+func (m *MockContactService) AddContact(ctx context.Context, userID, contactPubKey, nickname, group string) (*domain.Contact, error) {
+	args := m.Called(ctx, userID, contactPubKey, nickname, group)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Contact), args.Error(1)
 }
 
+// ImportContacts mocks the ImportContacts method
+func (m *MockContactService) ImportContacts(ctx context.Context, userID string, inputs []service.ContactInput) (int, int, int, error) {
+	args := m.Called(ctx, userID, inputs)
+	return args.Int(0), args.Int(1), args.Int(2), args.Error(3)
+}
+
 // GetContacts mocks the GetContacts method
-func (m *MockContactService) GetContacts(ctx context.Context, userID string) ([]*domain.Contact, error) {
+func (m *MockContactService) GetContacts(ctx context.Context, userID string, limit, offset int) ([]*domain.Contact, int, error) {
+	args := m.Called(ctx, userID, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]*domain.Contact), args.Int(1), args.Error(2)
+}
+
+// GetContactsByGroup mocks the GetContactsByGroup method
+func (m *MockContactService) GetContactsByGroup(ctx context.Context, userID, group string, limit, offset int) ([]*domain.Contact, int, error) {
+	args := m.Called(ctx, userID, group, limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Int(1), args.Error(2)
+	}
+	return args.Get(0).([]*domain.Contact), args.Int(1), args.Error(2)
+}
+
+// GetGroups mocks the GetGroups method
+func (m *MockContactService) GetGroups(ctx context.Context, userID string) ([]*domain.ContactGroup, error) {
 	args := m.Called(ctx, userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]*domain.Contact), args.Error(1)
+	return args.Get(0).([]*domain.ContactGroup), args.Error(1)
 }
 
 // GetContact mocks the GetContact method
@@ -221,9 +439,15 @@ func (m *MockContactService) GetContact(ctx context.Context, userID, contactPubK
 	return args.Get(0).(*domain.Contact), args.Error(1)
 }
 
+// GetSafetyNumber mocks the GetSafetyNumber method
+func (m *MockContactService) GetSafetyNumber(ctx context.Context, userID, contactPubKey string) (string, error) {
+	args := m.Called(ctx, userID, contactPubKey)
+	return args.String(0), args.Error(1)
+}
+
 // UpdateContact mocks the UpdateContact method
-func (m *MockContactService) UpdateContact(ctx context.Context, userID, contactPubKey, nickname string) (*domain.Contact, error) {
-	args := m.Called(ctx, userID, contactPubKey, nickname)
+func (m *MockContactService) UpdateContact(ctx context.Context, userID, contactPubKey, nickname string, group *string) (*domain.Contact, error) {
+	args := m.Called(ctx, userID, contactPubKey, nickname, group)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -236,35 +460,80 @@ func (m *MockContactService) DeleteContact(ctx context.Context, userID, contactP
 	return args.Error(0)
 }
 
+// CountContacts mocks the CountContacts method
+func (m *MockContactService) CountContacts(ctx context.Context, userID string) (int, error) {
+	args := m.Called(ctx, userID)
+	return args.Int(0), args.Error(1)
+}
+
+// DeleteContacts mocks the DeleteContacts method
+func (m *MockContactService) DeleteContacts(ctx context.Context, userID string, contactPubKeys []string) (int64, error) {
+	args := m.Called(ctx, userID, contactPubKeys)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // DeleteUserContacts mocks the DeleteUserContacts method
 func (m *MockContactService) DeleteUserContacts(ctx context.Context, userID string) (int64, error) {
 	args := m.Called(ctx, userID)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-// MockAccountService is a mock implementation of the AccountService
-type MockAccountService struct {
+// MockBlockService is a mock implementation of the BlockService
+type MockBlockService struct {
 	mock.Mock
 }
 
-// BackupAccount mocks the BackupAccount method
-func (m *MockAccountService) BackupAccount(ctx context.Context, userID string) (*service.BackupData, error) {
+// Block mocks the Block method
+func (m *MockBlockService) Block(ctx context.Context, userID, blockedPubKey string) (*domain.Block, error) {
+	args := m.Called(ctx, userID, blockedPubKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Block), args.Error(1)
+}
+
+// Unblock mocks the Unblock method
+func (m *MockBlockService) Unblock(ctx context.Context, userID, blockedPubKey string) error {
+	args := m.Called(ctx, userID, blockedPubKey)
+	return args.Error(0)
+}
+
+// IsBlocked mocks the IsBlocked method
+func (m *MockBlockService) IsBlocked(ctx context.Context, userID, senderPubKey string) (bool, error) {
+	args := m.Called(ctx, userID, senderPubKey)
+	return args.Bool(0), args.Error(1)
+}
+
+// GetBlocks mocks the GetBlocks method
+func (m *MockBlockService) GetBlocks(ctx context.Context, userID string) ([]*domain.Block, error) {
 	args := m.Called(ctx, userID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*service.BackupData), args.Error(1)
+	return args.Get(0).([]*domain.Block), args.Error(1)
+}
+
+// MockAccountService is a mock implementation of the AccountService
+type MockAccountService struct {
+	mock.Mock
+}
+
+// BackupAccount mocks the BackupAccount method
+func (m *MockAccountService) BackupAccount(ctx context.Context, userID string,
+	writeHeader func(*service.BackupHeader) error, writeMessage func(map[string]interface{}) error) (int, error) {
+	args := m.Called(ctx, userID, writeHeader, writeMessage)
+	return args.Int(0), args.Error(1)
 }
 
 // RecoverAccount mocks the RecoverAccount method
 func (m *MockAccountService) RecoverAccount(ctx context.Context, username, publicKeyB64 string,
 	encryptedPrivateKey map[string]string, contactsData map[string]interface{},
-	messagesData []interface{}) (*domain.User, error) {
+	messagesData []interface{}) (*domain.User, *service.RecoverySummary, error) {
 	args := m.Called(ctx, username, publicKeyB64, encryptedPrivateKey, contactsData, messagesData)
 	if args.Get(0) == nil {
-		return nil, args.Error(1)
+		return nil, nil, args.Error(2)
 	}
-	return args.Get(0).(*domain.User), args.Error(1)
+	return args.Get(0).(*domain.User), args.Get(1).(*service.RecoverySummary), args.Error(2)
 }
 
 // DeleteAccount mocks the DeleteAccount method
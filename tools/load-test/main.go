@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -71,10 +77,10 @@ func main() {
 	fmt.Printf("Total Requests:    %d\n", results.TotalRequests)
 	fmt.Printf("Successful:        %d (%.2f%%)\n",
 		results.SuccessfulRequests,
-		float64(results.SuccessfulRequests)/float64(results.TotalRequests)*100)
+		percentage(results.SuccessfulRequests, results.TotalRequests))
 	fmt.Printf("Failed:            %d (%.2f%%)\n",
 		results.FailedRequests,
-		float64(results.FailedRequests)/float64(results.TotalRequests)*100)
+		percentage(results.FailedRequests, results.TotalRequests))
 	fmt.Printf("Average Response:  %.2f ms\n", float64(results.AverageResponseTime.Milliseconds()))
 	fmt.Printf("95th Percentile:   %.2f ms\n", float64(results.Percentile95.Milliseconds()))
 	fmt.Printf("Requests/sec:      %.2f\n", results.RequestsPerSecond)
@@ -87,6 +93,14 @@ func main() {
 	}
 }
 
+// percentage returns part as a percentage of total, or 0 when there is no total
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
 // Config holds the load test configuration
 type Config struct {
 	BaseURL      string
@@ -101,48 +115,135 @@ type Config struct {
 // LoadTest represents a load test
 type LoadTest struct {
 	config   *Config
-	scenario Scenario
 	stopChan chan struct{}
+	stopOnce sync.Once
 }
 
 // NewLoadTest creates a new load test
 func NewLoadTest(config *Config) (*LoadTest, error) {
-	scenario, err := GetScenario(config.ScenarioName)
-	if err != nil {
+	// Each virtual user gets its own scenario, but an unknown name should fail before the test starts
+	if _, err := GetScenario(config.ScenarioName); err != nil {
 		return nil, err
 	}
+	if config.NumUsers < 1 {
+		return nil, fmt.Errorf("number of users must be at least 1")
+	}
 
 	return &LoadTest{
 		config:   config,
-		scenario: scenario,
 		stopChan: make(chan struct{}),
 	}, nil
 }
 
+// sample is the outcome of a single scenario iteration
+type sample struct {
+	success      bool
+	responseTime time.Duration
+}
+
 // Run runs the load test
+// Virtual users are started evenly over the ramp-up time and keep executing the scenario
+// until the duration elapses or the test is stopped
 func (lt *LoadTest) Run() (*Results, error) {
-	// This is a placeholder implementation
-	// In a real implementation, this would start multiple goroutines
-	// to simulate users and collect results
+	ctx, cancel := context.WithTimeout(context.Background(), lt.config.Duration)
+	defer cancel()
 
-	results := &Results{
-		TotalRequests:       1000,
-		SuccessfulRequests:  970,
-		FailedRequests:      30,
-		AverageResponseTime: 150 * time.Millisecond,
-		Percentile95:        300 * time.Millisecond,
-		RequestsPerSecond:   50.0,
-		StartTime:           time.Now().Add(-lt.config.Duration),
-		EndTime:             time.Now(),
-		ScenarioName:        lt.config.ScenarioName,
+	go func() {
+		select {
+		case <-lt.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		mutex   sync.Mutex
+		samples []sample
+		wg      sync.WaitGroup
+	)
+	record := func(s sample) {
+		mutex.Lock()
+		samples = append(samples, s)
+		mutex.Unlock()
+	}
+
+	startTime := time.Now()
+	rampUpDelay := lt.config.RampUpTime / time.Duration(lt.config.NumUsers)
+
+	for userID := 0; userID < lt.config.NumUsers; userID++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+
+			// Stagger the start so the load ramps up instead of arriving all at once
+			select {
+			case <-time.After(rampUpDelay * time.Duration(userID)):
+			case <-ctx.Done():
+				return
+			}
+
+			lt.runUser(ctx, userID, record)
+		}(userID)
+	}
+
+	wg.Wait()
+	endTime := time.Now()
+
+	return newResults(lt.config.ScenarioName, samples, startTime, endTime), nil
+}
+
+// runUser sets up a scenario for one virtual user and executes it until ctx is done
+func (lt *LoadTest) runUser(ctx context.Context, userID int, record func(sample)) {
+	scenario, err := GetScenario(lt.config.ScenarioName)
+	if err != nil {
+		log.Printf("User %d: failed to create scenario: %v", userID, err)
+		return
 	}
 
-	return results, nil
+	if err := scenario.Setup(ctx, lt.config.BaseURL, userID); err != nil {
+		log.Printf("User %d: scenario setup failed: %v", userID, err)
+		return
+	}
+
+	defer func() {
+		// The test context is done by now, so teardown gets its own
+		teardownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := scenario.Teardown(teardownCtx); err != nil && lt.config.Verbose {
+			log.Printf("User %d: scenario teardown failed: %v", userID, err)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		start := time.Now()
+		result, err := scenario.Execute(ctx)
+
+		// Requests cut short by the end of the test say nothing about the server
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil || result == nil {
+			if lt.config.Verbose {
+				log.Printf("User %d: scenario execution failed: %v", userID, err)
+			}
+			record(sample{success: false, responseTime: time.Since(start)})
+			continue
+		}
+
+		if !result.Success && lt.config.Verbose {
+			log.Printf("User %d: request failed with status %d: %v", userID, result.StatusCode, result.Error)
+		}
+		record(sample{success: result.Success, responseTime: result.ResponseTime})
+	}
 }
 
 // Stop stops the load test
+// It is safe to call more than once
 func (lt *LoadTest) Stop() {
-	close(lt.stopChan)
+	lt.stopOnce.Do(func() {
+		close(lt.stopChan)
+	})
 }
 
 // Results holds the load test results
@@ -156,11 +257,149 @@ type Results struct {
 	StartTime           time.Time
 	EndTime             time.Time
 	ScenarioName        string
+	Histogram           []HistogramBucket
+}
+
+// HistogramBucket counts the requests that took at most UpperBound
+// and more than the previous bucket's bound; the last bucket has no bound
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      int
+}
+
+// histogramBounds are the upper bounds of the latency histogram buckets
+var histogramBounds = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
 }
 
-// SaveToFile saves the results to a file
+// newHistogram buckets sorted latencies by histogramBounds
+func newHistogram(sorted []time.Duration) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(histogramBounds)+1)
+	for i, bound := range histogramBounds {
+		buckets[i].UpperBound = bound
+	}
+
+	i := 0
+	for _, latency := range sorted {
+		for i < len(histogramBounds) && latency > histogramBounds[i] {
+			i++
+		}
+		buckets[i].Count++
+	}
+
+	return buckets
+}
+
+// newResults computes the summary statistics for the collected samples
+func newResults(scenarioName string, samples []sample, startTime, endTime time.Time) *Results {
+	results := &Results{
+		TotalRequests: len(samples),
+		StartTime:     startTime,
+		EndTime:       endTime,
+		ScenarioName:  scenarioName,
+	}
+	if len(samples) == 0 {
+		results.Histogram = newHistogram(nil)
+		return results
+	}
+
+	latencies := make([]time.Duration, len(samples))
+	var total time.Duration
+	for i, s := range samples {
+		if s.success {
+			results.SuccessfulRequests++
+		} else {
+			results.FailedRequests++
+		}
+		latencies[i] = s.responseTime
+		total += s.responseTime
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	results.Histogram = newHistogram(latencies)
+	results.AverageResponseTime = total / time.Duration(len(latencies))
+	results.Percentile95 = latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+
+	if elapsed := endTime.Sub(startTime).Seconds(); elapsed > 0 {
+		results.RequestsPerSecond = float64(len(samples)) / elapsed
+	}
+
+	return results
+}
+
+// resultsFile is the JSON layout of saved results, with durations in milliseconds
+type resultsFile struct {
+	ScenarioName          string          `json:"scenario"`
+	StartTime             time.Time       `json:"start_time"`
+	EndTime               time.Time       `json:"end_time"`
+	DurationSeconds       float64         `json:"duration_seconds"`
+	TotalRequests         int             `json:"total_requests"`
+	SuccessfulRequests    int             `json:"successful_requests"`
+	FailedRequests        int             `json:"failed_requests"`
+	AverageResponseTimeMs float64         `json:"average_response_time_ms"`
+	Percentile95Ms        float64         `json:"percentile_95_ms"`
+	RequestsPerSecond     float64         `json:"requests_per_second"`
+	Histogram             []histogramFile `json:"histogram"`
+}
+
+// histogramFile is the JSON layout of a histogram bucket
+// The last bucket has no upper bound, so its le_ms is omitted
+type histogramFile struct {
+	UpperBoundMs *float64 `json:"le_ms,omitempty"`
+	Count        int      `json:"count"`
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// SaveToFile writes the results to filename as JSON, creating its directory if needed
 func (r *Results) SaveToFile(filename string) error {
-	// This is a placeholder implementation
-	// In a real implementation, this would serialize the results to JSON and write to file
+	file := resultsFile{
+		ScenarioName:          r.ScenarioName,
+		StartTime:             r.StartTime,
+		EndTime:               r.EndTime,
+		DurationSeconds:       r.EndTime.Sub(r.StartTime).Seconds(),
+		TotalRequests:         r.TotalRequests,
+		SuccessfulRequests:    r.SuccessfulRequests,
+		FailedRequests:        r.FailedRequests,
+		AverageResponseTimeMs: milliseconds(r.AverageResponseTime),
+		Percentile95Ms:        milliseconds(r.Percentile95),
+		RequestsPerSecond:     r.RequestsPerSecond,
+		Histogram:             make([]histogramFile, len(r.Histogram)),
+	}
+	for i, bucket := range r.Histogram {
+		file.Histogram[i].Count = bucket.Count
+		if bucket.UpperBound > 0 {
+			upperBound := milliseconds(bucket.UpperBound)
+			file.Histogram[i].UpperBoundMs = &upperBound
+		}
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode results: %w", err)
+	}
+
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create results directory: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(filename, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+
 	return nil
 }